@@ -1,14 +1,33 @@
 package acme
 
-import "github.com/ImageWare/TLSential/model"
+import (
+	"errors"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+var (
+	// ErrImported is returned for ACME operations on imported certs.
+	ErrImported = errors.New("imported certificates can't be managed via ACME")
+	// ErrNotIssued is returned when an operation needs an issued cert.
+	ErrNotIssued = errors.New("certificate not issued")
+	// ErrNoAccount is returned for operations that need the cert's own
+	// ACME account when it was imported without one.
+	ErrNoAccount = errors.New("certificate has no ACME account; reissue it first")
+)
 
 // Service implements the ability to trigger a new certificate request, or Renew
 // a certificate. Renewal presumes a certificate has already been issued.
 type Service interface {
 	Trigger(id string)
 	Renew(c *model.Certificate)
+	DryRun(id string)
+	UpdateEmail(c *model.Certificate, email string) error
+	Revoke(c *model.Certificate) error
 	RequestIssue(id string) bool
 	RequestRenew(id string) bool
+	RequestDryRun(id string) bool
 	GetAutoRenewChannel() chan string
 	GetIssueChannel() chan string
+	GetDryRunChannel() chan string
 }
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/api"
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/ImageWare/TLSential/repository/sqldb"
+	"github.com/ImageWare/TLSential/service"
+	"github.com/ImageWare/TLSential/user"
+)
+
+// Admin commands work on the database directly, for when the API can't be
+// used, such as a locked out super admin. Bolt only allows one process at a
+// time, so the server has to be stopped first.
+var (
+	userCommand = subcommands("user", map[string]func([]string) error{
+		"add":    userAdd,
+		"list":   userList,
+		"passwd": userPasswd,
+		"delete": userDelete,
+	})
+	certCommand = subcommands("cert", map[string]func([]string) error{
+		"list":   certList,
+		"show":   certShow,
+		"renew":  certRenew,
+		"delete": certDelete,
+	})
+	configCommand = subcommands("config", map[string]func([]string) error{
+		"reset-superadmin": configResetSuperAdmin,
+		"rotate-jwt":       configRotateJWT,
+		"rotate-session":   configRotateSession,
+	})
+	dbCommand = subcommands("db", map[string]func([]string) error{
+		"check":      dbCheck,
+		"compact":    dbCompact,
+		"rotate-key": dbRotateKey,
+	})
+)
+
+// subcommands returns a command running the action named by its first
+// argument with the rest.
+func subcommands(name string, actions map[string]func([]string) error) func([]string) error {
+	names := make([]string, 0, len(actions))
+	for n := range actions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return func(args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: tlsential %s %s [flags]", name, strings.Join(names, "|"))
+		}
+		action, ok := actions[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown command %q, expected tlsential %s %s", args[0], name, strings.Join(names, "|"))
+		}
+		return action(args[1:])
+	}
+}
+
+// dbFlags select the database for commands that open it directly.
+type dbFlags struct {
+	dbDriver      string
+	dbFile        string
+	masterKeyFile string
+}
+
+func (f *dbFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dbDriver, "db-driver", DriverBolt, "database to use: bolt, sqlite3 or postgres")
+	fs.StringVar(&f.dbFile, "db", "tlsential.db", "filename for bolt or sqlite3, connection string for postgres")
+	fs.StringVar(&f.masterKeyFile, "master-key-file", "", "file of base64 master keys, newest first (default $"+masterKeyEnv+")")
+}
+
+func (f *dbFlags) open() (*repositories, error) {
+	ci, err := loadCipher(f.masterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return openRepositories(f.dbDriver, f.dbFile, ci)
+}
+
+// newCommand returns a flag set for "tlsential name", with the database flags
+// and -json registered.
+func newCommand(name, usage string, f *dbFlags, asJSON *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	f.register(fs)
+	if asJSON != nil {
+		fs.BoolVar(asJSON, "json", false, "print JSON instead of a table")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tlsential %s [flags] %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses args into fs and checks it leaves n positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	fs.Parse(args)
+	if fs.NArg() != n {
+		fs.Usage()
+		return fmt.Errorf("%s takes %d arguments, got %d", fs.Name(), n, fs.NArg())
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// password reads a password from file, or generates one if file is empty.
+// generated reports which happened, so the caller knows to show it.
+func password(file string) (p string, generated bool, err error) {
+	if file == "" {
+		return auth.NewPassword(), true, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false, err
+	}
+	p = strings.TrimRight(string(b), "\r\n")
+	if p == "" {
+		return "", false, fmt.Errorf("%s is empty", file)
+	}
+	return p, false, nil
+}
+
+// newKey returns a random signing key.
+func newKey() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// userInfo is a user as listed, without the password hash.
+type userInfo struct {
+	Name       string
+	Role       string
+	SuperAdmin bool
+	// Password is only set when one was generated.
+	Password string `json:",omitempty"`
+}
+
+func userAdd(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("user add", "name", &f, &asJSON)
+	role := fs.String("role", auth.RoleUserReader, "role of the new user")
+	passwordFile := fs.String("password-file", "", "file holding the password, generated if not given")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+	if !auth.ValidRole(*role) {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+	p, generated, err := password(*passwordFile)
+	if err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	us := service.NewUserService(db.users)
+
+	existing, err := us.GetUser(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return user.ErrUserExists
+	}
+	u, err := model.NewUser(name, p, *role)
+	if err != nil {
+		return err
+	}
+	err = us.SaveUser(u)
+	if err != nil {
+		return err
+	}
+
+	info := &userInfo{Name: u.Name, Role: u.Role}
+	if generated {
+		info.Password = p
+	}
+	if asJSON {
+		return printJSON(info)
+	}
+	fmt.Printf("Created user %s with role %s\n", u.Name, u.Role)
+	if generated {
+		fmt.Printf("Password: %s\n", p)
+	}
+	return nil
+}
+
+func userList(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("user list", "", &f, &asJSON)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	users, err := service.NewUserService(db.users).GetAllUsers()
+	if err != nil {
+		return err
+	}
+	sa, err := db.config.SuperAdmin()
+	if err != nil {
+		return err
+	}
+	infos := make([]*userInfo, 0, len(users))
+	for _, u := range users {
+		infos = append(infos, &userInfo{Name: u.Name, Role: u.Role, SuperAdmin: u.Name == sa})
+	}
+	if asJSON {
+		return printJSON(infos)
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "NAME\tROLE\tSUPER ADMIN")
+	for _, i := range infos {
+		star := ""
+		if i.SuperAdmin {
+			star = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", i.Name, i.Role, star)
+	}
+	return w.Flush()
+}
+
+func userPasswd(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("user passwd", "name", &f, &asJSON)
+	passwordFile := fs.String("password-file", "", "file holding the new password, generated if not given")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	p, generated, err := password(*passwordFile)
+	if err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	us := service.NewUserService(db.users)
+
+	u, err := us.GetUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return user.ErrUserNotFound
+	}
+	err = u.SetPassword(p)
+	if err != nil {
+		return err
+	}
+	err = us.SaveUser(u)
+	if err != nil {
+		return err
+	}
+
+	info := &userInfo{Name: u.Name, Role: u.Role}
+	if generated {
+		info.Password = p
+	}
+	if asJSON {
+		return printJSON(info)
+	}
+	fmt.Printf("Changed the password for %s\n", u.Name)
+	if generated {
+		fmt.Printf("Password: %s\n", p)
+	}
+	return nil
+}
+
+func userDelete(args []string) error {
+	var f dbFlags
+	fs := newCommand("user delete", "name", &f, nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	us := service.NewUserService(db.users)
+
+	sa, err := db.config.SuperAdmin()
+	if err != nil {
+		return err
+	}
+	if name == sa {
+		return fmt.Errorf("%s is the super admin, run tlsential config reset-superadmin first", name)
+	}
+	u, err := us.GetUser(name)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return user.ErrUserNotFound
+	}
+	err = us.DeleteUser(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted user %s\n", name)
+	return nil
+}
+
+// certState is the single state shown for a cert in listings. A failed
+// renewal of an issued cert shows as failed.
+func certState(s *model.CertificateSummary) string {
+	switch {
+	case s.Failed:
+		return model.StateFailed
+	case s.Issued:
+		return model.StateIssued
+	}
+	return model.StatePending
+}
+
+func certList(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("cert list", "", &f, &asJSON)
+	labels := make(labelFlags)
+	fs.Var(labels, "label", "only list certs with this key=value label, or just key for any value; repeatable")
+	q := &model.CertificateQuery{Labels: labels}
+	fs.StringVar(&q.Domain, "domain", "", "only list certs with a domain containing this")
+	fs.StringVar(&q.State, "state", "", "only list issued, failed or pending certs")
+	fs.IntVar(&q.ExpiringWithin, "expiring", 0, "only list issued certs expiring within this many days")
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if err := q.Validate(); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	// The summary index has everything listed, so nothing is decrypted.
+	all, err := service.NewCertificateService(db.certs).CertSummaries()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	summaries := make([]*model.CertificateSummary, 0, len(all))
+	for _, s := range all {
+		if q.Matches(s, now) {
+			summaries = append(summaries, s)
+		}
+	}
+	// KSUIDs sort by creation time.
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].ID < summaries[j].ID })
+	if asJSON {
+		return printJSON(summaries)
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "ID\tCOMMON NAME\tSTATE\tEXPIRY\tLABELS")
+	for _, s := range summaries {
+		expiry := "-"
+		if s.Issued {
+			expiry = s.Expiry.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.CommonName, certState(s), expiry, model.FormatLabels(s.Labels))
+	}
+	return w.Flush()
+}
+
+// getCert returns the cert with id, or ErrCertNotFound.
+func getCert(cs certificate.Service, id string) (*model.Certificate, error) {
+	c, err := cs.Cert(id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, certificate.ErrCertNotFound
+	}
+	return c, nil
+}
+
+func certShow(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("cert show", "id", &f, &asJSON)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	c, err := getCert(service.NewCertificateService(db.certs), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cr := api.NewCertResp(c)
+	// A cert that can't be parsed is still worth showing.
+	cr.Details, err = c.Details()
+	if err != nil {
+		log.Printf("cert show: %s: %s", c.ID, err.Error())
+		cr.Details = nil
+	}
+	if asJSON {
+		return printJSON(cr)
+	}
+
+	w := newTable()
+	fmt.Fprintf(w, "ID\t%s\n", cr.ID)
+	fmt.Fprintf(w, "Common name\t%s\n", cr.CommonName)
+	fmt.Fprintf(w, "Domains\t%s\n", strings.Join(cr.Domains, ", "))
+	fmt.Fprintf(w, "Labels\t%s\n", model.FormatLabels(cr.Labels))
+	fmt.Fprintf(w, "State\t%s\n", certState(c.Summary()))
+	fmt.Fprintf(w, "Version\t%d\n", cr.Version)
+	fmt.Fprintf(w, "Imported\t%t\n", cr.Imported)
+	fmt.Fprintf(w, "Revoked\t%t\n", cr.Revoked)
+	fmt.Fprintf(w, "Renew at\t%d days before expiry\n", cr.RenewAt)
+	if cr.Issued {
+		fmt.Fprintf(w, "Expiry\t%s\n", cr.Expiry.Format(time.RFC3339))
+	}
+	if cr.Details != nil {
+		fmt.Fprintf(w, "Serial\t%s\n", cr.Details.SerialNumber)
+		fmt.Fprintf(w, "Issuer\t%s\n", cr.Details.Issuer)
+		fmt.Fprintf(w, "Key\t%s %d\n", cr.Details.KeyAlgorithm, cr.Details.KeySize)
+	}
+	if cr.DeploymentStatus != "" {
+		fmt.Fprintf(w, "Deployment\t%s\n", cr.DeploymentStatus)
+	}
+	if cr.LastError != "" {
+		fmt.Fprintf(w, "Last error\t%s\n", cr.LastError)
+	}
+	fmt.Fprintf(w, "Modified\t%s\n", cr.ModTime.Format(time.RFC3339))
+	return w.Flush()
+}
+
+// certRenew renews a cert in the foreground, or issues it if it never was.
+func certRenew(args []string) error {
+	var f dbFlags
+	fs := newCommand("cert renew", "id", &f, nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	cs := newCertService(db)
+
+	c, err := getCert(cs, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if c.Imported {
+		return acme.ErrImported
+	}
+	ns := newNotifierService(db)
+	newACMEService(db, ns).Renew(c)
+	// Notifiers send in the background, so wait for them before exiting.
+	// Webhooks and deployments are queued for the server to deliver.
+	ns.Wait()
+
+	c, err = getCert(cs, c.ID)
+	if err != nil {
+		return err
+	}
+	if c.LastError != nil {
+		return fmt.Errorf("renewing %s failed: %s", c.ID, c.LastError)
+	}
+	fmt.Printf("Renewed %s, now expires %s\n", c.ID, c.Expiry.Format(time.RFC3339))
+	return nil
+}
+
+func certDelete(args []string) error {
+	var f dbFlags
+	fs := newCommand("cert delete", "id", &f, nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	cs := newCertService(db)
+
+	c, err := getCert(cs, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = cs.DeleteCert(c.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted certificate %s (%s)\n", c.ID, c.CommonName)
+	return nil
+}
+
+// configResetSuperAdmin clears the super admin so a new one can be set up
+// through the UI or API, or sets one up straight away if given a name. Giving
+// the current super admin's name resets their password.
+func configResetSuperAdmin(args []string) error {
+	var f dbFlags
+	fs := newCommand("config reset-superadmin", "[name]", &f, nil)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("config reset-superadmin takes at most one name")
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	cs := service.NewConfigService(db.config, service.NewUserService(db.users))
+
+	err = cs.ResetSuperAdmin()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fmt.Println("Cleared the super admin, a new one can be created on the next start")
+		return nil
+	}
+
+	u, p, err := cs.CreateSuperAdmin(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Super admin is now %s\nPassword: %s\n", u.Name, p)
+	return nil
+}
+
+func configRotateJWT(args []string) error {
+	var f dbFlags
+	fs := newCommand("config rotate-jwt", "", &f, nil)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	err = db.config.SetJWTSecret(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rotated the JWT secret, all API tokens are now invalid")
+	return nil
+}
+
+func configRotateSession(args []string) error {
+	var f dbFlags
+	fs := newCommand("config rotate-session", "", &f, nil)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	err = db.config.SetSessionKey(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rotated the session key, all web sessions are now invalid")
+	return nil
+}
+
+// checkResult is the outcome of db check.
+type checkResult struct {
+	SchemaVersion int
+	Certificates  int
+	Users         int
+	Problems      []string
+}
+
+// dbCheck runs the database's own integrity check, then reads back every
+// certificate, user and the challenge config to prove they decode and their
+// secrets open with the master key.
+func dbCheck(args []string) error {
+	var f dbFlags
+	var asJSON bool
+	fs := newCommand("db check", "", &f, &asJSON)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	res := &checkResult{Problems: make([]string, 0)}
+	problems, err := db.health.Check()
+	if err != nil {
+		return err
+	}
+	res.Problems = append(res.Problems, problems...)
+
+	stats, err := db.health.Stats()
+	if err != nil {
+		res.Problems = append(res.Problems, "stats: "+err.Error())
+	} else {
+		res.SchemaVersion = stats.SchemaVersion
+	}
+	certs, err := db.certs.AllCerts()
+	if err != nil {
+		res.Problems = append(res.Problems, "certificates: "+err.Error())
+	}
+	res.Certificates = len(certs)
+	users, err := db.users.GetAllUsers()
+	if err != nil {
+		res.Problems = append(res.Problems, "users: "+err.Error())
+	}
+	res.Users = len(users)
+	if _, err := db.challenges.AuthKey(); err != nil {
+		res.Problems = append(res.Problems, "challenge config: "+err.Error())
+	}
+
+	if asJSON {
+		err = printJSON(res)
+	} else {
+		fmt.Printf("Schema version %d, %d certificates, %d users\n", res.SchemaVersion, res.Certificates, res.Users)
+		for _, p := range res.Problems {
+			fmt.Println(p)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(res.Problems) > 0 {
+		return fmt.Errorf("found %d problems", len(res.Problems))
+	}
+	if !asJSON {
+		fmt.Println("No problems found")
+	}
+	return nil
+}
+
+// dbCompact reclaims the space left behind by deleted records.
+func dbCompact(args []string) error {
+	var f dbFlags
+	fs := newCommand("db compact", "", &f, nil)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	var before, after int64
+	var err error
+	switch f.dbDriver {
+	case DriverBolt:
+		before, after, err = boltdb.Compact(f.dbFile)
+	default:
+		var db *sqldb.DB
+		db, err = sqldb.Open(f.dbDriver, f.dbFile)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		before, after, err = db.Compact()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Compacted from %d to %d bytes\n", before, after)
+	return nil
+}
+
+// dbRotateKey reseals every secret with the newest master key, sealing any
+// still in plaintext. The old keys have to stay in the key file until it's
+// done, since they're needed to open what they sealed.
+func dbRotateKey(args []string) error {
+	var f dbFlags
+	fs := newCommand("db rotate-key", "", &f, nil)
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	ci, err := loadCipher(f.masterKeyFile)
+	if err != nil {
+		return err
+	}
+	if ci == envelope.None {
+		return errors.New("no master key, set -master-key-file or $" + masterKeyEnv)
+	}
+	db, err := openRepositories(f.dbDriver, f.dbFile, ci)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	n, err := db.reseal()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Resealed %d records with the current master key\n", n)
+	return nil
+}
@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// ErrUnauthorized means the server rejected the install token, most likely
+// because it was revoked. A new one has to be put in the config.
+var ErrUnauthorized = errors.New("the server rejected the install token")
+
+// Agent installs one certificate.
+type Agent struct {
+	cfg    *Config
+	client *http.Client
+}
+
+// New returns an Agent for a validated config.
+func New(cfg *Config) (*Agent, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &Agent{cfg, &http.Client{Transport: transport, Timeout: time.Minute}}, nil
+}
+
+// Run installs the certificate if the server has a newer version than the
+// one last installed. It reports whether it installed anything, and tells the
+// server how the install went.
+func (a *Agent) Run() (bool, error) {
+	st, err := loadState(a.cfg.StateFile)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := a.fetch(st)
+	if err != nil || b == nil {
+		return false, err
+	}
+
+	report := &model.Install{Host: a.cfg.Host, Version: b.Version, Status: model.InstallOK}
+	ierr := a.install(b)
+	if ierr != nil {
+		report.Status = model.InstallFailed
+		if _, ok := ierr.(*reloadError); ok {
+			report.Status = model.InstallRolledBack
+		}
+		report.Error = ierr.Error()
+	} else {
+		st.Version = b.Version
+		err = st.save(a.cfg.StateFile)
+		if err != nil {
+			ierr = fmt.Errorf("installed version %d but couldn't save the state: %s", b.Version, err)
+		}
+	}
+
+	rerr := a.report(report)
+	switch {
+	case ierr != nil:
+		return false, ierr
+	case rerr != nil:
+		return true, fmt.Errorf("installed version %d but couldn't report it: %s", b.Version, rerr)
+	}
+	return true, nil
+}
+
+func (a *Agent) url() string {
+	return strings.TrimSuffix(a.cfg.Server, "/") + "/api/certificate/" + url.PathEscape(a.cfg.CertificateID) + "/install"
+}
+
+// fetch downloads the certificate, returning nil if there's nothing newer
+// than st.Version.
+func (a *Agent) fetch(st *state) (*model.InstallBundle, error) {
+	req, err := http.NewRequest(http.MethodGet, a.url()+"?version="+strconv.Itoa(st.Version), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Install "+a.cfg.Token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	default:
+		return nil, responseError(resp)
+	}
+
+	b := &model.InstallBundle{}
+	err = json.NewDecoder(resp.Body).Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// report tells the server how an install went.
+func (a *Agent) report(i *model.Install) error {
+	body, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.url(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Install "+a.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	}
+	return responseError(resp)
+}
+
+func responseError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// reloadError is a failed reload command, after which the previous files
+// were put back.
+type reloadError struct {
+	err    error
+	output string
+}
+
+func (e *reloadError) Error() string {
+	if e.output == "" {
+		return fmt.Sprintf("reload failed, rolled back: %s", e.err)
+	}
+	return fmt.Sprintf("reload failed, rolled back: %s: %s", e.err, e.output)
+}
+
+// install writes every file and runs the reload command, putting the old
+// files back if either fails.
+func (a *Agent) install(b *model.InstallBundle) error {
+	if _, err := tls.X509KeyPair([]byte(b.Certificate), []byte(b.PrivateKey)); err != nil {
+		return fmt.Errorf("downloaded key doesn't match the certificate: %s", err)
+	}
+
+	uid, gid, err := lookupOwner(a.cfg.Owner, a.cfg.Group)
+	if err != nil {
+		return err
+	}
+
+	var done []*replacement
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			done[i].rollback()
+		}
+	}
+	for _, f := range a.cfg.Files {
+		r, err := replace(f.Path, b.Contents(f.Contents), f.mode, uid, gid)
+		if err != nil {
+			rollback()
+			return err
+		}
+		done = append(done, r)
+	}
+
+	if out, err := a.reload(); err != nil {
+		rollback()
+		// Put whatever was serving the old files back as it was.
+		a.reload()
+		return &reloadError{err, out}
+	}
+
+	for _, r := range done {
+		r.commit()
+	}
+	return nil
+}
+
+// reload runs the reload command, returning the end of its output.
+func (a *Agent) reload() (string, error) {
+	if a.cfg.Reload == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.reloadTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", a.cfg.Reload)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", a.cfg.Reload)
+	}
+	out, err := cmd.CombinedOutput()
+	s := strings.TrimSpace(string(out))
+	if len(s) > 500 {
+		s = "..." + s[len(s)-500:]
+	}
+	return s, err
+}
@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// fakeServer plays the install endpoints for one certificate.
+type fakeServer struct {
+	mu      sync.Mutex
+	version int
+	token   string
+	// drop makes the next download fail partway through.
+	drop    bool
+	cert    string
+	key     string
+	reports []*model.Install
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path != "/api/certificate/cert1/install" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Install "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		i := &model.Install{}
+		json.NewDecoder(r.Body).Decode(i)
+		s.reports = append(s.reports, i)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	have, _ := strconv.Atoi(r.URL.Query().Get("version"))
+	if s.version <= have {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if s.drop {
+		s.drop = false
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte(`{"Version":`))
+		return
+	}
+	json.NewEncoder(w).Encode(&model.InstallBundle{
+		Version:           s.version,
+		Certificate:       s.cert,
+		IssuerCertificate: s.cert,
+		PrivateKey:        s.key,
+	})
+}
+
+// issue replaces the server's certificate with a new version.
+func (s *fakeServer) issue(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	s.key = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func (s *fakeServer) lastReport() *model.Install {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reports) == 0 {
+		return nil
+	}
+	return s.reports[len(s.reports)-1]
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &fakeServer{token: "tok1.secret"}
+	fs.issue(t)
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	keyPath := filepath.Join(dir, "privkey.pem")
+	chainPath := filepath.Join(dir, "fullchain.pem")
+	marker := filepath.Join(dir, "reloaded")
+	cfg := &Config{
+		Server:        srv.URL,
+		CertificateID: "cert1",
+		Token:         "tok1.secret",
+		StateFile:     filepath.Join(dir, "agent.state"),
+		Files: []*File{
+			{Path: chainPath, Contents: ContentsFullChain},
+			{Path: keyPath, Contents: ContentsKey, Mode: "0640"},
+		},
+		Reload: "touch " + marker,
+		Host:   "web1",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First run installs everything.
+	installed, err := a.Run()
+	if err != nil || !installed {
+		t.Fatalf("expected an install, got %t, %v", installed, err)
+	}
+	if got := readFile(t, keyPath); got != fs.key {
+		t.Errorf("unexpected key file %q", got)
+	}
+	if got := readFile(t, chainPath); got != fs.cert+fs.cert {
+		t.Errorf("unexpected full chain %q", got)
+	}
+	if fi, err := os.Stat(keyPath); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("expected the key to be 0640, got %v, %v", fi.Mode(), err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("expected the reload command to run")
+	}
+	if r := fs.lastReport(); r == nil || r.Status != model.InstallOK || r.Version != 1 || r.Host != "web1" {
+		t.Errorf("expected an installed report, got %+v", r)
+	}
+
+	// Nothing new, so nothing happens.
+	os.Remove(marker)
+	installed, err = a.Run()
+	if err != nil || installed {
+		t.Fatalf("expected no install, got %t, %v", installed, err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected no reload without a new version")
+	}
+
+	// A failing reload puts the previous files back.
+	oldKey := fs.key
+	fs.issue(t)
+	cfg.Reload = "test -e " + marker + ".rolledback && exit 0; touch " + marker + ".rolledback; echo broken; exit 1"
+	installed, err = a.Run()
+	if err == nil || installed {
+		t.Fatalf("expected the install to fail, got %t, %v", installed, err)
+	}
+	if got := readFile(t, keyPath); got != oldKey {
+		t.Error("expected the previous key to be restored")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tlsential-old")); len(matches) > 0 {
+		t.Errorf("expected no leftover files, found %v", matches)
+	}
+	if r := fs.lastReport(); r == nil || r.Status != model.InstallRolledBack || r.Version != 2 {
+		t.Errorf("expected a rolled back report, got %+v", r)
+	}
+
+	// A download that's cut off changes nothing, and the next run tries
+	// again with the same token.
+	cfg.Reload = ""
+	fs.mu.Lock()
+	fs.drop = true
+	fs.mu.Unlock()
+	if installed, err := a.Run(); err == nil || installed {
+		t.Fatalf("expected the cut off download to fail, got %t, %v", installed, err)
+	}
+	installed, err = a.Run()
+	if err != nil || !installed {
+		t.Fatalf("expected the retry to install, got %t, %v", installed, err)
+	}
+	if got := readFile(t, keyPath); got != fs.key {
+		t.Error("expected the new key to be installed")
+	}
+	st, err := loadState(cfg.StateFile)
+	if err != nil || st.Version != 2 {
+		t.Errorf("unexpected state %+v, %v", st, err)
+	}
+
+	// A revoked token needs a new one in the config.
+	fs.mu.Lock()
+	fs.token = "tok2.secret"
+	fs.mu.Unlock()
+	if _, err := a.Run(); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Server:        "https://tlsential.example.com",
+			CertificateID: "cert1",
+			Token:         "tok1.secret",
+			StateFile:     "/tmp/state",
+			Files:         []*File{{Path: "/tmp/key.pem", Contents: ContentsKey}},
+			Host:          "web1",
+		}
+	}
+
+	c := valid()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Files[0].mode != 0600 || c.reloadTimeout != DefaultReloadTimeout {
+		t.Errorf("expected defaults, got mode %o and timeout %s", c.Files[0].mode, c.reloadTimeout)
+	}
+
+	tests := map[string]func(c *Config){
+		"no server":        func(c *Config) { c.Server = "" },
+		"ftp server":       func(c *Config) { c.Server = "ftp://example.com" },
+		"no token":         func(c *Config) { c.Token = "" },
+		"old secret":       func(c *Config) { c.Token, c.Secret = "", "secret" },
+		"no files":         func(c *Config) { c.Files = nil },
+		"unknown contents": func(c *Config) { c.Files[0].Contents = "everything" },
+		"bad mode":         func(c *Config) { c.Files[0].Mode = "rw-r--r--" },
+		"bad timeout":      func(c *Config) { c.ReloadTimeout = "soon" },
+	}
+	for name, breakIt := range tests {
+		c := valid()
+		breakIt(c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package agent installs certificates from a TLSential server onto the host
+// it runs on, reloading whatever serves them.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// What a target file holds.
+const (
+	ContentsCert      = model.ContentsCert
+	ContentsChain     = model.ContentsChain
+	ContentsFullChain = model.ContentsFullChain
+	ContentsKey       = model.ContentsKey
+	ContentsCombined  = model.ContentsCombined
+)
+
+// DefaultReloadTimeout bounds the reload command when the config doesn't.
+const DefaultReloadTimeout = time.Minute
+
+// Config is the agent's config file, in JSON.
+type Config struct {
+	// Server is the base URL of the TLSential server.
+	Server string `json:"server"`
+	// CAFile verifies the server's certificate instead of the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+
+	CertificateID string `json:"certificate_id"`
+	// Token is an install token created for this host on the certificate.
+	Token string `json:"token"`
+	// Secret was the certificate's secret, which agents no longer use. It's
+	// only read to say so.
+	Secret string `json:"secret,omitempty"`
+
+	// StateFile remembers the installed version. It defaults to the config
+	// file's path with ".state" appended.
+	StateFile string `json:"state_file,omitempty"`
+
+	Files []*File `json:"files"`
+	// Owner and Group own the written files, if set.
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+
+	// Reload is run with the shell after the files are in place. If it fails
+	// the previous files are put back and it is run again.
+	Reload string `json:"reload,omitempty"`
+	// ReloadTimeout is a duration such as "30s".
+	ReloadTimeout string `json:"reload_timeout,omitempty"`
+
+	// Host is the name reported to the server, the hostname by default.
+	Host string `json:"host,omitempty"`
+
+	reloadTimeout time.Duration
+}
+
+// File is one file to install.
+type File struct {
+	Path     string `json:"path"`
+	Contents string `json:"contents"`
+	// Mode is in octal, 0600 by default for files holding the key and 0644
+	// otherwise.
+	Mode string `json:"mode,omitempty"`
+
+	mode os.FileMode
+}
+
+// LoadConfig reads and checks the config file at path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if c.StateFile == "" {
+		c.StateFile = path + ".state"
+	}
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks c and fills in defaults.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.Server)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("server must be an http or https URL")
+	}
+	if c.Token == "" && c.Secret != "" {
+		return errors.New("secret has been replaced by token, create an install token for this host on the certificate")
+	}
+	if c.CertificateID == "" || c.Token == "" {
+		return errors.New("certificate_id and token are required")
+	}
+	if c.StateFile == "" {
+		return errors.New("state_file is required")
+	}
+	if len(c.Files) == 0 {
+		return errors.New("at least one file is required")
+	}
+	for _, f := range c.Files {
+		if f.Path == "" {
+			return errors.New("every file needs a path")
+		}
+		var ok bool
+		f.mode, ok = model.ContentsMode(f.Contents)
+		if !ok {
+			return fmt.Errorf("%s: contents must be cert, chain, fullchain, key or combined", f.Path)
+		}
+		if f.Mode != "" {
+			m, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil || m > 0777 {
+				return fmt.Errorf("%s: mode must be octal permissions such as 0640", f.Path)
+			}
+			f.mode = os.FileMode(m)
+		}
+	}
+
+	c.reloadTimeout = DefaultReloadTimeout
+	if c.ReloadTimeout != "" {
+		c.reloadTimeout, err = time.ParseDuration(c.ReloadTimeout)
+		if err != nil || c.reloadTimeout <= 0 {
+			return errors.New("reload_timeout must be a positive duration such as 30s")
+		}
+	}
+
+	if c.Host == "" {
+		c.Host, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
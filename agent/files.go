@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// state is what the agent remembers between runs.
+type state struct {
+	Version int
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, st)
+	return st, err
+}
+
+func (st *state) save(path string) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b, 0600, -1, -1)
+}
+
+// lookupOwner returns the uid and gid for names, or -1 for those not given.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// writeFile atomically replaces path with data, so readers see either the
+// old file or the new one in full.
+func writeFile(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	err = tmp.Chmod(mode)
+	if err == nil && (uid != -1 || gid != -1) {
+		err = tmp.Chown(uid, gid)
+	}
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name, path)
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// replacement is a file that has been replaced but can still be put back.
+type replacement struct {
+	path string
+	// old is a hard link to the previous file, empty if there wasn't one.
+	old string
+}
+
+// replace writes data to path, keeping the previous file until commit or
+// rollback.
+func replace(path string, data []byte, mode os.FileMode, uid, gid int) (*replacement, error) {
+	r := &replacement{path: path}
+	if _, err := os.Lstat(path); err == nil {
+		r.old = path + ".tlsential-old"
+		os.Remove(r.old)
+		err = os.Link(path, r.old)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writeFile(path, data, mode, uid, gid)
+	if err != nil {
+		r.commit()
+		return nil, err
+	}
+	return r, nil
+}
+
+// commit drops the previous file.
+func (r *replacement) commit() {
+	if r.old != "" {
+		os.Remove(r.old)
+	}
+}
+
+// rollback puts the previous file back, or removes path if there wasn't one.
+func (r *replacement) rollback() {
+	var err error
+	if r.old != "" {
+		err = os.Rename(r.old, r.path)
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err != nil {
+		log.Printf("Rolling back %s: %s", r.path, err)
+	}
+}
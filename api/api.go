@@ -6,16 +6,23 @@ import (
 	"net/http"
 
 	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/archive"
+	"github.com/ImageWare/TLSential/audit"
 	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/backup"
 	"github.com/ImageWare/TLSential/certificate"
 	"github.com/ImageWare/TLSential/challenge_config"
 	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/events"
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/script"
 	"github.com/ImageWare/TLSential/user"
+	"github.com/ImageWare/TLSential/webhook"
 	"github.com/gorilla/mux"
 )
 
-// TODO: Syslog formatted log/audit files.
-
 var (
 	// ErrMissingID is returned when you made a call that isn't supported
 	// without an ID in the URI
@@ -40,11 +47,21 @@ type apiHandler struct {
 	configHandler      ConfigHandler
 	challengeHandler   ChallengeHandler
 	certificateHandler CertificateHandler
+	notifierHandler    NotifierHandler
+	webhookHandler     WebhookHandler
+	eventsHandler      EventsHandler
+	auditHandler       AuditHandler
+	healthHandler      HealthHandler
+	backupHandler      BackupHandler
+	archiveHandler     ArchiveHandler
+	installHandler     InstallHandler
+	scriptHandler      ScriptHandler
+	deployHandler      DeployHandler
 	Version            string
 }
 
 // NewHandler creates a new apiHandler with given UserService and ConfigService.
-func NewHandler(version string, us user.Service, cs config.Service, chs challenge_config.Service, crs certificate.Service, as acme.Service) Handler {
+func NewHandler(version string, us user.Service, cs config.Service, chs challenge_config.Service, crs certificate.Service, as acme.Service, ns notifier.Service, ws webhook.Service, bus *events.Bus, aus audit.Service, hr health.Repository, br backup.Repository, st *archive.Store, ss script.Service, ds deploy.Service) Handler {
 	// TODO: Make RBAC persistent if needed.
 	rbac := auth.InitRBAC()
 	uh := NewUserHandler(us)
@@ -53,7 +70,17 @@ func NewHandler(version string, us user.Service, cs config.Service, chs challeng
 	ch := NewConfigHandler(cs)
 	chah := NewChallengeHandler(chs)
 	crh := NewCertificateHandler(crs, as)
-	return &apiHandler{userHandler: uh, midHandler: mh, authHandler: ah, configHandler: ch, challengeHandler: chah, certificateHandler: crh, Version: version}
+	nh := NewNotifierHandler(ns)
+	wh := NewWebhookHandler(crs, ws)
+	eh := NewEventsHandler(crs, bus, mh)
+	auh := NewAuditHandler(cs, aus)
+	hh := NewHealthHandler(version, hr, as, crs, chs)
+	bh := NewBackupHandler(br)
+	arh := NewArchiveHandler(st)
+	ih := NewInstallHandler(crs)
+	sh := NewScriptHandler(ss, crs)
+	dh := NewDeployHandler(crs, ds)
+	return &apiHandler{userHandler: uh, midHandler: mh, authHandler: ah, configHandler: ch, challengeHandler: chah, certificateHandler: crh, notifierHandler: nh, webhookHandler: wh, eventsHandler: eh, auditHandler: auh, healthHandler: hh, backupHandler: bh, archiveHandler: arh, installHandler: ih, scriptHandler: sh, deployHandler: dh, Version: version}
 }
 
 // Status returns the current version of the server.
@@ -66,11 +93,47 @@ func (h *apiHandler) Status() http.HandlerFunc {
 // TODO: Break this up into sub routers within the handlers.
 func (h *apiHandler) Route() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(instrument)
+	r.Use(h.auditHandler.Audit)
 
 	r.HandleFunc("/api/status", h.Status())
 
+	// Unauthenticated, for load balancers.
+	r.HandleFunc("/healthz", h.healthHandler.Healthz()).Methods("GET")
+	r.HandleFunc("/readyz", h.healthHandler.Readyz()).Methods("GET")
+
+	r.HandleFunc("/api/diagnostics",
+		h.midHandler.Permission(
+			auth.PermDiagnostics,
+			h.healthHandler.Diagnostics(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/admin/backup",
+		h.midHandler.Permission(
+			auth.PermBackup,
+			h.backupHandler.Backup(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/admin/export",
+		h.midHandler.Permission(
+			auth.PermBackup,
+			h.archiveHandler.Export(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/admin/import",
+		h.midHandler.Permission(
+			auth.PermBackup,
+			h.archiveHandler.Import(),
+		)).Methods("POST")
+
 	r.HandleFunc("/api/authenticate", h.authHandler.Authenticate()).Methods("POST")
 
+	r.HandleFunc("/api/audit",
+		h.midHandler.Permission(
+			auth.PermAuditRead,
+			h.auditHandler.GetAll(),
+		)).Methods("GET")
+
 	r.HandleFunc("/api/config/superadmin/{id}", h.configHandler.SuperAdmin()).Methods("POST")
 
 	// api/certificate
@@ -86,6 +149,18 @@ func (h *apiHandler) Route() *mux.Router {
 			h.certificateHandler.Get(),
 		)).Methods("GET")
 
+	r.HandleFunc("/api/certificate/{id}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.certificateHandler.Put(),
+		)).Methods("PUT")
+
+	r.HandleFunc("/api/certificate/import",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.certificateHandler.Import(),
+		)).Methods("POST")
+
 	r.HandleFunc("/api/certificate",
 		h.midHandler.Permission(
 			auth.PermCertAdmin,
@@ -116,10 +191,192 @@ func (h *apiHandler) Route() *mux.Router {
 		h.certificateHandler.GetIssuer(),
 	).Methods("GET")
 
+	r.HandleFunc("/api/certificate/{id}/bundle",
+		h.certificateHandler.GetBundle(),
+	).Methods("GET")
+
+	// Deployment agents authenticate with an install token.
+	r.HandleFunc("/api/certificate/{id}/install",
+		h.installHandler.Bundle(),
+	).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/install",
+		h.installHandler.Report(),
+	).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/installtoken",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.installHandler.CreateToken(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/installtoken/{token}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.installHandler.DeleteToken(),
+		)).Methods("DELETE")
+
 	r.HandleFunc("/api/certificate/{id}/renew",
 		h.certificateHandler.Renew(),
 	).Methods("POST")
 
+	r.HandleFunc("/api/certificate/{id}/dryrun",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.certificateHandler.DryRun(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/probe",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.certificateHandler.Probe(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/revoke",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.certificateHandler.Revoke(),
+		)).Methods("POST")
+
+	// Authenticates with either a JWT or the cert secret.
+	r.HandleFunc("/api/certificate/{id}/events",
+		h.eventsHandler.Events(),
+	).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/webhook",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.webhookHandler.GetAll(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/webhook",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.webhookHandler.Post(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/webhook/{hook}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.webhookHandler.Delete(),
+		)).Methods("DELETE")
+
+	r.HandleFunc("/api/certificate/{id}/webhook/{hook}/deliveries",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.webhookHandler.Deliveries(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/target",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.GetAll(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/target",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.Post(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/certificate/{id}/target/{target}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.Get(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/certificate/{id}/target/{target}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.Put(),
+		)).Methods("PUT")
+
+	r.HandleFunc("/api/certificate/{id}/target/{target}",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.Delete(),
+		)).Methods("DELETE")
+
+	r.HandleFunc("/api/certificate/{id}/target/{target}/deploy",
+		h.midHandler.Permission(
+			auth.PermCertAdmin,
+			h.deployHandler.Deploy(),
+		)).Methods("POST")
+
+	// api/notifier
+	r.HandleFunc("/api/notifier",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.GetAll(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/notifier",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.Post(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/notifier/{id}",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.Get(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/notifier/{id}",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.Put(),
+		)).Methods("PUT")
+
+	r.HandleFunc("/api/notifier/{id}",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.Delete(),
+		)).Methods("DELETE")
+
+	r.HandleFunc("/api/notifier/{id}/test",
+		h.midHandler.Permission(
+			auth.PermNotifierAdmin,
+			h.notifierHandler.Test(),
+		)).Methods("POST")
+
+	// api/script
+	r.HandleFunc("/api/script",
+		h.midHandler.Permission(
+			auth.PermScriptAdmin,
+			h.scriptHandler.GetAll(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/script",
+		h.midHandler.Permission(
+			auth.PermScriptAdmin,
+			h.scriptHandler.Post(),
+		)).Methods("POST")
+
+	r.HandleFunc("/api/script/{id}",
+		h.midHandler.Permission(
+			auth.PermScriptAdmin,
+			h.scriptHandler.Get(),
+		)).Methods("GET")
+
+	r.HandleFunc("/api/script/{id}",
+		h.midHandler.Permission(
+			auth.PermScriptAdmin,
+			h.scriptHandler.Put(),
+		)).Methods("PUT")
+
+	r.HandleFunc("/api/script/{id}",
+		h.midHandler.Permission(
+			auth.PermScriptAdmin,
+			h.scriptHandler.Delete(),
+		)).Methods("DELETE")
+
+	// Rendered deploy scripts authenticate with the cert secret in the
+	// query, so they can be fetched with a plain curl one-liner.
+	r.HandleFunc("/script/{id}",
+		h.scriptHandler.Render(),
+	).Methods("GET")
+
 	// api/challenge
 	r.HandleFunc("/api/challenge",
 		h.midHandler.Permission(
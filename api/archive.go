@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ImageWare/TLSential/archive"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// passphraseHeader carries the archive passphrase, so it stays out of URLs
+// and access logs.
+const passphraseHeader = "X-Archive-Passphrase"
+
+// Largest archive accepted for import.
+const maxArchiveSize = 64 << 20
+
+// ArchiveHandler provides export and import of portable archives.
+type ArchiveHandler interface {
+	Export() http.HandlerFunc
+	Import() http.HandlerFunc
+}
+
+type archiveHandler struct {
+	store *archive.Store
+}
+
+// NewArchiveHandler returns a working ArchiveHandler.
+func NewArchiveHandler(store *archive.Store) ArchiveHandler {
+	return &archiveHandler{store}
+}
+
+// Export returns an archive of the certs selected by label parameters, plus
+// all users, the challenge config and settings. private_keys=false leaves
+// out keys, and a passphrase header encrypts the archive.
+func (h *archiveHandler) Export() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		opts := &archive.ExportOptions{
+			Labels:        labelParams(v),
+			NoPrivateKeys: v.Get("private_keys") == "false",
+		}
+
+		a, err := archive.Export(h.store, opts)
+		if err == model.ErrInvalidLabels {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("api ArchiveHandler Export(), Export(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="tlsential-export-`+time.Now().UTC().Format("20060102T150405Z")+`.json"`)
+		err = archive.Encode(w, a, r.Header.Get(passphraseHeader))
+		if err != nil {
+			log.Printf("api ArchiveHandler Export(), Encode(), %s", err.Error())
+		}
+	}
+}
+
+// Import reads an archive from the body and saves the certs selected by
+// label parameters. conflict=skip|overwrite|rename says what to do with IDs
+// and usernames that already exist, and private_keys=false drops the
+// archive's keys, keeping those of certs it overwrites.
+func (h *archiveHandler) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		opts := &archive.ImportOptions{
+			Labels:        labelParams(v),
+			Conflict:      v.Get("conflict"),
+			NoPrivateKeys: v.Get("private_keys") == "false",
+		}
+
+		a, err := archive.Decode(http.MaxBytesReader(w, r.Body, maxArchiveSize), r.Header.Get(passphraseHeader))
+		if err == archive.ErrPassphraseRequired || err == archive.ErrWrongPassphrase {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := archive.Import(h.store, a, opts)
+		if err == model.ErrInvalidConflict || err == model.ErrInvalidLabels {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("api ArchiveHandler Import(), Import(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(res)
+		if err != nil {
+			log.Printf("api ArchiveHandler Import(), json.Encode(), %s", err.Error())
+		}
+	}
+}
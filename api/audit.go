@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/gorilla/mux"
+)
+
+// AuditHandler provides the api/audit endpoint and the middleware that
+// records API calls.
+type AuditHandler interface {
+	GetAll() http.HandlerFunc
+	Audit(next http.Handler) http.Handler
+}
+
+type auditHandler struct {
+	cs config.Service
+	as audit.Service
+}
+
+// NewAuditHandler returns a working AuditHandler.
+func NewAuditHandler(cs config.Service, as audit.Service) AuditHandler {
+	return &auditHandler{cs, as}
+}
+
+// GetAll returns audit entries, newest first, filtered by the actor, action,
+// target, since (RFC 3339) and limit query parameters.
+func (h *auditHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		q := &model.AuditQuery{
+			Actor:  v.Get("actor"),
+			Action: v.Get("action"),
+			Target: v.Get("target"),
+		}
+
+		if s := v.Get("since"); s != "" {
+			since, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			q.Since = since
+		}
+		if s := v.Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, model.ErrInvalidAuditLimit.Error(), http.StatusBadRequest)
+				return
+			}
+			q.Limit = limit
+		}
+		if err := q.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := h.as.Entries(q)
+		if err != nil {
+			log.Printf("api AuditHandler GetAll(), Entries(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(entries)
+		if err != nil {
+			log.Printf("api AuditHandler GetAll(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Audit is a middleware recording every mutating call and every download
+// that can include a private key. It has to run after routing so the route
+// template and vars are known.
+func (h *auditHandler) Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route, _ = cr.GetPathTemplate()
+		}
+		if !audited(r.Method, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{w, http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		vars := mux.Vars(r)
+		target := vars["hook"]
+		if target == "" {
+			target = vars["token"]
+		}
+		if target == "" {
+			target = vars["target"]
+		}
+		if target == "" {
+			target = vars["id"]
+		}
+		actorType, actor, claimed := h.actor(r, route, rec.code)
+		e := model.NewAuditEntry(actorType, actor, remoteIP(r), r.Method+" "+route, target, rec.code)
+		e.ClaimedActor = claimed
+		h.as.Record(e)
+	})
+}
+
+func audited(method, route string) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return true
+	}
+	return strings.HasSuffix(route, "/privkey") || strings.HasSuffix(route, "/bundle") || strings.HasSuffix(route, "/install") || route == "/api/admin/backup" || route == "/api/admin/export" || route == "/script/{id}"
+}
+
+// actor works out who made the request from its credentials and the status
+// they got. Credentials the handler didn't accept are recorded as
+// unauthenticated, with the name they claimed returned separately.
+func (h *auditHandler) actor(r *http.Request, route string, status int) (actorType, actor, claimed string) {
+	// Passwords are only checked by Authenticate, which succeeds with a
+	// token and nothing else.
+	if username, _, ok := r.BasicAuth(); ok {
+		if status < 200 || status > 299 {
+			return model.ActorUnauthenticated, "", username
+		}
+		return model.ActorUser, username, ""
+	}
+
+	if _, ok := getSecret(r); ok {
+		if status == http.StatusUnauthorized {
+			return model.ActorUnauthenticated, "", mux.Vars(r)["id"]
+		}
+		return model.ActorCertToken, mux.Vars(r)["id"], ""
+	}
+
+	// Install tokens start with their ID, which is all that's recorded.
+	// Scripts are fetched by URL, so theirs is in the query instead.
+	token, ok := getInstallToken(r)
+	if !ok && route == "/script/{id}" {
+		token = r.URL.Query().Get("token")
+		ok = token != ""
+	}
+	if ok {
+		id := strings.SplitN(token, ".", 2)[0]
+		if status == http.StatusUnauthorized {
+			return model.ActorUnauthenticated, "", id
+		}
+		return model.ActorInstallToken, id, ""
+	}
+
+	a := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(a) == 2 && a[0] == "Bearer" {
+		secret, err := h.cs.JWTSecret()
+		if err != nil {
+			return model.ActorUnauthenticated, "", ""
+		}
+		claims, err := secret.ValidateToken(a[1])
+		if err != nil {
+			return model.ActorUnauthenticated, "", ""
+		}
+		if sub, ok := claims["sub"].(string); ok {
+			return model.ActorUser, sub, ""
+		}
+		// Tokens minted before subjects were added only carry a role.
+		role, _ := claims["role"].(string)
+		return model.ActorUser, "role:" + role, ""
+	}
+
+	return model.ActorAnonymous, "", ""
+}
+
+// remoteIP returns the IP address the request came from.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -81,7 +81,7 @@ func (h *authHandler) Authenticate() http.HandlerFunc {
 			return
 		}
 
-		token, err := secret.Sign(u.Role)
+		token, err := secret.SignUser(u.Name, u.Role)
 		if err != nil {
 			log.Printf("Authenticate, Sign(), %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -104,11 +104,20 @@ func getSecret(r *http.Request) (secret string, ok bool) {
 }
 
 func parseSecretAuth(auth string) (secret string, ok bool) {
-	const prefix = "Secret "
+	return parseAuth(auth, "Secret ")
+}
+
+// getInstallToken returns the install token from an "Install" Authorization
+// header.
+func getInstallToken(r *http.Request) (token string, ok bool) {
+	return parseAuth(r.Header.Get("Authorization"), "Install ")
+}
 
+// parseAuth returns what follows prefix in an Authorization header.
+func parseAuth(auth, prefix string) (string, bool) {
 	// Case insensitive prefix match.
 	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
-		return
+		return "", false
 	}
 
 	return auth[len(prefix):], true
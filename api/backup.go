@@ -0,0 +1,49 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ImageWare/TLSential/backup"
+)
+
+// BackupHandler provides the database backup endpoint.
+type BackupHandler interface {
+	Backup() http.HandlerFunc
+}
+
+type backupHandler struct {
+	br backup.Repository
+}
+
+// NewBackupHandler returns a working BackupHandler.
+func NewBackupHandler(br backup.Repository) BackupHandler {
+	return &backupHandler{br}
+}
+
+// Backup streams a consistent snapshot of the whole database. It holds every
+// private key, so it's limited to super admins and always audited.
+func (h *backupHandler) Backup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+backup.Name(time.Now())+`"`)
+
+		n, err := h.br.Snapshot(w)
+		if err == backup.ErrNotSupported {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			log.Printf("api BackupHandler Backup(), Snapshot(), %s", err.Error())
+			// Once the snapshot has started the status is already sent,
+			// and a short body is all the client will see.
+			if n == 0 {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+}
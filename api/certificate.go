@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ImageWare/TLSential/acme"
 	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/bundle"
 	"github.com/ImageWare/TLSential/certificate"
 	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/probe"
 
 	"github.com/gorilla/mux"
 )
@@ -20,17 +25,41 @@ const CertFileExt = ".crt"
 const IssuerCertFileExt = ".issuer.crt"
 const KeyFileExt = ".key"
 const PemFileExt = ".pem"
+const DerFileExt = ".der"
+const PfxFileExt = ".pfx"
+const JksFileExt = ".jks"
+
+// bundlePasswordHeader carries the pfx and jks password, so it stays out of
+// URLs and access logs.
+const bundlePasswordHeader = "X-Bundle-Password"
+
+// bundleFiles maps each bundle format to its file extension and content type.
+var bundleFiles = map[string]struct {
+	ext         string
+	contentType string
+}{
+	bundle.FormatPEM: {PemFileExt, "application/x-pem-file"},
+	bundle.FormatDER: {DerFileExt, "application/pkix-cert"},
+	bundle.FormatPFX: {PfxFileExt, "application/x-pkcs12"},
+	bundle.FormatJKS: {JksFileExt, "application/x-java-keystore"},
+}
 
 type CertificateHandler interface {
 	GetAll() http.HandlerFunc
 	Get() http.HandlerFunc
 	Post() http.HandlerFunc
+	Put() http.HandlerFunc
 	Delete() http.HandlerFunc
 	DeleteAll() http.HandlerFunc
 	GetCert() http.HandlerFunc
 	GetPrivkey() http.HandlerFunc
 	GetIssuer() http.HandlerFunc
 	Renew() http.HandlerFunc
+	DryRun() http.HandlerFunc
+	Import() http.HandlerFunc
+	GetBundle() http.HandlerFunc
+	Probe() http.HandlerFunc
+	Revoke() http.HandlerFunc
 }
 
 type certHandler struct {
@@ -47,6 +76,40 @@ type CertReq struct {
 	Domains []string
 	Email   string
 	RenewAt int
+	Labels  map[string]string
+
+	// Endpoints are host:port services probed to verify deployment. Only
+	// Address and ServerName are read.
+	Endpoints []*model.Endpoint
+
+	// ValidateFirst runs a staging dry run before the real issuance is queued.
+	ValidateFirst bool
+}
+
+// CertUpdateReq is used for parsing changes to an existing certificate. Fields
+// left out of the payload are not changed.
+type CertUpdateReq struct {
+	Domains []string
+	Email   *string
+	RenewAt *int
+
+	// Labels replaces all labels when present. Send {} to clear them. The
+	// reserved managed-by and ingress-* labels can't be sent and are kept.
+	Labels map[string]string
+
+	// Endpoints replaces all endpoints when present. Send [] to clear them.
+	Endpoints []*model.Endpoint
+}
+
+// CertImportReq is used for parsing externally issued certificates uploaded via
+// the API. All fields are PEM encoded.
+type CertImportReq struct {
+	Certificate string
+	Chain       string
+	PrivateKey  string
+	RenewAt     int
+	Labels      map[string]string
+	Endpoints   []*model.Endpoint
 }
 
 // CertResp is used for exporting User data via API responses
@@ -55,14 +118,67 @@ type CertResp struct {
 	Secret        string
 	CommonName    string
 	Domains       []string
+	Labels        map[string]string
 	CertURL       string
 	CertStableURL string
 	Expiry        time.Time
 	RenewAt       int
 	Issued        bool
+	Version       int
+	Imported      bool
+	Revoked       bool
 	LastError     string
+	ValidateFirst bool
+	DryRun        *model.DryRun
 	ACMEEmail     string
 	ModTime       time.Time
+
+	// PendingDomains are requested but not issued yet.
+	PendingDomains []string
+
+	Endpoints        []*model.Endpoint
+	DeploymentStatus string
+	Installs         []*model.Install
+	InstallTokens    []*InstallTokenResp
+
+	// Details is only filled in when fetching a single certificate.
+	Details *model.CertificateDetails
+}
+
+// NewCertResp builds a CertResp from c, specifically leaving out Keys and
+// Certs.
+func NewCertResp(c *model.Certificate) *CertResp {
+	var lastError string
+	if c.LastError != nil {
+		lastError = c.LastError.Error()
+	}
+	return &CertResp{
+		ID:            c.ID,
+		Secret:        c.Secret,
+		CommonName:    c.CommonName,
+		Domains:       c.Domains,
+		Labels:        c.Labels,
+		CertURL:       c.CertURL,
+		CertStableURL: c.CertStableURL,
+		Expiry:        c.Expiry,
+		RenewAt:       c.RenewAt,
+		Issued:        c.Issued,
+		Version:       c.Version,
+		Imported:      c.Imported,
+		Revoked:       c.Revoked,
+		LastError:     lastError,
+		ValidateFirst: c.ValidateFirst,
+		DryRun:        c.DryRun,
+		ACMEEmail:     c.ACMEEmail,
+		ModTime:       c.ModTime,
+
+		PendingDomains: c.PendingDomains,
+
+		Endpoints:        c.Endpoints,
+		DeploymentStatus: c.DeploymentStatus(),
+		Installs:         c.Installs,
+		InstallTokens:    newInstallTokenResps(c.InstallTokens),
+	}
 }
 
 // TODO: Add validation function to make sure domains are actual domains.
@@ -116,41 +232,43 @@ func (h *certHandler) Delete() http.HandlerFunc {
 
 }
 
+// GetAll lists certificates a page at a time. See parseCertQuery for the
+// supported query parameters. The total number of matches is returned in
+// X-Total-Count, and a Link header points at the next page if there is one.
 func (h *certHandler) GetAll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		certs, err := h.cs.AllCerts()
+		q, err := parseCertQuery(r.URL.Query())
+		if err == nil {
+			err = q.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := h.cs.QueryCerts(q)
 		if err != nil {
-			log.Printf("api CertHandler Get(), GetAllCerts(), %s", err.Error())
+			log.Printf("api CertHandler Get(), QueryCerts(), %s", err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		var crs = make([]*CertResp, 0)
 
-		for _, c := range certs {
-			var lastError string
-			if c.LastError != nil {
-				lastError = c.LastError.Error()
-			}
-			cr := &CertResp{
-				ID:            c.ID,
-				Secret:        c.Secret,
-				CommonName:    c.CommonName,
-				Domains:       c.Domains,
-				CertURL:       c.CertURL,
-				CertStableURL: c.CertStableURL,
-				Expiry:        c.Expiry,
-				RenewAt:       c.RenewAt,
-				Issued:        c.Issued,
-				LastError:     lastError,
-				ACMEEmail:     c.ACMEEmail,
-				ModTime:       c.ModTime,
-			}
-			crs = append(crs, cr)
+		for _, c := range page.Certs {
+			crs = append(crs, NewCertResp(c))
 		}
 
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		if page.NextCursor != "" {
+			next := *r.URL
+			v := next.Query()
+			v.Set("cursor", page.NextCursor)
+			next.RawQuery = v.Encode()
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+		}
+		w.WriteHeader(http.StatusOK)
 
 		err = json.NewEncoder(w).Encode(crs)
 		if err != nil {
@@ -161,6 +279,68 @@ func (h *certHandler) GetAll() http.HandlerFunc {
 	}
 }
 
+// labelParams reads repeated label=key=value (or label=key for any value)
+// parameters, returning nil if there are none.
+func labelParams(v url.Values) map[string]string {
+	labels, ok := v["label"]
+	if !ok {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, l := range labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		} else {
+			m[kv[0]] = ""
+		}
+	}
+	return m
+}
+
+// parseCertQuery builds a certificate query from URL parameters:
+//
+//	label=key=value  repeatable; label=key only requires the key
+//	domain=sub       domain substring
+//	state=issued|failed|pending
+//	expiring=N       issued certs expiring within N days
+//	sort=created|name|expiry|modified
+//	order=asc|desc
+//	limit=N, cursor=...
+func parseCertQuery(v url.Values) (*model.CertificateQuery, error) {
+	q := &model.CertificateQuery{
+		Domain: v.Get("domain"),
+		State:  v.Get("state"),
+		Sort:   v.Get("sort"),
+		Cursor: v.Get("cursor"),
+	}
+
+	q.Labels = labelParams(v)
+
+	switch v.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if s := v.Get("expiring"); s != "" {
+		q.ExpiringWithin, err = strconv.Atoi(s)
+		if err != nil || q.ExpiringWithin <= 0 {
+			return nil, model.ErrInvalidExpiring
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit <= 0 {
+			return nil, model.ErrInvalidLimit
+		}
+	}
+	return q, nil
+}
+
 func (h *certHandler) Get() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -186,24 +366,15 @@ func (h *certHandler) Get() http.HandlerFunc {
 			return
 		}
 
-		// Make an appropriate response object (ie. pkey returned)
-		var lastError string
-		if c.LastError != nil {
-			lastError = c.LastError.Error()
-		}
-		cr := &CertResp{
-			ID:            c.ID,
-			Secret:        c.Secret,
-			CommonName:    c.CommonName,
-			Domains:       c.Domains,
-			CertURL:       c.CertURL,
-			CertStableURL: c.CertStableURL,
-			Expiry:        c.Expiry,
-			RenewAt:       c.RenewAt,
-			Issued:        c.Issued,
-			LastError:     lastError,
-			ACMEEmail:     c.ACMEEmail,
-			ModTime:       c.ModTime,
+		// Make an appropriate response object (ie. no pkey returned)
+		cr := NewCertResp(c)
+
+		// A broken stored cert is still returned, so it can be fixed or
+		// deleted.
+		cr.Details, err = c.Details()
+		if err != nil {
+			log.Printf("apiCertHandler GET, Details(), %s", err.Error())
+			cr.Details = nil
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -236,21 +407,41 @@ func (h *certHandler) Post() http.HandlerFunc {
 			return
 		}
 
+		// Validate everything before NewCertificate, which registers an ACME
+		// account.
+		if !model.ValidRenewAt(creq.RenewAt) {
+			http.Error(w, model.ErrInvalidRenewAt.Error(), http.StatusBadRequest)
+			return
+		}
+		if !model.ValidLabels(creq.Labels) {
+			http.Error(w, model.ErrInvalidLabels.Error(), http.StatusBadRequest)
+			return
+		}
+		if !model.ValidEndpoints(creq.Endpoints) {
+			http.Error(w, model.ErrInvalidEndpoint.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Create new Certificate obj.
-		// TODO: Not all errors are Server Errors.
 		c, err := model.NewCertificate(creq.Domains, creq.Email)
+		if err == model.ErrInvalidDomains || err == model.ErrInvalidEmail {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			log.Printf("api CertHandler POST, NewCertificate(), %s", err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		//TODO: Should probably decide valid range for client supplied RenewAt value
-		//For instance we may not want them to be able to specify 0 or less, as that would
-		//cause the cert to never auto renew. Although maybe thats a valid use case?
-		//We may also not want them to be able to specify a time as long or longer than the certs lifetime
-		//as that would cause to autorenew every time autoRenewal is run.
+		err = c.SetEndpoints(creq.Endpoints)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		c.RenewAt = creq.RenewAt
+		c.Labels = creq.Labels
+		c.ValidateFirst = creq.ValidateFirst
 
 		// Save to database
 		err = h.cs.SaveCert(c)
@@ -261,25 +452,17 @@ func (h *certHandler) Post() http.HandlerFunc {
 		}
 
 		//We're not using RequestIssue because we always want this request to go through even if the
-		//channel buffers are full.
-		go func(id string) { h.acme.GetIssueChannel() <- id }(c.ID)
+		//channel buffers are full. A dry run queues the issuance itself once
+		//staging succeeds.
+		if c.ValidateFirst {
+			go func(id string) { h.acme.GetDryRunChannel() <- id }(c.ID)
+		} else {
+			go func(id string) { h.acme.GetIssueChannel() <- id }(c.ID)
+		}
 
 		// Build a response obj to return, specifically leaving out
 		// Keys and Certs
-		cresp := &CertResp{
-			ID:            c.ID,
-			Secret:        c.Secret,
-			CommonName:    c.CommonName,
-			Domains:       c.Domains,
-			CertURL:       c.CertURL,
-			CertStableURL: c.CertStableURL,
-			Expiry:        c.Expiry,
-			RenewAt:       c.RenewAt,
-			Issued:        c.Issued,
-			LastError:     "",
-			ACMEEmail:     c.ACMEEmail,
-			ModTime:       c.ModTime,
-		}
+		cresp := NewCertResp(c)
 
 		w.WriteHeader(http.StatusCreated)
 		w.Header().Set("Content-Type", "application/json")
@@ -293,6 +476,121 @@ func (h *certHandler) Post() http.HandlerFunc {
 	}
 }
 
+// Put handles changes to the domains, ACME email and RenewAt of an existing
+// certificate. A change to the domain set queues a fresh issuance; the current
+// certificate keeps being served until it succeeds.
+func (h *certHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, err := h.cs.Cert(id)
+		if err != nil {
+			log.Printf("api CertHandler PUT, GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		ureq := &CertUpdateReq{}
+		err = json.NewDecoder(r.Body).Decode(ureq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if ureq.RenewAt != nil && !model.ValidRenewAt(*ureq.RenewAt) {
+			http.Error(w, model.ErrInvalidRenewAt.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !model.ValidLabels(ureq.Labels) {
+			http.Error(w, model.ErrInvalidLabels.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if c.Imported && (ureq.Domains != nil || ureq.Email != nil) {
+			http.Error(w, "imported certificates can only change RenewAt, Labels and Endpoints", http.StatusBadRequest)
+			return
+		}
+
+		if ureq.Email != nil && !model.ValidEmail(*ureq.Email) {
+			http.Error(w, model.ErrInvalidEmail.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Everything is validated before UpdateEmail changes the ACME
+		// account, so a rejected request changes nothing.
+		reissue := false
+		if ureq.Domains != nil {
+			reissue, err = c.SetDomains(ureq.Domains)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if ureq.Endpoints != nil {
+			err = c.SetEndpoints(ureq.Endpoints)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if ureq.RenewAt != nil {
+			c.RenewAt = *ureq.RenewAt
+		}
+		if ureq.Labels != nil {
+			c.SetLabels(ureq.Labels)
+		}
+
+		if ureq.Email != nil && *ureq.Email != c.ACMEEmail {
+			err = h.acme.UpdateEmail(c, *ureq.Email)
+			if err == model.ErrInvalidEmail {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("api CertHandler PUT, UpdateEmail(), %s", err.Error())
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+
+		err = h.cs.SaveSettings(c)
+		if err != nil {
+			log.Printf("api CertHandler PUT, SaveSettings(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Renew would reuse the old key for the new names, so go through a
+		// full issuance instead. Trigger only replaces the stored cert once
+		// the new one has been minted.
+		if reissue {
+			go func(id string) { h.acme.GetIssueChannel() <- id }(c.ID)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+
+		err = json.NewEncoder(w).Encode(NewCertResp(c))
+		if err != nil {
+			log.Printf("apiCertHandler PUT, json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 // TODO: Refactor GetCert, GetIssuer, and GetPrivkey as they do almost the exact
 // same things.
 
@@ -412,11 +710,22 @@ func (h *certHandler) GetPrivkey() http.HandlerFunc {
 			return
 		}
 
+		// Imported certs may have been uploaded without their key.
+		if len(c.PrivateKey) == 0 {
+			http.Error(w, "certificate has no private key", http.StatusNotFound)
+			return
+		}
+
 		// Secrets are one time use for downloading PrivKeys.
+		old := c.Secret
 		c.Secret = auth.NewPassword()
-		err = h.cs.SaveCert(c)
+		err = h.cs.SaveSecret(c, old)
+		if err == certificate.ErrSecretUsed {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
-			log.Printf("apiCertHandler GET PrivKey, SaveCert(), %s", err.Error())
+			log.Printf("apiCertHandler GET PrivKey, SaveSecret(), %s", err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -430,6 +739,137 @@ func (h *certHandler) GetPrivkey() http.HandlerFunc {
 	}
 }
 
+// /api/certificate/{id}/bundle?format=pfx|der|pem|jks
+//
+// pfx and jks output is protected with the password from the
+// X-Bundle-Password header, which they require. pfx and jks always include
+// the private key, and pem includes it when key=true, in
+// which case the request must carry the certificate secret just like
+// /privkey. pfx uses modern encryption unless legacy=true is given for older
+// Windows and Java versions.
+func (h *certHandler) GetBundle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		if id == "" {
+			log.Printf("api CertHandler GetBundle, should never have routed here")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		q := r.URL.Query()
+		format := strings.ToLower(q.Get("format"))
+		if format == "" {
+			format = bundle.FormatPEM
+		}
+		if !bundle.ValidFormat(format) {
+			http.Error(w, bundle.ErrUnknownFormat.Error(), http.StatusBadRequest)
+			return
+		}
+		password := r.Header.Get(bundlePasswordHeader)
+		if bundle.IncludesKey(format) && password == "" {
+			http.Error(w, bundle.ErrEmptyPassword.Error(), http.StatusBadRequest)
+			return
+		}
+		includeKey := bundle.IncludesKey(format) || (format == bundle.FormatPEM && q.Get("key") == "true")
+
+		// Return cert if found
+		c, err := h.cs.Cert(id)
+		if err != nil {
+			log.Printf("apiCertHandler GET, GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		if !c.Issued {
+			http.Error(w, "certificate not issued", http.StatusBadRequest)
+			return
+		}
+
+		if includeKey {
+			secret, ok := getSecret(r)
+			if !ok || secret != c.Secret {
+				// https://tools.ietf.org/html/rfc7235#section-3.1
+				w.Header().Set("WWW-Authenticate", "Secret")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if len(c.PrivateKey) == 0 {
+				http.Error(w, "certificate has no private key", http.StatusNotFound)
+				return
+			}
+		}
+
+		chain, err := bundle.Chain(c.Certificate, c.IssuerCertificate)
+		if err != nil {
+			log.Printf("apiCertHandler GET Bundle, Chain(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var out []byte
+		switch format {
+		case bundle.FormatDER:
+			out = bundle.DER(chain)
+		case bundle.FormatPEM:
+			var key []byte
+			if includeKey {
+				key = c.PrivateKey
+			}
+			out = bundle.FullChainPEM(chain, key)
+		case bundle.FormatPFX, bundle.FormatJKS:
+			key, err := bundle.PrivateKey(c.PrivateKey)
+			if err != nil {
+				log.Printf("apiCertHandler GET Bundle, PrivateKey(), %s", err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if format == bundle.FormatPFX {
+				out, err = bundle.PKCS12(chain, key, password, q.Get("legacy") == "true")
+			} else {
+				out, err = bundle.JKS(chain, key, password, c.CommonName)
+			}
+			if err != nil {
+				log.Printf("apiCertHandler GET Bundle, encode %s, %s", format, err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if includeKey {
+			// Secrets are one time use for downloading PrivKeys.
+			old := c.Secret
+			c.Secret = auth.NewPassword()
+			err = h.cs.SaveSecret(c, old)
+			if err == certificate.ErrSecretUsed {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Printf("apiCertHandler GET Bundle, SaveSecret(), %s", err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		f := bundleFiles[format]
+		modtime := c.ModTime
+		filename := fmt.Sprintf("%s%s", c.CommonName, f.ext)
+		cd := fmt.Sprintf("attachment; filename=%s", filename)
+
+		w.Header().Add("Content-Disposition", cd)
+		w.Header().Set("Content-Type", f.contentType)
+		http.ServeContent(w, r, filename, modtime, bytes.NewReader(out))
+	}
+}
+
 func (h *certHandler) Renew() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -467,6 +907,11 @@ func (h *certHandler) Renew() http.HandlerFunc {
 			return
 		}
 
+		if c.Imported {
+			http.Error(w, "imported certificates can't be renewed", http.StatusBadRequest)
+			return
+		}
+
 		if !h.acme.RequestRenew(c.ID) {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
@@ -475,3 +920,179 @@ func (h *certHandler) Renew() http.HandlerFunc {
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
+
+// /api/certificate/{id}/dryrun
+func (h *certHandler) DryRun() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		if id == "" {
+			log.Printf("api CertHandler DryRun(), should never have routed here")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		c, err := h.cs.Cert(id)
+		if err != nil {
+			log.Printf("api CertHandler DryRun(), GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		if c.Imported {
+			http.Error(w, "imported certificates can't be issued via ACME", http.StatusBadRequest)
+			return
+		}
+
+		if !h.acme.RequestDryRun(c.ID) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// /api/certificate/import
+func (h *certHandler) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ireq := &CertImportReq{
+			RenewAt: model.DefaultRenewAt,
+		}
+		err := json.NewDecoder(r.Body).Decode(ireq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, err := model.NewImportedCertificate([]byte(ireq.Certificate), []byte(ireq.Chain), []byte(ireq.PrivateKey))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !model.ValidRenewAt(ireq.RenewAt) {
+			http.Error(w, model.ErrInvalidRenewAt.Error(), http.StatusBadRequest)
+			return
+		}
+		if !model.ValidLabels(ireq.Labels) {
+			http.Error(w, model.ErrInvalidLabels.Error(), http.StatusBadRequest)
+			return
+		}
+		err = c.SetEndpoints(ireq.Endpoints)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.RenewAt = ireq.RenewAt
+		c.Labels = ireq.Labels
+
+		err = h.cs.SaveCert(c)
+		if err != nil {
+			log.Printf("api CertHandler Import, SaveCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Content-Type", "application/json")
+
+		err = json.NewEncoder(w).Encode(NewCertResp(c))
+		if err != nil {
+			log.Printf("apiCertHandler Import, json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// /api/certificate/{id}/probe
+//
+// Probe checks the certificate's endpoints right away instead of waiting for
+// the next background run, and returns the certificate with the results.
+func (h *certHandler) Probe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		c, err := h.cs.Cert(id)
+		if err != nil {
+			log.Printf("api CertHandler Probe(), GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		if !c.Issued {
+			http.Error(w, "certificate not issued", http.StatusBadRequest)
+			return
+		}
+
+		c.UpdateEndpoints(probe.Check(c, probe.DefaultTimeout))
+
+		err = h.cs.SaveEndpoints(c)
+		if err != nil {
+			log.Printf("api CertHandler Probe(), SaveEndpoints(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(NewCertResp(c))
+		if err != nil {
+			log.Printf("apiCertHandler Probe, json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// /api/certificate/{id}/revoke
+func (h *certHandler) Revoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		c, err := h.cs.Cert(id)
+		if err != nil {
+			log.Printf("api CertHandler Revoke(), GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err = h.acme.Revoke(c)
+		if err == acme.ErrImported || err == acme.ErrNotIssued {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("api CertHandler Revoke(), Revoke(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
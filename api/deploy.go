@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/gorilla/mux"
+)
+
+// DeployHandler provides endpoints for api/certificate/{id}/target calls.
+type DeployHandler interface {
+	GetAll() http.HandlerFunc
+	Get() http.HandlerFunc
+	Post() http.HandlerFunc
+	Put() http.HandlerFunc
+	Delete() http.HandlerFunc
+	Deploy() http.HandlerFunc
+}
+
+type deployHandler struct {
+	cs certificate.Service
+	ds deploy.Service
+}
+
+// NewDeployHandler returns a working DeployHandler.
+func NewDeployHandler(cs certificate.Service, ds deploy.Service) DeployHandler {
+	return &deployHandler{cs, ds}
+}
+
+// DeployTargetReq is used for parsing API input.
+type DeployTargetReq struct {
+	Type       string
+	SSH        *model.SSHTarget
+	Kubernetes *model.KubernetesTarget
+	Vault      *model.VaultTarget
+}
+
+// cert returns the {id} cert, writing a 404 or 500 if it can't be found.
+func (h *deployHandler) cert(w http.ResponseWriter, r *http.Request) *model.Certificate {
+	c, err := h.cs.Cert(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("api DeployHandler, GetCert(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil
+	}
+	return c
+}
+
+// target returns the {target} deploy target if it belongs to the {id} cert,
+// writing a 404 or 500 otherwise.
+func (h *deployHandler) target(w http.ResponseWriter, r *http.Request) *model.DeployTarget {
+	vars := mux.Vars(r)
+	t, err := h.ds.Target(vars["target"])
+	if err != nil {
+		log.Printf("api DeployHandler, Target(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if t == nil || t.CertID != vars["id"] {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil
+	}
+	return t
+}
+
+// decode reads a DeployTargetReq into t and validates it, writing a 400 and
+// returning false if it's no good.
+func (h *deployHandler) decode(w http.ResponseWriter, r *http.Request, t *model.DeployTarget) bool {
+	if r.Body == nil {
+		http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+		return false
+	}
+	req := &DeployTargetReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	t.Type = req.Type
+	t.SSH = req.SSH
+	t.Kubernetes = req.Kubernetes
+	t.Vault = req.Vault
+	err = t.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeTarget(w http.ResponseWriter, status int, v interface{}, method string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("api DeployHandler %s(), json.Encode(), %s", method, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *deployHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.cert(w, r) == nil {
+			return
+		}
+
+		targets, err := h.ds.Targets(mux.Vars(r)["id"])
+		if err != nil {
+			log.Printf("api DeployHandler GetAll(), Targets(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTarget(w, http.StatusOK, targets, "GetAll")
+	}
+}
+
+func (h *deployHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := h.target(w, r)
+		if t == nil {
+			return
+		}
+		writeTarget(w, http.StatusOK, t, "Get")
+	}
+}
+
+// Post adds a target. If the cert has been issued its current version is
+// pushed on the next run.
+func (h *deployHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := h.cert(w, r)
+		if c == nil {
+			return
+		}
+
+		t := model.NewDeployTarget(c.ID)
+		if !h.decode(w, r, t) {
+			return
+		}
+		if c.Version > 0 {
+			t.Schedule(c.Version)
+		}
+
+		err := h.ds.SaveTarget(t)
+		if err != nil {
+			log.Printf("api DeployHandler Post(), SaveTarget(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTarget(w, http.StatusCreated, t, "Post")
+	}
+}
+
+// Put replaces a target's settings and pushes the current version to it
+// again, since the files or host may have changed.
+func (h *deployHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := h.cert(w, r)
+		if c == nil {
+			return
+		}
+		t := h.target(w, r)
+		if t == nil {
+			return
+		}
+
+		if !h.decode(w, r, t) {
+			return
+		}
+		if c.Version > 0 {
+			t.Schedule(c.Version)
+		}
+
+		err := h.ds.SaveTarget(t)
+		if err != nil {
+			log.Printf("api DeployHandler Put(), SaveTarget(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTarget(w, http.StatusOK, t, "Put")
+	}
+}
+
+func (h *deployHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := h.target(w, r)
+		if t == nil {
+			return
+		}
+
+		err := h.ds.DeleteTarget(t.ID)
+		if err != nil {
+			log.Printf("api DeployHandler Delete(), DeleteTarget(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Deploy queues the current version for the target and returns 202 with the
+// pending target. The push happens in the background, so poll the target
+// until its Status is no longer pending; failures are retried with backoff.
+func (h *deployHandler) Deploy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := h.cert(w, r)
+		if c == nil {
+			return
+		}
+		t := h.target(w, r)
+		if t == nil {
+			return
+		}
+		if c.Version == 0 {
+			http.Error(w, "certificate hasn't been issued yet", http.StatusConflict)
+			return
+		}
+
+		t, err := h.ds.Deploy(t.ID)
+		if err == deploy.ErrBusy {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("api DeployHandler Deploy(), Deploy(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTarget(w, http.StatusAccepted, t, "Deploy")
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/events"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/gorilla/mux"
+)
+
+// Both waits outlast the server's WriteTimeout, so the handler moves its own
+// write deadline along instead, never more than eventWriteTimeout past the
+// next write it expects. SSE clients reconnect on their own when the stream
+// ends.
+const longPollTimeout = 25 * time.Second
+const eventStreamTimeout = 10 * time.Minute
+const eventStreamKeepalive = 15 * time.Second
+const eventWriteTimeout = 10 * time.Second
+
+// EventsHandler provides the api/certificate/{id}/events endpoint.
+type EventsHandler interface {
+	Events() http.HandlerFunc
+}
+
+type eventsHandler struct {
+	cs  certificate.Service
+	bus *events.Bus
+	mh  MiddlewareHandler
+}
+
+// NewEventsHandler returns a working EventsHandler.
+func NewEventsHandler(cs certificate.Service, bus *events.Bus, mh MiddlewareHandler) EventsHandler {
+	return &eventsHandler{cs, bus, mh}
+}
+
+// Events streams a certificate's events as Server-Sent Events, or with
+// ?since=version waits for a single event and returns it as JSON. Requests
+// are authenticated with either a JWT or the certificate's secret.
+func (h *eventsHandler) Events() http.HandlerFunc {
+	withJWT := h.mh.Permission(auth.PermCertAdmin, h.serve)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := getSecret(r)
+		if !ok {
+			withJWT(w, r)
+			return
+		}
+
+		c, err := h.cs.Cert(mux.Vars(r)["id"])
+		if err != nil {
+			log.Printf("api EventsHandler Events(), GetCert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if c == nil || secret != c.Secret {
+			// https://tools.ietf.org/html/rfc7235#section-3.1
+			w.Header().Set("WWW-Authenticate", "Secret")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h.serve(w, r)
+	}
+}
+
+func (h *eventsHandler) serve(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var since int
+	_, longPoll := r.URL.Query()["since"]
+	if longPoll {
+		var err error
+		since, err = strconv.Atoi(r.URL.Query().Get("since"))
+		if err != nil || since < 0 {
+			http.Error(w, "since must be a certificate version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Subscribe before reading the cert so nothing is missed in between.
+	ch, unsubscribe := h.bus.Subscribe(id)
+	defer unsubscribe()
+
+	c, err := h.cs.Cert(id)
+	if err != nil {
+		log.Printf("api EventsHandler serve(), GetCert(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if longPoll {
+		h.longPoll(w, r, rc, c, since, ch)
+		return
+	}
+	h.stream(w, r, rc, c, ch)
+}
+
+// extendDeadline gives the response until d from now, plus eventWriteTimeout
+// to write.
+func extendDeadline(rc *http.ResponseController, d time.Duration) error {
+	return rc.SetWriteDeadline(time.Now().Add(d + eventWriteTimeout))
+}
+
+// longPoll answers straight away if a version newer than since exists, and
+// otherwise waits for the next event. It returns 204 if nothing happened.
+func (h *eventsHandler) longPoll(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, c *model.Certificate, since int, ch <-chan *model.Event) {
+	var e *model.Event
+	if c.Version > since {
+		e = stateEvent(c)
+	} else {
+		err := extendDeadline(rc, longPollTimeout)
+		if err != nil {
+			log.Printf("api EventsHandler longPoll(), SetWriteDeadline(), %s", err.Error())
+			http.Error(w, "long polling unsupported", http.StatusInternalServerError)
+			return
+		}
+		select {
+		case e = <-ch:
+		case <-time.After(longPollTimeout):
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(e)
+	if err != nil {
+		log.Printf("api EventsHandler longPoll(), json.Encode(), %s", err.Error())
+	}
+}
+
+// stream sends the current state of c followed by every event until the
+// client goes away or the stream times out.
+func (h *eventsHandler) stream(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, c *model.Certificate, ch <-chan *model.Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	err := extendDeadline(rc, eventStreamKeepalive)
+	if err != nil {
+		log.Printf("api EventsHandler stream(), SetWriteDeadline(), %s", err.Error())
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if writeSSE(w, stateEvent(c)) != nil {
+		return
+	}
+	flusher.Flush()
+
+	timeout := time.After(eventStreamTimeout)
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			if writeSSE(w, e) != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+		// A client that stops reading is cut off once the deadline passes.
+		if extendDeadline(rc, eventStreamKeepalive) != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes e as a Server-Sent Event named after its type, with the
+// certificate version as the event ID.
+func writeSSE(w http.ResponseWriter, e *model.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Version, e.Type, data)
+	return err
+}
+
+// stateEvent describes where c currently stands: revoked, issued, failed, or
+// still being issued.
+func stateEvent(c *model.Certificate) *model.Event {
+	switch {
+	case c.Revoked:
+		return model.NewEvent(model.EventRevoked, c, "The certificate is revoked.", nil)
+	case c.Issued:
+		return model.NewEvent(model.EventIssued, c, "The certificate is issued.", nil)
+	case c.LastError != nil:
+		return model.NewEvent(model.EventFailed, c, "Issuing the certificate failed.", c.LastError)
+	default:
+		return model.NewEvent(model.EventIssuing, c, "The certificate hasn't been issued yet.", nil)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// The renewal queue is considered wedged if certs have been waiting this long
+// without a worker picking one up. Issuing can take several minutes while DNS
+// propagates, so this is generous.
+const queueWedgedAfter = 30 * time.Minute
+
+// HealthHandler provides the health, readiness and diagnostics endpoints.
+type HealthHandler interface {
+	Healthz() http.HandlerFunc
+	Readyz() http.HandlerFunc
+	Diagnostics() http.HandlerFunc
+}
+
+type healthHandler struct {
+	version string
+	hr      health.Repository
+	as      acme.Service
+	cs      certificate.Service
+	chs     challenge_config.Service
+}
+
+// NewHealthHandler returns a working HealthHandler.
+func NewHealthHandler(version string, hr health.Repository, as acme.Service, cs certificate.Service, chs challenge_config.Service) HealthHandler {
+	return &healthHandler{version, hr, as, cs, chs}
+}
+
+// ReadyResp lists each readiness check with "ok" or what's wrong.
+type ReadyResp struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+// Healthz only says the process is up and serving.
+func (h *healthHandler) Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// Readyz checks the database can be read, the renewal workers are running
+// and the queue is moving. It returns 503 if any check fails.
+func (h *healthHandler) Readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &ReadyResp{Ready: true, Checks: make(map[string]string)}
+		fail := func(check, msg string) {
+			resp.Ready = false
+			resp.Checks[check] = msg
+		}
+
+		resp.Checks["db"] = "ok"
+		if err := h.hr.Ping(); err != nil {
+			fail("db", err.Error())
+		}
+
+		resp.Checks["workers"] = "ok"
+		if workers, _ := health.Workers(); workers < 1 {
+			fail("workers", "no renewal workers running")
+		}
+
+		resp.Checks["queue"] = "ok"
+		if health.Wedged(len(h.as.GetAutoRenewChannel()), queueWedgedAfter, time.Now()) {
+			fail("queue", "renewal queue hasn't moved in "+queueWedgedAfter.String())
+		}
+
+		code := http.StatusOK
+		if !resp.Ready {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("api HealthHandler Readyz(), json.Encode(), %s", err.Error())
+		}
+	}
+}
+
+// Diagnostics reports database, queue and scan state, whether the DNS
+// provider config works, and which certs are failing.
+func (h *healthHandler) Diagnostics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := h.hr.Stats()
+		if err != nil {
+			log.Printf("api HealthHandler Diagnostics(), Stats(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		certs, err := h.cs.AllCerts()
+		if err != nil {
+			log.Printf("api HealthHandler Diagnostics(), AllCerts(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d := &model.Diagnostics{
+			Version:       h.version,
+			DB:            stats,
+			QueueLength:   len(h.as.GetAutoRenewChannel()),
+			QueueCapacity: cap(h.as.GetAutoRenewChannel()),
+			LastScan:      health.LastScan(),
+			FailingCerts:  make([]*model.FailingCert, 0),
+		}
+		d.Workers, d.LastDequeue = health.Workers()
+
+		d.DNSProviderValid = true
+		if err := h.chs.CheckDNSProvider(); err != nil {
+			d.DNSProviderValid = false
+			d.DNSProviderError = err.Error()
+		}
+
+		for _, c := range certs {
+			if c.LastError != nil {
+				d.FailingCerts = append(d.FailingCerts, &model.FailingCert{
+					ID:         c.ID,
+					CommonName: c.CommonName,
+					Error:      c.LastError.Error(),
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(d)
+		if err != nil {
+			log.Printf("api HealthHandler Diagnostics(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/gorilla/mux"
+)
+
+// InstallHandler provides the endpoints used by deployment agents and deploy
+// scripts, which authenticate with an install token, and the ones admins
+// manage those tokens with.
+type InstallHandler interface {
+	Bundle() http.HandlerFunc
+	Report() http.HandlerFunc
+	CreateToken() http.HandlerFunc
+	DeleteToken() http.HandlerFunc
+}
+
+type installHandler struct {
+	cs certificate.Service
+}
+
+// NewInstallHandler returns a working InstallHandler.
+func NewInstallHandler(cs certificate.Service) InstallHandler {
+	return &installHandler{cs}
+}
+
+// InstallTokenReq is used for parsing API input.
+type InstallTokenReq struct {
+	// Name says which host the token is for.
+	Name string
+}
+
+// InstallTokenResp is used for exporting install tokens. The Token is only
+// included when it's created.
+type InstallTokenResp struct {
+	ID      string
+	Name    string
+	Token   string `json:",omitempty"`
+	Created time.Time
+}
+
+func newInstallTokenResp(t *model.InstallToken) *InstallTokenResp {
+	return &InstallTokenResp{
+		ID:      t.ID,
+		Name:    t.Name,
+		Created: t.Created,
+	}
+}
+
+func newInstallTokenResps(tokens []*model.InstallToken) []*InstallTokenResp {
+	trs := make([]*InstallTokenResp, 0, len(tokens))
+	for _, t := range tokens {
+		trs = append(trs, newInstallTokenResp(t))
+	}
+	return trs
+}
+
+// certByToken returns the cert named in the route if the request carries one
+// of its install tokens, and otherwise writes an error and returns nil.
+func (h *installHandler) certByToken(w http.ResponseWriter, r *http.Request) *model.Certificate {
+	c, err := h.cs.Cert(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("api InstallHandler certByToken(), Cert(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	token, ok := getInstallToken(r)
+	if c == nil || !ok || c.InstallToken(token) == nil {
+		// https://tools.ietf.org/html/rfc7235#section-3.1
+		w.Header().Set("WWW-Authenticate", "Install")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil
+	}
+	return c
+}
+
+// Bundle returns the cert, chain and key as an InstallBundle, or 204 if the
+// agent already has the current version given by ?version=N. Install tokens
+// aren't used up, so a failed download can simply be tried again.
+//
+// With ?format=pem it returns the key, cert and chain as PEM instead, with
+// the version in the X-Certificate-Version header, for deploy scripts that
+// can't parse JSON.
+//
+// /api/certificate/{id}/install
+func (h *installHandler) Bundle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		have := 0
+		if v := r.URL.Query().Get("version"); v != "" {
+			var err error
+			have, err = strconv.Atoi(v)
+			if err != nil || have < 0 {
+				http.Error(w, "version must be a certificate version", http.StatusBadRequest)
+				return
+			}
+		}
+
+		c := h.certByToken(w, r)
+		if c == nil {
+			return
+		}
+
+		if !c.Issued {
+			http.Error(w, "certificate not issued", http.StatusBadRequest)
+			return
+		}
+		if len(c.PrivateKey) == 0 {
+			http.Error(w, "certificate has no private key", http.StatusNotFound)
+			return
+		}
+		if c.Version <= have {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.URL.Query().Get("format") == "pem" {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Header().Set("X-Certificate-Version", strconv.Itoa(c.Version))
+			w.WriteHeader(http.StatusOK)
+			w.Write(c.PrivateKey)
+			w.Write(c.Certificate)
+			w.Write(c.IssuerCertificate)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err := json.NewEncoder(w).Encode(model.NewInstallBundle(c))
+		if err != nil {
+			log.Printf("api InstallHandler Bundle(), json.Encode(), %s", err.Error())
+		}
+	}
+}
+
+// Report records the outcome of an install on one host.
+//
+// /api/certificate/{id}/install
+func (h *installHandler) Report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := h.certByToken(w, r)
+		if c == nil {
+			return
+		}
+
+		i := &model.Install{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(i)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := i.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		i.Time = time.Now()
+
+		err = h.cs.SaveInstall(c, i)
+		if err != nil {
+			log.Printf("api InstallHandler Report(), SaveInstall(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if i.Status != model.InstallOK {
+			log.Printf("Install of certificate %s version %d on %s %s: %s", c.ID, i.Version, i.Host, i.Status, i.Error)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateToken adds an install token for one host, returning it the only time
+// it's available.
+//
+// /api/certificate/{id}/installtoken
+func (h *installHandler) CreateToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, err := h.cs.Cert(mux.Vars(r)["id"])
+		if err != nil {
+			log.Printf("api InstallHandler CreateToken(), Cert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		req := &InstallTokenReq{}
+		err = json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		t, value, err := model.NewInstallToken(req.Name)
+		if err == nil {
+			err = c.AddInstallToken(t)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = h.cs.SaveInstallTokens(c)
+		if err != nil {
+			log.Printf("api InstallHandler CreateToken(), SaveInstallTokens(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := newInstallTokenResp(t)
+		resp.Token = value
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("api InstallHandler CreateToken(), json.Encode(), %s", err.Error())
+		}
+	}
+}
+
+// DeleteToken revokes an install token.
+//
+// /api/certificate/{id}/installtoken/{token}
+func (h *installHandler) DeleteToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		c, err := h.cs.Cert(vars["id"])
+		if err != nil {
+			log.Printf("api InstallHandler DeleteToken(), Cert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if c == nil || !c.RemoveInstallToken(vars["token"]) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err = h.cs.SaveInstallTokens(c)
+		if err != nil {
+			log.Printf("api InstallHandler DeleteToken(), SaveInstallTokens(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
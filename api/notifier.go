@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/service"
+	"github.com/gorilla/mux"
+)
+
+// NotifierHandler provides endpoints for all api/notifier calls.
+type NotifierHandler interface {
+	GetAll() http.HandlerFunc
+	Get() http.HandlerFunc
+	Post() http.HandlerFunc
+	Put() http.HandlerFunc
+	Delete() http.HandlerFunc
+	Test() http.HandlerFunc
+}
+
+type notifierHandler struct {
+	ns notifier.Service
+}
+
+// NewNotifierHandler takes a notifier.Service and returns a working
+// NotifierHandler.
+func NewNotifierHandler(ns notifier.Service) NotifierHandler {
+	return &notifierHandler{ns}
+}
+
+// NotifierReq is used for parsing API input. Password is left unchanged on
+// PUT if it's empty.
+type NotifierReq struct {
+	Name string
+	Type string
+	URL  string
+
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	Events []string
+	Labels map[string]string
+}
+
+// NotifierResp is used for exporting notifiers, leaving out the SMTP password.
+type NotifierResp struct {
+	ID   string
+	Name string
+	Type string
+	URL  string
+
+	Host     string
+	Port     int
+	Username string
+	From     string
+	To       []string
+
+	Events []string
+	Labels map[string]string
+}
+
+func newNotifierResp(n *model.Notifier) *NotifierResp {
+	return &NotifierResp{
+		ID:       n.ID,
+		Name:     n.Name,
+		Type:     n.Type,
+		URL:      n.URL,
+		Host:     n.Host,
+		Port:     n.Port,
+		Username: n.Username,
+		From:     n.From,
+		To:       n.To,
+		Events:   n.Events,
+		Labels:   n.Labels,
+	}
+}
+
+// apply copies the request onto n.
+func (req *NotifierReq) apply(n *model.Notifier) {
+	n.Name = req.Name
+	n.Type = req.Type
+	n.URL = req.URL
+	n.Host = req.Host
+	n.Port = req.Port
+	n.Username = req.Username
+	if req.Password != "" {
+		n.Password = req.Password
+	}
+	n.From = req.From
+	n.To = req.To
+	n.Events = req.Events
+	n.Labels = req.Labels
+}
+
+func (h *notifierHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		notifiers, err := h.ns.AllNotifiers()
+		if err != nil {
+			log.Printf("api NotifierHandler GetAll(), AllNotifiers(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var nrs = make([]*NotifierResp, 0)
+		for _, n := range notifiers {
+			nrs = append(nrs, newNotifierResp(n))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(nrs)
+		if err != nil {
+			log.Printf("api NotifierHandler GetAll(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *notifierHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		n, err := h.ns.Notifier(id)
+		if err != nil {
+			log.Printf("api NotifierHandler Get(), Notifier(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if n == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(newNotifierResp(n))
+		if err != nil {
+			log.Printf("api NotifierHandler Get(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *notifierHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req := &NotifierReq{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		n := model.NewNotifier()
+		req.apply(n)
+		h.save(w, n, http.StatusCreated)
+	}
+}
+
+func (h *notifierHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		n, err := h.ns.Notifier(id)
+		if err != nil {
+			log.Printf("api NotifierHandler Put(), Notifier(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if n == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		req := &NotifierReq{}
+		err = json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req.apply(n)
+		h.save(w, n, http.StatusOK)
+	}
+}
+
+// save validates and stores n, then writes it out with the given status.
+func (h *notifierHandler) save(w http.ResponseWriter, n *model.Notifier, status int) {
+	err := n.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.ns.SaveNotifier(n)
+	if err != nil {
+		log.Printf("api NotifierHandler, SaveNotifier(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(newNotifierResp(n))
+	if err != nil {
+		log.Printf("api NotifierHandler, json.Encode(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *notifierHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		n, err := h.ns.Notifier(id)
+		if err != nil {
+			log.Printf("api NotifierHandler Delete(), Notifier(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if n == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err = h.ns.DeleteNotifier(id)
+		if err != nil {
+			log.Printf("api NotifierHandler Delete(), DeleteNotifier(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Test sends a test event through the notifier and reports any delivery
+// error as a 502.
+func (h *notifierHandler) Test() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		err := h.ns.Test(id)
+		if err == service.ErrNotifierNotFound {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/script"
+	"github.com/gorilla/mux"
+)
+
+// ScriptHandler provides endpoints for managing deploy script templates under
+// api/script, and for serving them rendered from /script/{id}.
+type ScriptHandler interface {
+	GetAll() http.HandlerFunc
+	Get() http.HandlerFunc
+	Post() http.HandlerFunc
+	Put() http.HandlerFunc
+	Delete() http.HandlerFunc
+	Render() http.HandlerFunc
+}
+
+type scriptHandler struct {
+	ss script.Service
+	cs certificate.Service
+}
+
+// NewScriptHandler takes a script.Service and certificate.Service and returns
+// a working ScriptHandler.
+func NewScriptHandler(ss script.Service, cs certificate.Service) ScriptHandler {
+	return &scriptHandler{ss, cs}
+}
+
+// ScriptReq is used for parsing API input. ID is only read on POST, since
+// it's the name in the URL.
+type ScriptReq struct {
+	ID          string
+	Name        string
+	Description string
+	Body        string
+}
+
+func (h *scriptHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scripts, err := h.ss.AllScripts()
+		if err != nil {
+			log.Printf("api ScriptHandler GetAll(), AllScripts(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(scripts)
+		if err != nil {
+			log.Printf("api ScriptHandler GetAll(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *scriptHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		s, err := h.ss.Script(id)
+		if err != nil {
+			log.Printf("api ScriptHandler Get(), Script(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if s == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(s)
+		if err != nil {
+			log.Printf("api ScriptHandler Get(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *scriptHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req := &ScriptReq{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		existing, err := h.ss.Script(req.ID)
+		if err != nil {
+			log.Printf("api ScriptHandler Post(), Script(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			http.Error(w, "a script with that ID already exists", http.StatusConflict)
+			return
+		}
+
+		s := &model.ScriptTemplate{
+			ID:          req.ID,
+			Name:        req.Name,
+			Description: req.Description,
+			Body:        req.Body,
+		}
+		h.save(w, s, http.StatusCreated)
+	}
+}
+
+func (h *scriptHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := h.ss.Script(id)
+		if err != nil {
+			log.Printf("api ScriptHandler Put(), Script(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if s == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		req := &ScriptReq{}
+		err = json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ID != "" && req.ID != id {
+			http.Error(w, ErrMismatchedID.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Name = req.Name
+		s.Description = req.Description
+		s.Body = req.Body
+		h.save(w, s, http.StatusOK)
+	}
+}
+
+// save validates and stores s, then writes it out with the given status.
+func (h *scriptHandler) save(w http.ResponseWriter, s *model.ScriptTemplate, status int) {
+	err := h.ss.SaveScript(s)
+	if err == model.ErrInvalidScript {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := err.(*script.BodyError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("api ScriptHandler, SaveScript(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(s)
+	if err != nil {
+		log.Printf("api ScriptHandler, json.Encode(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *scriptHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		s, err := h.ss.Script(id)
+		if err != nil {
+			log.Printf("api ScriptHandler Delete(), Script(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if s == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err = h.ss.DeleteScript(id)
+		if err != nil {
+			log.Printf("api ScriptHandler Delete(), DeleteScript(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// certByToken returns the cert with id if token is one of its install tokens,
+// or nil.
+func (h *scriptHandler) certByToken(id, token string) (*model.Certificate, error) {
+	if id == "" || token == "" {
+		return nil, nil
+	}
+	c, err := h.cs.Cert(id)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	if c.InstallToken(token) == nil {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// Render serves the script template with id rendered for the certificate
+// ?cert=, as long as ?token= is one of its install tokens. The script
+// downloads the certificate with the same token, so every host running it
+// should have its own.
+//
+// /script/{id}?cert={cert-id}&token={install-token}
+func (h *scriptHandler) Render() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		q := r.URL.Query()
+		c, err := h.certByToken(q.Get("cert"), q.Get("token"))
+		if err != nil {
+			log.Printf("api ScriptHandler Render(), Cert(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		body, err := h.ss.Render(id, c, q.Get("token"))
+		if err == script.ErrScriptNotFound {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err == script.ErrNoBaseURL {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			log.Printf("api ScriptHandler Render(), Render(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="deploy.sh"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/webhook"
+	"github.com/gorilla/mux"
+)
+
+// WebhookHandler provides endpoints for api/certificate/{id}/webhook calls.
+type WebhookHandler interface {
+	GetAll() http.HandlerFunc
+	Post() http.HandlerFunc
+	Delete() http.HandlerFunc
+	Deliveries() http.HandlerFunc
+}
+
+type webhookHandler struct {
+	cs certificate.Service
+	ws webhook.Service
+}
+
+// NewWebhookHandler returns a working WebhookHandler.
+func NewWebhookHandler(cs certificate.Service, ws webhook.Service) WebhookHandler {
+	return &webhookHandler{cs, ws}
+}
+
+// WebhookReq is used for parsing API input.
+type WebhookReq struct {
+	URL    string
+	Events []string
+}
+
+// WebhookResp is used for exporting subscriptions. The Secret is only
+// included when the subscription is created.
+type WebhookResp struct {
+	ID      string
+	CertID  string
+	URL     string
+	Secret  string `json:",omitempty"`
+	Events  []string
+	Created time.Time
+}
+
+func newWebhookResp(w *model.Webhook) *WebhookResp {
+	return &WebhookResp{
+		ID:      w.ID,
+		CertID:  w.CertID,
+		URL:     w.URL,
+		Events:  w.Events,
+		Created: w.Created,
+	}
+}
+
+// certExists writes a 404 or 500 and returns false if the {id} cert can't be
+// found.
+func (h *webhookHandler) certExists(w http.ResponseWriter, r *http.Request) bool {
+	c, err := h.cs.Cert(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("api WebhookHandler, GetCert(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// webhook returns the {hook} subscription if it belongs to the {id} cert,
+// writing a 404 or 500 otherwise.
+func (h *webhookHandler) webhook(w http.ResponseWriter, r *http.Request) *model.Webhook {
+	vars := mux.Vars(r)
+	hook, err := h.ws.Webhook(vars["hook"])
+	if err != nil {
+		log.Printf("api WebhookHandler, Webhook(), %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if hook == nil || hook.CertID != vars["id"] {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil
+	}
+	return hook
+}
+
+func (h *webhookHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.certExists(w, r) {
+			return
+		}
+
+		hooks, err := h.ws.Webhooks(mux.Vars(r)["id"])
+		if err != nil {
+			log.Printf("api WebhookHandler GetAll(), Webhooks(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var wrs = make([]*WebhookResp, 0)
+		for _, hook := range hooks {
+			wrs = append(wrs, newWebhookResp(hook))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(wrs)
+		if err != nil {
+			log.Printf("api WebhookHandler GetAll(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *webhookHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			http.Error(w, ErrBodyRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !h.certExists(w, r) {
+			return
+		}
+
+		req := &WebhookReq{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hook, err := model.NewWebhook(mux.Vars(r)["id"], req.URL, req.Events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = h.ws.SaveWebhook(hook)
+		if err != nil {
+			log.Printf("api WebhookHandler Post(), SaveWebhook(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := newWebhookResp(hook)
+		resp.Secret = hook.Secret
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Printf("api WebhookHandler Post(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *webhookHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook := h.webhook(w, r)
+		if hook == nil {
+			return
+		}
+
+		err := h.ws.DeleteWebhook(hook.ID)
+		if err != nil {
+			log.Printf("api WebhookHandler Delete(), DeleteWebhook(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Deliveries returns the delivery history of a subscription, newest first.
+func (h *webhookHandler) Deliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook := h.webhook(w, r)
+		if hook == nil {
+			return
+		}
+
+		deliveries, err := h.ws.Deliveries(hook.ID)
+		if err != nil {
+			log.Printf("api WebhookHandler Deliveries(), Deliveries(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(deliveries)
+		if err != nil {
+			log.Printf("api WebhookHandler Deliveries(), json.Encode(), %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package archive exports an instance's certificates, users, challenge
+// config and settings to a portable JSON archive, and imports them again,
+// possibly into a different instance or storage backend.
+package archive
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/user"
+	"github.com/segmentio/ksuid"
+)
+
+// Store is where archives are exported from and imported into.
+type Store struct {
+	Certs      certificate.Repository
+	Users      user.Repository
+	Challenges challenge_config.Repository
+	Config     config.Repository
+}
+
+// ExportOptions select what goes into an archive.
+type ExportOptions struct {
+	// Labels only exports certs carrying all of them. An empty value
+	// matches any value for the key.
+	Labels map[string]string
+	// NoPrivateKeys leaves out cert private keys and ACME account keys.
+	NoPrivateKeys bool
+}
+
+// ImportOptions select what's taken from an archive and how clashes with
+// existing records are resolved.
+type ImportOptions struct {
+	// Labels only imports certs carrying all of them.
+	Labels map[string]string
+	// Conflict is one of model.ConflictSkip, ConflictOverwrite or
+	// ConflictRename, and defaults to skip.
+	Conflict string
+	// NoPrivateKeys drops any keys the archive carries. Certs it overwrites
+	// keep the keys they already have; new ones register a fresh ACME
+	// account and get a new key at their next issuance.
+	NoPrivateKeys bool
+}
+
+// Outcome lists what happened to each imported record, by ID or name.
+type Outcome struct {
+	Created     []string
+	Overwritten []string
+	Skipped     []string
+	// Renamed maps each archived ID to the one it was saved under.
+	Renamed map[string]string
+}
+
+// Outcomes for the single challenge config and super admin setting.
+const (
+	Created     = "created"
+	Overwritten = "overwritten"
+	Skipped     = "skipped"
+)
+
+// ImportResult reports what an import did.
+type ImportResult struct {
+	Certificates *Outcome
+	Users        *Outcome
+	// Challenge and SuperAdmin are Created, Overwritten or Skipped, or empty
+	// if the archive had none.
+	Challenge  string
+	SuperAdmin string
+}
+
+func newOutcome() *Outcome {
+	return &Outcome{
+		Created:     make([]string, 0),
+		Overwritten: make([]string, 0),
+		Skipped:     make([]string, 0),
+		Renamed:     make(map[string]string),
+	}
+}
+
+// labelFilter returns a func reporting whether a cert carries all labels.
+func labelFilter(labels map[string]string) (func(c *model.Certificate) bool, error) {
+	q := &model.CertificateQuery{Labels: labels}
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return func(c *model.Certificate) bool {
+		return q.Matches(c.Summary(), now)
+	}, nil
+}
+
+// Export copies the selected records out of s.
+func Export(s *Store, opts *ExportOptions) (*model.Archive, error) {
+	match, err := labelFilter(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &model.Archive{
+		Version:       model.ArchiveVersion,
+		Created:       time.Now().UTC(),
+		NoPrivateKeys: opts.NoPrivateKeys,
+		Certificates:  make([]*model.ArchivedCertificate, 0),
+		Users:         make([]*model.User, 0),
+	}
+
+	certs, err := s.Certs.AllCerts()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range certs {
+		if match(c) {
+			a.Certificates = append(a.Certificates, model.NewArchivedCertificate(c, !opts.NoPrivateKeys))
+		}
+	}
+
+	users, err := s.Users.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+	a.Users = append(a.Users, users...)
+
+	a.Challenge = &model.ChallengeConfig{}
+	if a.Challenge.AuthEmail, err = s.Challenges.AuthEmail(); err != nil {
+		return nil, err
+	}
+	if a.Challenge.AuthKey, err = s.Challenges.AuthKey(); err != nil {
+		return nil, err
+	}
+
+	a.Settings = &model.ArchivedSettings{}
+	if a.Settings.SuperAdmin, err = s.Config.SuperAdmin(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Import saves the selected records from a into s.
+func Import(s *Store, a *model.Archive, opts *ImportOptions) (*ImportResult, error) {
+	if a.Version > model.ArchiveVersion {
+		return nil, fmt.Errorf("archive version %d is newer than this build supports (%d)", a.Version, model.ArchiveVersion)
+	}
+	conflict := opts.Conflict
+	if conflict == "" {
+		conflict = model.ConflictSkip
+	}
+	if conflict != model.ConflictSkip && conflict != model.ConflictOverwrite && conflict != model.ConflictRename {
+		return nil, model.ErrInvalidConflict
+	}
+	match, err := labelFilter(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{}
+	res.Certificates, err = importCerts(s, a.Certificates, conflict, match, opts.NoPrivateKeys)
+	if err != nil {
+		return res, err
+	}
+	res.Users, err = importUsers(s, a.Users, conflict)
+	if err != nil {
+		return res, err
+	}
+
+	if a.Challenge != nil && (a.Challenge.AuthEmail != "" || a.Challenge.AuthKey != "") {
+		res.Challenge, err = importChallenge(s, a.Challenge, conflict)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if a.Settings != nil && a.Settings.SuperAdmin != "" {
+		res.SuperAdmin, err = importSuperAdmin(s, a.Settings.SuperAdmin, res.Users)
+	}
+	return res, err
+}
+
+func importCerts(s *Store, archived []*model.ArchivedCertificate, conflict string, match func(*model.Certificate) bool, noKeys bool) (*Outcome, error) {
+	out := newOutcome()
+	for _, ac := range archived {
+		c, err := ac.Cert()
+		if err != nil {
+			return out, fmt.Errorf("cert %s: %s", ac.ID, err)
+		}
+		if !match(c) {
+			continue
+		}
+		if noKeys {
+			c.PrivateKey = nil
+			c.ACMEKey = nil
+		}
+
+		existing, err := s.Certs.Cert(c.ID)
+		if err != nil {
+			return out, err
+		}
+		id := c.ID
+		switch {
+		case existing == nil:
+			out.Created = append(out.Created, id)
+		case conflict == model.ConflictSkip:
+			out.Skipped = append(out.Skipped, id)
+			continue
+		case conflict == model.ConflictOverwrite:
+			out.Overwritten = append(out.Overwritten, id)
+			// Only the archive's keys are dropped, not the ones already
+			// here.
+			if noKeys {
+				c.PrivateKey = existing.PrivateKey
+				c.ACMEKey = existing.ACMEKey
+			}
+		case conflict == model.ConflictRename:
+			c.ID = ksuid.New().String()
+			// The copy needs its own secret and install tokens, or one
+			// token would authorize both certs.
+			c.Secret = auth.NewPassword()
+			c.InstallTokens = nil
+			out.Renamed[id] = c.ID
+		}
+
+		err = s.Certs.SaveCert(c)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func importUsers(s *Store, users []*model.User, conflict string) (*Outcome, error) {
+	out := newOutcome()
+	for _, u := range users {
+		existing, err := s.Users.GetUser(u.Name)
+		if err != nil {
+			return out, err
+		}
+		name := u.Name
+		switch {
+		case existing == nil:
+			out.Created = append(out.Created, name)
+		case conflict == model.ConflictSkip:
+			out.Skipped = append(out.Skipped, name)
+			continue
+		case conflict == model.ConflictOverwrite:
+			out.Overwritten = append(out.Overwritten, name)
+		case conflict == model.ConflictRename:
+			u = &model.User{Name: name, Role: u.Role, Hash: u.Hash}
+			for i := 1; existing != nil; i++ {
+				u.Name = fmt.Sprintf("%s-%d", name, i)
+				existing, err = s.Users.GetUser(u.Name)
+				if err != nil {
+					return out, err
+				}
+			}
+			out.Renamed[name] = u.Name
+		}
+
+		err = s.Users.SaveUser(u)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func importChallenge(s *Store, ch *model.ChallengeConfig, conflict string) (string, error) {
+	email, err := s.Challenges.AuthEmail()
+	if err != nil {
+		return "", err
+	}
+	key, err := s.Challenges.AuthKey()
+	if err != nil {
+		return "", err
+	}
+
+	outcome := Created
+	if email != "" || key != "" {
+		// There's only one config, so there's nothing to rename to.
+		if conflict != model.ConflictOverwrite {
+			return Skipped, nil
+		}
+		outcome = Overwritten
+	}
+
+	err = s.Challenges.SetAuthEmail(ch.AuthEmail)
+	if err != nil {
+		return "", err
+	}
+	return outcome, s.Challenges.SetAuthKey(ch.AuthKey)
+}
+
+// importSuperAdmin only fills in a missing super admin, and only with a user
+// the import actually saved under that name.
+func importSuperAdmin(s *Store, name string, users *Outcome) (string, error) {
+	current, err := s.Config.SuperAdmin()
+	if err != nil || current != "" {
+		return Skipped, err
+	}
+	saved := false
+	for _, n := range append(users.Created, users.Overwritten...) {
+		saved = saved || n == name
+	}
+	if !saved {
+		return Skipped, nil
+	}
+	return Created, s.Config.SetSuperAdmin(name)
+}
@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/ImageWare/TLSential/repository/sqldb"
+	"github.com/boltdb/bolt"
+)
+
+func boltStore(t *testing.T, path string) *Store {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Store{}
+	if s.Certs, err = boltdb.NewCertificateRepository(db, envelope.None); err != nil {
+		t.Fatal(err)
+	}
+	if s.Users, err = boltdb.NewUserRepository(db); err != nil {
+		t.Fatal(err)
+	}
+	if s.Challenges, err = boltdb.NewChallengeConfigRepository(db, envelope.None); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config, err = boltdb.NewConfigRepository(db, envelope.None); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func sqliteStore(t *testing.T, path string) *Store {
+	t.Helper()
+	db, err := sqldb.Open(sqldb.DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Store{
+		Certs:      sqldb.NewCertificateRepository(db, envelope.None),
+		Users:      sqldb.NewUserRepository(db),
+		Challenges: sqldb.NewChallengeConfigRepository(db, envelope.None),
+		Config:     sqldb.NewConfigRepository(db, envelope.None),
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := boltStore(t, filepath.Join(dir, "staging.db"))
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	prod := &model.Certificate{ID: "prod", Secret: "s1", CommonName: "a.com", Domains: []string{"a.com"},
+		Labels: map[string]string{"env": "prod"}, PrivateKey: []byte("key"), ACMEKey: key, RenewAt: 30}
+	dev := &model.Certificate{ID: "dev", Secret: "s2", CommonName: "b.com", Domains: []string{"b.com"},
+		Labels: map[string]string{"env": "dev"}}
+	for _, c := range []*model.Certificate{prod, dev} {
+		if err := src.Certs.SaveCert(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Users.SaveUser(&model.User{Name: "alice", Role: "super_admin", Hash: "h1"})
+	src.Config.SetSuperAdmin("alice")
+	src.Challenges.SetAuthEmail("dns@example.com")
+	src.Challenges.SetAuthKey("dns-key")
+
+	a, err := Export(src, &ExportOptions{Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Certificates) != 1 || a.Certificates[0].ID != "prod" {
+		t.Fatalf("expected only the prod cert, got %d", len(a.Certificates))
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, a, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "dns-key") {
+		t.Fatal("expected the encrypted archive not to contain secrets")
+	}
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), ""); err != ErrPassphraseRequired {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), "wrong"); err != ErrWrongPassphrase {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	// Costs other than the ones archives are written with are refused
+	// before any key is derived.
+	costly := bytes.Replace(buf.Bytes(), []byte(`"Memory":65536`), []byte(`"Memory":1048576`), 1)
+	if bytes.Equal(costly, buf.Bytes()) {
+		t.Fatal("expected the archive to record its KDF memory")
+	}
+	if _, err := Decode(bytes.NewReader(costly), "correct horse"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("expected other KDF parameters to be refused, got %v", err)
+	}
+	a, err = Decode(bytes.NewReader(buf.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move it to a different backend.
+	dst := sqliteStore(t, filepath.Join(dir, "prod.db"))
+	res, err := Import(dst, a, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Certificates.Created) != 1 || len(res.Users.Created) != 1 ||
+		res.Challenge != Created || res.SuperAdmin != Created {
+		t.Errorf("unexpected result %+v", res)
+	}
+	got, err := dst.Certs.Cert("prod")
+	if err != nil || got == nil {
+		t.Fatalf("expected the imported cert, got %v", err)
+	}
+	if got.Secret != "s1" || string(got.PrivateKey) != "key" || got.ACMEKey == nil || got.ACMEKey.D.Cmp(key.D) != 0 {
+		t.Error("cert didn't survive the trip")
+	}
+	if sa, _ := dst.Config.SuperAdmin(); sa != "alice" {
+		t.Errorf("expected alice as super admin, got %q", sa)
+	}
+	if k, _ := dst.Challenges.AuthKey(); k != "dns-key" {
+		t.Errorf("unexpected auth key %q", k)
+	}
+
+	res, err = Import(dst, a, &ImportOptions{Conflict: model.ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Certificates.Skipped) != 1 || len(res.Users.Skipped) != 1 || res.Challenge != Skipped || res.SuperAdmin != Skipped {
+		t.Errorf("expected everything skipped, got %+v", res)
+	}
+
+	a.Certificates[0].RenewAt = 10
+	res, err = Import(dst, a, &ImportOptions{Conflict: model.ConflictOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dst.Certs.Cert("prod"); len(res.Certificates.Overwritten) != 1 || got.RenewAt != 10 {
+		t.Error("expected the cert to be overwritten")
+	}
+
+	// Overwriting without the archive's keys keeps the existing ones.
+	a.Certificates[0].RenewAt = 20
+	_, err = Import(dst, a, &ImportOptions{Conflict: model.ConflictOverwrite, NoPrivateKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = dst.Certs.Cert("prod")
+	if got.RenewAt != 20 || string(got.PrivateKey) != "key" || got.ACMEKey == nil || got.ACMEKey.D.Cmp(key.D) != 0 {
+		t.Error("expected the cert to be overwritten but keep its keys")
+	}
+
+	res, err = Import(dst, a, &ImportOptions{Conflict: model.ConflictRename, NoPrivateKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID := res.Certificates.Renamed["prod"]
+	if newID == "" || newID == "prod" || res.Users.Renamed["alice"] != "alice-1" {
+		t.Fatalf("unexpected renames %v, %v", res.Certificates.Renamed, res.Users.Renamed)
+	}
+	if got, _ := dst.Certs.Cert(newID); got == nil || got.PrivateKey != nil || got.ACMEKey != nil {
+		t.Error("expected a renamed copy without keys")
+	} else if got.Secret == "" || got.Secret == "s1" {
+		t.Errorf("expected the renamed copy to get its own secret, got %q", got.Secret)
+	}
+
+	if _, err := Import(dst, a, &ImportOptions{Conflict: "merge"}); err != model.ErrInvalidConflict {
+		t.Errorf("expected ErrInvalidConflict, got %v", err)
+	}
+}
+
+func TestExportNoPrivateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := boltStore(t, filepath.Join(dir, "tlsential.db"))
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Certs.SaveCert(&model.Certificate{ID: "a", PrivateKey: []byte("key"), ACMEKey: key})
+
+	a, err := Export(s, &ExportOptions{NoPrivateKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.NoPrivateKeys || a.Certificates[0].PrivateKey != nil || a.Certificates[0].ACMEKey != "" {
+		t.Error("expected keys to be left out")
+	}
+
+	var buf bytes.Buffer
+	Encode(&buf, a, "")
+	if _, err := Decode(&buf, ""); err != nil {
+		t.Errorf("expected a plain archive to decode, got %v", err)
+	}
+
+	newer := strings.NewReader(`{"Version": 99}`)
+	if _, err := Decode(newer, ""); err == nil {
+		t.Error("expected a newer archive to be refused")
+	}
+}
@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ImageWare/TLSential/model"
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	// ErrPassphraseRequired is returned when decoding an encrypted archive
+	// without a passphrase.
+	ErrPassphraseRequired = errors.New("archive is encrypted, a passphrase is required")
+	// ErrWrongPassphrase is returned when an encrypted archive doesn't
+	// decrypt, whether from the wrong passphrase or tampering.
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted archive")
+)
+
+const kdfArgon2id = "argon2id"
+
+// Key derivation costs. Archives are only ever written with these, and
+// decoding refuses anything else, so an uploaded archive can't make the
+// server spend more than 64 MiB deriving its key.
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+	saltSize   = 16
+)
+
+// sealed is the on-disk form of an encrypted archive. Only the version and
+// key derivation parameters are readable without the passphrase.
+type sealed struct {
+	Version int
+
+	KDF     string
+	Salt    []byte
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+
+	// Ciphertext is the nonce followed by the AES-256-GCM sealed archive.
+	Ciphertext []byte
+}
+
+// Encode writes a as JSON, encrypted with a key derived from passphrase
+// unless it's empty.
+func Encode(w io.Writer, a *model.Archive, passphrase string) error {
+	if passphrase == "" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a)
+	}
+
+	plaintext, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	s := &sealed{
+		Version: a.Version,
+		KDF:     kdfArgon2id,
+		Salt:    make([]byte, saltSize),
+		Time:    kdfTime,
+		Memory:  kdfMemory,
+		Threads: kdfThreads,
+	}
+	_, err = io.ReadFull(rand.Reader, s.Salt)
+	if err != nil {
+		return err
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	s.Ciphertext = aead.Seal(nonce, nonce, plaintext, nil)
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Decode reads an archive written by Encode. passphrase is only used if the
+// archive is encrypted.
+func Decode(r io.Reader, passphrase string) (*model.Archive, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sealed{}
+	err = json.Unmarshal(buf, s)
+	if err != nil {
+		return nil, fmt.Errorf("not an archive: %s", err)
+	}
+	if s.Version > model.ArchiveVersion {
+		return nil, fmt.Errorf("archive version %d is newer than this build supports (%d)", s.Version, model.ArchiveVersion)
+	}
+
+	if s.Ciphertext != nil {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		buf, err = s.open(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &model.Archive{}
+	err = json.Unmarshal(buf, a)
+	if err != nil {
+		return nil, fmt.Errorf("not an archive: %s", err)
+	}
+	if a.Version < 1 || a.Version > model.ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", a.Version)
+	}
+	return a, nil
+}
+
+func (s *sealed) open(passphrase string) ([]byte, error) {
+	if s.KDF != kdfArgon2id || s.Time != kdfTime || s.Memory != kdfMemory ||
+		s.Threads != kdfThreads || len(s.Salt) != saltSize {
+		return nil, errors.New("unsupported archive encryption parameters")
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Ciphertext) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce, ciphertext := s.Ciphertext[:aead.NonceSize()], s.Ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func (s *sealed) aead(passphrase string) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), s.Salt, s.Time, s.Memory, s.Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
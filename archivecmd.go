@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ImageWare/TLSential/archive"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// passphraseEnv holds the archive passphrase when no file is given.
+const passphraseEnv = "TLSENTIAL_ARCHIVE_PASSPHRASE"
+
+// labelFlags collects repeated -label key=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	return model.FormatLabels(l)
+}
+
+func (l labelFlags) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) == 2 {
+		l[kv[0]] = kv[1]
+	} else {
+		l[kv[0]] = ""
+	}
+	return nil
+}
+
+// archiveFlags are shared by export and import.
+type archiveFlags struct {
+	dbFlags
+	passphraseFile string
+	labels         labelFlags
+	noPrivateKeys  bool
+}
+
+func (f *archiveFlags) register(fs *flag.FlagSet) {
+	f.dbFlags.register(fs)
+	f.labels = make(labelFlags)
+	fs.StringVar(&f.passphraseFile, "passphrase-file", "", "file holding the archive passphrase (default $"+passphraseEnv+", unencrypted if neither)")
+	fs.Var(f.labels, "label", "only include certs with this key=value label, or just key for any value; repeatable")
+	fs.BoolVar(&f.noPrivateKeys, "no-private-keys", false, "leave out private keys and secrets")
+}
+
+func (f *archiveFlags) passphrase() (string, error) {
+	if f.passphraseFile == "" {
+		return os.Getenv(passphraseEnv), nil
+	}
+	b, err := ioutil.ReadFile(f.passphraseFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// exportArchive writes certs, users, the challenge config and settings to a
+// portable archive that import can load into any storage backend. Bolt
+// databases can only be exported while the server is stopped; otherwise use
+// /api/admin/export.
+//
+//	tlsential export [-db tlsential.db] [-label k=v] [-no-private-keys] [-o file]
+func exportArchive(args []string) error {
+	var f archiveFlags
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	f.register(fs)
+	out := fs.String("o", "-", "file to write the archive to, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tlsential export [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("export takes no arguments")
+	}
+
+	passphrase, err := f.passphrase()
+	if err != nil {
+		return err
+	}
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	a, err := archive.Export(db.archiveStore(), &archive.ExportOptions{Labels: f.labels, NoPrivateKeys: f.noPrivateKeys})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	err = archive.Encode(w, a, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d certificates and %d users\n", len(a.Certificates), len(a.Users))
+	return nil
+}
+
+// importArchive loads an archive written by export or /api/admin/export.
+//
+//	tlsential import [-db tlsential.db] [-conflict skip|overwrite|rename] archive.json
+func importArchive(args []string) error {
+	var f archiveFlags
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	f.register(fs)
+	conflict := fs.String("conflict", model.ConflictSkip, "what to do when an ID or username already exists: skip, overwrite or rename")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tlsential import [flags] archive.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import needs exactly one archive file")
+	}
+
+	passphrase, err := f.passphrase()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	a, err := archive.Decode(file, passphrase)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fs.Arg(0), err)
+	}
+
+	db, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	res, err := archive.Import(db.archiveStore(), a, &archive.ImportOptions{Labels: f.labels, Conflict: *conflict, NoPrivateKeys: f.noPrivateKeys})
+	if res != nil {
+		printOutcome("Certificates", res.Certificates)
+		printOutcome("Users", res.Users)
+		if res.Challenge != "" {
+			fmt.Printf("Challenge config: %s\n", res.Challenge)
+		}
+		if res.SuperAdmin != "" {
+			fmt.Printf("Super admin: %s\n", res.SuperAdmin)
+		}
+	}
+	return err
+}
+
+func printOutcome(what string, o *archive.Outcome) {
+	if o == nil {
+		return
+	}
+	fmt.Printf("%s: %d created, %d overwritten, %d skipped\n", what, len(o.Created), len(o.Overwritten), len(o.Skipped))
+	for from, to := range o.Renamed {
+		fmt.Printf("  %s renamed to %s\n", from, to)
+	}
+}
@@ -0,0 +1,17 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Repository stores the audit log. Entries can only be appended, or dropped
+// once they're older than the retention period.
+type Repository interface {
+	Append(e *model.AuditEntry) error
+	// Entries returns matching entries, newest first.
+	Entries(q *model.AuditQuery) ([]*model.AuditEntry, error)
+	// Prune removes entries recorded before the cutoff.
+	Prune(before time.Time) error
+}
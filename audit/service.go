@@ -0,0 +1,22 @@
+// Package audit records who did what, from where, and whether it worked.
+package audit
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Service provides an interface for all business operations on the audit log.
+type Service interface {
+	// Record stores e and ships it to the configured sinks. Failures are
+	// logged rather than returned, so auditing never breaks a request.
+	Record(e *model.AuditEntry)
+	Entries(q *model.AuditQuery) ([]*model.AuditEntry, error)
+	Prune(before time.Time) error
+}
+
+// Sink receives a copy of every audit entry, eg. syslog or a file.
+type Sink interface {
+	Write(e *model.AuditEntry) error
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+)
+
+// How often to drop audit entries past the retention period.
+var auditPrunePeriod = 24 * time.Hour
+
+func auditRetention(as audit.Service, retention time.Duration) {
+	for {
+		err := as.Prune(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("auditRetention: %s", err.Error())
+		}
+
+		select {
+		case <-time.After(auditPrunePeriod):
+			break
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package auditsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func testEntry() *model.AuditEntry {
+	e := model.NewAuditEntry(model.ActorUser, `al"ice]`, "10.0.0.1", "DELETE /api/certificate/{id}", "abc", 403)
+	e.Time = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	return e
+}
+
+func TestFormat(t *testing.T) {
+	msg := Format(testEntry(), "host", 42)
+
+	if !strings.HasPrefix(msg, "<84>1 2020-05-01T12:00:00Z host tlsential 42 audit [audit@32473 ") {
+		t.Errorf("unexpected header: %s", msg)
+	}
+	if !strings.Contains(msg, `actor="al\"ice\]"`) {
+		t.Errorf("param values should be escaped: %s", msg)
+	}
+	if !strings.Contains(msg, `outcome="denied"`) {
+		t.Errorf("missing outcome: %s", msg)
+	}
+
+	e := model.NewAuditEntry(model.ActorUnauthenticated, "", "10.0.0.1", "POST /api/authenticate", "", 401)
+	e.ClaimedActor = "alice"
+	msg = Format(e, "host", 42)
+	if !strings.Contains(msg, `actor="" actorType="unauthenticated" claimedActor="alice"`) {
+		t.Errorf("expected the claimed name apart from the actor: %s", msg)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := Syslog("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(testEntry()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "<84>1 ") {
+		t.Errorf("unexpected message %q", buf[:n])
+	}
+
+	if _, err := Syslog("http://example.com"); err != ErrInvalidSyslogAddr {
+		t.Errorf("expected ErrInvalidSyslogAddr, got %v", err)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := JSONLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(testEntry()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := &model.AuditEntry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Action != "DELETE /api/certificate/{id}" {
+			t.Errorf("unexpected action %s", e.Action)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 lines, got %d", lines)
+	}
+}
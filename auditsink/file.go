@@ -0,0 +1,36 @@
+package auditsink
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/model"
+)
+
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// JSONLines returns a sink appending one JSON object per entry to path.
+func JSONLines(path string) (audit.Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(e *model.AuditEntry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(buf, '\n'))
+	return err
+}
@@ -0,0 +1,134 @@
+// Package auditsink ships audit entries to places outside the database.
+package auditsink
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Facility authpriv, severities notice and warning.
+const (
+	facilityAuthPriv = 10
+	severityWarning  = 4
+	severityNotice   = 5
+)
+
+// sdID is the structured data ID, using the documentation enterprise number.
+const sdID = "audit@32473"
+
+const appName = "tlsential"
+
+var ErrInvalidSyslogAddr = errors.New("syslog address must be local, udp://host:port or tcp://host:port")
+
+type syslogSink struct {
+	network  string
+	addr     string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Syslog returns a sink sending RFC 5424 messages. addr is "local" for the
+// local syslog socket, or a udp:// or tcp:// URL.
+func Syslog(addr string) (audit.Sink, error) {
+	s := &syslogSink{}
+	if addr == "local" {
+		s.network, s.addr = "unixgram", "/dev/log"
+	} else {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, ErrInvalidSyslogAddr
+		}
+		s.network, s.addr = u.Scheme, u.Host
+	}
+
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	return s, nil
+}
+
+func (s *syslogSink) Write(e *model.AuditEntry) error {
+	msg := Format(e, s.hostname, os.Getpid())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reconnect once if the old connection went away.
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+
+		var err error
+		if s.network == "tcp" {
+			// Octet counting framing, RFC 6587.
+			_, err = fmt.Fprintf(s.conn, "%d %s", len(msg), msg)
+		} else {
+			_, err = s.conn.Write([]byte(msg))
+		}
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if i == 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format renders e as an RFC 5424 syslog message.
+func Format(e *model.AuditEntry, hostname string, pid int) string {
+	severity := severityNotice
+	if e.Outcome != model.OutcomeSuccess {
+		severity = severityWarning
+	}
+
+	params := []struct{ k, v string }{
+		{"id", e.ID},
+		{"actor", e.Actor},
+		{"actorType", e.ActorType},
+		{"claimedActor", e.ClaimedActor},
+		{"ip", e.IP},
+		{"action", e.Action},
+		{"target", e.Target},
+		{"outcome", e.Outcome},
+		{"status", fmt.Sprint(e.Status)},
+	}
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	for _, p := range params {
+		fmt.Fprintf(&sd, ` %s="%s"`, p.k, escapeParam(p.v))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d audit %s %s %s by %s: %s",
+		facilityAuthPriv*8+severity,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		hostname, appName, pid,
+		sd.String(),
+		e.Action, e.Target, e.Actor, e.Outcome,
+	)
+}
+
+// escapeParam escapes the characters RFC 5424 reserves in param values.
+func escapeParam(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
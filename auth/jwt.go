@@ -33,6 +33,12 @@ type JWTSecret struct {
 // WARNING: This method is dangerous to call with a cryptographically
 // insecure secret.
 func (s *JWTSecret) Sign(role string) (string, error) {
+	return s.SignUser("", role)
+}
+
+// SignUser is like Sign, but also records the username as the subject so
+// requests can be attributed to the user. An empty username is left out.
+func (s *JWTSecret) SignUser(username, role string) (string, error) {
 	// Make sure token is valid
 	err := s.ValidSecret()
 	if err != nil {
@@ -41,11 +47,15 @@ func (s *JWTSecret) Sign(role string) (string, error) {
 
 	// Create a new token object, specifying signing method and the claims
 	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"role": role,
 		"iat":  time.Now().Unix(),
 		"exp":  time.Now().Add(ExpiryDuration).Unix(),
-	})
+	}
+	if username != "" {
+		claims["sub"] = username
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign and get the complete encoded token as a string using the secret
 	tokenString, err := token.SignedString(s.Secret)
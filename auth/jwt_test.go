@@ -61,4 +61,20 @@ func TestSign(t *testing.T) {
 
 	})
 
+	t.Run("Subject", func(t *testing.T) {
+		s := &JWTSecret{}
+		s.SetSecret(make([]byte, 32))
+
+		token, err := s.SignUser("alice", "test")
+		if err != nil {
+			t.Fatalf("Error creating token: %s", err)
+		}
+		claims, err := s.ValidateToken(token)
+		if err != nil {
+			t.Fatalf("Error validating token: %s", err)
+		}
+		if claims["sub"] != "alice" {
+			t.Errorf("Expected subject alice, got %v", claims["sub"])
+		}
+	})
 }
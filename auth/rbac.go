@@ -15,6 +15,20 @@ var (
 
 	PermCertAdmin = gorbac.NewStdPermission("cert")
 
+	PermNotifierAdmin = gorbac.NewStdPermission("notifier")
+
+	// Permission for reading the audit log
+	PermAuditRead = gorbac.NewStdPermission("audit_read")
+
+	// Permission for reading server diagnostics
+	PermDiagnostics = gorbac.NewStdPermission("diagnostics")
+
+	// Permission for downloading database backups
+	PermBackup = gorbac.NewStdPermission("backup")
+
+	// Permission for managing deploy script templates
+	PermScriptAdmin = gorbac.NewStdPermission("script")
+
 	// Role that has User Read permission
 	RoleUserReader = "user_reader"
 	// Role that has User Write and Read permissions
@@ -43,6 +57,11 @@ func InitRBAC() *gorbac.RBAC {
 	rsa := gorbac.NewStdRole(RoleSuperAdmin)
 	rsa.Assign(PermChallengeAdmin)
 	rsa.Assign(PermCertAdmin)
+	rsa.Assign(PermNotifierAdmin)
+	rsa.Assign(PermAuditRead)
+	rsa.Assign(PermDiagnostics)
+	rsa.Assign(PermBackup)
+	rsa.Assign(PermScriptAdmin)
 	r.Add(rsa)
 	r.SetParents(RoleSuperAdmin, []string{RoleUserAdmin})
 
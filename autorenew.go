@@ -1,38 +1,86 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/ImageWare/TLSential/acme"
 	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
 )
 
 // How often to scan all certificates to determine if they'll need renewal.
 var scanPeriod = time.Hour
 
-func scanAllCerts(cs certificate.Service, as acme.Service) {
+// Certs this close to expiry are always reported, whatever RenewAt says.
+const expiringSoonDays = 7
+
+// How often to repeat an expiry warning for the same cert.
+const expiryNotifyPeriod = 24 * time.Hour
+
+// expiryNotified tracks when each cert last had an expiry warning sent. Only
+// the scan goroutine touches it.
+var expiryNotified = make(map[string]time.Time)
+
+func scanAllCerts(cs certificate.Service, as acme.Service, ns notifier.Service) {
 	now := time.Now()
+	result := &model.ScanResult{Time: now}
+	defer health.RecordScan(result)
 
 	certs, err := cs.AllCerts()
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("scanAllCerts: %s", err.Error())
+		result.Error = err.Error()
+		return
 	}
+	result.Checked = len(certs)
 	for _, c := range certs {
+		// An unissued cert has no expiry, so it would always look due.
+		if c.AwaitingDryRun() {
+			continue
+		}
+
 		hoursLeft := c.Expiry.Sub(now).Hours()
 		daysLeft := int(hoursLeft / 24)
-		if daysLeft < c.RenewAt {
+
+		// Warn if we're inside the renewal window and the last attempt
+		// didn't work, or can't work at all.
+		if c.Issued && (daysLeft < expiringSoonDays || (c.RenewDue(daysLeft) && (c.Imported || c.LastError != nil))) {
+			notifyExpiring(ns, c, daysLeft, now)
+		}
+
+		if c.RenewDue(daysLeft) {
+			// Imported certs can't be renewed here, so the best we can do is
+			// make noise until someone replaces them.
+			if c.Imported {
+				log.Printf("Imported certificate %s - %s expires in %d days", c.ID, c.CommonName, daysLeft)
+				continue
+			}
 			as.GetAutoRenewChannel() <- c.ID
+			result.Queued++
 		}
 	}
 }
 
-func autoRenewal(cs certificate.Service, as acme.Service) {
+func notifyExpiring(ns notifier.Service, c *model.Certificate, daysLeft int, now time.Time) {
+	if now.Sub(expiryNotified[c.ID]) < expiryNotifyPeriod {
+		return
+	}
+	expiryNotified[c.ID] = now
+
+	msg := fmt.Sprintf("The certificate expires in %d days and hasn't been renewed.", daysLeft)
+	ns.Notify(model.NewEvent(model.EventExpiring, c, msg, c.LastError))
+}
+
+func autoRenewal(cs certificate.Service, as acme.Service, ns notifier.Service) {
 	for {
 		select {
 		case <-time.After(scanPeriod):
 			log.Print("Scanning all certs for renewal...")
-			scanAllCerts(cs, as)
+			scanAllCerts(cs, as, ns)
 			break
 		}
 	}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+)
+
+// fakeCerts implements only what the scan uses.
+type fakeCerts struct {
+	certificate.Service
+	certs []*model.Certificate
+}
+
+func (f *fakeCerts) AllCerts() ([]*model.Certificate, error) {
+	return f.certs, nil
+}
+
+type fakeACME struct {
+	acme.Service
+	renew chan string
+}
+
+func (f *fakeACME) GetAutoRenewChannel() chan string {
+	return f.renew
+}
+
+type fakeNotifier struct {
+	notifier.Service
+}
+
+func (f *fakeNotifier) Notify(e *model.Event) {}
+
+func TestScanAwaitingDryRun(t *testing.T) {
+	now := time.Now()
+	failed := &model.Certificate{ID: "failed", RenewAt: model.DefaultRenewAt, ValidateFirst: true,
+		DryRun: &model.DryRun{Started: now, Finished: now, Error: "staging said no"}}
+	running := &model.Certificate{ID: "running", RenewAt: model.DefaultRenewAt, ValidateFirst: true,
+		DryRun: &model.DryRun{Started: now}}
+	passed := &model.Certificate{ID: "passed", RenewAt: model.DefaultRenewAt, ValidateFirst: true,
+		DryRun: &model.DryRun{Started: now, Finished: now, Success: true}}
+
+	as := &fakeACME{renew: make(chan string, 10)}
+	scanAllCerts(&fakeCerts{certs: []*model.Certificate{failed, running, passed}}, as, &fakeNotifier{})
+
+	if len(as.renew) != 1 {
+		t.Fatalf("expected only the cert that passed its dry run to be queued, got %d", len(as.renew))
+	}
+	if id := <-as.renew; id != "passed" {
+		t.Errorf("expected passed to be queued, got %s", id)
+	}
+}
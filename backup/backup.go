@@ -0,0 +1,87 @@
+// Package backup writes database snapshots to a directory and keeps only the
+// newest few.
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix = "tlsential-"
+	fileExt    = ".db"
+	timeFormat = "20060102T150405Z"
+)
+
+// Name returns the file name for a snapshot taken at t. Names sort in the
+// order they were taken.
+func Name(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeFormat) + fileExt
+}
+
+// ToDir writes a snapshot into dir and then removes all but the newest keep
+// snapshots there. It returns the new snapshot's path.
+func ToDir(r Repository, dir string, keep int, now time.Time) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// Write under a temporary name so a partial snapshot is never mistaken
+	// for a good one.
+	f, err := ioutil.TempFile(dir, ".snapshot-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = r.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, Name(now))
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", err
+	}
+	return path, Rotate(dir, keep)
+}
+
+// Rotate removes all but the newest keep snapshots in dir. Other files are
+// left alone.
+func Rotate(dir string, keep int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), filePrefix) && strings.HasSuffix(f.Name(), fileExt) {
+			names = append(names, f.Name())
+		}
+	}
+	if len(names) <= keep {
+		return nil
+	}
+
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
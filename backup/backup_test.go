@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeRepo struct {
+	data string
+	err  error
+}
+
+func (r *fakeRepo) Snapshot(w io.Writer) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := io.WriteString(w, r.data)
+	return int64(n), err
+}
+
+func TestToDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	other := filepath.Join(dir, "notes.txt")
+	ioutil.WriteFile(other, []byte("keep me"), 0600)
+
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := ToDir(&fakeRepo{data: "snapshot"}, dir, 2, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	if filepath.Base(paths[3]) != "tlsential-20200601T030000Z.db" {
+		t.Errorf("unexpected name %s", paths[3])
+	}
+	if buf, _ := ioutil.ReadFile(paths[3]); string(buf) != "snapshot" {
+		t.Errorf("unexpected contents %q", buf)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 3 {
+		t.Errorf("expected 2 snapshots and the other file, got %d files", len(files))
+	}
+	for _, p := range paths[:2] {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be rotated out", p)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("expected unrelated files to be left alone")
+	}
+
+	// A failed snapshot leaves nothing behind.
+	if _, err := ToDir(&fakeRepo{err: errors.New("boom")}, dir, 2, start.Add(24*time.Hour)); err == nil {
+		t.Error("expected the snapshot error")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 3 {
+		t.Errorf("expected a failed snapshot not to be kept, got %d files", len(files))
+	}
+}
@@ -0,0 +1,17 @@
+package backup
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSupported is returned by databases that have to be backed up with
+// their own tools.
+var ErrNotSupported = errors.New("snapshots aren't supported for this database, use its own backup tools")
+
+// Repository takes snapshots of the whole database.
+type Repository interface {
+	// Snapshot writes the database as of a single moment to w without
+	// blocking other reads and writes, and returns the bytes written.
+	Snapshot(w io.Writer) (int64, error)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ImageWare/TLSential/backup"
+)
+
+func scheduledBackups(br backup.Repository, dir string, interval time.Duration, keep int) {
+	for {
+		select {
+		case <-time.After(interval):
+			path, err := backup.ToDir(br, dir, keep, time.Now())
+			if err == backup.ErrNotSupported {
+				log.Printf("scheduledBackups: %s, disabling scheduled backups", err.Error())
+				return
+			}
+			if err != nil {
+				log.Printf("scheduledBackups: %s", err.Error())
+				break
+			}
+			log.Printf("Wrote backup %s", path)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package bundle converts issued certificates into the download formats
+// expected by different servers and platforms.
+package bundle
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"errors"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+)
+
+// Supported bundle formats.
+const (
+	FormatPEM = "pem"
+	FormatDER = "der"
+	FormatPFX = "pfx"
+	FormatJKS = "jks"
+)
+
+var (
+	// ErrUnknownFormat is returned for any format not listed above.
+	ErrUnknownFormat = errors.New("unknown bundle format")
+	// ErrNoCertificate means the PEM input held no certificates.
+	ErrNoCertificate = errors.New("no certificate found")
+	// ErrNoPrivateKey means a format requiring a key was requested without one.
+	ErrNoPrivateKey = errors.New("no private key found")
+)
+
+// ValidFormat returns true if f is one of the supported bundle formats.
+func ValidFormat(f string) bool {
+	switch f {
+	case FormatPEM, FormatDER, FormatPFX, FormatJKS:
+		return true
+	}
+	return false
+}
+
+// IncludesKey returns true if the given format always carries the private key.
+func IncludesKey(f string) bool {
+	return f == FormatPFX || f == FormatJKS
+}
+
+// Chain parses the leaf certificate followed by any issuer certificates and
+// returns them as DER in that order.
+func Chain(certPEM, issuerPEM []byte) ([][]byte, error) {
+	var chain [][]byte
+	for _, in := range [][]byte{certPEM, issuerPEM} {
+		rest := in
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return chain, nil
+}
+
+// PrivateKey parses a PEM encoded private key.
+func PrivateKey(keyPEM []byte) (crypto.PrivateKey, error) {
+	if b, _ := pem.Decode(keyPEM); b == nil {
+		return nil, ErrNoPrivateKey
+	}
+	return certcrypto.ParsePEMPrivateKey(keyPEM)
+}
+
+// DER returns the leaf certificate in DER form.
+func DER(chain [][]byte) []byte {
+	return chain[0]
+}
+
+// FullChainPEM returns the leaf and issuer certificates as a single PEM file,
+// followed by the private key if one is given.
+func FullChainPEM(chain [][]byte, keyPEM []byte) []byte {
+	var buf bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	buf.Write(keyPEM)
+	return buf.Bytes()
+}
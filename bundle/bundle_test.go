@@ -0,0 +1,173 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func testChain(t *testing.T) ([]byte, []byte, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	issuerPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return certPEM, issuerPEM, key
+}
+
+func TestChain(t *testing.T) {
+	certPEM, issuerPEM, _ := testChain(t)
+
+	chain, err := Chain(certPEM, issuerPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(chain))
+	}
+
+	leaf, err := x509.ParseCertificate(DER(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "example.com" {
+		t.Error("leaf should come first")
+	}
+
+	_, err = Chain(nil, nil)
+	if err != ErrNoCertificate {
+		t.Errorf("expected ErrNoCertificate, got %v", err)
+	}
+
+	full := FullChainPEM(chain, []byte("KEY"))
+	if !bytes.HasPrefix(full, certPEM) || !bytes.HasSuffix(full, []byte("KEY")) {
+		t.Error("full chain should start with leaf and end with key")
+	}
+}
+
+func TestPKCS12(t *testing.T) {
+	certPEM, issuerPEM, key := testChain(t)
+	chain, err := Chain(certPEM, issuerPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, legacy := range []bool{false, true} {
+		pfx, err := PKCS12(chain, key, "hunter2", legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k, leaf, cas, err := pkcs12.DecodeChain(pfx, "hunter2")
+		if err != nil {
+			t.Fatalf("decoding with legacy %t: %s", legacy, err)
+		}
+		if k.(*ecdsa.PrivateKey).D.Cmp(key.D) != 0 {
+			t.Error("decoded key does not match")
+		}
+		if !bytes.Equal(leaf.Raw, chain[0]) {
+			t.Error("decoded leaf does not match")
+		}
+		if len(cas) != 1 || !bytes.Equal(cas[0].Raw, chain[1]) {
+			t.Error("decoded chain does not match")
+		}
+
+		if _, _, _, err := pkcs12.DecodeChain(pfx, "wrong"); err == nil {
+			t.Error("expected error decoding with wrong password")
+		}
+	}
+
+	if _, err := PKCS12(chain, nil, "", false); err != ErrNoPrivateKey {
+		t.Errorf("expected ErrNoPrivateKey, got %v", err)
+	}
+}
+
+func TestJKS(t *testing.T) {
+	certPEM, issuerPEM, key := testChain(t)
+	chain, err := Chain(certPEM, issuerPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := JKS(chain, key, "", "tls"); err != ErrEmptyPassword {
+		t.Errorf("expected ErrEmptyPassword, got %v", err)
+	}
+
+	out, err := JKS(chain, key, "changeit", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := keystore.New()
+	if err := ks.Load(bytes.NewReader(out), []byte("changeit")); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ks.GetPrivateKeyEntry("tls", []byte("changeit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(entry.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.(*ecdsa.PrivateKey).D.Cmp(key.D) != 0 {
+		t.Error("recovered key does not match")
+	}
+	if len(entry.CertificateChain) != 2 {
+		t.Fatalf("expected chain of 2, got %d", len(entry.CertificateChain))
+	}
+	for i, c := range entry.CertificateChain {
+		if !bytes.Equal(c.Content, chain[i]) {
+			t.Errorf("certificate %d does not match", i)
+		}
+	}
+
+	if err := keystore.New().Load(bytes.NewReader(out), []byte("wrong")); err == nil {
+		t.Error("expected error loading with wrong password")
+	}
+}
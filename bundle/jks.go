@@ -0,0 +1,59 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+)
+
+// ErrEmptyPassword is returned for JKS, where keytool refuses empty passwords.
+// The API requires a password for pfx too, as both carry the private key.
+var ErrEmptyPassword = errors.New("password required for pfx and jks")
+
+// JKS encodes the private key and certificate chain as a Java KeyStore holding
+// a single PrivateKeyEntry under alias. The same password protects the entry
+// and the keystore, matching what keytool produces by default.
+func JKS(chain [][]byte, key crypto.PrivateKey, password, alias string) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoPrivateKey
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoCertificate
+	}
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := keystore.PrivateKeyEntry{
+		CreationTime: time.Now(),
+		PrivateKey:   pkcs8,
+	}
+	for _, der := range chain {
+		entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{
+			Type:    "X509",
+			Content: der,
+		})
+	}
+
+	ks := keystore.New()
+	err = ks.SetPrivateKeyEntry(alias, entry, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = ks.Store(&buf, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package bundle
+
+import (
+	"crypto"
+	"crypto/x509"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PKCS12 encodes the private key and certificate chain as a password protected
+// PKCS#12 (PFX) file. It uses AES-256 and a SHA-256 MAC unless legacy is set,
+// in which case it falls back to 3DES and a SHA-1 MAC for older Windows and
+// Java versions that can't read anything else.
+func PKCS12(chain [][]byte, key crypto.PrivateKey, password string, legacy bool) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoPrivateKey
+	}
+	certs, err := parseChain(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := pkcs12.Modern
+	if legacy {
+		enc = pkcs12.Legacy
+	}
+	return enc.Encode(key, certs[0], certs[1:], password)
+}
+
+func parseChain(chain [][]byte) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, ErrNoCertificate
+	}
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for _, der := range chain {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
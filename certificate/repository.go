@@ -7,8 +7,23 @@ import (
 // Repository provides an interface for persisting certificates.
 type Repository interface {
 	AllCerts() ([]*model.Certificate, error)
+	QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error)
+	CertSummaries() ([]*model.CertificateSummary, error)
 	Cert(id string) (*model.Certificate, error)
 	SaveCert(c *model.Certificate) error
+	SaveEndpoints(c *model.Certificate) error
+	SaveDryRun(c *model.Certificate) error
+	// SaveSecret replaces old with c.Secret, failing with ErrSecretUsed if
+	// old isn't current any more.
+	SaveSecret(c *model.Certificate, old string) error
+	// SaveInstall records i against the stored installs, so reports from
+	// different hosts don't overwrite each other, and updates c to match.
+	SaveInstall(c *model.Certificate, i *model.Install) error
+	SaveInstallTokens(c *model.Certificate) error
+	// SaveSettings saves only the fields users edit, and SaveIssuance only
+	// the ones the ACME workers own, so neither undoes the other.
+	SaveSettings(c *model.Certificate) error
+	SaveIssuance(c *model.Certificate) error
 	DeleteCert(id string) error
 	DeleteAllCerts() error
 }
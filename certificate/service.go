@@ -12,13 +12,30 @@ var (
 
 	// ErrCertExists is returned if a create is called on an existing cert
 	ErrCertExists = errors.New("cert with that id exists")
+
+	// ErrSecretUsed is returned when a cert's one time secret was rotated
+	// by someone else first.
+	ErrSecretUsed = errors.New("cert secret already used")
 )
 
 // Service provides an interface for all business operations on the Cert model.
 type Service interface {
 	AllCerts() ([]*model.Certificate, error)
+	QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error)
+	CertSummaries() ([]*model.CertificateSummary, error)
 	Cert(id string) (*model.Certificate, error)
 	SaveCert(c *model.Certificate) error
+	SaveEndpoints(c *model.Certificate) error
+	SaveDryRun(c *model.Certificate) error
+	// SaveSecret replaces old with c.Secret, failing with ErrSecretUsed if
+	// old isn't current any more.
+	SaveSecret(c *model.Certificate, old string) error
+	// SaveInstall records i against the stored installs, so reports from
+	// different hosts don't overwrite each other, and updates c to match.
+	SaveInstall(c *model.Certificate, i *model.Install) error
+	SaveInstallTokens(c *model.Certificate) error
+	SaveSettings(c *model.Certificate) error
+	SaveIssuance(c *model.Certificate) error
 	DeleteCert(id string) error
 	DeleteAllCerts() error
 }
@@ -8,6 +8,9 @@ import (
 // Service provides an interface for manipulating configs.
 type Service interface {
 	NewDNSProvider() (challenge.Provider, error)
+	// CheckDNSProvider returns an error if the stored config can't be used
+	// to build a DNS provider.
+	CheckDNSProvider() error
 	Auth() (*model.ChallengeConfig, error)
 	SetAuth(email, key string) error
 }
@@ -0,0 +1,68 @@
+// Command tlsential-agent keeps a certificate from a TLSential server
+// installed on this host. Run it from cron, or with -interval as a service.
+//
+//	tlsential-agent -config /etc/tlsential/agent.json
+//
+// An example config, with every field the agent understands:
+//
+//	{
+//	  "server": "https://tlsential.internal",
+//	  "ca_file": "/etc/tlsential/ca.pem",
+//	  "certificate_id": "1d3q...",
+//	  "token": "...",
+//	  "files": [
+//	    {"path": "/etc/nginx/pki/fullchain.pem", "contents": "fullchain"},
+//	    {"path": "/etc/nginx/pki/privkey.pem", "contents": "key", "mode": "0640"}
+//	  ],
+//	  "owner": "root",
+//	  "group": "nginx",
+//	  "reload": "nginx -t && systemctl reload nginx",
+//	  "reload_timeout": "30s"
+//	}
+//
+// The token is an install token created for this host on the certificate's
+// page or with POST /api/certificate/{id}/installtoken. File contents are
+// cert, chain, fullchain, key or combined (key and full chain in one file).
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/ImageWare/TLSential/agent"
+)
+
+func main() {
+	var configFile string
+	var interval time.Duration
+
+	flag.StringVar(&configFile, "config", "/etc/tlsential/agent.json", "agent config file")
+	flag.DurationVar(&interval, "interval", 0, "keep running and check this often, run once if 0")
+	flag.Parse()
+
+	cfg, err := agent.LoadConfig(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a, err := agent.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		installed, err := a.Run()
+		switch {
+		case err != nil && interval == 0:
+			log.Fatal(err)
+		case err != nil:
+			log.Print(err)
+		case installed:
+			log.Printf("Installed a new version of certificate %s", cfg.CertificateID)
+		}
+		if interval == 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
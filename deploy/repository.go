@@ -0,0 +1,20 @@
+package deploy
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Repository provides an interface for persisting deploy targets and the
+// state of their pushes.
+type Repository interface {
+	Targets(certID string) ([]*model.DeployTarget, error)
+	Target(id string) (*model.DeployTarget, error)
+	SaveTarget(t *model.DeployTarget) error
+	DeleteTarget(id string) error
+	// DueTargets returns pending targets whose next attempt is due.
+	DueTargets(now time.Time) ([]*model.DeployTarget, error)
+	// DeployedTargets returns every target whose last push succeeded.
+	DeployedTargets() ([]*model.DeployTarget, error)
+}
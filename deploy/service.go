@@ -0,0 +1,48 @@
+package deploy
+
+import (
+	"errors"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// ErrBusy is returned when a target is already being pushed to.
+var ErrBusy = errors.New("a deployment to this target is already running")
+
+// Service provides an interface for managing deploy targets and pushing
+// certificates to them.
+type Service interface {
+	Targets(certID string) ([]*model.DeployTarget, error)
+	Target(id string) (*model.DeployTarget, error)
+	SaveTarget(t *model.DeployTarget) error
+	DeleteTarget(id string) error
+
+	// Schedule queues c's current version for every one of its targets.
+	Schedule(c *model.Certificate) error
+
+	// Deploy queues the current version for a target, whatever its state,
+	// and starts pushing it in the background.
+	Deploy(id string) (*model.DeployTarget, error)
+
+	// DeployDue pushes to every target that's due, rescheduling failures
+	// with backoff.
+	DeployDue()
+
+	// CheckDrift checks deployed targets whose type has a Checker, recording
+	// whether they still hold what was pushed.
+	CheckDrift()
+}
+
+// Pusher puts a certificate in place on one type of target.
+type Pusher interface {
+	// Push returns whatever output the target produced, even on failure.
+	Push(t *model.DeployTarget, c *model.Certificate) (string, error)
+}
+
+// Checker is implemented by pushers that can tell whether a target still
+// holds what was pushed to it.
+type Checker interface {
+	// Check describes how t differs from the deployed version of c, or
+	// returns "" if it doesn't.
+	Check(t *model.DeployTarget, c *model.Certificate) (string, error)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/probe"
+)
+
+// probeAllCerts handshakes with every endpoint of every issued cert and records
+// what each one is serving.
+func probeAllCerts(cs certificate.Service, ns notifier.Service) {
+	certs, err := cs.AllCerts()
+	if err != nil {
+		log.Print(err.Error())
+		return
+	}
+	for _, c := range certs {
+		if !c.Issued || len(c.Endpoints) == 0 {
+			continue
+		}
+		probeCert(cs, ns, c)
+	}
+}
+
+// probeCert probes c's endpoints and saves the results, warning about any
+// endpoint that has newly gone stale or unreachable and notifying about it.
+func probeCert(cs certificate.Service, ns notifier.Service, c *model.Certificate) {
+	results := probe.Check(c, probe.DefaultTimeout)
+
+	// Probing takes a while, so judge the results against a fresh copy in
+	// case the cert was reissued in the meantime.
+	latest, err := cs.Cert(c.ID)
+	if err != nil {
+		log.Print(err.Error())
+		return
+	}
+	if latest == nil {
+		return
+	}
+
+	before := make(map[string]string)
+	for _, e := range latest.Endpoints {
+		before[e.String()] = e.Status
+	}
+
+	latest.UpdateEndpoints(results)
+	for _, e := range latest.Endpoints {
+		if e.Status != model.EndpointOK && e.Status != before[e.String()] {
+			log.Printf("***WARNING*** Certificate %s - %s is %s on %s: %s", latest.ID, latest.CommonName, e.Status, e, e.Error)
+			msg := fmt.Sprintf("The endpoint %s is %s.", e, e.Status)
+			if e.Status == model.EndpointStale {
+				msg = fmt.Sprintf("The endpoint %s is serving an old certificate (serial %s).", e, e.Serial)
+			}
+			var err error
+			if e.Error != "" {
+				err = errors.New(e.Error)
+			}
+			ns.Notify(model.NewEvent(model.EventDeployment, latest, msg, err))
+		}
+	}
+
+	err = cs.SaveEndpoints(latest)
+	if err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func deploymentChecks(cs certificate.Service, ns notifier.Service, period time.Duration) {
+	for {
+		select {
+		case <-time.After(period):
+			log.Print("Probing certificate endpoints...")
+			probeAllCerts(cs, ns)
+			break
+		}
+	}
+}
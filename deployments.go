@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/deploy"
+)
+
+// How often to check for deploy targets due a push.
+var deployPeriod = 30 * time.Second
+
+// How often to check deployed targets for drift.
+var driftPeriod = 15 * time.Minute
+
+func deployments(ds deploy.Service) {
+	drift := time.NewTicker(driftPeriod)
+	defer drift.Stop()
+	for {
+		select {
+		case <-time.After(deployPeriod):
+			ds.DeployDue()
+			break
+		case <-drift.C:
+			ds.CheckDrift()
+			break
+		}
+	}
+}
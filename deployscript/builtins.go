@@ -0,0 +1,226 @@
+package deployscript
+
+import "github.com/ImageWare/TLSential/model"
+
+// library is the shell shared by the built in templates. It downloads the
+// certificate with an install token, which downloads don't use up, so it
+// keeps no state: files are only replaced when they differ from what's
+// installed. Replaced files are put back if the reload fails, the outcome is
+// reported to the server and the script installs itself to run daily from
+// cron.
+const library = `#!/bin/sh
+# Deploys {{.CommonName}} (certificate {{.CertID}}) from TLSential, using the
+# "{{.ScriptID}}" template. Run it as root from a file, not a pipe, so it can
+# install itself to run daily from cron:
+#
+#   curl -fsS -o deploy.sh {{shquote .ScriptURL}} && sudo sh deploy.sh
+#
+# TLSENTIAL_SELF and TLSENTIAL_CRON in the environment override where it
+# keeps its own copy and its cron job.
+set -eu
+
+TLSENTIAL_SERVER={{shquote .Server}}
+TLSENTIAL_CERT={{shquote .CertID}}
+TLSENTIAL_TOKEN={{shquote .Token}}
+TLSENTIAL_SELF="${TLSENTIAL_SELF:-/usr/local/sbin/tlsential-deploy-{{.ScriptID}}-$TLSENTIAL_CERT.sh}"
+TLSENTIAL_CRON="${TLSENTIAL_CRON:-/etc/cron.d/tlsential-$TLSENTIAL_CERT}"
+TLSENTIAL_URL="$TLSENTIAL_SERVER/api/certificate/$TLSENTIAL_CERT/install"
+
+umask 077
+TLSENTIAL_WORK=$(mktemp -d)
+trap 'rm -rf "$TLSENTIAL_WORK"' EXIT
+: > "$TLSENTIAL_WORK/installed"
+
+# tlsential_cron copies this script to $TLSENTIAL_SELF and runs it daily.
+tlsential_cron() {
+	if [ ! -f "$0" ]; then
+		echo "Not installing the cron job, run this script from a file to do that" >&2
+		return
+	fi
+	if [ "$0" != "$TLSENTIAL_SELF" ]; then
+		mkdir -p "$(dirname "$TLSENTIAL_SELF")"
+		cp "$0" "$TLSENTIAL_SELF"
+		chmod 0700 "$TLSENTIAL_SELF"
+	fi
+	if [ -d "$(dirname "$TLSENTIAL_CRON")" ]; then
+		minute=$(awk 'BEGIN { srand(); print int(rand() * 60) }')
+		echo "$minute 3 * * * root /bin/sh $TLSENTIAL_SELF >/dev/null 2>&1" > "$TLSENTIAL_CRON"
+		chmod 0644 "$TLSENTIAL_CRON"
+	else
+		echo "No $(dirname "$TLSENTIAL_CRON"), schedule $TLSENTIAL_SELF to run daily yourself" >&2
+	fi
+}
+
+tlsential_header() {
+	awk -v name="$1" 'BEGIN { name = tolower(name) ":" } tolower($1) == name { sub(/\r$/, "", $2); print $2 }' "$TLSENTIAL_WORK/headers"
+}
+
+# tlsential_fetch downloads the certificate. It leaves key.pem, cert.pem,
+# chain.pem, fullchain.pem and combined.pem (key and full chain) in
+# $TLSENTIAL_WORK.
+tlsential_fetch() {
+	status=$(curl -sS -o "$TLSENTIAL_WORK/body" -D "$TLSENTIAL_WORK/headers" -w '%{http_code}' \
+		-H "Authorization: Install $TLSENTIAL_TOKEN" "$TLSENTIAL_URL?format=pem")
+	if [ "$status" != 200 ]; then
+		echo "Downloading $TLSENTIAL_CERT failed with HTTP $status" >&2
+		cat "$TLSENTIAL_WORK/body" >&2
+		exit 1
+	fi
+	TLSENTIAL_VERSION=$(tlsential_header X-Certificate-Version)
+
+	awk -v dir="$TLSENTIAL_WORK" '
+		/^-----BEGIN / { n++; f = (n == 1) ? "key" : (n == 2) ? "cert" : "chain" }
+		{ print > (dir "/" f ".pem") }
+	' "$TLSENTIAL_WORK/body"
+	touch "$TLSENTIAL_WORK/chain.pem"
+	cat "$TLSENTIAL_WORK/cert.pem" "$TLSENTIAL_WORK/chain.pem" > "$TLSENTIAL_WORK/fullchain.pem"
+	cat "$TLSENTIAL_WORK/key.pem" "$TLSENTIAL_WORK/fullchain.pem" > "$TLSENTIAL_WORK/combined.pem"
+}
+
+# tlsential_install part dest mode [owner[:group]] puts one file in place,
+# keeping the old one until the reload works. A file that's already up to
+# date is left alone.
+tlsential_install() {
+	if cmp -s "$TLSENTIAL_WORK/$1.pem" "$2"; then
+		return
+	fi
+	mkdir -p "$(dirname "$2")"
+	if [ -e "$2" ]; then
+		cp -p "$2" "$2.tlsential-old"
+	fi
+	echo "$2" >> "$TLSENTIAL_WORK/installed"
+	cp "$TLSENTIAL_WORK/$1.pem" "$2.tlsential-new"
+	chmod "$3" "$2.tlsential-new"
+	if [ -n "${4:-}" ]; then
+		chown "$4" "$2.tlsential-new"
+	fi
+	mv -f "$2.tlsential-new" "$2"
+}
+
+tlsential_report() {
+	curl -sS -o /dev/null -X POST -H "Authorization: Install $TLSENTIAL_TOKEN" \
+		-H 'Content-Type: application/json' \
+		--data "{\"Host\": \"$(hostname)\", \"Version\": $TLSENTIAL_VERSION, \"Status\": \"$1\", \"Error\": \"$2\"}" \
+		"$TLSENTIAL_URL" || echo "Reporting to TLSential failed" >&2
+}
+
+# tlsential_reload command runs the reload command if any file was replaced.
+# If it fails the old files are put back and it's run again.
+tlsential_reload() {
+	if [ ! -s "$TLSENTIAL_WORK/installed" ]; then
+		echo "$TLSENTIAL_CERT is up to date at version $TLSENTIAL_VERSION"
+		exit 0
+	fi
+	if sh -c "$1"; then
+		while read -r f; do rm -f "$f.tlsential-old"; done < "$TLSENTIAL_WORK/installed"
+		tlsential_report installed ""
+		echo "Installed version $TLSENTIAL_VERSION of $TLSENTIAL_CERT"
+		return
+	fi
+
+	while read -r f; do
+		if [ -e "$f.tlsential-old" ]; then
+			mv -f "$f.tlsential-old" "$f"
+		else
+			rm -f "$f"
+		fi
+	done < "$TLSENTIAL_WORK/installed"
+	sh -c "$1" || true
+	tlsential_report rolled_back "reload failed, rolled back"
+	echo "Reloading failed, rolled back to the previous files" >&2
+	exit 1
+}
+`
+
+// Builtins returns the templates TLSential ships with.
+func Builtins() []*model.ScriptTemplate {
+	return []*model.ScriptTemplate{
+		{
+			ID:          "nginx",
+			Name:        "nginx",
+			Description: "Installs fullchain.pem and privkey.pem under /etc/nginx/pki and reloads nginx.",
+			Body: `{{template "tlsential" .}}
+CERT_DIR=/etc/nginx/pki/{{shquote .Name}}
+
+tlsential_cron
+tlsential_fetch
+tlsential_install fullchain "$CERT_DIR/fullchain.pem" 0644
+tlsential_install key "$CERT_DIR/privkey.pem" 0600
+tlsential_reload 'nginx -t && { systemctl reload nginx || nginx -s reload; }'
+
+echo "nginx needs: ssl_certificate $CERT_DIR/fullchain.pem; ssl_certificate_key $CERT_DIR/privkey.pem;"
+`,
+			Builtin: true,
+		},
+		{
+			ID:          "apache",
+			Name:        "Apache httpd",
+			Description: "Installs fullchain.pem and privkey.pem under /etc/ssl/tlsential and gracefully reloads Apache.",
+			Body: `{{template "tlsential" .}}
+CERT_DIR=/etc/ssl/tlsential/{{shquote .Name}}
+
+tlsential_cron
+tlsential_fetch
+tlsential_install fullchain "$CERT_DIR/fullchain.pem" 0644
+tlsential_install key "$CERT_DIR/privkey.pem" 0600
+tlsential_reload 'apachectl configtest && { systemctl reload apache2 || systemctl reload httpd || apachectl graceful; }'
+
+echo "Apache needs: SSLCertificateFile $CERT_DIR/fullchain.pem and SSLCertificateKeyFile $CERT_DIR/privkey.pem"
+`,
+			Builtin: true,
+		},
+		{
+			ID:          "haproxy",
+			Name:        "HAProxy",
+			Description: "Installs the key and full chain as a single PEM under /etc/haproxy/certs and reloads HAProxy.",
+			Body: `{{template "tlsential" .}}
+CERT_FILE=/etc/haproxy/certs/{{shquote .Name}}.pem
+
+tlsential_cron
+tlsential_fetch
+tlsential_install combined "$CERT_FILE" 0600
+tlsential_reload 'haproxy -c -q -f /etc/haproxy/haproxy.cfg && systemctl reload haproxy'
+
+echo "HAProxy needs: bind :443 ssl crt $CERT_FILE"
+`,
+			Builtin: true,
+		},
+		{
+			ID:          "postfix",
+			Name:        "Postfix",
+			Description: "Installs fullchain.pem and privkey.pem under /etc/postfix/tls and reloads Postfix.",
+			Body: `{{template "tlsential" .}}
+CERT_DIR=/etc/postfix/tls/{{shquote .Name}}
+
+tlsential_cron
+tlsential_fetch
+tlsential_install fullchain "$CERT_DIR/fullchain.pem" 0644
+tlsential_install key "$CERT_DIR/privkey.pem" 0600
+tlsential_reload 'postfix check && postfix reload'
+
+echo "Postfix needs: smtpd_tls_chain_files = $CERT_DIR/privkey.pem, $CERT_DIR/fullchain.pem"
+`,
+			Builtin: true,
+		},
+		{
+			ID:          "generic",
+			Name:        "Generic",
+			Description: "Installs every format under /etc/ssl/tlsential. Set RELOAD in the installed copy to restart whatever uses them.",
+			Body: `{{template "tlsential" .}}
+CERT_DIR=/etc/ssl/tlsential/{{shquote .Name}}
+RELOAD=true
+
+tlsential_cron
+tlsential_fetch
+tlsential_install cert "$CERT_DIR/cert.pem" 0644
+tlsential_install chain "$CERT_DIR/chain.pem" 0644
+tlsential_install fullchain "$CERT_DIR/fullchain.pem" 0644
+tlsential_install key "$CERT_DIR/privkey.pem" 0600
+tlsential_reload "$RELOAD"
+
+echo "Installed to $CERT_DIR, edit RELOAD in $TLSENTIAL_SELF to restart your service"
+`,
+			Builtin: true,
+		},
+	}
+}
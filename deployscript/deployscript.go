@@ -0,0 +1,100 @@
+// Package deployscript renders the shell scripts served from /script/{id},
+// which install a certificate on a web server, reload it and keep it up to
+// date from cron.
+package deployscript
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Data is what a template can use.
+type Data struct {
+	// Server is the base URL of TLSential, without a trailing slash.
+	Server   string
+	ScriptID string
+	// ScriptURL fetches this script again for the same certificate.
+	ScriptURL string
+
+	CertID     string
+	CommonName string
+	Domains    []string
+	// Name is CommonName made safe for file names.
+	Name string
+	// Token is the install token the script was fetched with.
+	Token string
+}
+
+// NewData returns the data for rendering s for c, authenticating with the
+// install token.
+func NewData(s *model.ScriptTemplate, c *model.Certificate, server, token string) *Data {
+	server = strings.TrimSuffix(server, "/")
+	return &Data{
+		Server:     server,
+		ScriptID:   s.ID,
+		ScriptURL:  server + "/script/" + s.ID + "?cert=" + url.QueryEscape(c.ID) + "&token=" + url.QueryEscape(token),
+		CertID:     c.ID,
+		CommonName: c.CommonName,
+		Domains:    c.Domains,
+		Name:       safeName(c.CommonName),
+		Token:      token,
+	}
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+// safeName turns a common name into something usable as a file name, so
+// *.example.com becomes _.example.com.
+func safeName(s string) string {
+	return unsafeChars.ReplaceAllString(s, "_")
+}
+
+// shquote quotes s for the shell.
+func shquote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+var funcs = template.FuncMap{
+	"shquote": shquote,
+}
+
+// Parse parses a template body. Bodies can use {{template "tlsential" .}} for
+// the shared shell functions the built in templates are written with.
+func Parse(body string) (*template.Template, error) {
+	t, err := template.New("tlsential").Funcs(funcs).Option("missingkey=error").Parse(library)
+	if err != nil {
+		return nil, err
+	}
+	return t.New("script").Parse(body)
+}
+
+// Check parses body and renders it with sample data, catching templates that
+// parse but can't be rendered, such as ones using a field Data doesn't have.
+func Check(body string) error {
+	t, err := Parse(body)
+	if err != nil {
+		return err
+	}
+	c := &model.Certificate{ID: "check", CommonName: "example.com", Domains: []string{"example.com"}}
+	return t.Execute(ioutil.Discard, NewData(&model.ScriptTemplate{ID: "check"}, c, "https://tlsential.example.com", "token"))
+}
+
+// Render renders s with d.
+func Render(s *model.ScriptTemplate, d *Data) ([]byte, error) {
+	t, err := Parse(s.Body)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, d)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,273 @@
+package deployscript
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func testCert() *model.Certificate {
+	return &model.Certificate{
+		ID:         "cert1",
+		CommonName: "*.example.com",
+		Domains:    []string{"*.example.com"},
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	c := testCert()
+	for _, s := range Builtins() {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%s: %s", s.ID, err)
+		}
+		b, err := Render(s, NewData(s, c, "https://tlsential.example.com/", "it's-secret"))
+		if err != nil {
+			t.Errorf("%s: %s", s.ID, err)
+			continue
+		}
+		script := string(b)
+		if !strings.Contains(script, `TLSENTIAL_TOKEN='it'\''s-secret'`) {
+			t.Errorf("%s: expected the quoted token", s.ID)
+		}
+		if !strings.Contains(script, "https://tlsential.example.com/script/"+s.ID+"?cert="+c.ID+"&token=") {
+			t.Errorf("%s: expected the script URL", s.ID)
+		}
+		if strings.Contains(script, "*.example.com'") || !strings.Contains(script, "_.example.com") {
+			t.Errorf("%s: expected a safe file name", s.ID)
+		}
+
+		cmd := exec.Command("sh", "-n")
+		cmd.Stdin = strings.NewReader(script)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: syntax error: %s", s.ID, out)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(`{{template "tlsential" .}}echo {{shquote .CommonName}}`); err != nil {
+		t.Error(err)
+	}
+	for _, body := range []string{`{{.CommonName`, `{{.Nope}}`, `{{template "missing" .}}`} {
+		if err := Check(body); err == nil {
+			t.Errorf("expected %q to fail", body)
+		}
+	}
+}
+
+// fakeServer plays the PEM install endpoint for one certificate.
+type fakeServer struct {
+	mu      sync.Mutex
+	version int
+	token   string
+	cert    string
+	key     string
+	reports []*model.Install
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path != "/api/certificate/cert1/install" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Install "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		i := &model.Install{}
+		json.NewDecoder(r.Body).Decode(i)
+		s.reports = append(s.reports, i)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.URL.Query().Get("format") != "pem" {
+		http.Error(w, "expected format=pem", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Certificate-Version", strconv.Itoa(s.version))
+	fmt.Fprint(w, s.key+s.cert+s.cert)
+}
+
+// issue replaces the server's certificate with a new version.
+func (s *fakeServer) issue(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	s.key = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func (s *fakeServer) lastReport() *model.Install {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reports) == 0 {
+		return nil
+	}
+	return s.reports[len(s.reports)-1]
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestRun runs a rendered script against a fake server, with everything it
+// writes redirected to a temporary directory.
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not found")
+	}
+
+	dir, err := ioutil.TempDir("", "tlsential-deployscript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &fakeServer{token: "tok1.secret"}
+	fs.issue(t)
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	s := &model.ScriptTemplate{ID: "test", Body: `{{template "tlsential" .}}
+tlsential_cron
+tlsential_fetch
+tlsential_install fullchain "$DEST/fullchain.pem" 0644
+tlsential_install key "$DEST/privkey.pem" 0600
+tlsential_reload "$RELOAD"
+`}
+	c := &model.Certificate{ID: "cert1", CommonName: "example.com"}
+	b, err := Render(s, NewData(s, c, srv.URL, "tok1.secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "deploy.sh")
+	if err := ioutil.WriteFile(script, b, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "certs")
+	self := filepath.Join(dir, "sbin", "deploy.sh")
+	marker := filepath.Join(dir, "reloaded")
+	run := func(path, reload string) error {
+		cmd := exec.Command("sh", path)
+		cmd.Env = append(os.Environ(),
+			"TLSENTIAL_SELF="+self,
+			"TLSENTIAL_CRON="+filepath.Join(dir, "cron"),
+			"DEST="+dest,
+			"RELOAD="+reload,
+		)
+		out, err := cmd.CombinedOutput()
+		t.Logf("%s", out)
+		return err
+	}
+
+	// First run installs everything and sets up cron.
+	if err := run(script, "touch "+marker); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dest, "privkey.pem")); got != fs.key {
+		t.Errorf("unexpected key file %q", got)
+	}
+	if got := readFile(t, filepath.Join(dest, "fullchain.pem")); got != fs.cert+fs.cert {
+		t.Errorf("unexpected full chain %q", got)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "privkey.pem")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the key to be 0600, got %v, %v", fi, err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("expected the reload command to run")
+	}
+	if r := fs.lastReport(); r == nil || r.Status != model.InstallOK || r.Version != 1 {
+		t.Errorf("expected an installed report, got %+v", r)
+	}
+	if got := readFile(t, filepath.Join(dir, "cron")); !strings.Contains(got, self) {
+		t.Errorf("expected a cron job running %s, got %q", self, got)
+	}
+	if got := readFile(t, self); got != string(b) {
+		t.Error("expected the script to copy itself")
+	}
+
+	// Nothing new, so nothing happens.
+	os.Remove(marker)
+	if err := run(self, "touch "+marker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected no reload without a new version")
+	}
+
+	// A failing reload puts the previous files back.
+	oldKey := fs.key
+	fs.issue(t)
+	if err := run(self, "test -e "+marker+".rolledback && exit 0; touch "+marker+".rolledback; exit 1"); err == nil {
+		t.Fatal("expected the install to fail")
+	}
+	if got := readFile(t, filepath.Join(dest, "privkey.pem")); got != oldKey {
+		t.Error("expected the previous key to be restored")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dest, "*.tlsential-*")); len(matches) > 0 {
+		t.Errorf("expected no leftover files, found %v", matches)
+	}
+	if r := fs.lastReport(); r == nil || r.Status != model.InstallRolledBack || r.Version != 2 {
+		t.Errorf("expected a rolled back report, got %+v", r)
+	}
+
+	// The next run tries again with the same token.
+	if err := run(self, "true"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dest, "privkey.pem")); got != fs.key {
+		t.Error("expected the new key to be installed")
+	}
+	if r := fs.lastReport(); r == nil || r.Status != model.InstallOK || r.Version != 2 {
+		t.Errorf("expected an installed report, got %+v", r)
+	}
+}
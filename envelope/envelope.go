@@ -0,0 +1,218 @@
+// Package envelope encrypts secrets before they're stored. Each value gets
+// its own random data key for AES-GCM, and the data key is in turn wrapped
+// by a master key held by a KMS, so rotating the master key only re-wraps
+// data keys. Values are bound to the record and field they're stored in, so
+// one can't be copied over another and still open.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// prefix marks a sealed value. Anything else is treated as plaintext written
+// before encryption was turned on.
+const prefix = "tlsenc:v1:"
+
+const dataKeySize = 32
+
+// ErrMasterKeyRequired is returned when opening a sealed value without a
+// master key configured.
+var ErrMasterKeyRequired = errors.New("value is encrypted but no master key is configured")
+
+// ErrMalformed is returned for a sealed value that can't be parsed.
+var ErrMalformed = errors.New("malformed encrypted value")
+
+// Cipher seals values before they're stored and opens them when read back.
+// The aad given to Seal, usually from AAD, has to be given to Open too.
+type Cipher interface {
+	Seal(plaintext, aad []byte) ([]byte, error)
+	Open(data, aad []byte) ([]byte, error)
+	// Current reports whether data is already stored the way Seal would
+	// store it now. Values that aren't get rewritten by a reseal.
+	Current(data []byte) bool
+}
+
+// Resealer is implemented by repositories holding sealed values. Reseal
+// rewrites every value that isn't Current, which both encrypts plaintext
+// left from before a master key was configured and moves values onto a new
+// master key. It returns the number of records rewritten.
+type Resealer interface {
+	Reseal() (int, error)
+}
+
+// KMS holds master keys and uses them to wrap data keys. Implement it to keep
+// the master key in an external key management service; Keyring keeps it in
+// memory.
+type KMS interface {
+	// KeyID names the master key that Wrap uses.
+	KeyID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	// Unwrap returns the data key wrapped by the named master key.
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// AAD returns the associated data for field of the record of type kind
+// identified by id.
+func AAD(kind, id, field string) []byte {
+	return []byte(kind + "\x00" + id + "\x00" + field)
+}
+
+// IsSealed reports whether data was produced by an Envelope.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(prefix))
+}
+
+// None stores values in plaintext. It refuses to open sealed values rather
+// than hand back ciphertext.
+var None Cipher = none{}
+
+type none struct{}
+
+func (none) Seal(plaintext, aad []byte) ([]byte, error) {
+	return plaintext, nil
+}
+
+func (none) Open(data, aad []byte) ([]byte, error) {
+	if IsSealed(data) {
+		return nil, ErrMasterKeyRequired
+	}
+	return data, nil
+}
+
+func (none) Current(data []byte) bool {
+	return !IsSealed(data)
+}
+
+// Envelope is a Cipher using data keys wrapped by a KMS.
+type Envelope struct {
+	kms KMS
+}
+
+// New returns an Envelope wrapping data keys with kms.
+func New(kms KMS) *Envelope {
+	return &Envelope{kms}
+}
+
+// Seal encrypts plaintext under a new data key, authenticating aad with it.
+// Empty values stay empty so "not set" survives a round trip.
+func (e *Envelope) Seal(plaintext, aad []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	ciphertext, err := gcmSeal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := e.kms.Wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %s", err)
+	}
+
+	enc := base64.RawStdEncoding
+	return []byte(prefix + e.kms.KeyID() + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext)), nil
+}
+
+// Open decrypts a sealed value, failing if it wasn't sealed with aad.
+// Plaintext is returned as is.
+func (e *Envelope) Open(data, aad []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+
+	keyID, wrapped, ciphertext, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.kms.Unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %s", err)
+	}
+	return gcmOpen(dataKey, ciphertext, aad)
+}
+
+// Current reports whether data is empty or sealed under the current master
+// key.
+func (e *Envelope) Current(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	if !IsSealed(data) {
+		return false
+	}
+	keyID, _, _, err := parse(data)
+	return err == nil && keyID == e.kms.KeyID()
+}
+
+func parse(data []byte) (keyID string, wrapped, ciphertext []byte, err error) {
+	parts := strings.Split(string(data[len(prefix):]), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	enc := base64.RawStdEncoding
+	wrapped, err = enc.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	ciphertext, err = enc.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// gcmSeal encrypts plaintext with AES-GCM, prepending the random nonce.
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key, data, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealString is Seal for string fields.
+func SealString(c Cipher, s string, aad []byte) (string, error) {
+	buf, err := c.Seal([]byte(s), aad)
+	return string(buf), err
+}
+
+// OpenString is Open for string fields.
+func OpenString(c Cipher, s string, aad []byte) (string, error) {
+	buf, err := c.Open([]byte(s), aad)
+	return string(buf), err
+}
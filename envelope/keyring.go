@@ -0,0 +1,99 @@
+package envelope
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// MasterKeySize is the length of a master key in bytes, for AES-256.
+const MasterKeySize = 32
+
+// ErrNoMasterKey is returned for a keyring without any keys.
+var ErrNoMasterKey = errors.New("no master key given")
+
+// Keyring is a KMS holding master keys in memory. The first key wraps new
+// data keys; the rest are kept so values sealed under them can still be
+// opened until they're resealed.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring returns a Keyring for the given master keys, newest first.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+	kr := &Keyring{keys: make(map[string][]byte)}
+	for i, k := range keys {
+		if len(k) != MasterKeySize {
+			return nil, fmt.Errorf("master key %d is %d bytes, must be %d", i+1, len(k), MasterKeySize)
+		}
+		id := keyID(k)
+		if i == 0 {
+			kr.current = id
+		}
+		kr.keys[id] = k
+	}
+	return kr, nil
+}
+
+// ParseKeyring reads base64 master keys separated by whitespace or commas,
+// newest first. Lines starting with # are ignored.
+func ParseKeyring(s string) (*Keyring, error) {
+	var keys [][]byte
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		}) {
+			k, err := base64.StdEncoding.DecodeString(f)
+			if err != nil {
+				return nil, fmt.Errorf("master key %d isn't valid base64", len(keys)+1)
+			}
+			keys = append(keys, k)
+		}
+	}
+	return NewKeyring(keys...)
+}
+
+// LoadKeyring reads a keyring file in the format ParseKeyring expects.
+func LoadKeyring(file string) (*Keyring, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(buf))
+}
+
+// keyID names a master key without giving anything useful away.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// KeyID returns the ID of the newest master key.
+func (kr *Keyring) KeyID() string {
+	return kr.current
+}
+
+// Wrap encrypts a data key under the newest master key.
+func (kr *Keyring) Wrap(dataKey []byte) ([]byte, error) {
+	return gcmSeal(kr.keys[kr.current], dataKey, nil)
+}
+
+// Unwrap decrypts a data key wrapped by the named master key.
+func (kr *Keyring) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	k, ok := kr.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s isn't in the keyring", keyID)
+	}
+	return gcmOpen(k, wrapped, nil)
+}
@@ -0,0 +1,64 @@
+// Package events is an in-process publish/subscribe bus for certificate
+// events, so API clients can wait on a certificate instead of polling it.
+package events
+
+import (
+	"sync"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// subscriberBuffer is how many events a slow subscriber can fall behind by
+// before further events are dropped for it.
+const subscriberBuffer = 16
+
+// Bus fans events out to the subscribers of each certificate. The zero value
+// isn't usable; use NewBus.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan *model.Event]struct{}
+}
+
+// NewBus returns an empty Bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[string]map[chan *model.Event]struct{})}
+}
+
+// Publish sends e to everyone subscribed to e.CertID. It never blocks; events
+// are dropped for subscribers whose buffer is full.
+func (b *Bus) Publish(e *model.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[e.CertID] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events for certID, and a function
+// that must be called to unsubscribe once the caller is done with it.
+func (b *Bus) Subscribe(certID string) (<-chan *model.Event, func()) {
+	ch := make(chan *model.Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[certID] == nil {
+		b.subs[certID] = make(map[chan *model.Event]struct{})
+	}
+	b.subs[certID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[certID], ch)
+			if len(b.subs[certID]) == 0 {
+				delete(b.subs, certID)
+			}
+			b.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+
+	ch, unsubscribe := b.Subscribe("a")
+	other, unsubscribeOther := b.Subscribe("b")
+	defer unsubscribeOther()
+
+	b.Publish(&model.Event{CertID: "a", Type: model.EventIssued})
+	select {
+	case e := <-ch:
+		if e.Type != model.EventIssued {
+			t.Errorf("expected issued, got %s", e.Type)
+		}
+	default:
+		t.Fatal("expected an event")
+	}
+	select {
+	case <-other:
+		t.Error("events shouldn't reach other certificates' subscribers")
+	default:
+	}
+
+	// A subscriber that doesn't read mustn't block publishers.
+	for i := 0; i < subscriberBuffer*2; i++ {
+		b.Publish(&model.Event{CertID: "a"})
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := b.subs["a"]; ok {
+		t.Error("expected the subscription to be removed")
+	}
+}
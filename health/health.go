@@ -0,0 +1,75 @@
+// Package health tracks the state of the background workers so readiness
+// and diagnostics can report on them.
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+var mu sync.Mutex
+var workers int
+var lastDequeue time.Time
+var lastScan *model.ScanResult
+
+// WorkerStarted records a renewal worker starting. Workers call WorkerStopped
+// when they exit.
+func WorkerStarted() {
+	mu.Lock()
+	defer mu.Unlock()
+	workers++
+	if lastDequeue.IsZero() {
+		// Count from startup, so an idle queue isn't mistaken for a stuck one.
+		lastDequeue = time.Now()
+	}
+}
+
+// WorkerStopped records a renewal worker exiting.
+func WorkerStopped() {
+	mu.Lock()
+	defer mu.Unlock()
+	workers--
+}
+
+// Dequeued records a worker taking a certificate off the renewal queue.
+func Dequeued() {
+	mu.Lock()
+	defer mu.Unlock()
+	lastDequeue = time.Now()
+}
+
+// RecordScan stores the result of the latest renewal scan.
+func RecordScan(r *model.ScanResult) {
+	mu.Lock()
+	defer mu.Unlock()
+	lastScan = r
+}
+
+// Workers returns how many renewal workers are running and when one last
+// took something off the queue.
+func Workers() (int, time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	return workers, lastDequeue
+}
+
+// LastScan returns a copy of the latest scan result, or nil if there hasn't
+// been one.
+func LastScan() *model.ScanResult {
+	mu.Lock()
+	defer mu.Unlock()
+	if lastScan == nil {
+		return nil
+	}
+	r := *lastScan
+	return &r
+}
+
+// Wedged reports whether the queue has had work waiting for longer than
+// after without any worker picking it up.
+func Wedged(queueLength int, after time.Duration, now time.Time) bool {
+	_, last := Workers()
+	return queueLength > 0 && now.Sub(last) > after
+}
@@ -0,0 +1,45 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func TestWorkers(t *testing.T) {
+	WorkerStarted()
+	n, started := Workers()
+	if n != 1 || started.IsZero() {
+		t.Fatalf("expected 1 worker and a start time, got %d, %v", n, started)
+	}
+
+	now := time.Now()
+	if Wedged(0, time.Minute, now.Add(time.Hour)) {
+		t.Error("an empty queue is never wedged")
+	}
+	if !Wedged(3, time.Minute, now.Add(time.Hour)) {
+		t.Error("expected a queue that hasn't moved for an hour to be wedged")
+	}
+	Dequeued()
+	if Wedged(3, time.Minute, time.Now()) {
+		t.Error("expected the queue to be moving after a dequeue")
+	}
+
+	WorkerStopped()
+	if n, _ := Workers(); n != 0 {
+		t.Errorf("expected no workers, got %d", n)
+	}
+}
+
+func TestLastScan(t *testing.T) {
+	RecordScan(&model.ScanResult{Checked: 2, Queued: 1})
+	r := LastScan()
+	if r == nil || r.Checked != 2 || r.Queued != 1 {
+		t.Fatalf("unexpected scan result %+v", r)
+	}
+	r.Checked = 5
+	if LastScan().Checked != 2 {
+		t.Error("LastScan should return a copy")
+	}
+}
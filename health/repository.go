@@ -0,0 +1,13 @@
+package health
+
+import "github.com/ImageWare/TLSential/model"
+
+// Repository reports on the database backing the other repositories.
+type Repository interface {
+	// Ping returns an error if the database can't be read.
+	Ping() error
+	Stats() (*model.DBStats, error)
+	// Check looks for corruption, returning a description of each problem
+	// found.
+	Check() ([]string, error)
+}
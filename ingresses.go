@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/ImageWare/TLSential/ingresswatch"
+	"github.com/ImageWare/TLSential/kube"
+)
+
+// How often to sync Ingresses when none have changed, to collect orphaned
+// certificates.
+var ingressResync = 10 * time.Minute
+
+// newIngressController connects to the cluster with the kubeconfig named in
+// config, from the deploy key directory, or as the pod's service account.
+func newIngressController(db *repositories, config ingresswatch.Config) (*ingresswatch.Controller, error) {
+	var kubeconfig string
+	if config.Kubeconfig != "" {
+		kubeconfig = filepath.Join(deployKeyDir, config.Kubeconfig)
+	}
+	client, err := kube.NewClient(kubeconfig, config.Context)
+	if err != nil {
+		return nil, err
+	}
+	return ingresswatch.New(client, newCertService(db), newDeployService(db), newACMEService(db, newNotifierService(db)), config), nil
+}
+
+func ingressWatcher(c *ingresswatch.Controller) {
+	err := c.Run(make(chan struct{}), ingressResync)
+	if err != nil {
+		log.Printf("ingressWatcher: %s", err.Error())
+	}
+}
@@ -0,0 +1,421 @@
+// Package ingresswatch creates certificates for Kubernetes Ingresses that ask
+// for one, and keeps the Secrets they name filled in.
+//
+// Ingresses are watched with a shared informer, and any change syncs them all
+// from its cache, as a sync has to see every Ingress to notice deletions.
+package ingresswatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IssueAnnotation set to "true" on an Ingress asks for certificates for its
+// TLS hosts.
+const IssueAnnotation = "tlsential.io/issue"
+
+// ManagedBy is the model.ManagedByLabel value on the certificates the
+// controller manages. Only certificates carrying it are ever updated or
+// collected.
+const ManagedBy = "ingresswatch"
+
+// Labels on the certificates the controller manages. A certificate is
+// identified by the Secret it's for, so Ingresses sharing a Secret share a
+// certificate covering all their hosts. Like ManagedBy, they're reserved, so
+// API and UI callers can't change them and break the link to the Secret.
+const (
+	NamespaceLabel = model.IngressLabelPrefix + "namespace"
+	SecretLabel    = model.IngressLabelPrefix + "secret"
+	// OrphanedLabel holds when, in Unix seconds, the last Ingress using the
+	// certificate went away.
+	OrphanedLabel = model.IngressLabelPrefix + "orphaned"
+)
+
+// maxLabelValue is the longest label value a certificate can hold.
+const maxLabelValue = 63
+
+// Config is how the controller reaches the cluster and what it may issue.
+type Config struct {
+	// Namespace limits the controller to one namespace, or it's all of
+	// them if empty.
+	Namespace string
+	// Kubeconfig and Context are copied to the deploy targets created for
+	// each Secret.
+	Kubeconfig string
+	Context    string
+
+	// Domains is the domain policy: hosts must be one of these or under
+	// one. Nothing is issued for an Ingress with any other host.
+	Domains []string
+	// Email is the ACME account email for new certificates.
+	Email string
+	// GracePeriod is how long a certificate is kept after the last Ingress
+	// using it is deleted, in case it comes back.
+	GracePeriod time.Duration
+}
+
+// Controller creates, updates and garbage collects certificates to match
+// the cluster's Ingresses.
+type Controller struct {
+	client  kubernetes.Interface
+	certs   certificate.Service
+	deploys deploy.Service
+	acme    acme.Service
+	config  Config
+
+	// list returns the Ingresses to sync, from the informer's cache once
+	// Run has started it.
+	list func() ([]*networkingv1.Ingress, error)
+	// newCert sets up a certificate, registering its ACME account.
+	newCert func(domains []string, email string) (*model.Certificate, error)
+	now     func() time.Time
+	// refused is the last reason each Secret's certificate couldn't be
+	// synced, so it's only logged when it changes.
+	refused map[string]string
+}
+
+// New returns a Controller for the cluster client talks to.
+func New(client kubernetes.Interface, cs certificate.Service, ds deploy.Service, as acme.Service, config Config) *Controller {
+	c := &Controller{
+		client:  client,
+		certs:   cs,
+		deploys: ds,
+		acme:    as,
+		config:  config,
+		newCert: model.NewCertificate,
+		now:     time.Now,
+		refused: make(map[string]string),
+	}
+	c.list = c.listIngresses
+	return c
+}
+
+// listIngresses lists the Ingresses straight from the cluster.
+func (c *Controller) listIngresses() ([]*networkingv1.Ingress, error) {
+	list, err := c.client.NetworkingV1().Ingresses(c.config.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ingresses := make([]*networkingv1.Ingress, 0, len(list.Items))
+	for i := range list.Items {
+		ingresses = append(ingresses, &list.Items[i])
+	}
+	return ingresses, nil
+}
+
+// Run watches the Ingresses until stop is closed, syncing whenever one
+// changes. It also syncs every resync, so orphaned certificates are deleted
+// once their grace period is up.
+func (c *Controller) Run(stop <-chan struct{}, resync time.Duration) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(c.config.Namespace))
+	defer factory.Shutdown()
+	informer := factory.Networking().V1().Ingresses()
+
+	// A burst of changes only needs one sync.
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		return err
+	}
+	lister := informer.Lister()
+	c.list = func() ([]*networkingv1.Ingress, error) {
+		return lister.List(labels.Everything())
+	}
+
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.Informer().HasSynced) {
+		// Only happens once stop is closed.
+		return nil
+	}
+
+	for {
+		err := c.Sync()
+		if err != nil {
+			log.Printf("ingresswatch: %s", err.Error())
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-changed:
+		case <-time.After(resync):
+		}
+	}
+}
+
+// demand is what the Ingresses using one Secret ask for.
+type demand struct {
+	namespace string
+	secret    string
+	hosts     map[string]bool
+}
+
+func (d *demand) domains() []string {
+	var domains []string
+	for h := range d.hosts {
+		domains = append(domains, h)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func key(namespace, secret string) string {
+	return namespace + "/" + secret
+}
+
+// Sync makes one pass over the Ingresses, creating or updating the
+// certificate for each Secret they name, and marking or removing
+// certificates no Ingress uses any more.
+func (c *Controller) Sync() error {
+	ingresses, err := c.list()
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]*demand)
+	for _, ing := range ingresses {
+		if ing.Annotations[IssueAnnotation] != "true" {
+			continue
+		}
+		for _, tls := range ing.Spec.TLS {
+			// Without a Secret the ingress controller's default
+			// certificate is used, so there's nothing to fill in.
+			if tls.SecretName == "" || len(tls.Hosts) == 0 {
+				continue
+			}
+			k := key(ing.Namespace, tls.SecretName)
+			d := wanted[k]
+			if d == nil {
+				d = &demand{namespace: ing.Namespace, secret: tls.SecretName, hosts: make(map[string]bool)}
+				wanted[k] = d
+			}
+			for _, h := range tls.Hosts {
+				d.hosts[strings.ToLower(h)] = true
+			}
+		}
+	}
+
+	certs, err := c.managedCerts()
+	if err != nil {
+		return err
+	}
+	managed := make(map[string]*model.Certificate)
+	for _, cert := range certs {
+		ns, secret := cert.Labels[NamespaceLabel], cert.Labels[SecretLabel]
+		if ns != "" && secret != "" {
+			managed[key(ns, secret)] = cert
+		}
+	}
+
+	for k, d := range wanted {
+		err := c.sync(d, managed[k])
+		if err != nil {
+			if c.refused[k] != err.Error() {
+				log.Printf("ingresswatch: secret %s: %s", k, err.Error())
+			}
+			c.refused[k] = err.Error()
+			continue
+		}
+		delete(c.refused, k)
+	}
+	for k, cert := range managed {
+		if wanted[k] != nil {
+			continue
+		}
+		delete(c.refused, k)
+		err := c.orphan(cert)
+		if err != nil {
+			log.Printf("ingresswatch: secret %s: %s", k, err.Error())
+		}
+	}
+	return nil
+}
+
+// managedCerts returns the controller's certificates, only from the
+// namespace it's limited to if any, as another namespace's Ingresses weren't
+// listed and its certificates would look orphaned.
+func (c *Controller) managedCerts() ([]*model.Certificate, error) {
+	q := &model.CertificateQuery{
+		Labels: map[string]string{model.ManagedByLabel: ManagedBy},
+		Limit:  model.MaxPageSize,
+	}
+	if c.config.Namespace != "" {
+		q.Labels[NamespaceLabel] = c.config.Namespace
+	}
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*model.Certificate
+	for {
+		page, err := c.certs.QueryCerts(q)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, page.Certs...)
+		if page.NextCursor == "" {
+			return certs, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// sync creates or updates the certificate for d's Secret, and its deploy
+// target.
+func (c *Controller) sync(d *demand, cert *model.Certificate) error {
+	domains := d.domains()
+	err := c.allowed(domains)
+	if err != nil {
+		return err
+	}
+
+	reissue, created := false, cert == nil
+	if created {
+		// Secret names can be longer than a label value.
+		if len(d.secret) > maxLabelValue {
+			return errors.New("the secret's name is too long to label a certificate with")
+		}
+		labels := map[string]string{NamespaceLabel: d.namespace, SecretLabel: d.secret}
+		cert, err = c.newCert(domains, c.config.Email)
+		if err != nil {
+			return err
+		}
+		labels[model.ManagedByLabel] = ManagedBy
+		cert.Labels = labels
+		reissue = true
+		log.Printf("ingresswatch: creating certificate %s for secret %s, %s", cert.ID, key(d.namespace, d.secret), strings.Join(domains, ", "))
+	} else {
+		changed, err := cert.SetDomains(domains)
+		if err != nil {
+			return err
+		}
+		_, orphaned := cert.Labels[OrphanedLabel]
+		if !changed && !orphaned {
+			return c.ensureTarget(d, cert)
+		}
+		delete(cert.Labels, OrphanedLabel)
+		// Renew would reuse the old key for the new names, so go through a
+		// full issuance, as the API does.
+		reissue = changed
+		if changed {
+			log.Printf("ingresswatch: reissuing certificate %s for secret %s, %s", cert.ID, key(d.namespace, d.secret), strings.Join(domains, ", "))
+		}
+	}
+
+	if created {
+		err = c.certs.SaveCert(cert)
+	} else {
+		err = c.certs.SaveSettings(cert)
+	}
+	if err != nil {
+		return err
+	}
+	if reissue {
+		go func(id string) { c.acme.GetIssueChannel() <- id }(cert.ID)
+	}
+	return c.ensureTarget(d, cert)
+}
+
+// ensureTarget adds a deploy target for d's Secret to cert if it hasn't got
+// one, pushing the current version straight away if there is one.
+func (c *Controller) ensureTarget(d *demand, cert *model.Certificate) error {
+	targets, err := c.deploys.Targets(cert.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		k := t.Kubernetes
+		if t.Type == model.DeployTargetKubernetes && k != nil && k.Namespace == d.namespace && k.Name == d.secret {
+			return nil
+		}
+	}
+
+	t := model.NewDeployTarget(cert.ID)
+	t.Type = model.DeployTargetKubernetes
+	t.Kubernetes = &model.KubernetesTarget{
+		Kubeconfig: c.config.Kubeconfig,
+		Context:    c.config.Context,
+		Namespace:  d.namespace,
+		Name:       d.secret,
+	}
+	if cert.Issued {
+		t.Schedule(cert.Version)
+	}
+	return c.deploys.SaveTarget(t)
+}
+
+// orphan marks a certificate no Ingress uses, deleting it once it's been
+// unused for the grace period. The Secret is left for whoever owns it.
+func (c *Controller) orphan(cert *model.Certificate) error {
+	since, err := strconv.ParseInt(cert.Labels[OrphanedLabel], 10, 64)
+	if err != nil {
+		cert.Labels[OrphanedLabel] = strconv.FormatInt(c.now().Unix(), 10)
+		log.Printf("ingresswatch: certificate %s is no longer used by any ingress, deleting it in %s", cert.ID, c.config.GracePeriod)
+		return c.certs.SaveSettings(cert)
+	}
+	if c.now().Before(time.Unix(since, 0).Add(c.config.GracePeriod)) {
+		return nil
+	}
+
+	targets, err := c.deploys.Targets(cert.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		err = c.deploys.DeleteTarget(t.ID)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("ingresswatch: deleting certificate %s, unused by any ingress since %s", cert.ID, time.Unix(since, 0).Format(time.RFC3339))
+	return c.certs.DeleteCert(cert.ID)
+}
+
+// allowed checks every domain against the domain policy.
+func (c *Controller) allowed(domains []string) error {
+	for _, d := range domains {
+		if !Allowed(c.config.Domains, d) {
+			return fmt.Errorf("%s isn't allowed by the domain policy", d)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether host is one of domains or a subdomain of one. A
+// wildcard host is treated as the domain under it.
+func Allowed(domains []string, host string) bool {
+	host = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(host), "*."), ".")
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(d), ".")
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
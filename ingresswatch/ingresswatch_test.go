@@ -0,0 +1,411 @@
+package ingresswatch
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/segmentio/ksuid"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeCerts implements only what the controller uses.
+type fakeCerts struct {
+	certificate.Service
+	certs map[string]*model.Certificate
+}
+
+func (f *fakeCerts) QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error) {
+	var summaries []*model.CertificateSummary
+	for _, c := range f.certs {
+		summaries = append(summaries, c.Summary())
+	}
+	ids, next, total, err := q.Page(summaries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	page := &model.CertificatePage{NextCursor: next, Total: total}
+	for _, id := range ids {
+		page.Certs = append(page.Certs, f.certs[id])
+	}
+	return page, nil
+}
+
+func (f *fakeCerts) SaveCert(c *model.Certificate) error {
+	f.certs[c.ID] = c
+	return nil
+}
+
+func (f *fakeCerts) SaveSettings(c *model.Certificate) error {
+	return f.SaveCert(c)
+}
+
+func (f *fakeCerts) DeleteCert(id string) error {
+	delete(f.certs, id)
+	return nil
+}
+
+type fakeDeploys struct {
+	deploy.Service
+	targets map[string]*model.DeployTarget
+}
+
+func (f *fakeDeploys) Targets(certID string) ([]*model.DeployTarget, error) {
+	var targets []*model.DeployTarget
+	for _, t := range f.targets {
+		if t.CertID == certID {
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
+
+func (f *fakeDeploys) SaveTarget(t *model.DeployTarget) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	f.targets[t.ID] = t
+	return nil
+}
+
+func (f *fakeDeploys) DeleteTarget(id string) error {
+	delete(f.targets, id)
+	return nil
+}
+
+type fakeACME struct {
+	acme.Service
+	issue chan string
+}
+
+func (f *fakeACME) GetIssueChannel() chan string {
+	return f.issue
+}
+
+type env struct {
+	client  *fake.Clientset
+	certs   *fakeCerts
+	deploys *fakeDeploys
+	issue   chan string
+	now     time.Time
+	c       *Controller
+}
+
+func newEnv() *env {
+	e := &env{
+		client:  fake.NewSimpleClientset(),
+		certs:   &fakeCerts{certs: make(map[string]*model.Certificate)},
+		deploys: &fakeDeploys{targets: make(map[string]*model.DeployTarget)},
+		issue:   make(chan string, 10),
+		now:     time.Now(),
+	}
+	e.c = New(e.client, e.certs, e.deploys, &fakeACME{issue: e.issue}, Config{
+		Kubeconfig:  "prod.yaml",
+		Domains:     []string{"example.com"},
+		Email:       "ops@example.org",
+		GracePeriod: time.Hour,
+	})
+	e.c.newCert = func(domains []string, email string) (*model.Certificate, error) {
+		return &model.Certificate{ID: ksuid.New().String(), Domains: domains, CommonName: domains[0], ACMEEmail: email}, nil
+	}
+	e.c.now = func() time.Time { return e.now }
+	return e
+}
+
+func (e *env) sync(t *testing.T) {
+	t.Helper()
+	if err := e.c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// issued returns the IDs queued for issuance.
+func (e *env) issued(t *testing.T, want int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < want; i++ {
+		select {
+		case id := <-e.issue:
+			ids = append(ids, id)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d issuances, got %d", want, i)
+		}
+	}
+	select {
+	case id := <-e.issue:
+		t.Fatalf("unexpected issuance of %s", id)
+	case <-time.After(10 * time.Millisecond):
+	}
+	return ids
+}
+
+// saveIngress creates or replaces an Ingress, as applying a manifest would.
+func (e *env) saveIngress(t *testing.T, i *networkingv1.Ingress) {
+	t.Helper()
+	ingresses := e.client.NetworkingV1().Ingresses(i.Namespace)
+	_, err := ingresses.Update(context.Background(), i, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = ingresses.Create(context.Background(), i, metav1.CreateOptions{})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e *env) deleteIngress(t *testing.T, namespace, name string) {
+	t.Helper()
+	err := e.client.NetworkingV1().Ingresses(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ingress(name, secret string, hosts ...string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "web",
+			Name:        name,
+			Annotations: map[string]string{IssueAnnotation: "true"},
+		},
+		Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{Hosts: hosts, SecretName: secret}}},
+	}
+}
+
+func TestSyncCreates(t *testing.T) {
+	e := newEnv()
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.saveIngress(t, ingress("api", "www-tls", "API.example.com"))
+	unannotated := ingress("blog", "blog-tls", "blog.example.com")
+	unannotated.Annotations = nil
+	e.saveIngress(t, unannotated)
+	e.sync(t)
+
+	if len(e.certs.certs) != 1 {
+		t.Fatalf("expected one certificate, got %d", len(e.certs.certs))
+	}
+	ids := e.issued(t, 1)
+	c := e.certs.certs[ids[0]]
+	if c == nil || len(c.Domains) != 2 || c.Domains[0] != "api.example.com" || c.Domains[1] != "www.example.com" {
+		t.Fatalf("expected a certificate for both hosts, got %+v", c)
+	}
+	if c.Labels[NamespaceLabel] != "web" || c.Labels[SecretLabel] != "www-tls" || c.Labels[model.ManagedByLabel] != ManagedBy || c.ACMEEmail != "ops@example.org" {
+		t.Errorf("unexpected certificate %+v", c)
+	}
+	targets, _ := e.deploys.Targets(c.ID)
+	if len(targets) != 1 {
+		t.Fatalf("expected a deploy target, got %d", len(targets))
+	}
+	k := targets[0].Kubernetes
+	if k == nil || k.Namespace != "web" || k.Name != "www-tls" || k.Kubeconfig != "prod.yaml" || targets[0].Status != "" {
+		t.Errorf("unexpected target %+v", targets[0])
+	}
+
+	// Nothing changes on the next pass.
+	e.sync(t)
+	e.issued(t, 0)
+	if len(e.certs.certs) != 1 || len(e.deploys.targets) != 1 {
+		t.Errorf("expected nothing new, got %d certificates and %d targets", len(e.certs.certs), len(e.deploys.targets))
+	}
+}
+
+func TestSyncUpdates(t *testing.T) {
+	e := newEnv()
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.sync(t)
+	id := e.issued(t, 1)[0]
+	c := e.certs.certs[id]
+	c.Issued, c.Version = true, 1
+
+	// A deleted target comes back, due straight away as the certificate
+	// has been issued.
+	for tid := range e.deploys.targets {
+		e.deploys.DeleteTarget(tid)
+	}
+	e.sync(t)
+	targets, _ := e.deploys.Targets(id)
+	if len(targets) != 1 || targets[0].Status != model.DeployPending || targets[0].Version != 1 {
+		t.Fatalf("expected the target to be recreated and pending, got %v", targets)
+	}
+	e.issued(t, 0)
+
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com", "example.com"))
+	e.sync(t)
+	if got := e.issued(t, 1); got[0] != id {
+		t.Errorf("expected %s to be reissued, got %s", id, got[0])
+	}
+	// The new host is requested, and only replaces the issued ones once the
+	// reissue succeeds.
+	if d := c.RequestedDomains(); len(d) != 2 || d[0] != "example.com" || len(c.Domains) != 1 {
+		t.Errorf("expected the new host to be requested, got %v", d)
+	}
+
+	// Another sync doesn't queue it again.
+	e.sync(t)
+	e.issued(t, 0)
+}
+
+func TestSyncPolicy(t *testing.T) {
+	e := newEnv()
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com", "www.example.net"))
+	e.saveIngress(t, ingress("wild", "wild-tls", "*.apps.example.com"))
+	e.sync(t)
+
+	ids := e.issued(t, 1)
+	if len(e.certs.certs) != 1 || e.certs.certs[ids[0]].Labels[SecretLabel] != "wild-tls" {
+		t.Errorf("expected only the wildcard's certificate, got %v", e.certs.certs)
+	}
+	if e.c.refused["web/www-tls"] == "" {
+		t.Error("expected the refusal to be remembered")
+	}
+}
+
+func TestSyncCollects(t *testing.T) {
+	e := newEnv()
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.sync(t)
+	id := e.issued(t, 1)[0]
+
+	e.deleteIngress(t, "web", "www")
+	e.sync(t)
+	c := e.certs.certs[id]
+	if c == nil || c.Labels[OrphanedLabel] != strconv.FormatInt(e.now.Unix(), 10) {
+		t.Fatalf("expected the certificate to be marked orphaned, got %+v", c)
+	}
+
+	// Coming back within the grace period keeps the certificate.
+	e.now = e.now.Add(30 * time.Minute)
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.sync(t)
+	if _, ok := c.Labels[OrphanedLabel]; ok {
+		t.Error("expected the orphaned label to be removed")
+	}
+	e.issued(t, 0)
+
+	e.deleteIngress(t, "web", "www")
+	e.sync(t)
+	e.now = e.now.Add(59 * time.Minute)
+	e.sync(t)
+	if e.certs.certs[id] == nil {
+		t.Fatal("expected the certificate to be kept during the grace period")
+	}
+	e.now = e.now.Add(2 * time.Minute)
+	e.sync(t)
+	if e.certs.certs[id] != nil || len(e.deploys.targets) != 0 {
+		t.Errorf("expected the certificate and its target to be deleted, got %d targets", len(e.deploys.targets))
+	}
+}
+
+func TestSyncNamespace(t *testing.T) {
+	e := newEnv()
+	e.c.config.Namespace = "web"
+	other := &model.Certificate{
+		ID:     ksuid.New().String(),
+		Labels: map[string]string{model.ManagedByLabel: ManagedBy, NamespaceLabel: "shop", SecretLabel: "shop-tls"},
+	}
+	e.certs.certs[other.ID] = other
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.now = e.now.Add(2 * time.Hour)
+	e.sync(t)
+	e.sync(t)
+
+	e.issued(t, 1)
+	if e.certs.certs[other.ID] == nil {
+		t.Fatal("expected another namespace's certificate to be kept")
+	}
+	if _, ok := other.Labels[OrphanedLabel]; ok {
+		t.Error("expected another namespace's certificate not to be marked orphaned")
+	}
+}
+
+func TestSyncUnmanaged(t *testing.T) {
+	e := newEnv()
+	// Labels anyone can set don't make a certificate the controller's.
+	mine := &model.Certificate{
+		ID:     ksuid.New().String(),
+		Labels: map[string]string{NamespaceLabel: "web", SecretLabel: "www-tls"},
+	}
+	e.certs.certs[mine.ID] = mine
+	e.sync(t)
+	e.now = e.now.Add(2 * time.Hour)
+	e.sync(t)
+	if e.certs.certs[mine.ID] == nil || mine.Labels[OrphanedLabel] != "" {
+		t.Fatalf("expected an unmanaged certificate to be left alone, got %+v", mine)
+	}
+
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.sync(t)
+	if id := e.issued(t, 1)[0]; id == mine.ID {
+		t.Error("expected a new certificate rather than taking over an unmanaged one")
+	}
+	if len(mine.Domains) != 0 {
+		t.Errorf("expected the unmanaged certificate to be unchanged, got %v", mine.Domains)
+	}
+}
+
+func TestSyncRelabelled(t *testing.T) {
+	e := newEnv()
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	e.sync(t)
+	id := e.issued(t, 1)[0]
+
+	// Replacing the labels through the API or UI keeps the controller's.
+	e.certs.certs[id].SetLabels(map[string]string{"team": "web"})
+	e.sync(t)
+	e.issued(t, 0)
+	if len(e.certs.certs) != 1 || e.certs.certs[id].Labels[SecretLabel] != "www-tls" {
+		t.Errorf("expected the certificate to still be matched to its secret, got %d certificates", len(e.certs.certs))
+	}
+}
+
+func TestRun(t *testing.T) {
+	e := newEnv()
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- e.c.Run(stop, time.Hour) }()
+
+	// A change is picked up straight away rather than at the next resync.
+	e.saveIngress(t, ingress("www", "www-tls", "www.example.com"))
+	select {
+	case <-e.issue:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the new ingress to be synced")
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(e.certs.certs) != 1 {
+		t.Errorf("expected one certificate, got %d", len(e.certs.certs))
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	domains := []string{"example.com", "Corp.Example.NET."}
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"*.example.com", true},
+		{"WWW.corp.example.net", true},
+		{"badexample.com", false},
+		{"example.net", false},
+		{"*.com", false},
+	}
+	for _, tt := range tests {
+		if got := Allowed(domains, tt.host); got != tt.allowed {
+			t.Errorf("%s: expected %t, got %t", tt.host, tt.allowed, got)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Package kube connects to Kubernetes API servers with client-go, using a
+// kubeconfig file or the pod's service account.
+package kube
+
+import (
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// timeout bounds each API request.
+const timeout = 30 * time.Second
+
+// NewClient returns a clientset for the cluster in the kubeconfig file, using
+// context or the file's current one. Without a file it uses the pod's service
+// account.
+func NewClient(kubeconfig, context string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = timeout
+	return kubernetes.NewForConfig(config)
+}
@@ -0,0 +1,81 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+users:
+- name: deploy
+  user:
+    token: %s
+contexts:
+- name: prod
+  context: {cluster: prod, user: deploy}
+- name: other
+  context: {cluster: prod, user: deploy, namespace: other}
+current-context: other
+`
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/web/secrets/www-tls" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "www-tls"},
+			Type:       corev1.SecretTypeTLS,
+		})
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "tlsential-kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prod.yaml")
+	err = ioutil.WriteFile(path, []byte(fmt.Sprintf(kubeconfig, srv.URL, "sekrit")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(path, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := client.CoreV1().Secrets("web").Get(context.Background(), "www-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != corev1.SecretTypeTLS {
+		t.Errorf("unexpected secret %+v", s)
+	}
+
+	if _, err := NewClient(path, "missing"); err == nil {
+		t.Error("expected an unknown context to be refused")
+	}
+}
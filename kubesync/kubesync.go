@@ -0,0 +1,202 @@
+// Package kubesync keeps kubernetes.io/tls Secrets in step with certificates
+// and reports when someone else has changed them.
+package kubesync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/kube"
+	"github.com/ImageWare/TLSential/model"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Metadata TLSential puts on the Secrets it writes.
+const (
+	ManagedByLabel    = "app.kubernetes.io/managed-by"
+	ManagedBy         = "tlsential"
+	CertIDAnnotation  = "tlsential.io/cert-id"
+	VersionAnnotation = "tlsential.io/version"
+)
+
+// updateRetries is how many times an update that lost a race with another
+// writer is retried.
+const updateRetries = 3
+
+// Pusher pushes to kubernetes targets and checks them for drift.
+type Pusher interface {
+	deploy.Pusher
+	deploy.Checker
+}
+
+type pusher struct {
+	keyDir string
+	// client, if set, is used for every target instead of its kubeconfig.
+	client kubernetes.Interface
+}
+
+// New returns a Pusher for kubernetes targets, reading kubeconfigs from
+// keyDir. Targets without one use the pod's service account.
+func New(keyDir string) Pusher {
+	return &pusher{keyDir: keyDir}
+}
+
+// NewWithClient returns a Pusher that uses c for every target.
+func NewWithClient(c kubernetes.Interface) Pusher {
+	return &pusher{client: c}
+}
+
+func (p *pusher) clientFor(k *model.KubernetesTarget) (kubernetes.Interface, error) {
+	if p.client != nil {
+		return p.client, nil
+	}
+	var kubeconfig string
+	if k.Kubeconfig != "" {
+		kubeconfig = filepath.Join(p.keyDir, k.Kubeconfig)
+	}
+	return kube.NewClient(kubeconfig, k.Context)
+}
+
+func target(t *model.DeployTarget) (*model.KubernetesTarget, error) {
+	if t.Type != model.DeployTargetKubernetes || t.Kubernetes == nil {
+		return nil, model.ErrInvalidDeployTarget
+	}
+	return t.Kubernetes, nil
+}
+
+// Push creates or updates the target's Secret with c. Labels, annotations and
+// data keys that TLSential doesn't manage are left alone.
+func (p *pusher) Push(t *model.DeployTarget, c *model.Certificate) (string, error) {
+	k, err := target(t)
+	if err != nil {
+		return "", err
+	}
+	client, err := p.clientFor(k)
+	if err != nil {
+		return "", err
+	}
+	want := desired(k, c)
+	where := k.Namespace + "/" + k.Name
+	secrets := client.CoreV1().Secrets(k.Namespace)
+	ctx := context.Background()
+
+	for i := 0; ; i++ {
+		s, err := secrets.Get(ctx, k.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, want, metav1.CreateOptions{})
+			if err != nil {
+				return "", err
+			}
+			return "created secret " + where, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if s.Type != corev1.SecretTypeTLS {
+			return "", fmt.Errorf("secret %s has type %q, not %s", where, s.Type, corev1.SecretTypeTLS)
+		}
+
+		merge(s, want)
+		_, err = secrets.Update(ctx, s, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) && i < updateRetries {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return "updated secret " + where, nil
+	}
+}
+
+// Check reports whether the Secret is missing, or no longer holds the version
+// last pushed.
+func (p *pusher) Check(t *model.DeployTarget, c *model.Certificate) (string, error) {
+	k, err := target(t)
+	if err != nil {
+		return "", err
+	}
+	client, err := p.clientFor(k)
+	if err != nil {
+		return "", err
+	}
+	where := k.Namespace + "/" + k.Name
+
+	s, err := client.CoreV1().Secrets(k.Namespace).Get(context.Background(), k.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "secret " + where + " has been deleted", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if s.Type != corev1.SecretTypeTLS {
+		return fmt.Sprintf("secret %s has been replaced with one of type %q", where, s.Type), nil
+	}
+	want := desired(k, c)
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if !bytes.Equal(s.Data[key], want.Data[key]) {
+			return fmt.Sprintf("%s in secret %s doesn't match version %d", key, where, t.DeployedVersion), nil
+		}
+	}
+	if v := s.Annotations[VersionAnnotation]; v != strconv.Itoa(c.Version) {
+		return fmt.Sprintf("secret %s is annotated with version %q, not %d", where, v, c.Version), nil
+	}
+	return "", nil
+}
+
+// desired returns the Secret the target should be, holding c.
+func desired(k *model.KubernetesTarget, c *model.Certificate) *corev1.Secret {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   k.Namespace,
+			Name:        k.Name,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Type: corev1.SecretTypeTLS,
+	}
+	for key, v := range k.Labels {
+		s.Labels[key] = v
+	}
+	for key, v := range k.Annotations {
+		s.Annotations[key] = v
+	}
+	s.Labels[ManagedByLabel] = ManagedBy
+	s.Annotations[CertIDAnnotation] = c.ID
+	s.Annotations[VersionAnnotation] = strconv.Itoa(c.Version)
+
+	b := model.NewInstallBundle(c)
+	s.Data = map[string][]byte{
+		corev1.TLSCertKey:       b.Contents(model.ContentsFullChain),
+		corev1.TLSPrivateKeyKey: b.Contents(model.ContentsKey),
+	}
+	return s
+}
+
+// merge writes want's metadata and data over s, keeping whatever else s has.
+func merge(s, want *corev1.Secret) {
+	if s.Labels == nil {
+		s.Labels = map[string]string{}
+	}
+	if s.Annotations == nil {
+		s.Annotations = map[string]string{}
+	}
+	if s.Data == nil {
+		s.Data = map[string][]byte{}
+	}
+	for key, v := range want.Labels {
+		s.Labels[key] = v
+	}
+	for key, v := range want.Annotations {
+		s.Annotations[key] = v
+	}
+	for key, v := range want.Data {
+		s.Data[key] = v
+	}
+}
@@ -0,0 +1,165 @@
+package kubesync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ImageWare/TLSential/deploy/deploytest"
+	"github.com/ImageWare/TLSential/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testTarget() *model.DeployTarget {
+	t := model.NewDeployTarget("cert1")
+	t.Type = model.DeployTargetKubernetes
+	t.Kubernetes = &model.KubernetesTarget{
+		Namespace:   "web",
+		Name:        "www-tls",
+		Labels:      map[string]string{"app": "www"},
+		Annotations: map[string]string{"example.com/owner": "web-team"},
+	}
+	return t
+}
+
+// secret returns the stored Secret web/www-tls.
+func secret(t *testing.T, client kubernetes.Interface) *corev1.Secret {
+	t.Helper()
+	s, err := client.CoreV1().Secrets("web").Get(context.Background(), "www-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func update(client kubernetes.Interface, s *corev1.Secret) {
+	client.CoreV1().Secrets(s.Namespace).Update(context.Background(), s, metav1.UpdateOptions{})
+}
+
+func TestPushCreates(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	p := NewWithClient(client)
+	c, tg := deploytest.Cert(), testTarget()
+
+	out, err := p.Push(tg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "created secret web/www-tls" {
+		t.Errorf("unexpected output %q", out)
+	}
+	s := secret(t, client)
+	if s.Type != corev1.SecretTypeTLS {
+		t.Errorf("expected a TLS secret, got %q", s.Type)
+	}
+	b := model.NewInstallBundle(c)
+	if string(s.Data[corev1.TLSCertKey]) != string(b.Contents(model.ContentsFullChain)) {
+		t.Errorf("expected the full chain, got %q", s.Data[corev1.TLSCertKey])
+	}
+	if string(s.Data[corev1.TLSPrivateKeyKey]) != string(c.PrivateKey) {
+		t.Errorf("expected the key, got %q", s.Data[corev1.TLSPrivateKeyKey])
+	}
+	if s.Labels["app"] != "www" || s.Labels[ManagedByLabel] != ManagedBy {
+		t.Errorf("unexpected labels %v", s.Labels)
+	}
+	a := s.Annotations
+	if a["example.com/owner"] != "web-team" || a[CertIDAnnotation] != "cert1" || a[VersionAnnotation] != "3" {
+		t.Errorf("unexpected annotations %v", a)
+	}
+
+	tg.DeployedVersion = c.Version
+	if drift, err := p.Check(tg, c); err != nil || drift != "" {
+		t.Errorf("expected no drift, got %q, %v", drift, err)
+	}
+}
+
+func TestPushUpdates(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "web",
+			Name:        "www-tls",
+			Labels:      map[string]string{"team": "web"},
+			Annotations: map[string]string{VersionAnnotation: "2"},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{corev1.TLSCertKey: []byte("old"), "ca.crt": []byte("ca")},
+	})
+	p := NewWithClient(client)
+	c, tg := deploytest.Cert(), testTarget()
+
+	out, err := p.Push(tg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "updated secret web/www-tls" {
+		t.Errorf("unexpected output %q", out)
+	}
+	s := secret(t, client)
+	if string(s.Data[corev1.TLSPrivateKeyKey]) != string(c.PrivateKey) || s.Annotations[VersionAnnotation] != "3" {
+		t.Errorf("expected version 3 in the secret, got %+v", s)
+	}
+	if string(s.Data["ca.crt"]) != "ca" || s.Labels["team"] != "web" {
+		t.Errorf("expected other keys and labels to be kept, got %+v", s)
+	}
+}
+
+func TestPushWrongType(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "www-tls"},
+		Type:       corev1.SecretTypeOpaque,
+	})
+	_, err := NewWithClient(client).Push(testTarget(), deploytest.Cert())
+	if err == nil || !strings.Contains(err.Error(), "Opaque") {
+		t.Errorf("expected the secret's type to be refused, got %v", err)
+	}
+	if s := secret(t, client); s.Type != corev1.SecretTypeOpaque {
+		t.Error("expected the secret to be left alone")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c := deploytest.Cert()
+	tests := []struct {
+		name   string
+		change func(client kubernetes.Interface, s *corev1.Secret)
+		drift  string
+	}{
+		{"deleted", func(client kubernetes.Interface, s *corev1.Secret) {
+			client.CoreV1().Secrets("web").Delete(context.Background(), "www-tls", metav1.DeleteOptions{})
+		}, "has been deleted"},
+		{"edited", func(client kubernetes.Interface, s *corev1.Secret) {
+			s.Data[corev1.TLSCertKey] = []byte("self-signed")
+			update(client, s)
+		}, "tls.crt"},
+		{"annotation", func(client kubernetes.Interface, s *corev1.Secret) {
+			s.Annotations[VersionAnnotation] = "1"
+			update(client, s)
+		}, "annotated with version \"1\""},
+		{"unrelated", func(client kubernetes.Interface, s *corev1.Secret) {
+			s.Labels["team"] = "web"
+			update(client, s)
+		}, ""},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset()
+		p := NewWithClient(client)
+		tg := testTarget()
+		if _, err := p.Push(tg, c); err != nil {
+			t.Fatal(err)
+		}
+		tg.DeployedVersion = c.Version
+		tt.change(client, secret(t, client))
+
+		drift, err := p.Check(tg, c)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if (tt.drift == "") != (drift == "") || !strings.Contains(drift, tt.drift) {
+			t.Errorf("%s: expected drift %q, got %q", tt.name, tt.drift, drift)
+		}
+	}
+}
@@ -7,18 +7,28 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/ImageWare/TLSential/acme"
 	"github.com/ImageWare/TLSential/api"
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/auditsink"
 	"github.com/ImageWare/TLSential/certificate"
-	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/events"
+	"github.com/ImageWare/TLSential/ingresswatch"
+	"github.com/ImageWare/TLSential/kubesync"
+	"github.com/ImageWare/TLSential/metrics"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
 	"github.com/ImageWare/TLSential/service"
+	"github.com/ImageWare/TLSential/sshpush"
 	"github.com/ImageWare/TLSential/ui"
+	"github.com/ImageWare/TLSential/vaultsink"
+	"github.com/ImageWare/TLSential/webhook"
 	"github.com/gorilla/mux"
-
-	"github.com/boltdb/bolt"
 )
 
 // Version is the official version of the server app.
@@ -26,14 +36,55 @@ const Version = "v0.0.1"
 
 const localStaticDir = "./static"
 
+// baseURL is the externally reachable address of this server, used for links
+// sent to other systems.
+var baseURL string
+
+// deployKeyDir holds the private keys, known_hosts, kubeconfigs and Vault
+// credentials used to push
+// certificates to deploy targets.
+var deployKeyDir string
+
+// vaultAddresses are the Vault servers deploy targets may send the Vault
+// credentials in deployKeyDir to.
+var vaultAddresses []string
+
+// certEvents carries certificate events from the ACME workers to API clients
+// waiting on them, so every ACME service has to publish to it.
+var certEvents = events.NewBus()
+
 type middleware func(http.Handler) http.Handler
 
+// commands are the subcommands run instead of the server, given the
+// arguments after their name.
+var commands = map[string]func(args []string) error{
+	"restore": restore,
+	"export":  exportArchive,
+	"import":  importArchive,
+	"user":    userCommand,
+	"cert":    certCommand,
+	"config":  configCommand,
+	"db":      dbCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	fmt.Println("///- Starting up TLSential")
 	fmt.Printf("//- Version %s\n", Version)
 
 	var port int
+	var dbDriver string
 	var dbFile string
+	var masterKeyFile string
 	var secretReset bool
 	var sessionReset bool
 	var tlsCert string
@@ -43,12 +94,26 @@ func main() {
 	var debug bool
 	var autoRenewBuffSize int = 10
 	var autoRenewListeners int = 10
+	var probeInterval time.Duration
+	var metricsToken string
+	var auditSyslog string
+	var auditFile string
+	var auditRetentionPeriod time.Duration
+	var backupDir string
+	var backupInterval time.Duration
+	var backupKeep int
+	var watchIngresses bool
+	var ingressConfig ingresswatch.Config
+	var ingressDomains string
+	var vaultAddressList string
 
 	// Grab any command line arguments
 	flag.IntVar(&port, "port", 443, "port for webserver to run on")
-	flag.StringVar(&dbFile, "db", "tlsential.db", "filename for boltdb database")
+	flag.StringVar(&dbDriver, "db-driver", DriverBolt, "database to use: bolt, sqlite3 or postgres")
+	flag.StringVar(&dbFile, "db", "tlsential.db", "filename for bolt or sqlite3, connection string for postgres")
+	flag.StringVar(&masterKeyFile, "master-key-file", "", "file of base64 master keys, newest first, for encrypting secrets at rest (default $"+masterKeyEnv+")")
 	flag.BoolVar(&secretReset, "secret-reset", false, "reset the JWT secret - invalidates all API sessions")
-	flag.BoolVar(&secretReset, "session-reset", false, "reset the Session secret - invalidates all Web sessions")
+	flag.BoolVar(&sessionReset, "session-reset", false, "reset the Session secret - invalidates all Web sessions")
 	flag.StringVar(&tlsCert, "tls-cert", "/etc/pki/tlsential.crt", "file path for tls certificate")
 	flag.StringVar(&tlsKey, "tls-key", "/etc/pki/tlsential.key", "file path for tls private key")
 	flag.BoolVar(&noHTTPS, "no-https", false, "flag to run over http (HIGHLY INSECURE)")
@@ -56,9 +121,38 @@ func main() {
 	flag.BoolVar(&debug, "debug", false, "flag to increase logging")
 	flag.IntVar(&autoRenewBuffSize, "renew-buff", 10, "Set the buffer size of the certificate renewal channel")
 	flag.IntVar(&autoRenewListeners, "renew-threads", 10, "Set the number of threads handling certificate renewals and issues")
-
+	flag.StringVar(&baseURL, "base-url", "", "externally reachable URL of this server (eg. https://tls.example.com), needed for download links in webhook payloads and for deploy scripts to be served")
+	flag.StringVar(&deployKeyDir, "deploy-key-dir", "/etc/tlsential/deploy", "directory of SSH private keys, known_hosts, kubeconfigs and Vault credentials for pushing to deploy targets")
+	flag.StringVar(&vaultAddressList, "vault-addresses", "", "comma separated Vault URLs (eg. https://vault.example.com:8200) that Vault deploy targets may push to")
+	flag.DurationVar(&probeInterval, "probe-interval", 15*time.Minute, "how often to probe certificate endpoints for stale deployments, 0 to disable")
+
+	flag.StringVar(&metricsToken, "metrics-token", "", "bearer token required to read /metrics, unprotected if empty")
+	flag.StringVar(&auditSyslog, "audit-syslog", "", "also send the audit log to syslog: local, udp://host:port or tcp://host:port")
+	flag.StringVar(&auditFile, "audit-file", "", "also append the audit log as JSON lines to this file")
+	flag.DurationVar(&auditRetentionPeriod, "audit-retention", 365*24*time.Hour, "how long to keep audit log entries in the database")
+
+	flag.StringVar(&backupDir, "backup-dir", "", "directory to write scheduled database backups to, disabled if empty")
+	flag.DurationVar(&backupInterval, "backup-interval", 24*time.Hour, "how often to write a scheduled backup")
+	flag.IntVar(&backupKeep, "backup-keep", 7, "how many scheduled backups to keep")
+
+	flag.BoolVar(&watchIngresses, "ingress-watch", false, "create certificates for Kubernetes Ingresses annotated "+ingresswatch.IssueAnnotation+": \"true\"")
+	flag.StringVar(&ingressConfig.Kubeconfig, "ingress-kubeconfig", "", "kubeconfig file in deploy-key-dir for the Ingress watcher, the pod's service account if empty")
+	flag.StringVar(&ingressConfig.Context, "ingress-context", "", "kubeconfig context for the Ingress watcher, the current one if empty")
+	flag.StringVar(&ingressConfig.Namespace, "ingress-namespace", "", "only watch Ingresses in this namespace")
+	flag.StringVar(&ingressDomains, "ingress-domains", "", "comma separated domains Ingress hosts must be in for a certificate to be created")
+	flag.StringVar(&ingressConfig.Email, "ingress-email", "", "ACME account email for certificates created for Ingresses")
+	flag.DurationVar(&ingressConfig.GracePeriod, "ingress-grace", 7*24*time.Hour, "how long to keep a certificate after the last Ingress using it is deleted")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "Usage: tlsential [flags]")
+		fmt.Fprintln(out, "       tlsential restore|export|import|user|cert|config|db ...")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
 	if autoRenewBuffSize < 1 || autoRenewBuffSize > 100 {
 		log.Fatal("renew-buff out of range. Must be between 1 and 100")
 	}
@@ -67,13 +161,46 @@ func main() {
 		log.Fatal("renew-threads out of range. Must be between 1 and 100")
 	}
 
-	// Open our database file.
-	db, err := bolt.Open(dbFile, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if backupDir != "" && (backupKeep < 1 || backupInterval <= 0) {
+		log.Fatal("backup-keep must be at least 1 and backup-interval positive")
+	}
+
+	for _, d := range strings.Split(ingressDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			ingressConfig.Domains = append(ingressConfig.Domains, d)
+		}
+	}
+	for _, a := range strings.Split(vaultAddressList, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			vaultAddresses = append(vaultAddresses, a)
+		}
+	}
+
+	if watchIngresses && (len(ingressConfig.Domains) == 0 || ingressConfig.Email == "") {
+		log.Fatal("ingress-watch needs ingress-domains and ingress-email")
+	}
+
+	ci, err := loadCipher(masterKeyFile)
 	if err != nil {
-		log.Print("Bolt DB file lock timeout")
 		log.Fatal(err)
 	}
-	defer db.Close()
+
+	// Open our database.
+	db, err := openRepositories(dbDriver, dbFile, ci)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.close()
+
+	// Encrypt anything stored before a master key was configured, or under a
+	// key that has since been rotated out.
+	n, err := db.reseal()
+	if err != nil {
+		log.Fatalf("Resealing secrets: %s", err)
+	}
+	if n > 0 {
+		log.Printf("Resealed %d records with the current master key", n)
+	}
 
 	if secretReset {
 		resetSecret(db)
@@ -83,21 +210,46 @@ func main() {
 	if sessionReset {
 		resetSessionKey(db)
 	}
+	initSessionKey(db)
+
+	err = service.NewScriptService(db.scripts, baseURL).SeedBuiltins()
+	if err != nil {
+		log.Fatalf("Seeding deploy script templates: %s", err)
+	}
 
 	// Start a goroutine to automatically renew certificates in the DB.
 	cs := newCertService(db)
-	as := newACMEService(db)
+	ns := newNotifierService(db)
+	as := newACMEService(db, ns)
 
 	service.CreateChannelsAndListeners(autoRenewBuffSize, autoRenewListeners, cs, as)
 
-	go autoRenewal(cs, as)
+	go autoRenewal(cs, as, ns)
+	go webhookDeliveries(newWebhookService(db))
+	go deployments(newDeployService(db))
+	if watchIngresses {
+		ic, err := newIngressController(db, ingressConfig)
+		if err != nil {
+			log.Fatalf("Ingress watcher: %s", err)
+		}
+		go ingressWatcher(ic)
+	}
+	if probeInterval > 0 {
+		go deploymentChecks(cs, ns, probeInterval)
+	}
+
+	aus := newAuditService(db, auditSyslog, auditFile)
+	go auditRetention(aus, auditRetentionPeriod)
+	if backupDir != "" {
+		go scheduledBackups(db.backup, backupDir, backupInterval, backupKeep)
+	}
 	// Run http server concurrently
 	// Load routes for the server
 	var mux http.Handler
 
 	// Pass bool for HTTPS as it specifically needs to be disabled in CSRF
 	// protection if no HTTPS.
-	mux = NewMux(noHTTPS, db)
+	mux = NewMux(noHTTPS, db, metricsToken, aus)
 
 	if debug {
 		//For now the only middleware that debug adds is basic request logging.
@@ -207,14 +359,20 @@ func removeTrailingSlash(next http.Handler) http.Handler {
 }
 
 // NewMux returns a new http.ServeMux with established routes.
-func NewMux(unsafe bool, db *bolt.DB) *http.ServeMux {
-	apiHandler := newAPIHandler(db)
-	uiHandler := newUIHandler(db)
+func NewMux(unsafe bool, db *repositories, metricsToken string, aus audit.Service) *http.ServeMux {
+	apiHandler := newAPIHandler(db, aus)
+	uiHandler := newUIHandler(db, aus)
 
 	s := http.NewServeMux()
 	s.Handle("/ui/", uiHandler.Route(unsafe))
 
-	s.Handle("/api/", apiHandler.Route())
+	apiRouter := apiHandler.Route()
+	s.Handle("/api/", apiRouter)
+	s.Handle("/healthz", apiRouter)
+	s.Handle("/readyz", apiRouter)
+	s.Handle("/script/", apiRouter)
+
+	s.Handle("/metrics", metrics.Handler(newCertService(db), metricsToken))
 
 	r := mux.NewRouter()
 	// TODO: Make sure this mostly always works no matter what working directory
@@ -227,11 +385,8 @@ func NewMux(unsafe bool, db *bolt.DB) *http.ServeMux {
 	return s
 }
 
-func initSecret(db *bolt.DB) {
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+func initSecret(db *repositories) {
+	crepo := db.config
 
 	s, err := crepo.JWTSecret()
 	if err != nil {
@@ -248,11 +403,8 @@ func initSecret(db *bolt.DB) {
 	}
 }
 
-func initSessionKey(db *bolt.DB) {
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+func initSessionKey(db *repositories) {
+	crepo := db.config
 
 	s, err := crepo.SessionKey()
 	if err != nil {
@@ -269,121 +421,104 @@ func initSessionKey(db *bolt.DB) {
 	}
 }
 
-func resetSecret(db *bolt.DB) {
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = crepo.SetJWTSecret(nil)
+func resetSecret(db *repositories) {
+	err := db.config.SetJWTSecret(nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func resetSessionKey(db *bolt.DB) {
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = crepo.SetSessionKey(nil)
+func resetSessionKey(db *repositories) {
+	err := db.config.SetSessionKey(nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-// newAppController takes a bolt.DB and builds all necessary repos and usescases
+// newAPIHandler takes the app's repositories and builds all necessary usescases
 // for this app.
-func newAPIHandler(db *bolt.DB) api.Handler {
-	urepo, err := boltdb.NewUserRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	chrepo, err := boltdb.NewChallengeConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	certrepo, err := boltdb.NewCertificateRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	us := service.NewUserService(urepo)
-	cs := service.NewConfigService(crepo, us)
-	chs := service.NewChallengeConfigService(chrepo)
-	crs := service.NewCertificateService(certrepo)
-	as := service.NewAcmeService(crs, chs)
-
-	return api.NewHandler(Version, us, cs, chs, crs, as)
+func newAPIHandler(db *repositories, aus audit.Service) api.Handler {
+	us := service.NewUserService(db.users)
+	cs := service.NewConfigService(db.config, us)
+	chs := service.NewChallengeConfigService(db.challenges)
+	crs := service.NewCertificateService(db.certs)
+	ns := newNotifierService(db)
+	ws := newWebhookService(db)
+	ds := newDeployService(db)
+	as := service.NewAcmeService(crs, chs, ns, ws, ds, certEvents)
+
+	ss := service.NewScriptService(db.scripts, baseURL)
+
+	return api.NewHandler(Version, us, cs, chs, crs, as, ns, ws, certEvents, aus, db.health, db.backup, db.archiveStore(), ss, ds)
 }
 
-// newUIHandler takes a bolt.DB and builds all necessary repos and usescases
+// newUIHandler takes the app's repositories and builds all necessary usescases
 // for this app.
-func newUIHandler(db *bolt.DB) ui.Handler {
-	urepo, err := boltdb.NewUserRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+func newUIHandler(db *repositories, aus audit.Service) ui.Handler {
+	us := service.NewUserService(db.users)
+	cs := service.NewConfigService(db.config, us)
+	chs := service.NewChallengeConfigService(db.challenges)
+	crs := service.NewCertificateService(db.certs)
+	ds := newDeployService(db)
+	as := service.NewAcmeService(crs, chs, newNotifierService(db), newWebhookService(db), ds, certEvents)
 
-	crepo, err := boltdb.NewConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	chrepo, err := boltdb.NewChallengeConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+	ss := service.NewScriptService(db.scripts, baseURL)
 
-	certrepo, err := boltdb.NewCertificateRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return ui.NewHandler(Version, us, cs, chs, crs, as, aus, ss, ds)
+}
 
-	us := service.NewUserService(urepo)
-	cs := service.NewConfigService(crepo, us)
-	chs := service.NewChallengeConfigService(chrepo)
-	crs := service.NewCertificateService(certrepo)
-	as := service.NewAcmeService(crs, chs)
+// helper for creating an ACME Service from a db, notifying through ns.
+func newACMEService(db *repositories, ns notifier.Service) acme.Service {
+	chs := service.NewChallengeConfigService(db.challenges)
+	crs := service.NewCertificateService(db.certs)
+	as := service.NewAcmeService(crs, chs, ns, newWebhookService(db), newDeployService(db), certEvents)
 
-	return ui.NewHandler(Version, us, cs, chs, crs, as)
+	return as
 }
 
-// helper for creating an ACME Service from a db.
-func newACMEService(db *bolt.DB) acme.Service {
-	chrepo, err := boltdb.NewChallengeConfigRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	certrepo, err := boltdb.NewCertificateRepository(db)
-	if err != nil {
-		log.Fatal(err)
-	}
+// helper for creating an Certificate Service from a db.
+func newCertService(db *repositories) certificate.Service {
+	return service.NewCertificateService(db.certs)
+}
 
-	chs := service.NewChallengeConfigService(chrepo)
-	crs := service.NewCertificateService(certrepo)
-	as := service.NewAcmeService(crs, chs)
+// helper for creating a Notifier Service from a db.
+func newNotifierService(db *repositories) notifier.Service {
+	return service.NewNotifierService(db.notifiers)
+}
 
-	return as
+// helper for creating a Webhook Service from a db.
+func newWebhookService(db *repositories) webhook.Service {
+	return service.NewWebhookService(db.webhooks, baseURL)
 }
 
-// helper for creating an Certificate Service from a db.
-func newCertService(db *bolt.DB) certificate.Service {
-	certrepo, err := boltdb.NewCertificateRepository(db)
-	if err != nil {
-		log.Fatal(err)
+// helper for creating a Deploy Service from a db, with a pusher for every
+// target type.
+func newDeployService(db *repositories) deploy.Service {
+	pushers := map[string]deploy.Pusher{
+		model.DeployTargetSSH:        sshpush.New(deployKeyDir),
+		model.DeployTargetKubernetes: kubesync.New(deployKeyDir),
+		model.DeployTargetVault:      vaultsink.New(deployKeyDir, vaultAddresses),
 	}
+	return service.NewDeployService(db.deploys, newCertService(db), pushers)
+}
 
-	crs := service.NewCertificateService(certrepo)
+// helper for creating an Audit Service from a db, along with its sinks.
+func newAuditService(db *repositories, syslogAddr, file string) audit.Service {
+	var sinks []audit.Sink
+	if syslogAddr != "" {
+		sink, err := auditsink.Syslog(syslogAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if file != "" {
+		sink, err := auditsink.JSONLines(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
 
-	return crs
+	return service.NewAuditService(db.audit, sinks...)
 }
@@ -0,0 +1,212 @@
+// Package metrics exposes Prometheus metrics for certificates, the ACME
+// worker pool and the API.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tlsential"
+
+// Issuance outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var (
+	// IssuanceDuration times ACME orders by outcome.
+	IssuanceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "issuance_duration_seconds",
+		Help:      "Time taken to issue or renew a certificate via ACME.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"outcome"})
+
+	// DNSProviderErrors counts failures setting up or talking to the DNS
+	// provider during DNS-01 challenges.
+	DNSProviderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_provider_errors_total",
+		Help:      "Errors from the DNS provider, by operation.",
+	}, []string{"operation"})
+
+	// WorkersBusy is how many renewal workers are processing a certificate.
+	WorkersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Renewal workers currently processing a certificate.",
+	})
+
+	// Workers is the size of the renewal worker pool.
+	Workers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "Size of the renewal worker pool.",
+	})
+
+	// RenewQueueCapacity is the buffer size of the renewal queue.
+	RenewQueueCapacity = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "renew_queue_capacity",
+		Help:      "Buffer size of the renewal queue.",
+	})
+
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "API request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	renewQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "renew_queue_depth",
+		Help:      "Certificates waiting in the renewal queue.",
+	}, func() float64 {
+		queueMu.Lock()
+		defer queueMu.Unlock()
+		if queue == nil {
+			return 0
+		}
+		return float64(len(queue))
+	})
+)
+
+var queueMu sync.Mutex
+var queue chan string
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		IssuanceDuration,
+		DNSProviderErrors,
+		WorkersBusy,
+		Workers,
+		RenewQueueCapacity,
+		apiDuration,
+		renewQueueDepth,
+	)
+}
+
+// TrackRenewQueue reports the depth of ch as the renewal queue depth.
+func TrackRenewQueue(ch chan string) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	queue = ch
+	RenewQueueCapacity.Set(float64(cap(ch)))
+}
+
+// ObserveIssuance records an ACME order that started at start.
+func ObserveIssuance(start time.Time, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeFailure
+	}
+	IssuanceDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRequest records an API request to route.
+func ObserveRequest(route, method string, code int, d time.Duration) {
+	apiDuration.WithLabelValues(route, method, strconv.Itoa(code)).Observe(d.Seconds())
+}
+
+// certCollector reads the certificate summaries from the repo on every
+// scrape, so no keys are decoded.
+type certCollector struct {
+	cs certificate.Service
+
+	expiry *prometheus.Desc
+	count  *prometheus.Desc
+	errors *prometheus.Desc
+}
+
+func newCertCollector(cs certificate.Service) *certCollector {
+	return &certCollector{
+		cs: cs,
+		expiry: prometheus.NewDesc(
+			namespace+"_certificate_expiry_seconds",
+			"Seconds until the certificate expires. Negative once expired.",
+			[]string{"id", "common_name"}, nil,
+		),
+		count: prometheus.NewDesc(
+			namespace+"_certificates",
+			"Number of certificates by state. A renewed certificate that failed its last renewal is both issued and failed.",
+			[]string{"state"}, nil,
+		),
+		errors: prometheus.NewDesc(
+			namespace+"_certificate_scrape_errors",
+			"1 if the certificates couldn't be read for this scrape.",
+			nil, nil,
+		),
+	}
+}
+
+func (c *certCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.expiry
+	ch <- c.count
+	ch <- c.errors
+}
+
+func (c *certCollector) Collect(ch chan<- prometheus.Metric) {
+	summaries, err := c.cs.CertSummaries()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.errors, prometheus.GaugeValue, 0)
+
+	counts := map[string]int{model.StateIssued: 0, model.StateFailed: 0, model.StatePending: 0}
+	now := time.Now()
+	for _, s := range summaries {
+		if s.Issued {
+			counts[model.StateIssued]++
+			ch <- prometheus.MustNewConstMetric(c.expiry, prometheus.GaugeValue,
+				s.Expiry.Sub(now).Seconds(), s.ID, s.CommonName)
+		}
+		if s.Failed {
+			counts[model.StateFailed]++
+		}
+		if !s.Issued && !s.Failed {
+			counts[model.StatePending]++
+		}
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(n), state)
+	}
+}
+
+// Handler serves the metrics, including certificate metrics read from cs. If
+// token isn't empty, requests must send it as a bearer token. It must only be
+// called once.
+func Handler(cs certificate.Service, token string) http.Handler {
+	registry.MustRegister(newCertCollector(cs))
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		const prefix = "Bearer "
+		if !strings.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
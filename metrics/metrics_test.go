@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// fakeCerts implements only what the collector uses.
+type fakeCerts struct {
+	certificate.Service
+	certs []*model.Certificate
+}
+
+func (f *fakeCerts) CertSummaries() ([]*model.CertificateSummary, error) {
+	var summaries []*model.CertificateSummary
+	for _, c := range f.certs {
+		summaries = append(summaries, c.Summary())
+	}
+	return summaries, nil
+}
+
+func TestHandler(t *testing.T) {
+	cs := &fakeCerts{certs: []*model.Certificate{
+		{ID: "a", CommonName: "a.example.com", Issued: true, Expiry: time.Now().Add(time.Hour)},
+		{ID: "b", CommonName: "b.example.com"},
+	}}
+	ch := make(chan string, 4)
+	ch <- "a"
+	TrackRenewQueue(ch)
+	ObserveRequest("/api/certificate/{id}", "GET", 200, time.Millisecond)
+
+	srv := httptest.NewServer(Handler(cs, "token"))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`tlsential_certificate_expiry_seconds{common_name="a.example.com",id="a"}`,
+		`tlsential_certificates{state="issued"} 1`,
+		`tlsential_certificates{state="pending"} 1`,
+		`tlsential_renew_queue_depth 1`,
+		`tlsential_renew_queue_capacity 4`,
+		`tlsential_api_request_duration_seconds_count{code="200",method="GET",route="/api/certificate/{id}"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/go-acme/lego/v3/registration"
+)
+
+// ArchiveVersion is the archive format this build writes. Archives with a
+// higher version are refused.
+const ArchiveVersion = 1
+
+// Ways to handle an imported record whose ID or name is already taken.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+var ErrInvalidConflict = errors.New("conflict must be skip, overwrite or rename")
+
+// Archive is a portable copy of an instance's data, for moving it to another
+// instance or storage backend.
+type Archive struct {
+	Version int
+	Created time.Time
+
+	// NoPrivateKeys is set when cert and ACME account keys were left out.
+	// Such certs can be inventoried but not served or renewed until they're
+	// issued again.
+	NoPrivateKeys bool
+
+	Certificates []*ArchivedCertificate
+	// Users carry their password hashes, so passwords keep working.
+	Users     []*User
+	Challenge *ChallengeConfig
+	Settings  *ArchivedSettings
+}
+
+// ArchivedSettings are the app settings worth carrying between instances.
+// Secrets tied to one instance, like the JWT and session keys, aren't.
+type ArchivedSettings struct {
+	SuperAdmin string
+}
+
+// ArchivedCertificate is a Certificate in a form that survives JSON.
+type ArchivedCertificate struct {
+	ID     string
+	Secret string
+
+	Domains        []string
+	CommonName     string
+	PendingDomains []string `json:",omitempty"`
+	Labels         map[string]string
+
+	CertURL       string
+	CertStableURL string
+
+	PrivateKey        []byte
+	Certificate       []byte
+	IssuerCertificate []byte
+
+	Issued   bool
+	Version  int
+	Imported bool
+	Revoked  bool
+
+	Expiry  time.Time
+	RenewAt int
+
+	Endpoints []*Endpoint
+	// InstallTokens carry only their hashes, so agents keep working.
+	InstallTokens []*InstallToken `json:",omitempty"`
+
+	LastError string
+
+	ValidateFirst bool
+	DryRun        *DryRun
+
+	ModTime time.Time
+
+	ACMEEmail        string
+	ACMERegistration *registration.Resource
+	// ACMEKey is PEM encoded.
+	ACMEKey string
+}
+
+// NewArchivedCertificate converts c, leaving out its keys unless withKeys.
+func NewArchivedCertificate(c *Certificate, withKeys bool) *ArchivedCertificate {
+	a := &ArchivedCertificate{
+		ID:                c.ID,
+		Secret:            c.Secret,
+		Domains:           c.Domains,
+		CommonName:        c.CommonName,
+		PendingDomains:    c.PendingDomains,
+		Labels:            c.Labels,
+		CertURL:           c.CertURL,
+		CertStableURL:     c.CertStableURL,
+		Certificate:       c.Certificate,
+		IssuerCertificate: c.IssuerCertificate,
+		Issued:            c.Issued,
+		Version:           c.Version,
+		Imported:          c.Imported,
+		Revoked:           c.Revoked,
+		Expiry:            c.Expiry,
+		RenewAt:           c.RenewAt,
+		Endpoints:         c.Endpoints,
+		InstallTokens:     c.InstallTokens,
+		ValidateFirst:     c.ValidateFirst,
+		DryRun:            c.DryRun,
+		ModTime:           c.ModTime,
+		ACMEEmail:         c.ACMEEmail,
+		ACMERegistration:  c.ACMERegistration,
+	}
+	if c.LastError != nil {
+		a.LastError = c.LastError.Error()
+	}
+	if withKeys {
+		a.PrivateKey = c.PrivateKey
+		if c.ACMEKey != nil {
+			der, _ := x509.MarshalECPrivateKey(c.ACMEKey)
+			a.ACMEKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		}
+	}
+	return a
+}
+
+// Cert converts a back. It fails if the ACME key doesn't parse.
+func (a *ArchivedCertificate) Cert() (*Certificate, error) {
+	c := &Certificate{
+		ID:                a.ID,
+		Secret:            a.Secret,
+		Domains:           a.Domains,
+		CommonName:        a.CommonName,
+		PendingDomains:    a.PendingDomains,
+		Labels:            a.Labels,
+		CertURL:           a.CertURL,
+		CertStableURL:     a.CertStableURL,
+		PrivateKey:        a.PrivateKey,
+		Certificate:       a.Certificate,
+		IssuerCertificate: a.IssuerCertificate,
+		Issued:            a.Issued,
+		Version:           a.Version,
+		Imported:          a.Imported,
+		Revoked:           a.Revoked,
+		Expiry:            a.Expiry,
+		RenewAt:           a.RenewAt,
+		Endpoints:         a.Endpoints,
+		InstallTokens:     a.InstallTokens,
+		ValidateFirst:     a.ValidateFirst,
+		DryRun:            a.DryRun,
+		ModTime:           a.ModTime,
+		ACMEEmail:         a.ACMEEmail,
+		ACMERegistration:  a.ACMERegistration,
+	}
+	if a.LastError != "" {
+		c.LastError = errors.New(a.LastError)
+	}
+	if a.ACMEKey != "" {
+		block, _ := pem.Decode([]byte(a.ACMEKey))
+		if block == nil {
+			return nil, ErrInvalidPrivateKey
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidPrivateKey
+		}
+		c.ACMEKey = key
+	}
+	return c, nil
+}
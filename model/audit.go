@@ -0,0 +1,113 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Kinds of actor recorded in the audit log. Anonymous requests carried no
+// credentials, and unauthenticated ones carried credentials that were
+// refused.
+const (
+	ActorUser            = "user"
+	ActorCertToken       = "cert_secret"
+	ActorInstallToken    = "install_token"
+	ActorAnonymous       = "anonymous"
+	ActorUnauthenticated = "unauthenticated"
+)
+
+// Outcomes recorded in the audit log.
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// DefaultAuditLimit and MaxAuditLimit bound how many entries one query
+// returns.
+const DefaultAuditLimit = 100
+const MaxAuditLimit = 1000
+
+var ErrInvalidAuditLimit = errors.New("limit must be between 1 and 1000")
+
+// AuditEntry records one security relevant request.
+type AuditEntry struct {
+	ID   string
+	Time time.Time
+
+	// Actor is the username, or the certificate ID for certificate secret
+	// holders. ActorType says which.
+	Actor     string
+	ActorType string
+	IP        string
+	// ClaimedActor is who an unauthenticated request claimed to be. It
+	// wasn't proven, so it's kept out of Actor.
+	ClaimedActor string `json:",omitempty"`
+
+	// Action is the method and route, eg. "DELETE /api/certificate/{id}".
+	Action string
+	Target string
+
+	Outcome string
+	Status  int
+}
+
+// NewAuditEntry returns an entry with a fresh ID. The outcome is derived from
+// the HTTP status of the response.
+func NewAuditEntry(actorType, actor, ip, action, target string, status int) *AuditEntry {
+	outcome := OutcomeSuccess
+	switch {
+	case status == 401 || status == 403:
+		outcome = OutcomeDenied
+	case status >= 400:
+		outcome = OutcomeFailure
+	}
+
+	return &AuditEntry{
+		ID:        ksuid.New().String(),
+		Time:      time.Now(),
+		Actor:     actor,
+		ActorType: actorType,
+		IP:        ip,
+		Action:    action,
+		Target:    target,
+		Outcome:   outcome,
+		Status:    status,
+	}
+}
+
+// AuditQuery filters the audit log. Empty fields match everything.
+type AuditQuery struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Limit  int
+}
+
+// Validate fills in the default limit and checks it's in range.
+func (q *AuditQuery) Validate() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultAuditLimit
+	}
+	if q.Limit < 1 || q.Limit > MaxAuditLimit {
+		return ErrInvalidAuditLimit
+	}
+	return nil
+}
+
+// Matches reports whether e passes the filters.
+func (q *AuditQuery) Matches(e *AuditEntry) bool {
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.Target != "" && e.Target != q.Target {
+		return false
+	}
+	return q.Since.IsZero() || !e.Time.Before(q.Since)
+}
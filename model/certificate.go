@@ -1,10 +1,13 @@
 package model
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"log"
 	"net/mail"
@@ -22,12 +25,24 @@ import (
 
 const CADirURL = "https://acme-v02.api.letsencrypt.org/directory"
 
+// StagingCADirURL is Let's Encrypt's staging directory, used for dry runs so
+// mistakes don't count against production rate limits.
+const StagingCADirURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
 // DefaultRenewAt is the number of days before expiration a cert should be
 // renewed at.
 const DefaultRenewAt = 30
 
+// MaxRenewAt is the largest RenewAt we accept. Let's Encrypt certs are valid
+// for 90 days, so anything at or past that would renew on every run.
+const MaxRenewAt = 89
+
 var ErrInvalidDomains = errors.New("invalid domains")
 var ErrInvalidEmail = errors.New("email required")
+var ErrInvalidCertificate = errors.New("invalid PEM certificate")
+var ErrInvalidPrivateKey = errors.New("invalid PEM private key")
+var ErrKeyMismatch = errors.New("private key does not match certificate")
+var ErrInvalidRenewAt = errors.New("renewAt must be between 0 and 89 days")
 
 type Certificate struct {
 	ID     string
@@ -37,6 +52,13 @@ type Certificate struct {
 	Domains []string
 	// Main domain for "Common Name" field of cert.
 	CommonName string
+	// PendingDomains replaces Domains once a certificate for them has been
+	// issued, so Domains always matches the certificate being served.
+	PendingDomains []string
+
+	// Labels are free-form key/value pairs (team, env, app) used to filter
+	// certificates.
+	Labels map[string]string
 
 	// Let's Encrypt CertURL
 	CertURL string
@@ -50,9 +72,30 @@ type Certificate struct {
 	// Has this cert been issued yet?
 	Issued bool
 
+	// Version counts successful issuances, so clients can tell when the
+	// certificate has changed.
+	Version int
+
+	// Revoked is set when the current certificate has been revoked with the
+	// CA. It's cleared by the next successful issuance.
+	Revoked bool
+
+	// Imported certs were issued elsewhere and uploaded for inventory and
+	// distribution only. They are never renewed through ACME.
+	Imported bool
+
 	// NotAfter
 	Expiry time.Time
 
+	// Endpoints are probed to check the current certificate is deployed.
+	Endpoints []*Endpoint
+
+	// Installs are the latest reports from deployment agents, one per host.
+	Installs []*Install
+
+	// InstallTokens authenticate deployment agents and deploy scripts.
+	InstallTokens []*InstallToken
+
 	// RewnewAt specifies the number of days before expiration a cert should be
 	// renewed by.
 	RenewAt int
@@ -64,6 +107,13 @@ type Certificate struct {
 
 	LastError error
 
+	// ValidateFirst requests a dry run against the staging directory before
+	// the first production issuance is attempted.
+	ValidateFirst bool
+
+	// DryRun holds the outcome of the most recent staging dry run, if any.
+	DryRun *DryRun
+
 	ModTime time.Time
 
 	ACMEEmail        string
@@ -71,6 +121,20 @@ type Certificate struct {
 	ACMEKey          *ecdsa.PrivateKey
 }
 
+// DryRun records a full order run against the staging directory.
+type DryRun struct {
+	Started  time.Time
+	Finished time.Time
+
+	Success bool
+	Error   string
+}
+
+// Pending returns true while the dry run has been started but not finished.
+func (d *DryRun) Pending() bool {
+	return d.Finished.IsZero()
+}
+
 // NewCertificate sets up everything needed for Lego to move forward with cert
 // issuance and renewal, as well as generating a unique ID, and a
 // cryptographically secure secret.
@@ -129,6 +193,93 @@ func NewCertificate(domains []string, email string) (*Certificate, error) {
 	return c, nil
 }
 
+// NewImportedCertificate builds a certificate record from an externally issued
+// PEM certificate, an optional PEM chain and an optional PEM private key.
+// Domains and expiry are taken from the parsed leaf certificate. Any extra
+// certificates following the leaf in certPEM are treated as the chain when
+// chainPEM is empty.
+func NewImportedCertificate(certPEM, chainPEM, keyPEM []byte) (*Certificate, error) {
+	block, rest := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, ErrInvalidCertificate
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	if len(bytes.TrimSpace(chainPEM)) == 0 {
+		chainPEM = rest
+	}
+	if len(bytes.TrimSpace(chainPEM)) == 0 {
+		chainPEM = nil
+	} else {
+		if _, err := certcrypto.ParsePEMBundle(chainPEM); err != nil {
+			return nil, ErrInvalidCertificate
+		}
+	}
+
+	if len(bytes.TrimSpace(keyPEM)) != 0 {
+		// ParsePEMPrivateKey doesn't check for a PEM block before using it.
+		if b, _ := pem.Decode(keyPEM); b == nil {
+			return nil, ErrInvalidPrivateKey
+		}
+		key, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+		if err != nil {
+			return nil, ErrInvalidPrivateKey
+		}
+		if !publicKeysMatch(leaf.PublicKey, key) {
+			return nil, ErrKeyMismatch
+		}
+	}
+
+	domains := leaf.DNSNames
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = []string{leaf.Subject.CommonName}
+	}
+	if len(domains) == 0 {
+		return nil, ErrInvalidDomains
+	}
+
+	common := leaf.Subject.CommonName
+	if common == "" {
+		common = domains[0]
+	}
+
+	c := &Certificate{
+		ID:                ksuid.New().String(),
+		Secret:            auth.NewPassword(),
+		Domains:           domains,
+		CommonName:        common,
+		PrivateKey:        keyPEM,
+		Certificate:       pem.EncodeToMemory(block),
+		IssuerCertificate: chainPEM,
+		Issued:            true,
+		Version:           1,
+		Imported:          true,
+		Expiry:            leaf.NotAfter,
+		RenewAt:           DefaultRenewAt,
+	}
+	return c, nil
+}
+
+// publicKeysMatch reports whether priv is the private half of pub.
+func publicKeysMatch(pub crypto.PublicKey, priv crypto.PrivateKey) bool {
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	a, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return false
+	}
+	b, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
+
 // GetEmail is needed to implement the User interface for Lego Clients.
 func (c *Certificate) GetEmail() string {
 	return c.ACMEEmail
@@ -140,10 +291,33 @@ func (c *Certificate) GetRegistration() *registration.Resource {
 }
 
 // GetPrivateKey is needed to implement the User interface for Lego Clients.
+// Without an account key it returns a nil interface, so lego refuses it
+// rather than signing with a nil key.
 func (c *Certificate) GetPrivateKey() crypto.PrivateKey {
+	if c.ACMEKey == nil {
+		return nil
+	}
 	return c.ACMEKey
 }
 
+// HasAccount reports whether c has an ACME account to issue with. Certs
+// imported without their keys don't.
+func (c *Certificate) HasAccount() bool {
+	return c.ACMEKey != nil && c.ACMERegistration != nil
+}
+
+// ValidEmail reports whether email can be used for an ACME account.
+func ValidEmail(email string) bool {
+	_, err := mail.ParseAddress(email)
+	return err == nil
+}
+
+// AwaitingDryRun reports whether c asked to be validated first and hasn't
+// passed a dry run yet, so nothing may be ordered from production for it.
+func (c *Certificate) AwaitingDryRun() bool {
+	return c.ValidateFirst && !c.Issued && (c.DryRun == nil || !c.DryRun.Success)
+}
+
 // ValidDomains is used to validate that the passed domains set includes only
 // valid domains (ie example.com or *.example.com). Returns bool designating
 // whether or not they are ALL valid domains.
@@ -172,3 +346,63 @@ func ValidDomains(domains []string) bool {
 
 	return true
 }
+
+// ValidRenewAt reports whether days is an acceptable RenewAt value. Zero is
+// allowed and disables auto renewal.
+func ValidRenewAt(days int) bool {
+	return days >= 0 && days <= MaxRenewAt
+}
+
+// RenewDue reports whether a cert with daysLeft until expiry is inside its
+// renewal window. A RenewAt of zero disables auto renewal, even once the cert
+// has expired.
+func (c *Certificate) RenewDue(daysLeft int) bool {
+	return c.RenewAt > 0 && daysLeft < c.RenewAt
+}
+
+// SetDomains validates domains and requests them for the certificate, using
+// the first entry as the common name. A reordering of the current names
+// takes effect straight away. Any other set is kept in PendingDomains until
+// it's issued, and SetDomains returns true if it's a new request, in which
+// case the cert needs to be reissued. Order and case don't count as a
+// change.
+func (c *Certificate) SetDomains(domains []string) (bool, error) {
+	if len(domains) == 0 || !ValidDomains(domains) {
+		return false, ErrInvalidDomains
+	}
+	for _, d := range domains {
+		if d == "" {
+			return false, ErrInvalidDomains
+		}
+	}
+
+	changed := !SameDomains(domains, c.Domains) && !SameDomains(domains, c.PendingDomains)
+	c.Domains, c.CommonName, c.PendingDomains = ResolveDomains(c.Domains, c.CommonName, domains)
+	return changed, nil
+}
+
+// ResolveDomains splits requested against the issued domains. A reordering of
+// them replaces them, and any other set is returned as pending.
+func ResolveDomains(issued []string, commonName string, requested []string) (domains []string, cn string, pending []string) {
+	switch {
+	case len(requested) == 0:
+		return issued, commonName, nil
+	case SameDomains(issued, requested):
+		return requested, requested[0], nil
+	}
+	return issued, commonName, requested
+}
+
+// RequestedDomains returns the domains the next issuance is for.
+func (c *Certificate) RequestedDomains() []string {
+	if len(c.PendingDomains) != 0 {
+		return c.PendingDomains
+	}
+	return c.Domains
+}
+
+// SameDomains reports whether a and b hold the same names, ignoring order and
+// case.
+func SameDomains(a, b []string) bool {
+	return len(missingNames(a, b)) == 0 && len(missingNames(b, a)) == 0
+}
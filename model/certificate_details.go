@@ -0,0 +1,144 @@
+package model
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertificateDetails holds the interesting parts of an issued certificate, so
+// nobody has to download the PEM and run openssl to see them.
+type CertificateDetails struct {
+	SerialNumber string
+
+	// SANs as actually issued, which may differ from the requested Domains.
+	DNSNames []string
+
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	Subject string
+	Issuer  string
+
+	KeyAlgorithm       string
+	KeySize            int
+	SignatureAlgorithm string
+
+	// FingerprintSHA256 is the colon separated hex SHA-256 of the DER cert.
+	FingerprintSHA256 string
+	// SPKIPin is the base64 SHA-256 of the SubjectPublicKeyInfo, as used for
+	// HPKP style pinning.
+	SPKIPin string
+
+	// ChainSubjects lists the subject DN of every certificate in the chain.
+	ChainSubjects []string
+
+	// MissingSANs were requested but are not in the issued certificate, and
+	// ExtraSANs are in the issued certificate but no longer requested.
+	MissingSANs []string
+	ExtraSANs   []string
+}
+
+// SANMismatch returns true if the issued SANs differ from the requested
+// domains.
+func (d *CertificateDetails) SANMismatch() bool {
+	return len(d.MissingSANs) != 0 || len(d.ExtraSANs) != 0
+}
+
+// Details parses the stored certificate and chain. It returns nil with no
+// error if the certificate hasn't been issued yet.
+func (c *Certificate) Details() (*CertificateDetails, error) {
+	if len(c.Certificate) == 0 {
+		return nil, nil
+	}
+
+	block, _ := pem.Decode(c.Certificate)
+	if block == nil {
+		return nil, ErrInvalidCertificate
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fp := sha256.Sum256(leaf.Raw)
+	pin := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+
+	algo, size := publicKeyInfo(leaf)
+
+	d := &CertificateDetails{
+		SerialNumber:       hexColon(leaf.SerialNumber.Bytes()),
+		DNSNames:           leaf.DNSNames,
+		NotBefore:          leaf.NotBefore,
+		NotAfter:           leaf.NotAfter,
+		Subject:            leaf.Subject.String(),
+		Issuer:             leaf.Issuer.String(),
+		KeyAlgorithm:       algo,
+		KeySize:            size,
+		SignatureAlgorithm: leaf.SignatureAlgorithm.String(),
+		FingerprintSHA256:  hexColon(fp[:]),
+		SPKIPin:            base64.StdEncoding.EncodeToString(pin[:]),
+	}
+
+	rest := c.IssuerCertificate
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		ic, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		d.ChainSubjects = append(d.ChainSubjects, ic.Subject.String())
+	}
+
+	d.MissingSANs = missingNames(c.Domains, leaf.DNSNames)
+	d.ExtraSANs = missingNames(leaf.DNSNames, c.Domains)
+
+	return d, nil
+}
+
+// publicKeyInfo returns the name and size in bits of the leaf's public key.
+func publicKeyInfo(leaf *x509.Certificate) (string, int) {
+	switch k := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return leaf.PublicKeyAlgorithm.String(), 0
+}
+
+// missingNames returns every name in want that isn't in have, ignoring case.
+func missingNames(want, have []string) []string {
+	set := make(map[string]bool, len(have))
+	for _, h := range have {
+		set[strings.ToLower(h)] = true
+	}
+
+	var missing []string
+	for _, w := range want {
+		if !set[strings.ToLower(w)] {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+func hexColon(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertificateDetails(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	certPEM, _ := testSelfSigned(t, "example.com", []string{"example.com", "www.example.com"}, notAfter)
+	chainPEM, _ := testSelfSigned(t, "Test CA", nil, notAfter)
+
+	t.Run("not issued", func(t *testing.T) {
+		c := &Certificate{Domains: []string{"example.com"}}
+		d, err := c.Details()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != nil {
+			t.Error("details should be nil before issuance")
+		}
+	})
+
+	t.Run("matching SANs", func(t *testing.T) {
+		c := &Certificate{
+			Domains:           []string{"www.example.com", "Example.com"},
+			Certificate:       certPEM,
+			IssuerCertificate: chainPEM,
+		}
+		d, err := c.Details()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d.SANMismatch() {
+			t.Errorf("unexpected mismatch: missing %v, extra %v", d.MissingSANs, d.ExtraSANs)
+		}
+		if d.KeyAlgorithm != "ECDSA" || d.KeySize != 256 {
+			t.Errorf("unexpected key %s %d", d.KeyAlgorithm, d.KeySize)
+		}
+		if !d.NotAfter.Equal(notAfter) {
+			t.Errorf("not after mismatch: got %s, expected %s", d.NotAfter, notAfter)
+		}
+		if d.Subject != "CN=example.com" {
+			t.Errorf("unexpected subject %s", d.Subject)
+		}
+		if len(d.FingerprintSHA256) != 32*3-1 {
+			t.Errorf("unexpected fingerprint %s", d.FingerprintSHA256)
+		}
+		if d.SPKIPin == "" || d.SerialNumber == "" {
+			t.Error("pin and serial should be set")
+		}
+		if !testEq(d.ChainSubjects, []string{"CN=Test CA"}) {
+			t.Errorf("unexpected chain %v", d.ChainSubjects)
+		}
+	})
+
+	t.Run("mismatched SANs", func(t *testing.T) {
+		c := &Certificate{
+			Domains:     []string{"example.com", "api.example.com"},
+			Certificate: certPEM,
+		}
+		d, err := c.Details()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !d.SANMismatch() {
+			t.Error("expected mismatch")
+		}
+		if !testEq(d.MissingSANs, []string{"api.example.com"}) {
+			t.Errorf("unexpected missing SANs %v", d.MissingSANs)
+		}
+		if !testEq(d.ExtraSANs, []string{"www.example.com"}) {
+			t.Errorf("unexpected extra SANs %v", d.ExtraSANs)
+		}
+	})
+}
@@ -0,0 +1,373 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificate states that can be filtered on when listing.
+const (
+	StateIssued  = "issued"
+	StateFailed  = "failed"
+	StatePending = "pending"
+)
+
+// Certificate list sort orders.
+const (
+	SortCreated  = "created"
+	SortName     = "name"
+	SortExpiry   = "expiry"
+	SortModified = "modified"
+)
+
+// DefaultPageSize is used when a query doesn't set a limit.
+const DefaultPageSize = 100
+
+// MaxPageSize caps how many certificates a single page can return.
+const MaxPageSize = 500
+
+var ErrInvalidLabels = errors.New("labels must be key=value with keys of 1-63 and values of 0-63 letters, digits, '.', '_' or '-', and managed-by and ingress-* are reserved")
+var ErrInvalidState = errors.New("state must be issued, failed or pending")
+var ErrInvalidSort = errors.New("sort must be created, name, expiry or modified")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrCursorMismatch = errors.New("cursor is for a different sort or order")
+var ErrInvalidLimit = errors.New("limit must be between 1 and 500")
+var ErrInvalidExpiring = errors.New("expiring must be a positive number of days")
+
+var labelKeyRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,62})$`)
+var labelValueRe = regexp.MustCompile(`^[A-Za-z0-9._-]{0,63}$`)
+
+// ManagedByLabel names the controller that owns a certificate. Only the
+// server's own controllers set it, so API and UI callers can't hand a
+// certificate to one, or take one away, by labelling it.
+const ManagedByLabel = "managed-by"
+
+// IngressLabelPrefix starts the labels the Ingress controller keeps its
+// state in, such as which Secret a certificate is for. They're reserved like
+// ManagedByLabel.
+const IngressLabelPrefix = "ingress-"
+
+// ReservedLabel reports whether only the server's own controllers may set
+// or remove the label key.
+func ReservedLabel(key string) bool {
+	return key == ManagedByLabel || strings.HasPrefix(key, IngressLabelPrefix)
+}
+
+// ValidLabels reports whether every key and value in labels is acceptable
+// on a certificate. Reserved labels aren't.
+func ValidLabels(labels map[string]string) bool {
+	for k := range labels {
+		if ReservedLabel(k) {
+			return false
+		}
+	}
+	return validSelector(labels)
+}
+
+// validSelector reports whether labels is well formed. Unlike the labels on
+// a certificate, selectors can match reserved labels.
+func validSelector(labels map[string]string) bool {
+	for k, v := range labels {
+		if !labelKeyRe.MatchString(k) || !labelValueRe.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels parses a comma separated list of key=value pairs, such as
+// "team=web,env=prod". Whitespace around pairs is ignored. The result can be
+// used as a selector; check it with ValidLabels before putting it on a
+// certificate.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidLabels
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	if !validSelector(labels) {
+		return nil, ErrInvalidLabels
+	}
+	return labels, nil
+}
+
+// SetLabels replaces c's labels with labels, keeping the reserved ones,
+// which callers can't set or remove.
+func (c *Certificate) SetLabels(labels map[string]string) {
+	for k, v := range c.Labels {
+		if ReservedLabel(k) {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[k] = v
+		}
+	}
+	c.Labels = labels
+}
+
+// FormatLabels is the inverse of ParseLabels, with keys in sorted order.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// CertificateSummary holds just the fields needed to filter and sort
+// certificates, so listing doesn't need to decode keys and PEM data.
+type CertificateSummary struct {
+	ID         string
+	CommonName string
+	Domains    []string
+	Labels     map[string]string
+	Issued     bool
+	Failed     bool
+	Expiry     time.Time
+	ModTime    time.Time
+}
+
+// Summary returns the listing summary for c.
+func (c *Certificate) Summary() *CertificateSummary {
+	return &CertificateSummary{
+		ID:         c.ID,
+		CommonName: c.CommonName,
+		Domains:    c.Domains,
+		Labels:     c.Labels,
+		Issued:     c.Issued,
+		Failed:     c.LastError != nil,
+		Expiry:     c.Expiry,
+		ModTime:    c.ModTime,
+	}
+}
+
+// CertificateQuery filters, sorts and pages a certificate listing. The zero
+// value matches everything, sorted by creation time.
+type CertificateQuery struct {
+	// Labels must all be present on a certificate. An empty value only
+	// requires the key to be present.
+	Labels map[string]string
+
+	// Domain is a case insensitive substring of any of the cert's domains.
+	Domain string
+
+	// State is one of StateIssued, StateFailed or StatePending.
+	State string
+
+	// ExpiringWithin matches issued certs expiring in this many days.
+	ExpiringWithin int
+
+	Sort string
+	Desc bool
+
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+}
+
+// CertificatePage is one page of a certificate listing.
+type CertificatePage struct {
+	Certs []*Certificate
+
+	// NextCursor is empty on the last page.
+	NextCursor string
+
+	// Total is the number of certs matching the query across all pages.
+	Total int
+}
+
+// Validate checks the query and fills in defaults.
+func (q *CertificateQuery) Validate() error {
+	if !validSelector(q.Labels) {
+		return ErrInvalidLabels
+	}
+	switch q.State {
+	case "", StateIssued, StateFailed, StatePending:
+	default:
+		return ErrInvalidState
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreated
+	case SortCreated, SortName, SortExpiry, SortModified:
+	default:
+		return ErrInvalidSort
+	}
+	if q.ExpiringWithin < 0 {
+		return ErrInvalidExpiring
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit < 0 || q.Limit > MaxPageSize {
+		return ErrInvalidLimit
+	}
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return err
+		}
+		// The position is only meaningful in the order it was taken from.
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return ErrCursorMismatch
+		}
+	}
+	return nil
+}
+
+// Matches reports whether s passes the query's filters as of now.
+func (q *CertificateQuery) Matches(s *CertificateSummary, now time.Time) bool {
+	for k, v := range q.Labels {
+		got, ok := s.Labels[k]
+		if !ok || (v != "" && got != v) {
+			return false
+		}
+	}
+
+	if q.Domain != "" {
+		found := false
+		sub := strings.ToLower(q.Domain)
+		for _, d := range s.Domains {
+			if strings.Contains(strings.ToLower(d), sub) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch q.State {
+	case StateIssued:
+		if !s.Issued {
+			return false
+		}
+	case StateFailed:
+		if !s.Failed {
+			return false
+		}
+	case StatePending:
+		if s.Issued || s.Failed {
+			return false
+		}
+	}
+
+	if q.ExpiringWithin > 0 {
+		if !s.Issued || s.Expiry.After(now.AddDate(0, 0, q.ExpiringWithin)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Page filters and sorts summaries and returns the IDs on the page selected
+// by the query's cursor, along with the cursor for the next page and the total
+// number of matches. The query must have been validated.
+func (q *CertificateQuery) Page(summaries []*CertificateSummary, now time.Time) ([]string, string, int, error) {
+	var matched []cursor
+	for _, s := range summaries {
+		if q.Matches(s, now) {
+			matched = append(matched, cursor{K: q.sortKey(s), ID: s.ID})
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return q.before(matched[i], matched[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.before(after, matched[i])
+		})
+	}
+
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	ids := make([]string, 0, end-start)
+	for _, m := range matched[start:end] {
+		ids = append(ids, m.ID)
+	}
+
+	var next string
+	if end < len(matched) {
+		last := matched[end-1]
+		last.Sort, last.Desc = q.Sort, q.Desc
+		next = last.encode()
+	}
+	return ids, next, len(matched), nil
+}
+
+func (q *CertificateQuery) sortKey(s *CertificateSummary) string {
+	// Fixed width so times compare correctly as strings.
+	const timeKey = "20060102150405.000000000"
+	switch q.Sort {
+	case SortName:
+		return strings.ToLower(s.CommonName)
+	case SortExpiry:
+		return s.Expiry.UTC().Format(timeKey)
+	case SortModified:
+		return s.ModTime.UTC().Format(timeKey)
+	}
+	// KSUIDs sort by creation time.
+	return ""
+}
+
+func (q *CertificateQuery) before(a, b cursor) bool {
+	if a.K == b.K && a.ID == b.ID {
+		return false
+	}
+	if a.K != b.K {
+		return (a.K < b.K) != q.Desc
+	}
+	return (a.ID < b.ID) != q.Desc
+}
+
+// cursor marks a position in a sorted listing by the sort key and ID of the
+// last cert on a page, so pages stay stable as certs are added or removed.
+// Sort and Desc record the order the position was taken in.
+type cursor struct {
+	K    string
+	ID   string
+	Sort string `json:"S"`
+	Desc bool   `json:"D,omitempty"`
+}
+
+func (c cursor) encode() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(buf, &c); err != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(" team=web, env=prod ,app=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 3 || labels["team"] != "web" || labels["env"] != "prod" || labels["app"] != "" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if got := FormatLabels(labels); got != "app=,env=prod,team=web" {
+		t.Errorf("FormatLabels() = %q", got)
+	}
+
+	for _, bad := range []string{"team", "=web", "team=web app", "-team=web"} {
+		if _, err := ParseLabels(bad); err != ErrInvalidLabels {
+			t.Errorf("ParseLabels(%q) = %v, want ErrInvalidLabels", bad, err)
+		}
+	}
+
+	// Selectors can match the reserved label, but certificates can't be
+	// given it.
+	selector, err := ParseLabels("managed-by=ingresswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ValidLabels(selector) {
+		t.Error("ValidLabels should refuse managed-by")
+	}
+	if ValidLabels(map[string]string{"ingress-secret": "www-tls"}) {
+		t.Error("ValidLabels should refuse ingress-* labels")
+	}
+
+	c := &Certificate{Labels: map[string]string{ManagedByLabel: "ingresswatch", "ingress-secret": "www-tls", "team": "web"}}
+	c.SetLabels(map[string]string{"env": "prod"})
+	if len(c.Labels) != 3 || c.Labels[ManagedByLabel] != "ingresswatch" || c.Labels["ingress-secret"] != "www-tls" || c.Labels["env"] != "prod" {
+		t.Errorf("SetLabels should keep the reserved labels, got %v", c.Labels)
+	}
+	c.SetLabels(nil)
+	if len(c.Labels) != 2 || c.Labels[ManagedByLabel] != "ingresswatch" || c.Labels["ingress-secret"] != "www-tls" {
+		t.Errorf("clearing labels should keep the reserved labels, got %v", c.Labels)
+	}
+}
+
+func TestCertificateQuery(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	summaries := []*CertificateSummary{
+		{ID: "1", CommonName: "b.example.com", Domains: []string{"b.example.com"}, Labels: map[string]string{"env": "prod", "team": "web"}, Issued: true, Expiry: now.AddDate(0, 0, 10)},
+		{ID: "2", CommonName: "a.example.com", Domains: []string{"a.example.com", "API.other.org"}, Labels: map[string]string{"env": "dev"}, Issued: true, Expiry: now.AddDate(0, 0, 60)},
+		{ID: "3", CommonName: "c.example.com", Domains: []string{"c.example.com"}, Failed: true},
+		{ID: "4", CommonName: "d.example.com", Domains: []string{"d.example.com"}, Labels: map[string]string{"env": "prod"}},
+	}
+
+	tests := []struct {
+		name string
+		q    CertificateQuery
+		want []string
+	}{
+		{"all", CertificateQuery{}, []string{"1", "2", "3", "4"}},
+		{"label value", CertificateQuery{Labels: map[string]string{"env": "prod"}}, []string{"1", "4"}},
+		{"label key", CertificateQuery{Labels: map[string]string{"team": ""}}, []string{"1"}},
+		{"domain", CertificateQuery{Domain: "api.OTHER"}, []string{"2"}},
+		{"issued", CertificateQuery{State: StateIssued}, []string{"1", "2"}},
+		{"failed", CertificateQuery{State: StateFailed}, []string{"3"}},
+		{"pending", CertificateQuery{State: StatePending}, []string{"4"}},
+		{"expiring", CertificateQuery{ExpiringWithin: 30}, []string{"1"}},
+		{"name", CertificateQuery{Sort: SortName}, []string{"2", "1", "3", "4"}},
+		{"name desc", CertificateQuery{Sort: SortName, Desc: true}, []string{"4", "3", "1", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.q
+			if err := q.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			ids, next, total, err := q.Page(summaries, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != "" {
+				t.Errorf("unexpected next cursor %q", next)
+			}
+			if total != len(tt.want) || !testEq(ids, tt.want) {
+				t.Errorf("got %v (total %d), want %v", ids, total, tt.want)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		q := CertificateQuery{Sort: SortName, Limit: 3}
+		if err := q.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		ids, next, total, err := q.Page(summaries, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 4 || !testEq(ids, []string{"2", "1", "3"}) || next == "" {
+			t.Fatalf("first page got %v (total %d, next %q)", ids, total, next)
+		}
+
+		// A cert added before the cursor shouldn't shift the next page.
+		more := append(summaries, &CertificateSummary{ID: "5", CommonName: "0.example.com"})
+		q.Cursor = next
+		ids, next, _, err = q.Page(more, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !testEq(ids, []string{"4"}) || next != "" {
+			t.Errorf("second page got %v (next %q)", ids, next)
+		}
+	})
+
+	t.Run("pagination desc", func(t *testing.T) {
+		q := CertificateQuery{Sort: SortName, Desc: true, Limit: 2}
+		if err := q.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		_, next, _, err := q.Page(summaries, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Cursor = next
+		ids, next, _, err := q.Page(summaries, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !testEq(ids, []string{"1", "2"}) || next != "" {
+			t.Errorf("second page got %v (next %q)", ids, next)
+		}
+	})
+
+	t.Run("cursor order", func(t *testing.T) {
+		q := CertificateQuery{Sort: SortName, Limit: 1}
+		if err := q.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		_, next, _, err := q.Page(summaries, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, other := range []CertificateQuery{
+			{Sort: SortExpiry, Cursor: next},
+			{Sort: SortName, Desc: true, Cursor: next},
+			{Cursor: next},
+		} {
+			if err := other.Validate(); err != ErrCursorMismatch {
+				t.Errorf("expected ErrCursorMismatch for %+v, got %v", other, err)
+			}
+		}
+		same := CertificateQuery{Sort: SortName, Cursor: next}
+		if err := same.Validate(); err != nil {
+			t.Errorf("expected the cursor to be valid for the same order, got %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, q := range []CertificateQuery{
+			{State: "expired"},
+			{Sort: "size"},
+			{Limit: MaxPageSize + 1},
+			{ExpiringWithin: -1},
+			{Cursor: "not a cursor"},
+			{Labels: map[string]string{"bad key": ""}},
+		} {
+			if err := q.Validate(); err == nil {
+				t.Errorf("expected %+v to be invalid", q)
+			}
+		}
+	})
+}
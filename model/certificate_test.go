@@ -1,6 +1,13 @@
 package model
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"testing"
 	"time"
 )
@@ -179,6 +186,179 @@ func TestValidDomains(t *testing.T) {
 	}
 }
 
+func TestSetDomains(t *testing.T) {
+	c := &Certificate{Domains: []string{"example.com", "www.example.com"}, CommonName: "example.com"}
+
+	changed, err := c.SetDomains([]string{"WWW.example.com", "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("reordering domains shouldn't count as a change")
+	}
+	if c.CommonName != "WWW.example.com" {
+		t.Errorf("common name should follow the first domain, got %s", c.CommonName)
+	}
+
+	changed, err = c.SetDomains([]string{"example.com", "www.example.com", "api.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("adding a domain should count as a change")
+	}
+	if len(c.Domains) != 2 || len(c.PendingDomains) != 3 || len(c.RequestedDomains()) != 3 {
+		t.Errorf("expected the new names to wait for issuance, got %v and %v", c.Domains, c.PendingDomains)
+	}
+	changed, _ = c.SetDomains([]string{"api.example.com", "example.com", "www.example.com"})
+	if changed {
+		t.Error("repeating a pending request shouldn't count as a change")
+	}
+
+	changed, err = c.SetDomains([]string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("removing a domain should count as a change")
+	}
+
+	for _, bad := range [][]string{nil, {""}, {"https://example.com"}} {
+		if _, err := c.SetDomains(bad); err != ErrInvalidDomains {
+			t.Errorf("SetDomains(%q) = %v, want ErrInvalidDomains", bad, err)
+		}
+	}
+	if len(c.PendingDomains) != 1 || c.PendingDomains[0] != "example.com" {
+		t.Errorf("invalid domains shouldn't be stored, got %v", c.PendingDomains)
+	}
+
+	// Going back to the issued names drops the pending request.
+	changed, _ = c.SetDomains([]string{"www.example.com", "example.com"})
+	if changed || c.PendingDomains != nil || c.CommonName != "www.example.com" {
+		t.Errorf("expected the pending request to be dropped, got %v", c.PendingDomains)
+	}
+}
+
+func TestValidRenewAt(t *testing.T) {
+	for days, want := range map[int]bool{-1: false, 0: true, DefaultRenewAt: true, MaxRenewAt: true, MaxRenewAt + 1: false} {
+		if got := ValidRenewAt(days); got != want {
+			t.Errorf("ValidRenewAt(%d) = %t, want %t", days, got, want)
+		}
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	for email, want := range map[string]bool{"ops@example.com": true, "Ops <ops@example.com>": true, "": false, "ops": false} {
+		if got := ValidEmail(email); got != want {
+			t.Errorf("ValidEmail(%q) = %t, want %t", email, got, want)
+		}
+	}
+}
+
+func TestAwaitingDryRun(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		c    Certificate
+		want bool
+	}{
+		{"not validating first", Certificate{}, false},
+		{"no dry run yet", Certificate{ValidateFirst: true}, true},
+		{"dry run running", Certificate{ValidateFirst: true, DryRun: &DryRun{Started: now}}, true},
+		{"dry run failed", Certificate{ValidateFirst: true, DryRun: &DryRun{Started: now, Finished: now, Error: "nope"}}, true},
+		{"dry run passed", Certificate{ValidateFirst: true, DryRun: &DryRun{Started: now, Finished: now, Success: true}}, false},
+		{"issued", Certificate{ValidateFirst: true, Issued: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.AwaitingDryRun(); got != tt.want {
+			t.Errorf("%s: AwaitingDryRun() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenewDue(t *testing.T) {
+	c := &Certificate{RenewAt: 30}
+	for daysLeft, want := range map[int]bool{-1: true, 0: true, 29: true, 30: false, 60: false} {
+		if got := c.RenewDue(daysLeft); got != want {
+			t.Errorf("RenewDue(%d) with RenewAt 30 = %t, want %t", daysLeft, got, want)
+		}
+	}
+
+	c.RenewAt = 0
+	for _, daysLeft := range []int{-10, -1, 0, 30} {
+		if c.RenewDue(daysLeft) {
+			t.Errorf("RenewDue(%d) with RenewAt 0 should be false", daysLeft)
+		}
+	}
+}
+
+func TestNewImportedCertificate(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	certPEM, keyPEM := testSelfSigned(t, "example.com", []string{"example.com", "www.example.com"}, notAfter)
+	_, otherKeyPEM := testSelfSigned(t, "other.com", []string{"other.com"}, notAfter)
+
+	t.Run("happy path", func(t *testing.T) {
+		c, err := NewImportedCertificate(certPEM, nil, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Imported || !c.Issued {
+			t.Error("imported certificate should be marked imported and issued")
+		}
+		if !testEq(c.Domains, []string{"example.com", "www.example.com"}) {
+			t.Errorf("unexpected domains %v", c.Domains)
+		}
+		if c.CommonName != "example.com" {
+			t.Errorf("unexpected common name %s", c.CommonName)
+		}
+		if !c.Expiry.Equal(notAfter) {
+			t.Errorf("expiry mismatch: got %s, expected %s", c.Expiry, notAfter)
+		}
+		if c.ID == "" || c.Secret == "" {
+			t.Error("id and secret should be generated")
+		}
+	})
+
+	t.Run("chain in certificate file", func(t *testing.T) {
+		chainPEM, _ := testSelfSigned(t, "Test CA", nil, notAfter)
+		bundle := append(append([]byte{}, certPEM...), chainPEM...)
+		c, err := NewImportedCertificate(bundle, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(c.Certificate) != string(certPEM) {
+			t.Error("leaf should be split from bundle")
+		}
+		if len(c.IssuerCertificate) == 0 {
+			t.Error("chain should be taken from bundle")
+		}
+		if len(c.PrivateKey) != 0 {
+			t.Error("private key should be empty")
+		}
+	})
+
+	t.Run("mismatched key", func(t *testing.T) {
+		_, err := NewImportedCertificate(certPEM, nil, otherKeyPEM)
+		if err != ErrKeyMismatch {
+			t.Errorf("expected ErrKeyMismatch, got %v", err)
+		}
+	})
+
+	t.Run("invalid certificate", func(t *testing.T) {
+		_, err := NewImportedCertificate([]byte("not a cert"), nil, nil)
+		if err != ErrInvalidCertificate {
+			t.Errorf("expected ErrInvalidCertificate, got %v", err)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := NewImportedCertificate(certPEM, nil, []byte("not a key"))
+		if err != ErrInvalidPrivateKey {
+			t.Errorf("expected ErrInvalidPrivateKey, got %v", err)
+		}
+	})
+}
+
 func TestGetEmail(t *testing.T) {
 
 }
@@ -210,3 +390,35 @@ func testEq(a, b []string) bool {
 
 	return true
 }
+
+// testSelfSigned returns a PEM encoded self-signed certificate and its PEM
+// encoded private key.
+func testSelfSigned(t *testing.T, cn string, domains []string, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
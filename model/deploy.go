@@ -0,0 +1,320 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// Deploy target types.
+const (
+	// DeployTargetSSH copies files to a host over SFTP and runs a command.
+	DeployTargetSSH = "ssh"
+	// DeployTargetKubernetes writes a kubernetes.io/tls Secret.
+	DeployTargetKubernetes = "kubernetes"
+	// DeployTargetVault writes a Vault KV version 2 secret.
+	DeployTargetVault = "vault"
+)
+
+// Deploy states.
+const (
+	// DeployPending targets have a version waiting to be pushed, possibly
+	// after earlier attempts failed.
+	DeployPending  = "pending"
+	DeployDeployed = "deployed"
+	// DeployFailed targets ran out of retries. A redeploy starts over.
+	DeployFailed = "failed"
+)
+
+var ErrInvalidDeployTarget = errors.New("deploy targets need a type of ssh, kubernetes or vault and its settings")
+
+// keyFilePattern limits key and kubeconfig references to plain file names in
+// the key directory.
+var keyFilePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// dnsLabelPattern is a Kubernetes namespace, and dnsSubdomainPattern an
+// object name.
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+var dnsSubdomainPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// vaultPathPattern is a relative Vault path whose segments don't start with a
+// dot, so there's no way out of the mount.
+var vaultPathPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// DeployTarget is somewhere TLSential pushes a certificate to after every
+// issuance, along with how the last push went.
+type DeployTarget struct {
+	ID         string
+	CertID     string
+	Type       string
+	SSH        *SSHTarget        `json:",omitempty"`
+	Kubernetes *KubernetesTarget `json:",omitempty"`
+	Vault      *VaultTarget      `json:",omitempty"`
+
+	Status string
+	// Version is the certificate version being pushed, or last pushed.
+	Version int
+	// DeployedVersion is the last version pushed successfully.
+	DeployedVersion int
+	Attempts        int
+	NextAttempt     time.Time
+	LastAttempt     time.Time
+	LastError       string
+	// Output is what the last push reported, such as the end of an SSH
+	// target's command output.
+	Output string
+
+	// Drift says how the target no longer matches the last push, for types
+	// that can be checked. It's empty if it matches.
+	Drift        string `json:",omitempty"`
+	DriftChecked time.Time
+
+	Created time.Time
+}
+
+// SSHTarget is a host without an agent that files are pushed to over SFTP.
+type SSHTarget struct {
+	// Host is host or host:port, port 22 by default.
+	Host string
+	User string
+	// Key names the private key file in the server's deploy key directory.
+	// Keys are never stored in the database.
+	Key string
+	// HostKey is the host's public key in authorized_keys format. Without it
+	// the host must be in known_hosts in the deploy key directory.
+	HostKey string `json:",omitempty"`
+
+	Files []*DeployFile
+	// Command is run after the files are in place, such as a reload.
+	Command string `json:",omitempty"`
+}
+
+// DeployFile is one file to write on the target.
+type DeployFile struct {
+	Path     string
+	Contents string
+	// Mode is in octal, 0600 by default for files holding the key and 0644
+	// otherwise.
+	Mode string `json:",omitempty"`
+}
+
+// KubernetesTarget is a kubernetes.io/tls Secret kept in sync with the
+// certificate.
+type KubernetesTarget struct {
+	// Kubeconfig names a kubeconfig file in the server's deploy key
+	// directory. Without one the in-cluster service account is used.
+	Kubeconfig string `json:",omitempty"`
+	// Context is the kubeconfig context to use, its current one by default.
+	Context string `json:",omitempty"`
+
+	Namespace   string
+	Name        string
+	Labels      map[string]string `json:",omitempty"`
+	Annotations map[string]string `json:",omitempty"`
+}
+
+// VaultTarget is a secret in a Vault KV version 2 engine that the
+// certificate, chain and key are written to, with the certificate's version
+// in the secret's custom metadata.
+type VaultTarget struct {
+	// Address is Vault's URL, such as https://vault.example.com:8200.
+	Address string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string `json:",omitempty"`
+	// Mount is the KV engine's path, "secret" by default.
+	Mount string `json:",omitempty"`
+	// Path is the secret's path in the engine.
+	Path string
+
+	// TokenFile names a file in the server's deploy key directory holding
+	// a Vault token.
+	TokenFile string `json:",omitempty"`
+	// RoleID and SecretIDFile log in with AppRole instead of a token. The
+	// secret ID is read from the deploy key directory.
+	RoleID       string `json:",omitempty"`
+	SecretIDFile string `json:",omitempty"`
+	// AppRoleMount is the AppRole auth method's path, "approle" by default.
+	AppRoleMount string `json:",omitempty"`
+
+	// CACert names a PEM file in the deploy key directory to trust instead
+	// of the system roots.
+	CACert string `json:",omitempty"`
+}
+
+// NewDeployTarget returns a target for certID with a fresh ID.
+func NewDeployTarget(certID string) *DeployTarget {
+	return &DeployTarget{
+		ID:      ksuid.New().String(),
+		CertID:  certID,
+		Created: time.Now(),
+	}
+}
+
+// Validate checks the settings for the target's type.
+func (t *DeployTarget) Validate() error {
+	switch t.Type {
+	case DeployTargetSSH:
+		if t.SSH == nil {
+			return ErrInvalidDeployTarget
+		}
+		return t.SSH.Validate()
+	case DeployTargetKubernetes:
+		if t.Kubernetes == nil {
+			return ErrInvalidDeployTarget
+		}
+		return t.Kubernetes.Validate()
+	case DeployTargetVault:
+		if t.Vault == nil {
+			return ErrInvalidDeployTarget
+		}
+		return t.Vault.Validate()
+	}
+	return ErrInvalidDeployTarget
+}
+
+// Host describes where the target is, for lists.
+func (t *DeployTarget) Host() string {
+	switch {
+	case t.SSH != nil:
+		return t.SSH.User + "@" + t.SSH.Host
+	case t.Kubernetes != nil:
+		return "secret " + t.Kubernetes.Namespace + "/" + t.Kubernetes.Name
+	case t.Vault != nil:
+		return "vault " + t.Vault.MountPath() + "/" + t.Vault.Path
+	}
+	return t.Type
+}
+
+// Validate checks the connection settings and files.
+func (s *SSHTarget) Validate() error {
+	if s.Host == "" || strings.ContainsAny(s.Host, " /@") || s.User == "" {
+		return errors.New("ssh targets need a host and user")
+	}
+	if !keyFilePattern.MatchString(s.Key) {
+		return errors.New("ssh key must be the name of a file in the deploy key directory")
+	}
+	if s.HostKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.HostKey)); err != nil {
+			return fmt.Errorf("host key: %s", err)
+		}
+	}
+	if len(s.Files) == 0 {
+		return errors.New("ssh targets need at least one file")
+	}
+	for _, f := range s.Files {
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path {
+			return fmt.Errorf("%q: file paths must be absolute and clean", f.Path)
+		}
+		if _, ok := ContentsMode(f.Contents); !ok {
+			return fmt.Errorf("%s: contents must be cert, chain, fullchain, key or combined", f.Path)
+		}
+		if _, err := f.FileMode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the Secret's name and metadata.
+func (k *KubernetesTarget) Validate() error {
+	if k.Kubeconfig != "" && !keyFilePattern.MatchString(k.Kubeconfig) {
+		return errors.New("kubeconfig must be the name of a file in the deploy key directory")
+	}
+	if !dnsLabelPattern.MatchString(k.Namespace) {
+		return fmt.Errorf("%q isn't a valid namespace", k.Namespace)
+	}
+	if len(k.Name) > 253 || !dnsSubdomainPattern.MatchString(k.Name) {
+		return fmt.Errorf("%q isn't a valid secret name", k.Name)
+	}
+	for key := range k.Labels {
+		if key == "" || strings.ContainsAny(key, " \t\n") {
+			return fmt.Errorf("%q isn't a valid label", key)
+		}
+	}
+	for key := range k.Annotations {
+		if key == "" || strings.ContainsAny(key, " \t\n") {
+			return fmt.Errorf("%q isn't a valid annotation", key)
+		}
+	}
+	return nil
+}
+
+// Validate checks the address, paths and credentials.
+func (v *VaultTarget) Validate() error {
+	u, err := url.Parse(v.Address)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("vault address must be an http or https URL")
+	}
+	if !vaultPathPattern.MatchString(v.Path) {
+		return fmt.Errorf("%q isn't a valid vault path", v.Path)
+	}
+	for _, p := range []string{v.Mount, v.AppRoleMount, v.Namespace} {
+		if p != "" && !vaultPathPattern.MatchString(p) {
+			return fmt.Errorf("%q isn't a valid vault path", p)
+		}
+	}
+
+	token := v.TokenFile != ""
+	approle := v.RoleID != "" || v.SecretIDFile != ""
+	if token == approle {
+		return errors.New("vault targets need either a token file or an AppRole role ID and secret ID file")
+	}
+	if approle && (v.RoleID == "" || strings.ContainsAny(v.RoleID, " \t\n")) {
+		return errors.New("vault targets need an AppRole role ID")
+	}
+	for _, f := range []string{v.TokenFile, v.SecretIDFile, v.CACert} {
+		if f != "" && !keyFilePattern.MatchString(f) {
+			return fmt.Errorf("%q must be the name of a file in the deploy key directory", f)
+		}
+	}
+	if approle && v.SecretIDFile == "" {
+		return errors.New("vault targets need an AppRole secret ID file")
+	}
+	return nil
+}
+
+// MountPath returns the KV engine's path.
+func (v *VaultTarget) MountPath() string {
+	if v.Mount == "" {
+		return "secret"
+	}
+	return v.Mount
+}
+
+// AppRolePath returns the AppRole auth method's path.
+func (v *VaultTarget) AppRolePath() string {
+	if v.AppRoleMount == "" {
+		return "approle"
+	}
+	return v.AppRoleMount
+}
+
+// FileMode returns the permissions to write the file with.
+func (f *DeployFile) FileMode() (uint32, error) {
+	if f.Mode == "" {
+		m, _ := ContentsMode(f.Contents)
+		return uint32(m), nil
+	}
+	m, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%s: mode must be octal permissions such as 0640", f.Path)
+	}
+	return uint32(m), nil
+}
+
+// Schedule queues version for pushing as soon as possible, starting the
+// attempts over.
+func (t *DeployTarget) Schedule(version int) {
+	t.Status = DeployPending
+	t.Version = version
+	t.Attempts = 0
+	t.NextAttempt = time.Now()
+}
@@ -0,0 +1,129 @@
+package model
+
+import "testing"
+
+func sshTarget(mod func(s *SSHTarget)) *DeployTarget {
+	t := NewDeployTarget("cert1")
+	t.Type = DeployTargetSSH
+	t.SSH = &SSHTarget{
+		Host: "web1.example.com:2222",
+		User: "deploy",
+		Key:  "id_ed25519",
+		Files: []*DeployFile{
+			{Path: "/etc/ssl/fullchain.pem", Contents: ContentsFullChain},
+			{Path: "/etc/ssl/privkey.pem", Contents: ContentsKey, Mode: "0640"},
+		},
+	}
+	if mod != nil {
+		mod(t.SSH)
+	}
+	return t
+}
+
+func kubeTarget(mod func(k *KubernetesTarget)) *DeployTarget {
+	t := NewDeployTarget("cert1")
+	t.Type = DeployTargetKubernetes
+	t.Kubernetes = &KubernetesTarget{
+		Kubeconfig:  "prod.yaml",
+		Namespace:   "web",
+		Name:        "www-example-com-tls",
+		Labels:      map[string]string{"app": "www"},
+		Annotations: map[string]string{"example.com/owner": "web-team"},
+	}
+	if mod != nil {
+		mod(t.Kubernetes)
+	}
+	return t
+}
+
+func vaultTarget(mod func(v *VaultTarget)) *DeployTarget {
+	t := NewDeployTarget("cert1")
+	t.Type = DeployTargetVault
+	t.Vault = &VaultTarget{
+		Address:   "https://vault.example.com:8200",
+		Path:      "apps/web/tls",
+		TokenFile: "vault-token",
+	}
+	if mod != nil {
+		mod(t.Vault)
+	}
+	return t
+}
+
+func TestDeployTargetValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		t     *DeployTarget
+		valid bool
+	}{
+		{"ok", sshTarget(nil), true},
+		{"no type", &DeployTarget{}, false},
+		{"no settings", &DeployTarget{Type: DeployTargetSSH}, false},
+		{"no host", sshTarget(func(s *SSHTarget) { s.Host = "" }), false},
+		{"user in host", sshTarget(func(s *SSHTarget) { s.Host = "root@web1" }), false},
+		{"key path", sshTarget(func(s *SSHTarget) { s.Key = "../id_rsa" }), false},
+		{"hidden key", sshTarget(func(s *SSHTarget) { s.Key = ".ssh" }), false},
+		{"bad host key", sshTarget(func(s *SSHTarget) { s.HostKey = "ssh-ed25519 nope" }), false},
+		{"no files", sshTarget(func(s *SSHTarget) { s.Files = nil }), false},
+		{"relative path", sshTarget(func(s *SSHTarget) { s.Files[0].Path = "etc/ssl/cert.pem" }), false},
+		{"unclean path", sshTarget(func(s *SSHTarget) { s.Files[0].Path = "/etc/ssl/../passwd" }), false},
+		{"bad contents", sshTarget(func(s *SSHTarget) { s.Files[0].Contents = "pfx" }), false},
+		{"bad mode", sshTarget(func(s *SSHTarget) { s.Files[0].Mode = "0999" }), false},
+		{"big mode", sshTarget(func(s *SSHTarget) { s.Files[0].Mode = "4755" }), false},
+		{"kubernetes", kubeTarget(nil), true},
+		{"in cluster", kubeTarget(func(k *KubernetesTarget) { k.Kubeconfig = "" }), true},
+		{"no kubernetes settings", &DeployTarget{Type: DeployTargetKubernetes}, false},
+		{"kubeconfig path", kubeTarget(func(k *KubernetesTarget) { k.Kubeconfig = "/root/.kube/config" }), false},
+		{"no namespace", kubeTarget(func(k *KubernetesTarget) { k.Namespace = "" }), false},
+		{"bad namespace", kubeTarget(func(k *KubernetesTarget) { k.Namespace = "Web.Prod" }), false},
+		{"bad name", kubeTarget(func(k *KubernetesTarget) { k.Name = "www_tls" }), false},
+		{"empty label", kubeTarget(func(k *KubernetesTarget) { k.Labels[""] = "x" }), false},
+		{"bad annotation", kubeTarget(func(k *KubernetesTarget) { k.Annotations["a b"] = "x" }), false},
+		{"vault", vaultTarget(nil), true},
+		{"approle", vaultTarget(func(v *VaultTarget) { v.TokenFile, v.RoleID, v.SecretIDFile = "", "web", "web-secret-id" }), true},
+		{"no vault settings", &DeployTarget{Type: DeployTargetVault}, false},
+		{"vault address", vaultTarget(func(v *VaultTarget) { v.Address = "vault.example.com" }), false},
+		{"no vault path", vaultTarget(func(v *VaultTarget) { v.Path = "" }), false},
+		{"absolute vault path", vaultTarget(func(v *VaultTarget) { v.Path = "/apps/web" }), false},
+		{"escaping vault path", vaultTarget(func(v *VaultTarget) { v.Path = "apps/../sys" }), false},
+		{"bad mount", vaultTarget(func(v *VaultTarget) { v.Mount = "kv/" }), false},
+		{"no credentials", vaultTarget(func(v *VaultTarget) { v.TokenFile = "" }), false},
+		{"both credentials", vaultTarget(func(v *VaultTarget) { v.RoleID, v.SecretIDFile = "web", "web-secret-id" }), false},
+		{"no secret id", vaultTarget(func(v *VaultTarget) { v.TokenFile, v.RoleID = "", "web" }), false},
+		{"token path", vaultTarget(func(v *VaultTarget) { v.TokenFile = "../token" }), false},
+		{"ca path", vaultTarget(func(v *VaultTarget) { v.CACert = "/etc/ssl/ca.pem" }), false},
+	}
+	for _, tt := range tests {
+		err := tt.t.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %t, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestDeployFileMode(t *testing.T) {
+	tests := []struct {
+		f    *DeployFile
+		mode uint32
+	}{
+		{&DeployFile{Contents: ContentsCert}, 0644},
+		{&DeployFile{Contents: ContentsCombined}, 0600},
+		{&DeployFile{Contents: ContentsKey, Mode: "0640"}, 0640},
+	}
+	for _, tt := range tests {
+		m, err := tt.f.FileMode()
+		if err != nil || m != tt.mode {
+			t.Errorf("%s: expected %o, got %o, %v", tt.f.Contents, tt.mode, m, err)
+		}
+	}
+}
+
+func TestDeployTargetSchedule(t *testing.T) {
+	d := sshTarget(nil)
+	d.Status = DeployFailed
+	d.Attempts = 8
+	d.Schedule(4)
+	if d.Status != DeployPending || d.Version != 4 || d.Attempts != 0 || d.NextAttempt.IsZero() {
+		t.Errorf("expected a fresh pending push, got %+v", d)
+	}
+}
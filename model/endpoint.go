@@ -0,0 +1,203 @@
+package model
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deployment states of an endpoint, from the last probe.
+const (
+	EndpointOK          = "ok"
+	EndpointStale       = "stale"
+	EndpointUnreachable = "unreachable"
+)
+
+var ErrInvalidEndpoint = errors.New("endpoints must be host:port with an optional server name")
+
+// Endpoint is a live TLS service that should be serving a certificate, along
+// with what it was serving when last probed.
+type Endpoint struct {
+	// Address is the host:port to connect to.
+	Address string
+	// ServerName is sent as SNI. It defaults to the host part of Address.
+	ServerName string
+
+	// Status is empty until the endpoint has been probed.
+	Status  string
+	Checked time.Time
+	// Serial and Expiry describe the leaf certificate that was served.
+	Serial string
+	Expiry time.Time
+	Error  string
+}
+
+// ParseEndpoint parses "host:port" or "host:port servername".
+func ParseEndpoint(s string) (*Endpoint, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, ErrInvalidEndpoint
+	}
+	e := &Endpoint{Address: fields[0]}
+	if len(fields) == 2 {
+		e.ServerName = fields[1]
+	}
+	if !e.valid() {
+		return nil, ErrInvalidEndpoint
+	}
+	return e, nil
+}
+
+// ParseEndpoints parses one endpoint per line, skipping blank lines.
+func ParseEndpoints(s string) ([]*Endpoint, error) {
+	var eps []*Endpoint
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, err := ParseEndpoint(line)
+		if err != nil {
+			return nil, err
+		}
+		eps = append(eps, e)
+	}
+	return eps, nil
+}
+
+// FormatEndpoints is the inverse of ParseEndpoints.
+func FormatEndpoints(eps []*Endpoint) string {
+	lines := make([]string, len(eps))
+	for i, e := range eps {
+		lines[i] = e.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *Endpoint) String() string {
+	if e.ServerName == "" {
+		return e.Address
+	}
+	return e.Address + " " + e.ServerName
+}
+
+// SNI returns the server name to send when connecting.
+func (e *Endpoint) SNI() string {
+	if e.ServerName != "" {
+		return e.ServerName
+	}
+	host, _, _ := net.SplitHostPort(e.Address)
+	return host
+}
+
+func (e *Endpoint) valid() bool {
+	host, port, err := net.SplitHostPort(e.Address)
+	if err != nil || host == "" {
+		return false
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return false
+	}
+	return e.ServerName == "" || ValidDomains([]string{e.ServerName})
+}
+
+// Observe records that served was presented by the endpoint at now, and marks
+// it stale unless it's the certificate c currently holds.
+func (e *Endpoint) Observe(c *Certificate, served *x509.Certificate, now time.Time) {
+	e.Checked = now
+	e.Serial = hexColon(served.SerialNumber.Bytes())
+	e.Expiry = served.NotAfter
+	e.Error = ""
+	e.judge(c)
+}
+
+// judge marks the endpoint stale unless the serial it served is c's.
+func (e *Endpoint) judge(c *Certificate) {
+	e.Status = EndpointStale
+	d, err := c.Details()
+	if err == nil && d != nil && d.SerialNumber == e.Serial {
+		e.Status = EndpointOK
+	}
+}
+
+// Unreachable records a failed probe at now.
+func (e *Endpoint) Unreachable(err error, now time.Time) {
+	e.Checked = now
+	e.Status = EndpointUnreachable
+	e.Error = err.Error()
+}
+
+// ValidEndpoints reports whether every endpoint in eps can be probed.
+func ValidEndpoints(eps []*Endpoint) bool {
+	for _, e := range eps {
+		if !e.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetEndpoints validates eps and replaces c's endpoints with them. Probe
+// results are kept for endpoints that were already listed.
+func (c *Certificate) SetEndpoints(eps []*Endpoint) error {
+	var kept []*Endpoint
+	for _, e := range eps {
+		if !e.valid() {
+			return ErrInvalidEndpoint
+		}
+		n := &Endpoint{Address: e.Address, ServerName: e.ServerName}
+		if old := c.endpoint(n); old != nil {
+			n = old
+		}
+		kept = append(kept, n)
+	}
+	c.Endpoints = kept
+	return nil
+}
+
+// UpdateEndpoints copies probe results onto c's matching endpoints. Results
+// for endpoints that have since been removed are dropped. What each endpoint
+// served is judged against c, which may have been reissued since the probe
+// started.
+func (c *Certificate) UpdateEndpoints(results []*Endpoint) {
+	for _, r := range results {
+		if e := c.endpoint(r); e != nil {
+			*e = *r
+			if e.Status != EndpointUnreachable {
+				e.judge(c)
+			}
+		}
+	}
+}
+
+func (c *Certificate) endpoint(e *Endpoint) *Endpoint {
+	for _, have := range c.Endpoints {
+		if have.Address == e.Address && have.ServerName == e.ServerName {
+			return have
+		}
+	}
+	return nil
+}
+
+// DeploymentStatus summarises the endpoints: unreachable if any endpoint
+// couldn't be reached, otherwise stale if any is serving something else,
+// otherwise ok. It's empty if no endpoint has been probed.
+func (c *Certificate) DeploymentStatus() string {
+	status := ""
+	for _, e := range c.Endpoints {
+		switch e.Status {
+		case EndpointUnreachable:
+			return EndpointUnreachable
+		case EndpointStale:
+			status = EndpointStale
+		case EndpointOK:
+			if status == "" {
+				status = EndpointOK
+			}
+		}
+	}
+	return status
+}
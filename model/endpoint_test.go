@@ -0,0 +1,57 @@
+package model
+
+import "testing"
+
+func TestParseEndpoints(t *testing.T) {
+	eps, err := ParseEndpoints("lb1.example.com:443\n\n  10.0.0.5:8443 www.example.com \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(eps))
+	}
+	if eps[0].SNI() != "lb1.example.com" {
+		t.Errorf("SNI should default to the host, got %s", eps[0].SNI())
+	}
+	if eps[1].SNI() != "www.example.com" {
+		t.Errorf("SNI should use the server name, got %s", eps[1].SNI())
+	}
+	if got := FormatEndpoints(eps); got != "lb1.example.com:443\n10.0.0.5:8443 www.example.com" {
+		t.Errorf("FormatEndpoints() = %q", got)
+	}
+
+	for _, bad := range []string{"example.com", ":443", "example.com:0", "example.com:https", "a:443 b c", "a:443 https://b"} {
+		if _, err := ParseEndpoint(bad); err != ErrInvalidEndpoint {
+			t.Errorf("ParseEndpoint(%q) = %v, want ErrInvalidEndpoint", bad, err)
+		}
+	}
+}
+
+func TestSetEndpoints(t *testing.T) {
+	c := &Certificate{Endpoints: []*Endpoint{
+		{Address: "a.example.com:443", Status: EndpointOK},
+		{Address: "b.example.com:443", Status: EndpointStale},
+	}}
+
+	err := c.SetEndpoints([]*Endpoint{{Address: "a.example.com:443"}, {Address: "c.example.com:443"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Endpoints) != 2 || c.Endpoints[0].Status != EndpointOK || c.Endpoints[1].Status != "" {
+		t.Errorf("existing probe results should be kept, got %+v %+v", c.Endpoints[0], c.Endpoints[1])
+	}
+	if c.DeploymentStatus() != EndpointOK {
+		t.Errorf("expected ok, got %s", c.DeploymentStatus())
+	}
+
+	err = c.SetEndpoints([]*Endpoint{{Address: "nope"}})
+	if err != ErrInvalidEndpoint {
+		t.Errorf("expected ErrInvalidEndpoint, got %v", err)
+	}
+	if ValidEndpoints([]*Endpoint{{Address: "a.example.com:443"}, {Address: "nope"}}) {
+		t.Error("ValidEndpoints should reject an address without a port")
+	}
+	if !ValidEndpoints([]*Endpoint{{Address: "a.example.com:443", ServerName: "a.example.com"}}) {
+		t.Error("ValidEndpoints should accept a host and port")
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// DBStats describes the database file.
+type DBStats struct {
+	Path          string
+	Size          int64
+	SchemaVersion int
+	// Buckets maps each top level bucket to its number of keys.
+	Buckets map[string]int
+}
+
+// ScanResult is the outcome of one renewal scan over all certificates.
+type ScanResult struct {
+	Time    time.Time
+	Checked int
+	Queued  int
+	Error   string
+}
+
+// FailingCert is a certificate whose last issuance or renewal failed.
+type FailingCert struct {
+	ID         string
+	CommonName string
+	Error      string
+}
+
+// Diagnostics is a snapshot of the server's state for on-call.
+type Diagnostics struct {
+	Version string
+	DB      *DBStats
+
+	QueueLength   int
+	QueueCapacity int
+	Workers       int
+	LastDequeue   time.Time
+
+	// LastScan is nil until the first scan has run.
+	LastScan *ScanResult
+
+	DNSProviderValid bool
+	DNSProviderError string
+
+	FailingCerts []*FailingCert
+}
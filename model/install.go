@@ -0,0 +1,224 @@
+package model
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/segmentio/ksuid"
+)
+
+// Install states reported by deployment agents.
+const (
+	InstallOK         = "installed"
+	InstallFailed     = "failed"
+	InstallRolledBack = "rolled_back"
+)
+
+// MaxInstalls is how many hosts' reports are kept per certificate. The
+// oldest report is dropped to make room.
+const MaxInstalls = 100
+
+// ErrInvalidInstall is returned for a malformed install report.
+var ErrInvalidInstall = errors.New("install reports need a host and a status of installed, failed or rolled_back")
+
+// Install is the latest report from a deployment agent on one host.
+type Install struct {
+	Host    string
+	Version int
+	Status  string
+	Error   string
+	Time    time.Time
+}
+
+// Validate checks the report can be stored.
+func (i *Install) Validate() error {
+	if i.Host == "" || len(i.Host) > 255 || i.Version < 0 {
+		return ErrInvalidInstall
+	}
+	switch i.Status {
+	case InstallOK, InstallFailed, InstallRolledBack:
+		return nil
+	}
+	return ErrInvalidInstall
+}
+
+// RecordInstall stores i as the latest report for its host.
+func (c *Certificate) RecordInstall(i *Install) {
+	for n, have := range c.Installs {
+		if have.Host == i.Host {
+			c.Installs = append(c.Installs[:n], c.Installs[n+1:]...)
+			break
+		}
+	}
+	if len(c.Installs) >= MaxInstalls {
+		oldest := 0
+		for n, have := range c.Installs {
+			if have.Time.Before(c.Installs[oldest].Time) {
+				oldest = n
+			}
+		}
+		c.Installs = append(c.Installs[:oldest], c.Installs[oldest+1:]...)
+	}
+	c.Installs = append(c.Installs, i)
+}
+
+// MaxInstallTokens is how many install tokens a certificate can have.
+const MaxInstallTokens = 100
+
+var (
+	// ErrInvalidInstallToken is returned for a token without a usable name.
+	ErrInvalidInstallToken = errors.New("install tokens need a name of at most 255 characters")
+	// ErrTooManyInstallTokens is returned when a certificate already has
+	// MaxInstallTokens.
+	ErrTooManyInstallTokens = errors.New("too many install tokens, revoke some first")
+)
+
+// InstallToken lets one deployment agent or deploy script download the
+// certificate it belongs to. Unlike the certificate's secret it isn't used up
+// by downloads, so every host gets its own and can be revoked on its own.
+// Only a hash of the token is kept.
+type InstallToken struct {
+	ID      string
+	Name    string
+	Hash    []byte
+	Created time.Time
+}
+
+// NewInstallToken returns a token named name and the value to hand to the
+// host, which is its ID and a random part joined by a dot.
+func NewInstallToken(name string) (*InstallToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, "", ErrInvalidInstallToken
+	}
+	t := &InstallToken{
+		ID:      ksuid.New().String(),
+		Name:    name,
+		Created: time.Now(),
+	}
+	value := t.ID + "." + auth.NewPassword()
+	hash := sha256.Sum256([]byte(value))
+	t.Hash = hash[:]
+	return t, value, nil
+}
+
+// AddInstallToken adds t to c's tokens.
+func (c *Certificate) AddInstallToken(t *InstallToken) error {
+	if len(c.InstallTokens) >= MaxInstallTokens {
+		return ErrTooManyInstallTokens
+	}
+	c.InstallTokens = append(c.InstallTokens, t)
+	return nil
+}
+
+// RemoveInstallToken revokes the token with id, reporting whether c had it.
+func (c *Certificate) RemoveInstallToken(id string) bool {
+	for n, t := range c.InstallTokens {
+		if t.ID == id {
+			c.InstallTokens = append(c.InstallTokens[:n], c.InstallTokens[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// InstallToken returns the token value belongs to, or nil if it isn't one of
+// c's.
+func (c *Certificate) InstallToken(value string) *InstallToken {
+	n := strings.IndexByte(value, '.')
+	if n < 0 {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(value))
+	for _, t := range c.InstallTokens {
+		if t.ID == value[:n] && subtle.ConstantTimeCompare(t.Hash, hash[:]) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// What a deployed file holds.
+const (
+	ContentsCert      = "cert"
+	ContentsChain     = "chain"
+	ContentsFullChain = "fullchain"
+	ContentsKey       = "key"
+	// ContentsCombined is the key followed by the full chain, as HAProxy
+	// wants it.
+	ContentsCombined = "combined"
+)
+
+// ContentsMode returns the default permissions for a file holding what, or
+// false if what isn't one of the Contents values.
+func ContentsMode(what string) (os.FileMode, bool) {
+	switch what {
+	case ContentsCert, ContentsChain, ContentsFullChain:
+		return 0644, true
+	case ContentsKey, ContentsCombined:
+		return 0600, true
+	}
+	return 0, false
+}
+
+// InstallBundle is what a deployment agent downloads: everything it needs to
+// install a certificate version.
+type InstallBundle struct {
+	Version int
+	Expiry  time.Time
+
+	// PEM encoded.
+	Certificate       string
+	IssuerCertificate string
+	PrivateKey        string
+}
+
+// NewInstallBundle returns the bundle for the current version of c.
+func NewInstallBundle(c *Certificate) *InstallBundle {
+	return &InstallBundle{
+		Version:           c.Version,
+		Expiry:            c.Expiry,
+		Certificate:       string(c.Certificate),
+		IssuerCertificate: string(c.IssuerCertificate),
+		PrivateKey:        string(c.PrivateKey),
+	}
+}
+
+// Contents builds what a file holds from the bundle, or nil if what isn't
+// one of the Contents values.
+func (b *InstallBundle) Contents(what string) []byte {
+	fullchain := pemJoin(b.Certificate, b.IssuerCertificate)
+	switch what {
+	case ContentsCert:
+		return []byte(pemJoin(b.Certificate))
+	case ContentsChain:
+		return []byte(pemJoin(b.IssuerCertificate))
+	case ContentsFullChain:
+		return []byte(fullchain)
+	case ContentsKey:
+		return []byte(pemJoin(b.PrivateKey))
+	case ContentsCombined:
+		return []byte(pemJoin(b.PrivateKey, fullchain))
+	}
+	return nil
+}
+
+// pemJoin concatenates PEM blocks, making sure each ends with a newline.
+func pemJoin(blocks ...string) string {
+	var sb strings.Builder
+	for _, b := range blocks {
+		if b == "" {
+			continue
+		}
+		sb.WriteString(b)
+		if !strings.HasSuffix(b, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecordInstall(t *testing.T) {
+	c := &Certificate{}
+	now := time.Now()
+
+	c.RecordInstall(&Install{Host: "web1", Version: 1, Status: InstallOK, Time: now})
+	c.RecordInstall(&Install{Host: "web2", Version: 1, Status: InstallOK, Time: now})
+	c.RecordInstall(&Install{Host: "web1", Version: 2, Status: InstallRolledBack, Time: now})
+	if len(c.Installs) != 2 {
+		t.Fatalf("expected one report per host, got %d", len(c.Installs))
+	}
+	if last := c.Installs[1]; last.Host != "web1" || last.Version != 2 {
+		t.Errorf("expected the latest web1 report last, got %+v", last)
+	}
+
+	for i := 0; i < MaxInstalls; i++ {
+		c.RecordInstall(&Install{Host: fmt.Sprintf("host%d", i), Status: InstallOK, Time: now.Add(time.Duration(i+1) * time.Second)})
+	}
+	if len(c.Installs) != MaxInstalls {
+		t.Fatalf("expected %d reports, got %d", MaxInstalls, len(c.Installs))
+	}
+	for _, i := range c.Installs {
+		if i.Host == "web1" || i.Host == "web2" {
+			t.Errorf("expected the oldest reports to be dropped, found %s", i.Host)
+		}
+	}
+}
+
+func TestInstallValidate(t *testing.T) {
+	tests := []struct {
+		i     *Install
+		valid bool
+	}{
+		{&Install{Host: "web1", Status: InstallOK}, true},
+		{&Install{Host: "web1", Status: InstallFailed, Error: "reload failed"}, true},
+		{&Install{Host: "", Status: InstallOK}, false},
+		{&Install{Host: "web1", Status: "done"}, false},
+		{&Install{Host: "web1", Version: -1, Status: InstallOK}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.i.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %t, got %v", tt.i, tt.valid, err)
+		}
+	}
+}
+
+func TestInstallTokens(t *testing.T) {
+	if _, _, err := NewInstallToken(" "); err != ErrInvalidInstallToken {
+		t.Errorf("expected ErrInvalidInstallToken, got %v", err)
+	}
+
+	c := &Certificate{}
+	web1, value1, err := NewInstallToken("web1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web2, value2, err := NewInstallToken("web2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddInstallToken(web1)
+	c.AddInstallToken(web2)
+
+	if got := c.InstallToken(value1); got != web1 {
+		t.Errorf("expected web1's token, got %+v", got)
+	}
+	if got := c.InstallToken(web1.ID + "." + "guess"); got != nil {
+		t.Errorf("expected a wrong token to be refused, got %+v", got)
+	}
+	if got := c.InstallToken(web1.ID); got != nil {
+		t.Errorf("expected a bare ID to be refused, got %+v", got)
+	}
+
+	// Revoking one token leaves the others working.
+	if !c.RemoveInstallToken(web1.ID) || c.RemoveInstallToken(web1.ID) {
+		t.Error("expected web1's token to be removed once")
+	}
+	if c.InstallToken(value1) != nil || c.InstallToken(value2) != web2 {
+		t.Error("expected only web2's token to work")
+	}
+
+	for len(c.InstallTokens) < MaxInstallTokens {
+		tok, _, _ := NewInstallToken("host")
+		c.AddInstallToken(tok)
+	}
+	if err := c.AddInstallToken(web1); err != ErrTooManyInstallTokens {
+		t.Errorf("expected ErrTooManyInstallTokens, got %v", err)
+	}
+}
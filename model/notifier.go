@@ -0,0 +1,171 @@
+package model
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Events sent to notifiers.
+const (
+	EventIssued     = "issued"
+	EventRenewed    = "renewed"
+	EventFailed     = "failed"
+	EventExpiring   = "expiring"
+	EventRevoked    = "revoked"
+	EventDeployment = "deployment"
+	EventTest       = "test"
+
+	// EventIssuing is published when an ACME order starts. It only goes to
+	// event stream subscribers, not notifiers.
+	EventIssuing = "issuing"
+)
+
+// Notifier channel types.
+const (
+	NotifierSMTP    = "smtp"
+	NotifierWebhook = "webhook"
+	NotifierSlack   = "slack"
+	NotifierTeams   = "teams"
+)
+
+var ErrInvalidNotifierType = errors.New("type must be smtp, webhook, slack or teams")
+var ErrInvalidNotifierURL = errors.New("url must be an http or https URL")
+var ErrInvalidSMTP = errors.New("smtp notifiers need a host, a from address and at least one recipient")
+var ErrInvalidEvents = errors.New("events must be issued, renewed, failed, expiring, revoked or deployment")
+
+// Notifier is a destination for certificate events.
+type Notifier struct {
+	ID   string
+	Name string
+	Type string
+
+	// URL is used by webhook, slack and teams notifiers.
+	URL string
+
+	// SMTP settings.
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	// Events limits which events are sent. Empty means all of them.
+	Events []string
+
+	// Labels routes only events for certs carrying all of these labels. An
+	// empty value only requires the key.
+	Labels map[string]string
+}
+
+// NewNotifier returns a Notifier with a fresh ID.
+func NewNotifier() *Notifier {
+	return &Notifier{ID: ksuid.New().String()}
+}
+
+// Validate checks the notifier has what its type needs.
+func (n *Notifier) Validate() error {
+	switch n.Type {
+	case NotifierSMTP:
+		if n.Host == "" || len(n.To) == 0 {
+			return ErrInvalidSMTP
+		}
+		if _, err := mail.ParseAddress(n.From); err != nil {
+			return ErrInvalidSMTP
+		}
+		for _, to := range n.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return ErrInvalidSMTP
+			}
+		}
+	case NotifierWebhook, NotifierSlack, NotifierTeams:
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidNotifierURL
+		}
+	default:
+		return ErrInvalidNotifierType
+	}
+
+	for _, e := range n.Events {
+		switch e {
+		case EventIssued, EventRenewed, EventFailed, EventExpiring, EventRevoked, EventDeployment:
+		default:
+			return ErrInvalidEvents
+		}
+	}
+
+	if !validSelector(n.Labels) {
+		return ErrInvalidLabels
+	}
+	return nil
+}
+
+// Wants reports whether e should be sent to this notifier. Test events always
+// are.
+func (n *Notifier) Wants(e *Event) bool {
+	if e.Type == EventTest {
+		return true
+	}
+
+	if len(n.Events) != 0 {
+		found := false
+		for _, t := range n.Events {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, v := range n.Labels {
+		got, ok := e.Labels[k]
+		if !ok || (v != "" && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Event describes something that happened to a certificate.
+type Event struct {
+	Type       string
+	Time       time.Time
+	CertID     string
+	CommonName string
+	Domains    []string
+	Labels     map[string]string
+	Expiry     time.Time
+	Version    int
+
+	// Message is a human readable summary, and Error holds the failure if
+	// there was one.
+	Message string
+	Error   string
+}
+
+// NewEvent builds an event of type t for c. err may be nil.
+func NewEvent(t string, c *Certificate, message string, err error) *Event {
+	e := &Event{
+		Type:       t,
+		Time:       time.Now(),
+		CertID:     c.ID,
+		CommonName: c.CommonName,
+		Domains:    c.Domains,
+		Labels:     c.Labels,
+		Expiry:     c.Expiry,
+		Version:    c.Version,
+		Message:    message,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
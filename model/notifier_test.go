@@ -0,0 +1,51 @@
+package model
+
+import "testing"
+
+func TestNotifierValidate(t *testing.T) {
+	valid := []*Notifier{
+		{Type: NotifierWebhook, URL: "https://hooks.example.com/tls"},
+		{Type: NotifierSlack, URL: "https://hooks.slack.com/services/x"},
+		{Type: NotifierSMTP, Host: "mail.example.com", From: "tls@example.com", To: []string{"ops@example.com"}, Events: []string{EventFailed}},
+	}
+	for _, n := range valid {
+		if err := n.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %s", n, err)
+		}
+	}
+
+	invalid := []*Notifier{
+		{Type: "pager"},
+		{Type: NotifierWebhook, URL: "ftp://example.com"},
+		{Type: NotifierTeams},
+		{Type: NotifierSMTP, Host: "mail.example.com", From: "tls@example.com"},
+		{Type: NotifierWebhook, URL: "https://example.com", Events: []string{"exploded"}},
+		{Type: NotifierWebhook, URL: "https://example.com", Labels: map[string]string{"bad key": ""}},
+	}
+	for _, n := range invalid {
+		if err := n.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", n)
+		}
+	}
+}
+
+func TestNotifierWants(t *testing.T) {
+	n := &Notifier{Events: []string{EventFailed, EventExpiring}, Labels: map[string]string{"env": "prod", "team": ""}}
+
+	prod := &Event{Type: EventFailed, Labels: map[string]string{"env": "prod", "team": "web"}}
+	if !n.Wants(prod) {
+		t.Error("should want a failure for a prod cert")
+	}
+	if n.Wants(&Event{Type: EventIssued, Labels: prod.Labels}) {
+		t.Error("shouldn't want events it didn't subscribe to")
+	}
+	if n.Wants(&Event{Type: EventFailed, Labels: map[string]string{"env": "dev", "team": "web"}}) {
+		t.Error("shouldn't want events for other label values")
+	}
+	if n.Wants(&Event{Type: EventFailed, Labels: map[string]string{"env": "prod"}}) {
+		t.Error("shouldn't want events missing a required label")
+	}
+	if !n.Wants(&Event{Type: EventTest}) {
+		t.Error("test events should always be wanted")
+	}
+}
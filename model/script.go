@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+// ErrInvalidScript is returned for a script template that can't be saved.
+var ErrInvalidScript = errors.New("script templates need an ID of 1-63 lowercase letters, digits or '-', a name and a body")
+
+var scriptIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ScriptTemplate is a deploy script served from /script/{id}, rendered for
+// whichever certificate's secret it's requested with.
+type ScriptTemplate struct {
+	// ID is the name used in the URL, such as nginx.
+	ID          string
+	Name        string
+	Description string
+	// Body is a Go text/template.
+	Body string
+
+	// Builtin templates ship with TLSential. They can be edited or deleted
+	// like any other.
+	Builtin bool
+	ModTime time.Time
+}
+
+// Validate checks the fields, but not that the body parses.
+func (s *ScriptTemplate) Validate() error {
+	if !scriptIDPattern.MatchString(s.ID) || s.Name == "" || s.Body == "" {
+		return ErrInvalidScript
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptTemplateValidate(t *testing.T) {
+	tests := []struct {
+		s     *ScriptTemplate
+		valid bool
+	}{
+		{&ScriptTemplate{ID: "nginx", Name: "nginx", Body: "#!/bin/sh"}, true},
+		{&ScriptTemplate{ID: "nginx-2", Name: "nginx", Body: "#!/bin/sh"}, true},
+		{&ScriptTemplate{ID: "", Name: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: "-nginx", Name: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: "Nginx", Name: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: "../etc", Name: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: strings.Repeat("a", 64), Name: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: "nginx", Body: "#!/bin/sh"}, false},
+		{&ScriptTemplate{ID: "nginx", Name: "nginx"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.s.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %t, got %v", tt.s.ID, tt.valid, err)
+		}
+	}
+}
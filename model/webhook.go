@@ -0,0 +1,149 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/segmentio/ksuid"
+)
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	WebhookEventHeader     = "X-TLSential-Event"
+	WebhookDeliveryHeader  = "X-TLSential-Delivery"
+	WebhookTimestampHeader = "X-TLSential-Timestamp"
+	WebhookSignatureHeader = "X-TLSential-Signature"
+)
+
+var ErrInvalidWebhookURL = errors.New("webhook url must be an http or https URL")
+var ErrInvalidWebhookEvents = errors.New("webhook events must be issued, renewed or revoked")
+
+// Webhook is a subscription to changes of a single certificate.
+type Webhook struct {
+	ID     string
+	CertID string
+	URL    string
+
+	// Secret is the HMAC key deliveries are signed with.
+	Secret string
+
+	// Events limits which events are sent. Empty means all of them.
+	Events []string
+
+	Created time.Time
+}
+
+// NewWebhook returns a subscription for certID with a fresh ID and secret.
+func NewWebhook(certID, rawurl string, events []string) (*Webhook, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	for _, e := range events {
+		switch e {
+		case EventIssued, EventRenewed, EventRevoked:
+		default:
+			return nil, ErrInvalidWebhookEvents
+		}
+	}
+	return &Webhook{
+		ID:      ksuid.New().String(),
+		CertID:  certID,
+		URL:     rawurl,
+		Secret:  auth.NewPassword(),
+		Events:  events,
+		Created: time.Now(),
+	}, nil
+}
+
+// Wants reports whether the subscription covers the event type.
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook returns the signature header value for a delivery body sent at
+// timestamp: "sha256=" followed by the hex HMAC-SHA256 of
+// "<unix timestamp>.<body>" keyed with the webhook secret. Receivers should
+// recompute it and compare in constant time.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookPayload is the JSON body of a webhook delivery.
+type WebhookPayload struct {
+	Event      string
+	ID         string
+	Version    int
+	CommonName string
+	Domains    []string
+	Serial     string
+	Expiry     time.Time
+
+	// URLs to fetch the new certificate from. The private key and bundle
+	// downloads need the certificate secret. They're left out if the server
+	// has no base URL.
+	CertURL    string `json:",omitempty"`
+	IssuerURL  string `json:",omitempty"`
+	PrivkeyURL string `json:",omitempty"`
+	BundleURL  string `json:",omitempty"`
+}
+
+// Delivery is one webhook event in the outbox, along with its delivery
+// history.
+type Delivery struct {
+	ID        string
+	WebhookID string
+	CertID    string
+	Event     string
+	Payload   []byte
+
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+
+	LastStatusCode int
+	LastError      string
+
+	Created  time.Time
+	Finished time.Time
+}
+
+// NewDelivery queues payload for w.
+func NewDelivery(w *Webhook, event string, payload []byte) *Delivery {
+	now := time.Now()
+	return &Delivery{
+		ID:          ksuid.New().String(),
+		WebhookID:   w.ID,
+		CertID:      w.CertID,
+		Event:       event,
+		Payload:     payload,
+		Status:      DeliveryPending,
+		NextAttempt: now,
+		Created:     now,
+	}
+}
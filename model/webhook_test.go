@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	ts := time.Unix(1500000000, 0)
+	body := []byte(`{"Event":"issued"}`)
+
+	// echo -n '1500000000.{"Event":"issued"}' | openssl dgst -sha256 -hmac secret
+	got := SignWebhook("secret", ts, body)
+	want := "sha256=774ab59f35f6502e30891d082203f42179964b4b8ff1b0046e34acba99992a8c"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got == SignWebhook("other", ts, body) || got == SignWebhook("secret", ts.Add(time.Second), body) {
+		t.Error("signature should depend on the secret and timestamp")
+	}
+}
+
+func TestNewWebhook(t *testing.T) {
+	if _, err := NewWebhook("id", "ftp://example.com", nil); err != ErrInvalidWebhookURL {
+		t.Errorf("expected ErrInvalidWebhookURL, got %v", err)
+	}
+	if _, err := NewWebhook("id", "https://example.com", []string{EventExpiring}); err != ErrInvalidWebhookEvents {
+		t.Errorf("expected ErrInvalidWebhookEvents, got %v", err)
+	}
+	w, err := NewWebhook("id", "https://example.com", []string{EventRenewed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Secret == "" || !w.Wants(EventRenewed) || w.Wants(EventIssued) {
+		t.Errorf("unexpected webhook %+v", w)
+	}
+}
@@ -0,0 +1,13 @@
+package notifier
+
+import (
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Repository provides an interface for persisting notifiers.
+type Repository interface {
+	AllNotifiers() ([]*model.Notifier, error)
+	Notifier(id string) (*model.Notifier, error)
+	SaveNotifier(n *model.Notifier) error
+	DeleteNotifier(id string) error
+}
@@ -0,0 +1,25 @@
+package notifier
+
+import (
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Service provides an interface for managing notifiers and sending events to
+// them.
+type Service interface {
+	AllNotifiers() ([]*model.Notifier, error)
+	Notifier(id string) (*model.Notifier, error)
+	SaveNotifier(n *model.Notifier) error
+	DeleteNotifier(id string) error
+
+	// Notify sends e to every notifier routed to receive it, in the
+	// background.
+	Notify(e *model.Event)
+
+	// Wait blocks until every notification sent so far has been delivered
+	// or has failed, for callers that exit once they're done.
+	Wait()
+
+	// Test sends a test event to the notifier and waits for the result.
+	Test(id string) error
+}
@@ -0,0 +1,80 @@
+// Package notify delivers certificate events to the channels configured as
+// notifiers: email over SMTP, generic JSON webhooks, and Slack or Teams style
+// incoming webhooks.
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Timeout bounds a single delivery.
+var Timeout = 10 * time.Second
+
+// Sender delivers events to one notifier.
+type Sender interface {
+	Send(e *model.Event) error
+}
+
+// New returns the Sender for n's type.
+func New(n *model.Notifier) (Sender, error) {
+	switch n.Type {
+	case model.NotifierSMTP:
+		return &smtpSender{n}, nil
+	case model.NotifierWebhook:
+		return &webhookSender{n}, nil
+	case model.NotifierSlack:
+		return &chatSender{n, slackPayload}, nil
+	case model.NotifierTeams:
+		return &chatSender{n, teamsPayload}, nil
+	}
+	return nil, model.ErrInvalidNotifierType
+}
+
+// Subject is a one line summary of e, used for email subjects and chat
+// titles.
+func Subject(e *model.Event) string {
+	name := e.CommonName
+	switch e.Type {
+	case model.EventIssued:
+		return fmt.Sprintf("[TLSential] Certificate issued for %s", name)
+	case model.EventRenewed:
+		return fmt.Sprintf("[TLSential] Certificate renewed for %s", name)
+	case model.EventFailed:
+		return fmt.Sprintf("[TLSential] Certificate issuance failed for %s", name)
+	case model.EventExpiring:
+		return fmt.Sprintf("[TLSential] Certificate for %s expires soon", name)
+	case model.EventRevoked:
+		return fmt.Sprintf("[TLSential] Certificate revoked for %s", name)
+	case model.EventDeployment:
+		return fmt.Sprintf("[TLSential] Deployment problem for %s", name)
+	case model.EventTest:
+		return "[TLSential] Test notification"
+	}
+	return fmt.Sprintf("[TLSential] %s: %s", e.Type, name)
+}
+
+// Text is the plain text body for e.
+func Text(e *model.Event) string {
+	var b strings.Builder
+	if e.Message != "" {
+		fmt.Fprintf(&b, "%s\n\n", e.Message)
+	}
+	if e.CertID != "" {
+		fmt.Fprintf(&b, "Certificate: %s (%s)\n", e.CommonName, e.CertID)
+		fmt.Fprintf(&b, "Domains: %s\n", strings.Join(e.Domains, ", "))
+	}
+	if !e.Expiry.IsZero() {
+		fmt.Fprintf(&b, "Expires: %s\n", e.Expiry.Format(time.RFC1123))
+	}
+	if len(e.Labels) != 0 {
+		fmt.Fprintf(&b, "Labels: %s\n", model.FormatLabels(e.Labels))
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", e.Error)
+	}
+	return b.String()
+}
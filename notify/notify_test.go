@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func testEvent() *model.Event {
+	c := &model.Certificate{
+		ID:         "cert1",
+		CommonName: "example.com",
+		Domains:    []string{"example.com", "www.example.com"},
+		Labels:     map[string]string{"env": "prod"},
+		Expiry:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	return model.NewEvent(model.EventFailed, c, "Renewing the certificate failed.", errors.New("acme: rate limited"))
+}
+
+func TestWebhook(t *testing.T) {
+	var got model.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type %s", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	s, err := New(&model.Notifier{Type: model.NotifierWebhook, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Send(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != model.EventFailed || got.CertID != "cert1" || got.Error != "acme: rate limited" {
+		t.Errorf("unexpected payload %+v", got)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s, _ := New(&model.Notifier{Type: model.NotifierWebhook, URL: srv.URL})
+	if err := s.Send(testEvent()); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+func TestChat(t *testing.T) {
+	for _, typ := range []string{model.NotifierSlack, model.NotifierTeams} {
+		t.Run(typ, func(t *testing.T) {
+			var got map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&got)
+			}))
+			defer srv.Close()
+
+			s, _ := New(&model.Notifier{Type: typ, URL: srv.URL})
+			err := s.Send(testEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got["text"], "example.com") || !strings.Contains(got["text"], "rate limited") {
+				t.Errorf("unexpected payload %v", got)
+			}
+			if typ == model.NotifierTeams && got["@type"] != "MessageCard" {
+				t.Errorf("teams payload should be a MessageCard, got %v", got)
+			}
+		})
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type mail struct {
+		from string
+		to   []string
+		data string
+	}
+	received := make(chan mail, 1)
+	go serveSMTP(t, ln, func(from string, to []string, data string) {
+		received <- mail{from, to, data}
+	})
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	s, _ := New(&model.Notifier{
+		Type: model.NotifierSMTP,
+		Host: host,
+		Port: p,
+		From: "tlsential@example.com",
+		To:   []string{"ops@example.com", "oncall@example.com"},
+	})
+
+	err = s.Send(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-received
+	if m.from != "tlsential@example.com" || len(m.to) != 2 {
+		t.Errorf("unexpected envelope %s -> %v", m.from, m.to)
+	}
+	if !strings.Contains(m.data, "Subject: [TLSential] Certificate issuance failed for example.com") {
+		t.Errorf("missing subject in:\n%s", m.data)
+	}
+	if !strings.Contains(m.data, "Error: acme: rate limited") {
+		t.Errorf("missing error in:\n%s", m.data)
+	}
+}
+
+// serveSMTP is a minimal SMTP server accepting a single message without
+// STARTTLS or auth.
+func serveSMTP(t *testing.T, ln net.Listener, deliver func(from string, to []string, data string)) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost test server")
+
+	var from string
+	var to []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := ioutil.ReadAll(bufio.NewReader(tp.DotReader()))
+			if err != nil {
+				return
+			}
+			deliver(from, to, string(data))
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
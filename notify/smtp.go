@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// DefaultSMTPPort is the submission port, used when a notifier doesn't set one.
+const DefaultSMTPPort = 587
+
+type smtpSender struct {
+	n *model.Notifier
+}
+
+// Send emails e to every recipient. STARTTLS is used whenever the server
+// offers it.
+func (s *smtpSender) Send(e *model.Event) error {
+	port := s.n.Port
+	if port == 0 {
+		port = DefaultSMTPPort
+	}
+	addr := net.JoinHostPort(s.n.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if s.n.Username != "" {
+		auth = smtp.PlainAuth("", s.n.Username, s.n.Password, s.n.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.n.From, s.n.To, s.message(e))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(Timeout):
+		return fmt.Errorf("smtp: timed out sending to %s", addr)
+	}
+}
+
+func (s *smtpSender) message(e *model.Event) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.n.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", Subject(e)))
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(Text(e), "\n", "\r\n", -1))
+	return b.Bytes()
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+type webhookSender struct {
+	n *model.Notifier
+}
+
+// Send posts e as JSON.
+func (s *webhookSender) Send(e *model.Event) error {
+	return postJSON(s.n.URL, e)
+}
+
+// chatSender posts to Slack or Teams style incoming webhooks, which only
+// differ in the shape of the payload.
+type chatSender struct {
+	n       *model.Notifier
+	payload func(e *model.Event) interface{}
+}
+
+func (s *chatSender) Send(e *model.Event) error {
+	return postJSON(s.n.URL, s.payload(e))
+}
+
+func slackPayload(e *model.Event) interface{} {
+	return map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", Subject(e), Text(e)),
+	}
+}
+
+func teamsPayload(e *model.Event) interface{} {
+	color := "2EB886"
+	switch e.Type {
+	case model.EventFailed, model.EventRevoked, model.EventDeployment:
+		color = "D50200"
+	case model.EventExpiring:
+		color = "F2C744"
+	}
+	return map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"summary":    Subject(e),
+		"themeColor": color,
+		"title":      Subject(e),
+		// Teams renders markdown, which needs blank lines between lines.
+		"text": strings.Replace(Text(e), "\n", "\n\n", -1),
+	}
+}
+
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s responded %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package probe connects to the TLS endpoints listed on a certificate to check
+// which certificate they are actually serving.
+package probe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// DefaultTimeout bounds each handshake.
+const DefaultTimeout = 5 * time.Second
+
+var errNoCertificate = errors.New("no certificate presented")
+
+// Leaf does a TLS handshake with the endpoint and returns the leaf certificate
+// it presented. The chain isn't verified, since we only want to know what is
+// deployed.
+func Leaf(e *model.Endpoint, timeout time.Duration) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", e.Address, &tls.Config{
+		ServerName:         e.SNI(),
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errNoCertificate
+	}
+	return certs[0], nil
+}
+
+// Check probes every endpoint of c concurrently and returns copies of the
+// endpoints with their new status. c is not modified.
+func Check(c *model.Certificate, timeout time.Duration) []*model.Endpoint {
+	results := make([]*model.Endpoint, len(c.Endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range c.Endpoints {
+		r := *e
+		results[i] = &r
+
+		wg.Add(1)
+		go func(r *model.Endpoint) {
+			defer wg.Done()
+			leaf, err := Leaf(r, timeout)
+			if err != nil {
+				r.Unreachable(err, time.Now())
+				return
+			}
+			r.Observe(c, leaf, time.Now())
+		}(&r)
+	}
+	wg.Wait()
+
+	return results
+}
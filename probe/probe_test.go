@@ -0,0 +1,101 @@
+package probe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+func TestCheck(t *testing.T) {
+	deployed, deployedPEM := testCert(t, 1)
+	_, newerPEM := testCert(t, 2)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{deployed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	// Grab a port that nothing is listening on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	c := &model.Certificate{
+		Certificate: deployedPEM,
+		Endpoints: []*model.Endpoint{
+			{Address: ln.Addr().String(), ServerName: "example.com"},
+			{Address: closedAddr},
+		},
+	}
+
+	results := Check(c, time.Second)
+	if results[0].Status != model.EndpointOK {
+		t.Errorf("expected ok, got %s (%s)", results[0].Status, results[0].Error)
+	}
+	if results[1].Status != model.EndpointUnreachable || results[1].Error == "" {
+		t.Errorf("expected unreachable with an error, got %+v", results[1])
+	}
+	if c.Endpoints[0].Status != "" {
+		t.Error("Check shouldn't modify the certificate")
+	}
+
+	c.UpdateEndpoints(results)
+	if c.DeploymentStatus() != model.EndpointUnreachable {
+		t.Errorf("expected unreachable deployment, got %s", c.DeploymentStatus())
+	}
+
+	// Results are judged against the cert they're applied to, in case it
+	// was reissued while probing. Once it is, the endpoint is stale.
+	results = Check(c, time.Second)
+	c.Certificate = newerPEM
+	c.Endpoints = c.Endpoints[:1]
+	c.UpdateEndpoints(results)
+	if c.DeploymentStatus() != model.EndpointStale {
+		t.Errorf("expected stale deployment, got %s", c.DeploymentStatus())
+	}
+}
+
+func testCert(t *testing.T, serial int64) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,121 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+var auditBucket = []byte("audit")
+
+var auditBuckets = []string{
+	string(auditBucket),
+}
+
+type auditRepository struct {
+	*bolt.DB
+}
+
+// NewAuditRepository returns a new repo object with the associated bolt.DB
+func NewAuditRepository(db *bolt.DB) (audit.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range auditBuckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(b))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	return &auditRepository{db}, err
+}
+
+// auditKey orders entries by time: the big endian UnixNano followed by the ID
+// to keep keys unique.
+func auditKey(e *model.AuditEntry) []byte {
+	k := make([]byte, 8, 8+len(e.ID))
+	binary.BigEndian.PutUint64(k, uint64(e.Time.UnixNano()))
+	return append(k, e.ID...)
+}
+
+func auditTimeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// Append adds an entry to the log. Existing entries are never overwritten.
+func (ar *auditRepository) Append(e *model.AuditEntry) error {
+	return ar.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		k := auditKey(e)
+		if b.Get(k) != nil {
+			return fmt.Errorf("audit entry '%s' already exists", e.ID)
+		}
+
+		buf, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, buf)
+	})
+}
+
+// Entries walks the log backwards from the newest entry, stopping at q.Since
+// or once q.Limit entries match.
+func (ar *auditRepository) Entries(q *model.AuditQuery) ([]*model.AuditEntry, error) {
+	var entries = make([]*model.AuditEntry, 0)
+	var since []byte
+	if !q.Since.IsZero() {
+		since = auditTimeKey(q.Since)
+	}
+
+	err := ar.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < q.Limit; k, v = c.Prev() {
+			if since != nil && bytes.Compare(k, since) < 0 {
+				break
+			}
+
+			e := &model.AuditEntry{}
+			err := json.Unmarshal(v, e)
+			if err != nil {
+				return err
+			}
+			if q.Matches(e) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Prune drops entries recorded before the cutoff.
+func (ar *auditRepository) Prune(before time.Time) error {
+	end := auditTimeKey(before)
+	return ar.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+
+		// Collect first, since deleting while iterating skips keys.
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, k := range keys {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
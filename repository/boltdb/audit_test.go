@@ -0,0 +1,70 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+func TestAuditLog(t *testing.T) {
+	db, err := bolt.Open(TestDBPath, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening test db: %s", err.Error())
+	}
+	defer db.Close()
+
+	ar, err := NewAuditRepository(db)
+	if err != nil {
+		t.Fatalf("Error on NewAuditRepository: %s", err.Error())
+	}
+	// Start from an empty log.
+	if err := ar.Prune(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	old := model.NewAuditEntry(model.ActorUser, "alice", "127.0.0.1", "DELETE /api/certificate/{id}", "a", 204)
+	old.Time = time.Now().Add(-48 * time.Hour)
+	denied := model.NewAuditEntry(model.ActorAnonymous, "", "127.0.0.1", "DELETE /api/certificate/{id}", "b", 401)
+	download := model.NewAuditEntry(model.ActorCertToken, "b", "127.0.0.1", "GET /api/certificate/{id}/privkey", "b", 200)
+	for _, e := range []*model.AuditEntry{old, denied, download} {
+		if err := ar.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ar.Append(download); err == nil {
+		t.Error("expected appending the same entry twice to fail")
+	}
+
+	all, err := ar.Entries(&model.AuditQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].ID != download.ID || all[2].ID != old.ID {
+		t.Fatalf("expected 3 entries newest first, got %d", len(all))
+	}
+	if denied.Outcome != model.OutcomeDenied {
+		t.Errorf("expected a 401 to be denied, got %s", denied.Outcome)
+	}
+
+	got, err := ar.Entries(&model.AuditQuery{Target: "b", Since: time.Now().Add(-time.Hour), Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != download.ID {
+		t.Errorf("expected only the newest entry for b, got %d", len(got))
+	}
+
+	if err := ar.Prune(time.Now().Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	all, err = ar.Entries(&model.AuditQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expected the old entry to be pruned, got %d entries", len(all))
+	}
+}
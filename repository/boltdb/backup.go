@@ -0,0 +1,119 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/backup"
+	"github.com/boltdb/bolt"
+)
+
+type backupRepository struct {
+	*bolt.DB
+}
+
+// NewBackupRepository returns a new repo object with the associated bolt.DB
+func NewBackupRepository(db *bolt.DB) backup.Repository {
+	return &backupRepository{db}
+}
+
+// Snapshot copies the file from inside a read transaction, which sees a
+// consistent view while writers carry on.
+func (br *backupRepository) Snapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := br.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// ValidateSnapshot checks that the file at path is an intact bolt database
+// this build can use, and returns its schema version. Older versions are
+// fine since they're migrated when opened.
+func ValidateSnapshot(path string) (int, error) {
+	err := checkSize(path)
+	if err != nil {
+		return 0, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("not a bolt database: %s", err)
+	}
+	defer db.Close()
+
+	var version int
+	err = db.View(func(tx *bolt.Tx) error {
+		problems := checkTx(tx)
+		if len(problems) > 0 {
+			return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+		}
+
+		if tx.Bucket(certBucket) == nil && tx.Bucket(metaBucket) == nil {
+			return fmt.Errorf("doesn't look like a TLSential database")
+		}
+		version = schemaVersion(tx)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("snapshot schema version %d is newer than this build supports (%d)", version, SchemaVersion)
+	}
+	return version, nil
+}
+
+// Layout of bolt's meta pages, of which there are two at the start of the
+// file: a 16 byte page header, then the meta fields.
+const (
+	boltMagic         = 0xED0CDAED
+	metaOffset        = 16
+	metaPageSizeField = 8
+	metaPgidField     = 40
+	metaSize          = 64
+)
+
+// checkSize makes sure the file holds every page its meta pages claim. bolt
+// doesn't check, and a truncated file crashes it when it reads past the end
+// of its memory map.
+func checkSize(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	readMeta := func(off int64) (pageSize, pgid uint64, ok bool) {
+		buf := make([]byte, metaSize)
+		if _, err := f.ReadAt(buf, off+metaOffset); err != nil {
+			return 0, 0, false
+		}
+		if binary.LittleEndian.Uint32(buf) != boltMagic {
+			return 0, 0, false
+		}
+		return uint64(binary.LittleEndian.Uint32(buf[metaPageSizeField:])), binary.LittleEndian.Uint64(buf[metaPgidField:]), true
+	}
+
+	pageSize, pgid, ok := readMeta(0)
+	if !ok || pageSize == 0 {
+		return fmt.Errorf("not a bolt database")
+	}
+	if _, pgid1, ok := readMeta(int64(pageSize)); ok && pgid1 > pgid {
+		pgid = pgid1
+	}
+	if uint64(fi.Size()) < pgid*pageSize {
+		return fmt.Errorf("file is truncated: %d bytes, expected at least %d", fi.Size(), pgid*pageSize)
+	}
+	return nil
+}
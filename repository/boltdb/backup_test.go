@@ -0,0 +1,91 @@
+package boltdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "live.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, _, err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	ur, err := NewUserRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ur.SaveUser(&model.User{Name: "alice", Role: "super_admin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := NewBackupRepository(db).Snapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("reported %d bytes, wrote %d", n, buf.Len())
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	ioutil.WriteFile(snapshot, buf.Bytes(), 0600)
+	version, err := ValidateSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("expected version %d, got %d", SchemaVersion, version)
+	}
+
+	// The snapshot is independent of later writes.
+	ur.DeleteAllUsers()
+	sdb, err := bolt.Open(snapshot, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdb.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(userBucket).Get([]byte("alice")) == nil {
+			t.Error("expected the snapshot to keep alice")
+		}
+		return nil
+	})
+	sdb.Close()
+
+	truncated := filepath.Join(dir, "truncated.db")
+	ioutil.WriteFile(truncated, buf.Bytes()[:buf.Len()/3], 0600)
+	if _, err := ValidateSnapshot(truncated); err == nil {
+		t.Error("expected a truncated snapshot to be rejected")
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	ioutil.WriteFile(garbage, bytes.Repeat([]byte("x"), 8192), 0600)
+	if _, err := ValidateSnapshot(garbage); err == nil {
+		t.Error("expected garbage to be rejected")
+	}
+
+	db.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, SchemaVersion+1)
+	})
+	buf.Reset()
+	NewBackupRepository(db).Snapshot(&buf)
+	ioutil.WriteFile(snapshot, buf.Bytes(), 0600)
+	if _, err := ValidateSnapshot(snapshot); err == nil {
+		t.Error("expected a snapshot from a newer build to be rejected")
+	}
+}
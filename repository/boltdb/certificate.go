@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/envelope"
 	"github.com/ImageWare/TLSential/model"
 	"github.com/boltdb/bolt"
 	"github.com/go-acme/lego/v3/registration"
@@ -20,12 +21,18 @@ var errCertificateNotFound = errors.New("Certificate not found")
 
 var certBucket = []byte("certs")
 
+// certIndexBucket holds a model.CertificateSummary per cert so listings can be
+// filtered and sorted without decoding every full record.
+var certIndexBucket = []byte("certs_index")
+
 var certBuckets = []string{
 	string(certBucket),
+	string(certIndexBucket),
 }
 
 type certRepository struct {
 	*bolt.DB
+	cipher envelope.Cipher
 }
 
 // Used as a middleman to encode in json for storage, purely for
@@ -34,8 +41,10 @@ type encodedCert struct {
 	ID     string
 	Secret string
 
-	Domains    []string
-	CommonName string
+	Domains        []string
+	CommonName     string
+	PendingDomains []string `json:",omitempty"`
+	Labels         map[string]string
 
 	CertURL       string
 	CertStableURL string
@@ -44,13 +53,23 @@ type encodedCert struct {
 	Certificate       []byte
 	IssuerCertificate []byte
 
-	Issued bool
+	Issued   bool
+	Version  int
+	Imported bool
+	Revoked  bool
 
 	Expiry  time.Time
 	RenewAt int
 
+	Endpoints     []*model.Endpoint
+	Installs      []*model.Install
+	InstallTokens []*model.InstallToken `json:",omitempty"`
+
 	LastError string
 
+	ValidateFirst bool
+	DryRun        *model.DryRun
+
 	ModTime time.Time
 
 	ACMEEmail        string
@@ -62,8 +81,120 @@ type encodedCert struct {
 	ACMEKey string
 }
 
-// NewCertificateRepository returns a new repo object with the associate bolt.DB
-func NewCertificateRepository(db *bolt.DB) (certificate.Repository, error) {
+// newEncodedCert converts a model.Certificate into its storable form.
+func newEncodedCert(c *model.Certificate) *encodedCert {
+	var lastError string
+	if c.LastError != nil {
+		lastError = c.LastError.Error()
+	}
+	return &encodedCert{
+		ID:                c.ID,
+		Secret:            c.Secret,
+		Domains:           c.Domains,
+		CommonName:        c.CommonName,
+		PendingDomains:    c.PendingDomains,
+		Labels:            c.Labels,
+		CertURL:           c.CertURL,
+		CertStableURL:     c.CertStableURL,
+		PrivateKey:        c.PrivateKey,
+		Certificate:       c.Certificate,
+		IssuerCertificate: c.IssuerCertificate,
+		Issued:            c.Issued,
+		Version:           c.Version,
+		Imported:          c.Imported,
+		Revoked:           c.Revoked,
+		Expiry:            c.Expiry,
+		RenewAt:           c.RenewAt,
+		Endpoints:         c.Endpoints,
+		Installs:          c.Installs,
+		InstallTokens:     c.InstallTokens,
+		LastError:         lastError,
+		ValidateFirst:     c.ValidateFirst,
+		DryRun:            c.DryRun,
+		ACMEEmail:         c.ACMEEmail,
+		ACMERegistration:  c.ACMERegistration,
+		ACMEKey:           encode(c.ACMEKey),
+		ModTime:           c.ModTime,
+	}
+}
+
+// certificate converts a stored encodedCert back into a model.Certificate.
+func (ec *encodedCert) certificate() *model.Certificate {
+	var lastError error
+	if ec.LastError != "" {
+		lastError = errors.New(ec.LastError)
+	}
+	return &model.Certificate{
+		ID:                ec.ID,
+		Secret:            ec.Secret,
+		Domains:           ec.Domains,
+		CommonName:        ec.CommonName,
+		PendingDomains:    ec.PendingDomains,
+		Labels:            ec.Labels,
+		CertURL:           ec.CertURL,
+		CertStableURL:     ec.CertStableURL,
+		PrivateKey:        ec.PrivateKey,
+		Certificate:       ec.Certificate,
+		IssuerCertificate: ec.IssuerCertificate,
+		Issued:            ec.Issued,
+		Version:           ec.Version,
+		Imported:          ec.Imported,
+		Revoked:           ec.Revoked,
+		Expiry:            ec.Expiry,
+		RenewAt:           ec.RenewAt,
+		Endpoints:         ec.Endpoints,
+		Installs:          ec.Installs,
+		InstallTokens:     ec.InstallTokens,
+		LastError:         lastError,
+		ValidateFirst:     ec.ValidateFirst,
+		DryRun:            ec.DryRun,
+		ACMEEmail:         ec.ACMEEmail,
+		ACMERegistration:  ec.ACMERegistration,
+		ACMEKey:           decode(ec.ACMEKey),
+		ModTime:           ec.ModTime,
+	}
+}
+
+// certAAD binds a sealed field to the cert it's stored in.
+func certAAD(id, field string) []byte {
+	return envelope.AAD("certificate", id, field)
+}
+
+// seal encrypts the fields that would let someone impersonate the cert's
+// sites or its ACME account.
+func (ec *encodedCert) seal(ci envelope.Cipher) error {
+	var err error
+	if ec.Secret, err = envelope.SealString(ci, ec.Secret, certAAD(ec.ID, "secret")); err != nil {
+		return err
+	}
+	if ec.PrivateKey, err = ci.Seal(ec.PrivateKey, certAAD(ec.ID, "private_key")); err != nil {
+		return err
+	}
+	ec.ACMEKey, err = envelope.SealString(ci, ec.ACMEKey, certAAD(ec.ID, "acme_key"))
+	return err
+}
+
+// open reverses seal.
+func (ec *encodedCert) open(ci envelope.Cipher) error {
+	var err error
+	if ec.Secret, err = envelope.OpenString(ci, ec.Secret, certAAD(ec.ID, "secret")); err != nil {
+		return err
+	}
+	if ec.PrivateKey, err = ci.Open(ec.PrivateKey, certAAD(ec.ID, "private_key")); err != nil {
+		return err
+	}
+	ec.ACMEKey, err = envelope.OpenString(ci, ec.ACMEKey, certAAD(ec.ID, "acme_key"))
+	return err
+}
+
+// current reports whether every sealed field is as seal would leave it.
+func (ec *encodedCert) current(ci envelope.Cipher) bool {
+	return ci.Current([]byte(ec.Secret)) && ci.Current(ec.PrivateKey) && ci.Current([]byte(ec.ACMEKey))
+}
+
+// NewCertificateRepository returns a new repo object with the associate
+// bolt.DB, storing secrets sealed by ci.
+func NewCertificateRepository(db *bolt.DB, ci envelope.Cipher) (certificate.Repository, error) {
 	err := db.Update(func(tx *bolt.Tx) error {
 		for _, b := range certBuckets {
 			_, err := tx.CreateBucketIfNotExists([]byte(b))
@@ -71,9 +202,45 @@ func NewCertificateRepository(db *bolt.DB) (certificate.Repository, error) {
 				return fmt.Errorf("create bucket: %s", err)
 			}
 		}
+		return reindexCerts(tx)
+	})
+	return &certRepository{db, ci}, err
+}
+
+// reindexCerts rebuilds the summary index if it's out of step with the certs
+// bucket, such as on the first start after upgrading.
+func reindexCerts(tx *bolt.Tx) error {
+	b := tx.Bucket(certBucket)
+	ib := tx.Bucket(certIndexBucket)
+	if b.Stats().KeyN == ib.Stats().KeyN {
 		return nil
+	}
+
+	err := tx.DeleteBucket(certIndexBucket)
+	if err != nil {
+		return err
+	}
+	ib, err = tx.CreateBucket(certIndexBucket)
+	if err != nil {
+		return err
+	}
+
+	return b.ForEach(func(k, v []byte) error {
+		ec := &encodedCert{}
+		err := json.Unmarshal(v, ec)
+		if err != nil {
+			return err
+		}
+		return putSummary(ib, ec.certificate())
 	})
-	return &certRepository{db}, err
+}
+
+func putSummary(ib *bolt.Bucket, c *model.Certificate) error {
+	buf, err := json.Marshal(c.Summary())
+	if err != nil {
+		return err
+	}
+	return ib.Put([]byte(c.ID), buf)
 }
 
 // AllCerts returns a list of all Cert objects stored in the
@@ -98,34 +265,84 @@ func (cr *certRepository) AllCerts() ([]*model.Certificate, error) {
 		return nil
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
 	var certs = make([]*model.Certificate, 0)
 	for _, ec := range ecerts {
-		var lastError error
-		if ec.LastError != "" {
-			lastError = errors.New(ec.LastError)
-		}
-		c := &model.Certificate{
-			ID:                ec.ID,
-			Secret:            ec.Secret,
-			Domains:           ec.Domains,
-			CommonName:        ec.CommonName,
-			CertURL:           ec.CertURL,
-			CertStableURL:     ec.CertStableURL,
-			PrivateKey:        ec.PrivateKey,
-			Certificate:       ec.Certificate,
-			IssuerCertificate: ec.IssuerCertificate,
-			Issued:            ec.Issued,
-			Expiry:            ec.Expiry,
-			RenewAt:           ec.RenewAt,
-			LastError:         lastError,
-			ACMEEmail:         ec.ACMEEmail,
-			ACMERegistration:  ec.ACMERegistration,
-			ACMEKey:           decode(ec.ACMEKey),
-			ModTime:           ec.ModTime,
+		err := ec.open(cr.cipher)
+		if err != nil {
+			return nil, fmt.Errorf("cert %s: %s", ec.ID, err)
 		}
-		certs = append(certs, c)
+		certs = append(certs, ec.certificate())
 	}
-	return certs, err
+	return certs, nil
+}
+
+// QueryCerts returns the page of certs matching q. Filtering and sorting run
+// over the summary index, and only the certs on the page are decoded.
+func (cr *certRepository) QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error) {
+	page := &model.CertificatePage{Certs: make([]*model.Certificate, 0)}
+	err := cr.DB.View(func(tx *bolt.Tx) error {
+		summaries, err := readSummaries(tx)
+		if err != nil {
+			return err
+		}
+
+		ids, next, total, err := q.Page(summaries, time.Now())
+		if err != nil {
+			return err
+		}
+		page.NextCursor = next
+		page.Total = total
+
+		b := tx.Bucket(certBucket)
+		for _, id := range ids {
+			v := b.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+			ec := &encodedCert{}
+			err := json.Unmarshal(v, ec)
+			if err != nil {
+				return err
+			}
+			err = ec.open(cr.cipher)
+			if err != nil {
+				return fmt.Errorf("cert %s: %s", ec.ID, err)
+			}
+			page.Certs = append(page.Certs, ec.certificate())
+		}
+		return nil
+	})
+	return page, err
+}
+
+// CertSummaries returns the summary of every cert from the index, without
+// decoding any full records.
+func (cr *certRepository) CertSummaries() ([]*model.CertificateSummary, error) {
+	var summaries []*model.CertificateSummary
+	err := cr.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		summaries, err = readSummaries(tx)
+		return err
+	})
+	return summaries, err
+}
+
+func readSummaries(tx *bolt.Tx) ([]*model.CertificateSummary, error) {
+	var summaries []*model.CertificateSummary
+	err := tx.Bucket(certIndexBucket).ForEach(func(k, v []byte) error {
+		s := &model.CertificateSummary{}
+		err := json.Unmarshal(v, s)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, s)
+		return nil
+	})
+	return summaries, err
 }
 
 // Cert takes an id and returns their whole cert object.
@@ -145,97 +362,270 @@ func (cr *certRepository) Cert(id string) (*model.Certificate, error) {
 	if err == errCertificateNotFound {
 		return nil, nil
 	}
-
-	var lastError error
-	if ec.LastError != "" {
-		lastError = errors.New(ec.LastError)
+	if err != nil {
+		return nil, err
 	}
-	c := &model.Certificate{
-		ID:                ec.ID,
-		Secret:            ec.Secret,
-		Domains:           ec.Domains,
-		CommonName:        ec.CommonName,
-		CertURL:           ec.CertURL,
-		CertStableURL:     ec.CertStableURL,
-		PrivateKey:        ec.PrivateKey,
-		Certificate:       ec.Certificate,
-		IssuerCertificate: ec.IssuerCertificate,
-		Issued:            ec.Issued,
-		Expiry:            ec.Expiry,
-		RenewAt:           ec.RenewAt,
-		LastError:         lastError,
-		ACMEEmail:         ec.ACMEEmail,
-		ACMERegistration:  ec.ACMERegistration,
-		ACMEKey:           decode(ec.ACMEKey),
-		ModTime:           ec.ModTime,
+
+	err = ec.open(cr.cipher)
+	if err != nil {
+		return nil, fmt.Errorf("cert %s: %s", id, err)
 	}
-	return c, err
+	return ec.certificate(), nil
 }
 
 // SaveCert persists a Cert in BoltStore.
 func (cr *certRepository) SaveCert(c *model.Certificate) error {
-	var lastError string
-	if c.LastError != nil {
-		lastError = c.LastError.Error()
-	}
 	c.ModTime = time.Now()
-	ec := &encodedCert{
-		ID:                c.ID,
-		Secret:            c.Secret,
-		Domains:           c.Domains,
-		CommonName:        c.CommonName,
-		CertURL:           c.CertURL,
-		CertStableURL:     c.CertStableURL,
-		PrivateKey:        c.PrivateKey,
-		Certificate:       c.Certificate,
-		IssuerCertificate: c.IssuerCertificate,
-		Issued:            c.Issued,
-		Expiry:            c.Expiry,
-		RenewAt:           c.RenewAt,
-		LastError:         lastError,
-		ACMEEmail:         c.ACMEEmail,
-		ACMERegistration:  c.ACMERegistration,
-		ACMEKey:           encode(c.ACMEKey),
-		ModTime:           c.ModTime,
+	ec := newEncodedCert(c)
+	err := ec.seal(cr.cipher)
+	if err != nil {
+		return err
 	}
-	err := cr.DB.Update(func(tx *bolt.Tx) error {
+	err = cr.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(certBucket)
 		buf, err := json.Marshal(ec)
-		b.Put([]byte(ec.ID), buf)
-		return err
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(ec.ID), buf)
+		if err != nil {
+			return err
+		}
+		return putSummary(tx.Bucket(certIndexBucket), c)
 	})
 	return err
 }
 
+// errStale is returned by an update's set func to leave the cert alone.
+var errStale = errors.New("stale")
+
+// update rewrites the stored form of c with set, in one transaction so
+// fields set doesn't touch keep whatever was saved last. The secrets stay
+// sealed unless set replaces them. With touch, ModTime and the summary are
+// updated as for any change to the cert itself. Nothing is written if the
+// cert has been deleted.
+func (cr *certRepository) update(c *model.Certificate, touch bool, set func(ec *encodedCert) error) error {
+	return cr.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certBucket)
+		v := b.Get([]byte(c.ID))
+		if v == nil {
+			return nil
+		}
+		ec := &encodedCert{}
+		err := json.Unmarshal(v, ec)
+		if err != nil {
+			return err
+		}
+		err = set(ec)
+		if err == errStale {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if touch {
+			c.ModTime = time.Now()
+			ec.ModTime = c.ModTime
+		}
+		buf, err := json.Marshal(ec)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(c.ID), buf)
+		if err != nil || !touch {
+			return err
+		}
+		return putSummary(tx.Bucket(certIndexBucket), ec.certificate())
+	})
+}
+
+// SaveEndpoints persists just c's endpoints and their probe results. ModTime
+// is left alone since the cert itself hasn't changed, and nothing is written
+// if the cert has been deleted.
+func (cr *certRepository) SaveEndpoints(c *model.Certificate) error {
+	return cr.update(c, false, func(ec *encodedCert) error {
+		ec.Endpoints = c.Endpoints
+		return nil
+	})
+}
+
+// SaveDryRun persists just c's dry run outcome. Like probe results, it
+// leaves ModTime alone.
+func (cr *certRepository) SaveDryRun(c *model.Certificate) error {
+	return cr.update(c, false, func(ec *encodedCert) error {
+		ec.DryRun = c.DryRun
+		return nil
+	})
+}
+
+// SaveSecret replaces old with c.Secret, failing with
+// certificate.ErrSecretUsed if old has already been replaced.
+func (cr *certRepository) SaveSecret(c *model.Certificate, old string) error {
+	return cr.update(c, false, func(ec *encodedCert) error {
+		current, err := envelope.OpenString(cr.cipher, ec.Secret, certAAD(ec.ID, "secret"))
+		if err != nil {
+			return err
+		}
+		if current != old {
+			return certificate.ErrSecretUsed
+		}
+		ec.Secret, err = envelope.SealString(cr.cipher, c.Secret, certAAD(ec.ID, "secret"))
+		return err
+	})
+}
+
+// SaveInstall records i against the stored installs and copies the result to
+// c.
+func (cr *certRepository) SaveInstall(c *model.Certificate, i *model.Install) error {
+	return cr.update(c, false, func(ec *encodedCert) error {
+		stored := &model.Certificate{Installs: ec.Installs}
+		stored.RecordInstall(i)
+		ec.Installs, c.Installs = stored.Installs, stored.Installs
+		return nil
+	})
+}
+
+// SaveInstallTokens persists just c's install tokens. Like install reports,
+// they leave ModTime alone.
+func (cr *certRepository) SaveInstallTokens(c *model.Certificate) error {
+	return cr.update(c, false, func(ec *encodedCert) error {
+		ec.InstallTokens = c.InstallTokens
+		return nil
+	})
+}
+
+// SaveSettings persists the fields users edit: the requested domains, ACME
+// email, RenewAt, labels and endpoints. The requested domains are resolved
+// against the stored ones, in case an issuance finished in the meantime.
+func (cr *certRepository) SaveSettings(c *model.Certificate) error {
+	return cr.update(c, true, func(ec *encodedCert) error {
+		ec.Domains, ec.CommonName, ec.PendingDomains = model.ResolveDomains(ec.Domains, ec.CommonName, c.RequestedDomains())
+		ec.ACMEEmail = c.ACMEEmail
+		// An email change updates the account. Certs without one get it
+		// from SaveIssuance.
+		if c.ACMERegistration != nil {
+			ec.ACMERegistration = c.ACMERegistration
+		}
+		ec.RenewAt = c.RenewAt
+		ec.Labels = c.Labels
+		ec.Endpoints = c.Endpoints
+		return nil
+	})
+}
+
+// SaveIssuance persists the fields the ACME workers own: the certificate and
+// its key, its state and the ACME account. A new version also replaces the
+// domains with the ones it was issued for, clearing the pending domains only
+// if they're the same, so a change requested during issuance survives.
+// Nothing is written if a newer version has been saved since c was loaded.
+func (cr *certRepository) SaveIssuance(c *model.Certificate) error {
+	fresh := newEncodedCert(c)
+	err := fresh.seal(cr.cipher)
+	if err != nil {
+		return err
+	}
+	return cr.update(c, true, func(ec *encodedCert) error {
+		if c.Version < ec.Version {
+			return errStale
+		}
+		if c.Version > ec.Version {
+			ec.Domains, ec.CommonName = c.Domains, c.CommonName
+			if model.SameDomains(ec.PendingDomains, c.Domains) {
+				ec.PendingDomains = nil
+			}
+		}
+		ec.CertURL, ec.CertStableURL = fresh.CertURL, fresh.CertStableURL
+		ec.PrivateKey, ec.Certificate, ec.IssuerCertificate = fresh.PrivateKey, fresh.Certificate, fresh.IssuerCertificate
+		ec.Issued, ec.Version, ec.Revoked, ec.Expiry = fresh.Issued, fresh.Version, fresh.Revoked, fresh.Expiry
+		ec.LastError = fresh.LastError
+		ec.ACMERegistration, ec.ACMEKey = fresh.ACMERegistration, fresh.ACMEKey
+		return nil
+	})
+}
+
 // DeleteCert removes any saved Cert object matching the id
 func (cr *certRepository) DeleteCert(id string) error {
 	err := cr.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(certBucket)
 		b.Delete([]byte(id))
+		tx.Bucket(certIndexBucket).Delete([]byte(id))
 		return nil
 	})
 	return err
 }
 
-// DeleteAllCerts deletes the Bolt bucket holding certs and recreates
-// it, essentially deleting all objects.
+// DeleteAllCerts deletes the Bolt buckets holding certs and recreates
+// them, essentially deleting all objects.
 func (cr *certRepository) DeleteAllCerts() error {
 	err := cr.DB.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(certBucket)
-		if err != nil {
-			return err
+		for _, b := range certBuckets {
+			err := tx.DeleteBucket([]byte(b))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(b))
+			if err != nil {
+				return err
+			}
 		}
-		_, err = tx.CreateBucket(certBucket)
+
+		return nil
+	})
+	return err
+}
+
+// Reseal rewrites every cert whose secrets aren't sealed with the current key.
+// ModTime is left alone since nothing about the cert changed.
+func (cr *certRepository) Reseal() (int, error) {
+	n := 0
+	err := cr.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certBucket)
+
+		// Collect first, since writing while iterating isn't safe.
+		var stale []*encodedCert
+		err := b.ForEach(func(k, v []byte) error {
+			ec := &encodedCert{}
+			err := json.Unmarshal(v, ec)
+			if err != nil {
+				return err
+			}
+			if !ec.current(cr.cipher) {
+				stale = append(stale, ec)
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 
+		for _, ec := range stale {
+			err := ec.open(cr.cipher)
+			if err == nil {
+				err = ec.seal(cr.cipher)
+			}
+			if err != nil {
+				return fmt.Errorf("cert %s: %s", ec.ID, err)
+			}
+			buf, err := json.Marshal(ec)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(ec.ID), buf)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(stale)
 		return nil
 	})
-	return err
+	return n, err
 }
 
 func encode(privateKey *ecdsa.PrivateKey) string {
+	// Imported certs have no ACME account key.
+	if privateKey == nil {
+		return ""
+	}
 	x509Encoded, _ := x509.MarshalECPrivateKey(privateKey)
 	pemEncoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded})
 
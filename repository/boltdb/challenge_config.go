@@ -4,28 +4,32 @@ import (
 	"fmt"
 
 	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/envelope"
 	"github.com/boltdb/bolt"
 )
 
 const (
 	challengeConfigBucket = "challenge_config"
 
-	authEmailKey   = "authemail"
-	authKeyKey     = "authkey"
-	leUserEmailKey = "leemail"
-	leUserKeyKey   = "lekey"
+	authEmailKey = "authemail"
+	authKeyKey   = "authkey"
 )
 
+// authKeyAAD binds the sealed auth key to its field.
+var authKeyAAD = envelope.AAD("challenge_config", "", authKeyKey)
+
 var challengeConfigBuckets = []string{
 	challengeConfigBucket,
 }
 
 type challengeConfigRepository struct {
 	*bolt.DB
+	cipher envelope.Cipher
 }
 
-// NewChallengeConfigRepository provides a new challenge_config.Repository powered by BoltDB.
-func NewChallengeConfigRepository(db *bolt.DB) (challenge_config.Repository, error) {
+// NewChallengeConfigRepository provides a new challenge_config.Repository
+// powered by BoltDB. The DNS provider key is sealed by ci.
+func NewChallengeConfigRepository(db *bolt.DB, ci envelope.Cipher) (challenge_config.Repository, error) {
 	err := db.Update(func(tx *bolt.Tx) error {
 		for _, b := range challengeConfigBuckets {
 			_, err := tx.CreateBucketIfNotExists([]byte(b))
@@ -35,7 +39,7 @@ func NewChallengeConfigRepository(db *bolt.DB) (challenge_config.Repository, err
 		}
 		return nil
 	})
-	return &challengeConfigRepository{db}, err
+	return &challengeConfigRepository{db, ci}, err
 }
 
 // AuthEmail returns the currently stored AuthEmail from boltdb.
@@ -75,15 +79,45 @@ func (r *challengeConfigRepository) AuthKey() (string, error) {
 		authkey = string(v)
 		return nil
 	})
-	return authkey, err
+	if err != nil {
+		return "", err
+	}
+	return envelope.OpenString(r.cipher, authkey, authKeyAAD)
 }
 
 // SetAuthKey stores the given key in boltdb.
 func (r *challengeConfigRepository) SetAuthKey(authkey string) error {
-	err := r.DB.Update(func(tx *bolt.Tx) error {
+	sealed, err := r.cipher.Seal([]byte(authkey), authKeyAAD)
+	if err != nil {
+		return err
+	}
+	err = r.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(challengeConfigBucket))
-		b.Put([]byte(authKeyKey), []byte(authkey))
+		b.Put([]byte(authKeyKey), sealed)
 		return nil
 	})
 	return err
 }
+
+// Reseal rewrites the auth key if it isn't sealed with the current key.
+func (r *challengeConfigRepository) Reseal() (int, error) {
+	n := 0
+	err := r.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(challengeConfigBucket))
+		v := b.Get([]byte(authKeyKey))
+		if r.cipher.Current(v) {
+			return nil
+		}
+		plaintext, err := r.cipher.Open(v, authKeyAAD)
+		if err != nil {
+			return err
+		}
+		sealed, err := r.cipher.Seal(plaintext, authKeyAAD)
+		if err != nil {
+			return err
+		}
+		n = 1
+		return b.Put([]byte(authKeyKey), sealed)
+	})
+	return n, err
+}
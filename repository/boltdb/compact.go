@@ -0,0 +1,84 @@
+package boltdb
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Compact rewrites the bolt file at path without its free pages, returning
+// the file size before and after. Bolt never shrinks its file on its own, so
+// this is the only way to get space back after large deletes. It needs the
+// file to itself, so the server has to be stopped.
+func Compact(path string) (int64, int64, error) {
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before := fi.Size()
+
+	// Write next to the original then rename, so the swap is atomic.
+	tmp := path + ".compacting"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, fi.Mode(), nil)
+	if err != nil {
+		return before, 0, err
+	}
+	err = src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, nb)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return before, 0, err
+	}
+
+	fi, err = os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return before, 0, err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return before, 0, err
+	}
+	return before, fi.Size(), nil
+}
+
+// copyBucket copies every key and nested bucket in src to dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	// Keys are copied in order, so pages can be filled completely.
+	dst.FillPercent = 1.0
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nb, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nb)
+	})
+}
@@ -0,0 +1,92 @@
+package boltdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tlsential.db")
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	// Fill a bucket then empty most of it, leaving free pages behind.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(certBucket)
+		if err != nil {
+			return err
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		if err := nested.Put([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("key%04d", i)), make([]byte, 512)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certBucket)
+		for i := 1; i < 2000; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("key%04d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	before, after, err := Compact(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Errorf("expected the file to shrink, went from %d to %d bytes", before, after)
+	}
+
+	version, err := ValidateSnapshot(path)
+	if err != nil || version != SchemaVersion {
+		t.Fatalf("compacted file: version %d, %v", version, err)
+	}
+	db, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certBucket)
+		if b.Get([]byte("key0000")) == nil || b.Get([]byte("key0001")) != nil {
+			t.Error("expected only key0000 to survive")
+		}
+		if b.Bucket([]byte("nested")).Get([]byte("k")) == nil {
+			t.Error("expected the nested bucket to be copied")
+		}
+		return nil
+	})
+}
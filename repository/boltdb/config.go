@@ -5,6 +5,7 @@ import (
 
 	"github.com/ImageWare/TLSential/auth"
 	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/envelope"
 	"github.com/boltdb/bolt"
 )
 
@@ -20,12 +21,18 @@ var configBuckets = []string{
 	configBucket,
 }
 
+// sealedConfigKeys are the config values sealed at rest, since either one
+// lets its holder sign in as anyone.
+var sealedConfigKeys = []string{jwtSecretKey, sessionKeyKey}
+
 type configRepository struct {
 	*bolt.DB
+	cipher envelope.Cipher
 }
 
-// NewConfigRepository provides a new ConfigRepo powered by BoltDB.
-func NewConfigRepository(db *bolt.DB) (config.Repository, error) {
+// NewConfigRepository provides a new ConfigRepo powered by BoltDB. The JWT
+// secret and session key are sealed by ci.
+func NewConfigRepository(db *bolt.DB, ci envelope.Cipher) (config.Repository, error) {
 	err := db.Update(func(tx *bolt.Tx) error {
 		for _, b := range configBuckets {
 			_, err := tx.CreateBucketIfNotExists([]byte(b))
@@ -35,30 +42,45 @@ func NewConfigRepository(db *bolt.DB) (config.Repository, error) {
 		}
 		return nil
 	})
-	return &configRepository{db}, err
+	return &configRepository{db, ci}, err
 }
 
-// JWTSecret returns the currently stored JWT signing secret from boltdb.
-func (r *configRepository) JWTSecret() (*auth.JWTSecret, error) {
-	var secret auth.JWTSecret
+// get opens the sealed value stored under key.
+func (r *configRepository) get(key string) ([]byte, error) {
+	var v []byte
 	err := r.DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(configBucket))
-		v := b.Get([]byte(jwtSecretKey))
+		buf, err := r.cipher.Open(b.Get([]byte(key)), envelope.AAD("config", "", key))
+		// Plaintext comes back as is, and is only valid in the transaction.
+		v = append([]byte(nil), buf...)
+		return err
+	})
+	return v, err
+}
 
-		secret.SetSecret(v)
-		return nil
+// put seals value and stores it under key.
+func (r *configRepository) put(key string, value []byte) error {
+	sealed, err := r.cipher.Seal(value, envelope.AAD("config", "", key))
+	if err != nil {
+		return err
+	}
+	return r.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(configBucket))
+		return b.Put([]byte(key), sealed)
 	})
+}
+
+// JWTSecret returns the currently stored JWT signing secret from boltdb.
+func (r *configRepository) JWTSecret() (*auth.JWTSecret, error) {
+	var secret auth.JWTSecret
+	v, err := r.get(jwtSecretKey)
+	secret.SetSecret(v)
 	return &secret, err
 }
 
 // SetJWTSecret stores the given secret in boltdb.
 func (r *configRepository) SetJWTSecret(secret []byte) error {
-	err := r.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(configBucket))
-		b.Put([]byte(jwtSecretKey), secret)
-		return nil
-	})
-	return err
+	return r.put(jwtSecretKey, secret)
 }
 
 // SuperAdmin returns the currently stored super admin username from boltdb.
@@ -86,28 +108,43 @@ func (r *configRepository) SetSuperAdmin(name string) error {
 	return err
 }
 
-// JWTSecret returns the currently stored JWT signing secret from boltdb.
+// SessionKey returns the currently stored session key from boltdb.
 func (r *configRepository) SessionKey() ([]byte, error) {
-	var key []byte
-	err := r.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(configBucket))
-		v := b.Get([]byte(sessionKeyKey))
-
-		buf := make([]byte, len(v))
-		copy(buf, v)
-		key = buf
-
-		return nil
-	})
-	return key, err
+	return r.get(sessionKeyKey)
 }
 
 // SetSessionKey stores the given secret in boltdb.
 func (r *configRepository) SetSessionKey(key []byte) error {
+	return r.put(sessionKeyKey, key)
+}
+
+// Reseal rewrites the JWT secret and session key if they aren't sealed with
+// the current key.
+func (r *configRepository) Reseal() (int, error) {
+	n := 0
 	err := r.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(configBucket))
-		b.Put([]byte(sessionKeyKey), key)
+		for _, key := range sealedConfigKeys {
+			v := b.Get([]byte(key))
+			if r.cipher.Current(v) {
+				continue
+			}
+			aad := envelope.AAD("config", "", key)
+			plaintext, err := r.cipher.Open(v, aad)
+			if err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			sealed, err := r.cipher.Seal(plaintext, aad)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(key), sealed)
+			if err != nil {
+				return err
+			}
+			n++
+		}
 		return nil
 	})
-	return err
+	return n, err
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/envelope"
 	"github.com/boltdb/bolt"
 )
 
@@ -17,7 +18,7 @@ func TestConfigRepository(t *testing.T) {
 
 	var r config.Repository
 	t.Run("NewConfigRespository", func(t *testing.T) {
-		r, err = NewConfigRepository(db)
+		r, err = NewConfigRepository(db, envelope.None)
 		if err != nil {
 			t.Fatalf("Error on NewConfigRepository: %s", err.Error())
 		}
@@ -0,0 +1,115 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/repository/repotest"
+	"github.com/ImageWare/TLSential/webhook"
+	"github.com/boltdb/bolt"
+)
+
+// TestConformance runs the shared repository suite against a fresh bolt file,
+// so it doesn't disturb the data the other tests leave in TestDBPath.
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "conformance.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening test db: %s", err.Error())
+	}
+	defer db.Close()
+
+	ur, err := NewUserRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := NewConfigRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chr, err := NewChallengeConfigRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctr, err := NewCertificateRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, err := NewNotifierRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr, err := NewWebhookRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := NewAuditRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewScriptRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := NewDeployRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Users", func(t *testing.T) { repotest.Users(t, ur) })
+	t.Run("Config", func(t *testing.T) { repotest.Config(t, cr) })
+	t.Run("ChallengeConfig", func(t *testing.T) { repotest.ChallengeConfig(t, chr) })
+	t.Run("Certificates", func(t *testing.T) { repotest.Certificates(t, ctr) })
+	t.Run("Notifiers", func(t *testing.T) { repotest.Notifiers(t, nr) })
+	t.Run("Webhooks", func(t *testing.T) { repotest.Webhooks(t, wr) })
+	t.Run("Audit", func(t *testing.T) { repotest.Audit(t, ar) })
+	t.Run("Scripts", func(t *testing.T) { repotest.Scripts(t, sr) })
+	t.Run("DeployTargets", func(t *testing.T) { repotest.DeployTargets(t, dr) })
+	t.Run("Encryption", func(t *testing.T) {
+		repotest.Encryption(t, func(ci envelope.Cipher) (certificate.Repository, challenge_config.Repository) {
+			ctr, err := NewCertificateRepository(db, ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chr, err := NewChallengeConfigRepository(db, ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ctr, chr
+		})
+	})
+	t.Run("SecretEncryption", func(t *testing.T) {
+		repotest.SecretEncryption(t, func(ci envelope.Cipher) (notifier.Repository, webhook.Repository) {
+			nr, err := NewNotifierRepository(db, ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wr, err := NewWebhookRepository(db, ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return nr, wr
+		})
+	})
+	t.Run("ConfigEncryption", func(t *testing.T) {
+		repotest.ConfigEncryption(t, func(ci envelope.Cipher) config.Repository {
+			r, err := NewConfigRepository(db, ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+var deployTargetBucket = []byte("deploy_targets")
+
+type deployRepository struct {
+	*bolt.DB
+}
+
+// NewDeployRepository returns a new repo object with the associated bolt.DB
+func NewDeployRepository(db *bolt.DB) (deploy.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deployTargetBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return nil
+	})
+	return &deployRepository{db}, err
+}
+
+// Targets returns every deploy target for the cert.
+func (dr *deployRepository) Targets(certID string) ([]*model.DeployTarget, error) {
+	return dr.targets(func(t *model.DeployTarget) bool {
+		return t.CertID == certID
+	})
+}
+
+// Target returns the deploy target with the given id, or nil.
+func (dr *deployRepository) Target(id string) (*model.DeployTarget, error) {
+	var t *model.DeployTarget
+	err := dr.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(deployTargetBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		t = &model.DeployTarget{}
+		return json.Unmarshal(v, t)
+	})
+	return t, err
+}
+
+// SaveTarget persists a deploy target in BoltStore.
+func (dr *deployRepository) SaveTarget(t *model.DeployTarget) error {
+	return dr.DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(deployTargetBucket).Put([]byte(t.ID), buf)
+	})
+}
+
+// DeleteTarget removes a deploy target.
+func (dr *deployRepository) DeleteTarget(id string) error {
+	return dr.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deployTargetBucket).Delete([]byte(id))
+	})
+}
+
+// DueTargets returns pending targets due at or before now.
+func (dr *deployRepository) DueTargets(now time.Time) ([]*model.DeployTarget, error) {
+	return dr.targets(func(t *model.DeployTarget) bool {
+		return t.Status == model.DeployPending && !t.NextAttempt.After(now)
+	})
+}
+
+// DeployedTargets returns targets whose last push succeeded.
+func (dr *deployRepository) DeployedTargets() ([]*model.DeployTarget, error) {
+	return dr.targets(func(t *model.DeployTarget) bool {
+		return t.Status == model.DeployDeployed
+	})
+}
+
+func (dr *deployRepository) targets(match func(t *model.DeployTarget) bool) ([]*model.DeployTarget, error) {
+	var targets = make([]*model.DeployTarget, 0)
+	err := dr.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deployTargetBucket).ForEach(func(k, v []byte) error {
+			t := &model.DeployTarget{}
+			err := json.Unmarshal(v, t)
+			if err != nil {
+				return err
+			}
+			if match(t) {
+				targets = append(targets, t)
+			}
+			return nil
+		})
+	})
+	return targets, err
+}
@@ -0,0 +1,56 @@
+package boltdb
+
+import (
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+type healthRepository struct {
+	*bolt.DB
+}
+
+// NewHealthRepository returns a new repo object with the associated bolt.DB
+func NewHealthRepository(db *bolt.DB) health.Repository {
+	return &healthRepository{db}
+}
+
+// Ping opens a read transaction, which fails if the database was closed.
+func (hr *healthRepository) Ping() error {
+	return hr.DB.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Stats returns the file size, schema version and the key count of every top
+// level bucket.
+func (hr *healthRepository) Stats() (*model.DBStats, error) {
+	s := &model.DBStats{Path: hr.DB.Path(), Buckets: make(map[string]int)}
+	err := hr.DB.View(func(tx *bolt.Tx) error {
+		s.Size = tx.Size()
+		s.SchemaVersion = schemaVersion(tx)
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			s.Buckets[string(name)] = b.Stats().KeyN
+			return nil
+		})
+	})
+	return s, err
+}
+
+// Check runs bolt's consistency check over every page.
+func (hr *healthRepository) Check() ([]string, error) {
+	var problems []string
+	err := hr.DB.View(func(tx *bolt.Tx) error {
+		problems = checkTx(tx)
+		return nil
+	})
+	return problems, err
+}
+
+func checkTx(tx *bolt.Tx) []string {
+	var problems []string
+	for err := range tx.Check() {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
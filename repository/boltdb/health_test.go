@@ -0,0 +1,43 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/boltdb/bolt"
+)
+
+func TestHealthStats(t *testing.T) {
+	db, err := bolt.Open(TestDBPath, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening test db: %s", err.Error())
+	}
+
+	if _, err := NewNotifierRepository(db, envelope.None); err != nil {
+		t.Fatal(err)
+	}
+	hr := NewHealthRepository(db)
+
+	if err := hr.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := hr.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size == 0 || s.Path != TestDBPath {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if _, ok := s.Buckets["notifiers"]; !ok {
+		t.Error("expected the notifiers bucket to be listed")
+	}
+	problems, err := hr.Check()
+	if err != nil || len(problems) > 0 {
+		t.Errorf("expected a clean check, got %v, %v", problems, err)
+	}
+
+	db.Close()
+	if err := hr.Ping(); err == nil {
+		t.Error("expected Ping to fail on a closed db")
+	}
+}
@@ -0,0 +1,120 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaBucket holds data about the database itself rather than app data.
+var metaBucket = []byte("meta")
+
+var schemaVersionKey = []byte("schema_version")
+
+// migration moves the database up one schema version. It runs in the same
+// transaction that records the new version, so it either fully applies or
+// not at all.
+type migration struct {
+	description string
+	up          func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order; the schema version is the number applied.
+// Never edit or remove one that has shipped, add a new one instead. Buckets
+// are still created by the repository constructors, so a migration may find
+// any of them missing.
+var migrations = []migration{
+	{"remove unused Let's Encrypt account settings from challenge_config", func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(challengeConfigBucket))
+		if b == nil {
+			return nil
+		}
+		for _, k := range []string{"leemail", "lekey"} {
+			err := b.Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// SchemaVersion is the schema version this build writes.
+var SchemaVersion = len(migrations)
+
+// Migrate brings the database up to SchemaVersion. Before changing a database
+// that already holds data it copies the file alongside the original, and
+// returns that copy's path. A database from a newer build is refused, since
+// this one can't know what it would break.
+func Migrate(db *bolt.DB) (applied int, backup string, err error) {
+	var current int
+	var empty bool
+	err = db.View(func(tx *bolt.Tx) error {
+		current = schemaVersion(tx)
+		k, _ := tx.Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	if current > SchemaVersion {
+		return 0, "", fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, SchemaVersion)
+	}
+	if current == SchemaVersion {
+		return 0, "", nil
+	}
+
+	if !empty {
+		backup = fmt.Sprintf("%s.v%d-%s.bak", db.Path(), current, time.Now().UTC().Format("20060102T150405Z"))
+		err = db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backup, 0600)
+		})
+		if err != nil {
+			return 0, "", fmt.Errorf("backup before migrating: %s", err)
+		}
+	}
+
+	for v := current; v < SchemaVersion; v++ {
+		m := migrations[v]
+		err = db.Update(func(tx *bolt.Tx) error {
+			err := m.up(tx)
+			if err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, v+1)
+		})
+		if err != nil {
+			return applied, backup, fmt.Errorf("migration %d (%s): %s", v+1, m.description, err)
+		}
+		applied++
+	}
+	return applied, backup, nil
+}
+
+// schemaVersion returns the recorded version, 0 for databases from before
+// versioning.
+func schemaVersion(tx *bolt.Tx) int {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0
+	}
+	v := b.Get(schemaVersionKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func setSchemaVersion(tx *bolt.Tx, v int) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return b.Put(schemaVersionKey, buf)
+}
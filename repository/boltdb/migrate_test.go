@@ -0,0 +1,116 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(name string) *bolt.DB {
+		db, err := bolt.Open(filepath.Join(dir, name), 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	version := func(db *bolt.DB) int {
+		var v int
+		db.View(func(tx *bolt.Tx) error {
+			v = schemaVersion(tx)
+			return nil
+		})
+		return v
+	}
+
+	t.Run("fresh", func(t *testing.T) {
+		db := open("fresh.db")
+		defer db.Close()
+		applied, backup, err := Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied != SchemaVersion || backup != "" {
+			t.Errorf("expected %d migrations and no backup, got %d, %q", SchemaVersion, applied, backup)
+		}
+		if version(db) != SchemaVersion {
+			t.Errorf("expected version %d, got %d", SchemaVersion, version(db))
+		}
+
+		applied, _, err = Migrate(db)
+		if err != nil || applied != 0 {
+			t.Errorf("expected nothing to do the second time, got %d, %v", applied, err)
+		}
+	})
+
+	t.Run("unversioned", func(t *testing.T) {
+		db := open("old.db")
+		defer db.Close()
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte(challengeConfigBucket))
+			if err != nil {
+				return err
+			}
+			b.Put([]byte(authEmailKey), []byte("dns@example.com"))
+			return b.Put([]byte("lekey"), []byte("stale"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applied, backup, err := Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied != SchemaVersion || backup == "" {
+			t.Fatalf("expected a backup and %d migrations, got %q, %d", SchemaVersion, backup, applied)
+		}
+
+		db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(challengeConfigBucket))
+			if b.Get([]byte("lekey")) != nil {
+				t.Error("expected the unused key to be removed")
+			}
+			if string(b.Get([]byte(authEmailKey))) != "dns@example.com" {
+				t.Error("expected other settings to be kept")
+			}
+			return nil
+		})
+
+		// The backup is the database as it was.
+		bdb, err := bolt.Open(backup, 0600, &bolt.Options{ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bdb.Close()
+		bdb.View(func(tx *bolt.Tx) error {
+			if string(tx.Bucket([]byte(challengeConfigBucket)).Get([]byte("lekey"))) != "stale" || schemaVersion(tx) != 0 {
+				t.Error("expected the backup to be untouched")
+			}
+			return nil
+		})
+	})
+
+	t.Run("newer", func(t *testing.T) {
+		db := open("newer.db")
+		defer db.Close()
+		db.Update(func(tx *bolt.Tx) error {
+			return setSchemaVersion(tx, SchemaVersion+1)
+		})
+		if _, _, err := Migrate(db); err == nil {
+			t.Error("expected a newer database to be refused")
+		}
+		if version(db) != SchemaVersion+1 {
+			t.Error("expected a newer database to be left alone")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/boltdb/bolt"
+)
+
+var notifierBucket = []byte("notifiers")
+
+var notifierBuckets = []string{
+	string(notifierBucket),
+}
+
+type notifierRepository struct {
+	*bolt.DB
+	cipher envelope.Cipher
+}
+
+// NewNotifierRepository returns a new repo object with the associated
+// bolt.DB, storing SMTP passwords sealed by ci.
+func NewNotifierRepository(db *bolt.DB, ci envelope.Cipher) (notifier.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range notifierBuckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(b))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	return &notifierRepository{db, ci}, err
+}
+
+// put stores n with its password sealed.
+func (nr *notifierRepository) put(b *bolt.Bucket, n *model.Notifier) error {
+	sealed := *n
+	var err error
+	sealed.Password, err = envelope.SealString(nr.cipher, n.Password, envelope.AAD("notifier", n.ID, "password"))
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(&sealed)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(n.ID), buf)
+}
+
+// decode reverses put.
+func (nr *notifierRepository) decode(v []byte) (*model.Notifier, error) {
+	n := &model.Notifier{}
+	err := json.Unmarshal(v, n)
+	if err != nil {
+		return nil, err
+	}
+	n.Password, err = envelope.OpenString(nr.cipher, n.Password, envelope.AAD("notifier", n.ID, "password"))
+	if err != nil {
+		return nil, fmt.Errorf("notifier %s: %s", n.ID, err)
+	}
+	return n, nil
+}
+
+// AllNotifiers returns every stored notifier.
+func (nr *notifierRepository) AllNotifiers() ([]*model.Notifier, error) {
+	var notifiers = make([]*model.Notifier, 0)
+	err := nr.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(notifierBucket)
+		return b.ForEach(func(k, v []byte) error {
+			n, err := nr.decode(v)
+			if err != nil {
+				return err
+			}
+			notifiers = append(notifiers, n)
+			return nil
+		})
+	})
+	return notifiers, err
+}
+
+// Notifier returns the notifier with the given id, or nil if there isn't one.
+func (nr *notifierRepository) Notifier(id string) (*model.Notifier, error) {
+	var n *model.Notifier
+	err := nr.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(notifierBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		var err error
+		n, err = nr.decode(v)
+		return err
+	})
+	return n, err
+}
+
+// SaveNotifier persists a notifier in BoltStore.
+func (nr *notifierRepository) SaveNotifier(n *model.Notifier) error {
+	return nr.DB.Update(func(tx *bolt.Tx) error {
+		return nr.put(tx.Bucket(notifierBucket), n)
+	})
+}
+
+// DeleteNotifier removes the notifier with the given id.
+func (nr *notifierRepository) DeleteNotifier(id string) error {
+	return nr.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(notifierBucket)
+		return b.Delete([]byte(id))
+	})
+}
+
+// Reseal rewrites every notifier whose password isn't sealed with the current
+// key.
+func (nr *notifierRepository) Reseal() (int, error) {
+	n := 0
+	err := nr.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(notifierBucket)
+
+		// Collect first, since writing while iterating isn't safe.
+		var stale []*model.Notifier
+		err := b.ForEach(func(k, v []byte) error {
+			no := &model.Notifier{}
+			err := json.Unmarshal(v, no)
+			if err != nil {
+				return err
+			}
+			if nr.cipher.Current([]byte(no.Password)) {
+				return nil
+			}
+			no, err = nr.decode(v)
+			if err != nil {
+				return err
+			}
+			stale = append(stale, no)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, no := range stale {
+			err := nr.put(b, no)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(stale)
+		return nil
+	})
+	return n, err
+}
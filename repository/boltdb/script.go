@@ -0,0 +1,84 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/script"
+	"github.com/boltdb/bolt"
+)
+
+var scriptBucket = []byte("scripts")
+
+var scriptBuckets = []string{
+	string(scriptBucket),
+}
+
+type scriptRepository struct {
+	*bolt.DB
+}
+
+// NewScriptRepository returns a new repo object with the associated bolt.DB
+func NewScriptRepository(db *bolt.DB) (script.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range scriptBuckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(b))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	return &scriptRepository{db}, err
+}
+
+// AllScripts returns every stored script template, ordered by ID.
+func (sr *scriptRepository) AllScripts() ([]*model.ScriptTemplate, error) {
+	var scripts = make([]*model.ScriptTemplate, 0)
+	err := sr.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scriptBucket).ForEach(func(k, v []byte) error {
+			s := &model.ScriptTemplate{}
+			err := json.Unmarshal(v, s)
+			if err != nil {
+				return err
+			}
+			scripts = append(scripts, s)
+			return nil
+		})
+	})
+	return scripts, err
+}
+
+// Script returns the script template with the given id, or nil if there
+// isn't one.
+func (sr *scriptRepository) Script(id string) (*model.ScriptTemplate, error) {
+	var s *model.ScriptTemplate
+	err := sr.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(scriptBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		s = &model.ScriptTemplate{}
+		return json.Unmarshal(v, s)
+	})
+	return s, err
+}
+
+// SaveScript persists a script template in BoltStore.
+func (sr *scriptRepository) SaveScript(s *model.ScriptTemplate) error {
+	return sr.DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(scriptBucket).Put([]byte(s.ID), buf)
+	})
+}
+
+// DeleteScript removes the script template with the given id.
+func (sr *scriptRepository) DeleteScript(id string) error {
+	return sr.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scriptBucket).Delete([]byte(id))
+	})
+}
@@ -0,0 +1,261 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/webhook"
+	"github.com/boltdb/bolt"
+)
+
+var webhookBucket = []byte("webhooks")
+
+// webhookOutboxBucket holds every delivery keyed by its KSUID, so iteration
+// is in creation order.
+var webhookOutboxBucket = []byte("webhook_outbox")
+
+var webhookBuckets = []string{
+	string(webhookBucket),
+	string(webhookOutboxBucket),
+}
+
+type webhookRepository struct {
+	*bolt.DB
+	cipher envelope.Cipher
+}
+
+// NewWebhookRepository returns a new repo object with the associated bolt.DB,
+// storing signing secrets sealed by ci.
+func NewWebhookRepository(db *bolt.DB, ci envelope.Cipher) (webhook.Repository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range webhookBuckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(b))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	return &webhookRepository{db, ci}, err
+}
+
+// put stores w with its secret sealed.
+func (wr *webhookRepository) put(b *bolt.Bucket, w *model.Webhook) error {
+	sealed := *w
+	var err error
+	sealed.Secret, err = envelope.SealString(wr.cipher, w.Secret, envelope.AAD("webhook", w.ID, "secret"))
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(&sealed)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(w.ID), buf)
+}
+
+// decode reverses put.
+func (wr *webhookRepository) decode(v []byte) (*model.Webhook, error) {
+	w := &model.Webhook{}
+	err := json.Unmarshal(v, w)
+	if err != nil {
+		return nil, err
+	}
+	w.Secret, err = envelope.OpenString(wr.cipher, w.Secret, envelope.AAD("webhook", w.ID, "secret"))
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %s", w.ID, err)
+	}
+	return w, nil
+}
+
+// Webhooks returns every subscription for the cert.
+func (wr *webhookRepository) Webhooks(certID string) ([]*model.Webhook, error) {
+	var hooks = make([]*model.Webhook, 0)
+	err := wr.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookBucket).ForEach(func(k, v []byte) error {
+			w := &model.Webhook{}
+			err := json.Unmarshal(v, w)
+			if err != nil {
+				return err
+			}
+			if w.CertID != certID {
+				return nil
+			}
+			w, err = wr.decode(v)
+			if err != nil {
+				return err
+			}
+			hooks = append(hooks, w)
+			return nil
+		})
+	})
+	return hooks, err
+}
+
+// Webhook returns the subscription with the given id, or nil.
+func (wr *webhookRepository) Webhook(id string) (*model.Webhook, error) {
+	var w *model.Webhook
+	err := wr.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(webhookBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		var err error
+		w, err = wr.decode(v)
+		return err
+	})
+	return w, err
+}
+
+// SaveWebhook persists a subscription in BoltStore.
+func (wr *webhookRepository) SaveWebhook(w *model.Webhook) error {
+	return wr.DB.Update(func(tx *bolt.Tx) error {
+		return wr.put(tx.Bucket(webhookBucket), w)
+	})
+}
+
+// DeleteWebhook removes the subscription and all of its deliveries.
+func (wr *webhookRepository) DeleteWebhook(id string) error {
+	return wr.DB.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(webhookBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		return deleteDeliveries(tx, func(d *model.Delivery) bool {
+			return d.WebhookID == id
+		})
+	})
+}
+
+// SaveDelivery persists a delivery in the outbox.
+func (wr *webhookRepository) SaveDelivery(d *model.Delivery) error {
+	return wr.DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(webhookOutboxBucket).Put([]byte(d.ID), buf)
+	})
+}
+
+// Deliveries returns the webhook's deliveries, newest first.
+func (wr *webhookRepository) Deliveries(webhookID string) ([]*model.Delivery, error) {
+	var deliveries = make([]*model.Delivery, 0)
+	err := wr.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(webhookOutboxBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			d := &model.Delivery{}
+			err := json.Unmarshal(v, d)
+			if err != nil {
+				return err
+			}
+			if d.WebhookID == webhookID {
+				deliveries = append(deliveries, d)
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// DueDeliveries returns pending deliveries due at or before now, oldest first.
+func (wr *webhookRepository) DueDeliveries(now time.Time) ([]*model.Delivery, error) {
+	var deliveries []*model.Delivery
+	err := wr.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookOutboxBucket).ForEach(func(k, v []byte) error {
+			d := &model.Delivery{}
+			err := json.Unmarshal(v, d)
+			if err != nil {
+				return err
+			}
+			if d.Status == model.DeliveryPending && !d.NextAttempt.After(now) {
+				deliveries = append(deliveries, d)
+			}
+			return nil
+		})
+	})
+	return deliveries, err
+}
+
+// PruneDeliveries removes delivered and failed deliveries that finished before
+// the cutoff. Pending ones are kept however old they are.
+func (wr *webhookRepository) PruneDeliveries(before time.Time) error {
+	return wr.DB.Update(func(tx *bolt.Tx) error {
+		return deleteDeliveries(tx, func(d *model.Delivery) bool {
+			return d.Status != model.DeliveryPending && d.Finished.Before(before)
+		})
+	})
+}
+
+func deleteDeliveries(tx *bolt.Tx, match func(d *model.Delivery) bool) error {
+	b := tx.Bucket(webhookOutboxBucket)
+
+	// Collect first, since deleting while iterating skips keys.
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		d := &model.Delivery{}
+		err := json.Unmarshal(v, d)
+		if err != nil {
+			return err
+		}
+		if match(d) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = b.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reseal rewrites every subscription whose secret isn't sealed with the
+// current key.
+func (wr *webhookRepository) Reseal() (int, error) {
+	n := 0
+	err := wr.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhookBucket)
+
+		// Collect first, since writing while iterating isn't safe.
+		var stale []*model.Webhook
+		err := b.ForEach(func(k, v []byte) error {
+			w := &model.Webhook{}
+			err := json.Unmarshal(v, w)
+			if err != nil {
+				return err
+			}
+			if wr.cipher.Current([]byte(w.Secret)) {
+				return nil
+			}
+			w, err = wr.decode(v)
+			if err != nil {
+				return err
+			}
+			stale = append(stale, w)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, w := range stale {
+			err := wr.put(b, w)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(stale)
+		return nil
+	})
+	return n, err
+}
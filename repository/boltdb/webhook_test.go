@@ -0,0 +1,83 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/boltdb/bolt"
+)
+
+func TestWebhookOutbox(t *testing.T) {
+	db, err := bolt.Open(TestDBPath, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening test db: %s", err.Error())
+	}
+	defer db.Close()
+
+	wr, err := NewWebhookRepository(db, envelope.None)
+	if err != nil {
+		t.Fatalf("Error on NewWebhookRepository: %s", err.Error())
+	}
+
+	w, err := model.NewWebhook("webhook-test-cert", "https://example.com/hook", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.SaveWebhook(w); err != nil {
+		t.Fatal(err)
+	}
+	defer wr.DeleteWebhook(w.ID)
+
+	hooks, err := wr.Webhooks("webhook-test-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].Secret != w.Secret {
+		t.Fatalf("expected the saved webhook, got %+v", hooks)
+	}
+
+	due := model.NewDelivery(w, model.EventIssued, []byte("{}"))
+	now := time.Now()
+	later := model.NewDelivery(w, model.EventRenewed, []byte("{}"))
+	later.NextAttempt = now.Add(time.Hour)
+	done := model.NewDelivery(w, model.EventRevoked, []byte("{}"))
+	done.Status = model.DeliveryDelivered
+	done.Finished = now.Add(-48 * time.Hour)
+	for _, d := range []*model.Delivery{due, later, done} {
+		if err := wr.SaveDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds, err := wr.DueDeliveries(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].ID != due.ID {
+		t.Errorf("expected only the due delivery, got %d", len(ds))
+	}
+
+	if err := wr.PruneDeliveries(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ds, err = wr.Deliveries(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 2 {
+		t.Errorf("expected 2 deliveries after pruning, got %d", len(ds))
+	}
+
+	if err := wr.DeleteWebhook(w.ID); err != nil {
+		t.Fatal(err)
+	}
+	ds, err = wr.Deliveries(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 0 {
+		t.Errorf("expected history to be removed with the webhook, got %d", len(ds))
+	}
+}
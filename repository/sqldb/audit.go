@@ -0,0 +1,75 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/model"
+)
+
+type auditRepository struct {
+	*DB
+}
+
+// NewAuditRepository returns a new repo object with the associated DB.
+func NewAuditRepository(db *DB) audit.Repository {
+	return &auditRepository{db}
+}
+
+// Append adds an entry to the log. The primary key makes it fail if the entry
+// was already recorded.
+func (ar *auditRepository) Append(e *model.AuditEntry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = ar.Exec(`INSERT INTO audit (id, time, actor, action, target, data) VALUES ($1, $2, $3, $4, $5, $6)`,
+		e.ID, e.Time.UnixNano(), e.Actor, e.Action, e.Target, string(buf))
+	return err
+}
+
+// Entries returns matching entries, newest first.
+func (ar *auditRepository) Entries(q *model.AuditQuery) ([]*model.AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if q.Actor != "" {
+		add("actor = $%d", q.Actor)
+	}
+	if q.Action != "" {
+		add("action = $%d", q.Action)
+	}
+	if q.Target != "" {
+		add("target = $%d", q.Target)
+	}
+	if !q.Since.IsZero() {
+		add("time >= $%d", q.Since.UnixNano())
+	}
+
+	query := `SELECT data FROM audit`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(` ORDER BY time DESC, id DESC LIMIT $%d`, len(args))
+
+	var entries = make([]*model.AuditEntry, 0)
+	err := ar.listJSON(func(data []byte) error {
+		e := &model.AuditEntry{}
+		entries = append(entries, e)
+		return json.Unmarshal(data, e)
+	}, query, args...)
+	return entries, err
+}
+
+// Prune drops entries recorded before the cutoff.
+func (ar *auditRepository) Prune(before time.Time) error {
+	_, err := ar.Exec(`DELETE FROM audit WHERE time < $1`, before.UnixNano())
+	return err
+}
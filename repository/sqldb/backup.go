@@ -0,0 +1,47 @@
+package sqldb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ImageWare/TLSential/backup"
+)
+
+type backupRepository struct {
+	*DB
+}
+
+// NewBackupRepository returns a new repo object with the associated DB.
+// Only SQLite databases can be snapshotted; use pg_dump for PostgreSQL.
+func NewBackupRepository(db *DB) backup.Repository {
+	return &backupRepository{db}
+}
+
+// Snapshot has SQLite write a compacted copy to a temporary file, then
+// streams that.
+func (br *backupRepository) Snapshot(w io.Writer) (int64, error) {
+	if br.Driver != DriverSQLite {
+		return 0, backup.ErrNotSupported
+	}
+
+	dir, err := ioutil.TempDir("", "tlsential-snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot.db")
+	_, err = br.Exec(`VACUUM INTO $1`, path)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
@@ -0,0 +1,586 @@
+package sqldb
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+)
+
+type certRepository struct {
+	*DB
+	cipher envelope.Cipher
+}
+
+// NewCertificateRepository returns a new repo object with the associated DB,
+// storing secrets sealed by ci.
+func NewCertificateRepository(db *DB, ci envelope.Cipher) certificate.Repository {
+	return &certRepository{db, ci}
+}
+
+var certColumnList = []string{
+	"id", "secret", "common_name", "domains", "labels", "cert_url", "cert_stable_url",
+	"private_key", "certificate", "issuer_certificate", "issued", "version", "imported", "revoked",
+	"expiry", "renew_at", "endpoints", "last_error", "validate_first", "dry_run", "mod_time",
+	"acme_email", "acme_registration", "acme_key", "installs", "pending_domains",
+	"install_tokens",
+}
+
+var certColumns = strings.Join(certColumnList, ", ")
+
+// certUpsert inserts a cert row, replacing every column but the ID if it
+// already exists.
+var certUpsert = func() string {
+	var params, updates []string
+	for i, col := range certColumnList {
+		params = append(params, fmt.Sprintf("$%d", i+1))
+		if col != "id" {
+			updates = append(updates, col+" = excluded."+col)
+		}
+	}
+	return "INSERT INTO certificates (" + certColumns + ") VALUES (" + strings.Join(params, ", ") +
+		") ON CONFLICT (id) DO UPDATE SET " + strings.Join(updates, ", ")
+}()
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sealedColumns are the columns sealed at rest, in the order SaveCert and
+// Reseal handle them.
+var sealedColumns = [3]string{"secret", "private_key", "acme_key"}
+
+// certAAD binds a sealed column to the cert it's stored in.
+func certAAD(id, column string) []byte {
+	return envelope.AAD("certificate", id, column)
+}
+
+func (cr *certRepository) scanCert(s scanner) (*model.Certificate, error) {
+	c := &model.Certificate{}
+	var domains, labels, endpoints, installs, tokens, dryRun, registration, pending string
+	var privateKey, cert, issuer string
+	var lastError, acmeKey string
+	var expiry, modTime int64
+
+	err := s.Scan(&c.ID, &c.Secret, &c.CommonName, &domains, &labels, &c.CertURL, &c.CertStableURL,
+		&privateKey, &cert, &issuer, &c.Issued, &c.Version, &c.Imported, &c.Revoked,
+		&expiry, &c.RenewAt, &endpoints, &lastError, &c.ValidateFirst, &dryRun, &modTime,
+		&c.ACMEEmail, &registration, &acmeKey, &installs, &pending, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Secret, err = envelope.OpenString(cr.cipher, c.Secret, certAAD(c.ID, "secret")); err != nil {
+		return nil, fmt.Errorf("cert %s: %s", c.ID, err)
+	}
+	if privateKey, err = envelope.OpenString(cr.cipher, privateKey, certAAD(c.ID, "private_key")); err != nil {
+		return nil, fmt.Errorf("cert %s: %s", c.ID, err)
+	}
+	if acmeKey, err = envelope.OpenString(cr.cipher, acmeKey, certAAD(c.ID, "acme_key")); err != nil {
+		return nil, fmt.Errorf("cert %s: %s", c.ID, err)
+	}
+
+	c.PrivateKey = bytesOrNil(privateKey)
+	c.Certificate = bytesOrNil(cert)
+	c.IssuerCertificate = bytesOrNil(issuer)
+	c.Expiry = fromNanos(expiry)
+	c.ModTime = fromNanos(modTime)
+	if lastError != "" {
+		c.LastError = errors.New(lastError)
+	}
+	c.ACMEKey = decodeKey(acmeKey)
+
+	for _, f := range []struct {
+		s string
+		v interface{}
+	}{
+		{domains, &c.Domains},
+		{labels, &c.Labels},
+		{endpoints, &c.Endpoints},
+		{installs, &c.Installs},
+		{tokens, &c.InstallTokens},
+		{dryRun, &c.DryRun},
+		{registration, &c.ACMERegistration},
+		{pending, &c.PendingDomains},
+	} {
+		err := json.Unmarshal([]byte(f.s), f.v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func bytesOrNil(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+// AllCerts returns every stored cert.
+func (cr *certRepository) AllCerts() ([]*model.Certificate, error) {
+	rows, err := cr.Query(`SELECT ` + certColumns + ` FROM certificates ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs = make([]*model.Certificate, 0)
+	for rows.Next() {
+		c, err := cr.scanCert(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+// QueryCerts returns the page of certs matching q. Only the summary columns
+// are read to filter and sort; full rows are loaded for the page alone.
+func (cr *certRepository) QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error) {
+	summaries, err := cr.CertSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, next, total, err := q.Page(summaries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.CertificatePage{Certs: make([]*model.Certificate, 0), NextCursor: next, Total: total}
+	for _, id := range ids {
+		c, err := cr.Cert(id)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			page.Certs = append(page.Certs, c)
+		}
+	}
+	return page, nil
+}
+
+// CertSummaries returns the summary of every cert, reading only the summary
+// columns.
+func (cr *certRepository) CertSummaries() ([]*model.CertificateSummary, error) {
+	rows, err := cr.Query(`SELECT id, common_name, domains, labels, issued, last_error, expiry, mod_time FROM certificates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*model.CertificateSummary
+	for rows.Next() {
+		s := &model.CertificateSummary{}
+		var domains, labels, lastError string
+		var expiry, modTime int64
+		err := rows.Scan(&s.ID, &s.CommonName, &domains, &labels, &s.Issued, &lastError, &expiry, &modTime)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(domains), &s.Domains); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &s.Labels); err != nil {
+			return nil, err
+		}
+		s.Failed = lastError != ""
+		s.Expiry = fromNanos(expiry)
+		s.ModTime = fromNanos(modTime)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// Cert takes an id and returns the whole cert object, or nil if there's no
+// such cert.
+func (cr *certRepository) Cert(id string) (*model.Certificate, error) {
+	c, err := cr.scanCert(cr.QueryRow(`SELECT `+certColumns+` FROM certificates WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// SaveCert inserts or replaces a cert.
+func (cr *certRepository) SaveCert(c *model.Certificate) error {
+	c.ModTime = time.Now()
+
+	var jsonCols [8]string
+	for i, v := range []interface{}{c.Domains, c.Labels, c.Endpoints, c.DryRun, c.ACMERegistration, c.Installs, c.PendingDomains, c.InstallTokens} {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		jsonCols[i] = string(buf)
+	}
+
+	var lastError string
+	if c.LastError != nil {
+		lastError = c.LastError.Error()
+	}
+
+	var sealed [3]string
+	for i, v := range []string{c.Secret, string(c.PrivateKey), encodeKey(c.ACMEKey)} {
+		var err error
+		sealed[i], err = envelope.SealString(cr.cipher, v, certAAD(c.ID, sealedColumns[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := cr.Exec(certUpsert,
+		c.ID, sealed[0], c.CommonName, jsonCols[0], jsonCols[1], c.CertURL, c.CertStableURL,
+		sealed[1], string(c.Certificate), string(c.IssuerCertificate), c.Issued, c.Version, c.Imported, c.Revoked,
+		nanos(c.Expiry), c.RenewAt, jsonCols[2], lastError, c.ValidateFirst, jsonCols[3], nanos(c.ModTime),
+		c.ACMEEmail, jsonCols[4], sealed[2], jsonCols[5], jsonCols[6], jsonCols[7])
+	return err
+}
+
+// SaveEndpoints updates just c's endpoints and their probe results. mod_time
+// is left alone since the cert itself hasn't changed.
+func (cr *certRepository) SaveEndpoints(c *model.Certificate) error {
+	buf, err := json.Marshal(c.Endpoints)
+	if err != nil {
+		return err
+	}
+	_, err = cr.Exec(`UPDATE certificates SET endpoints = $1 WHERE id = $2`, string(buf), c.ID)
+	return err
+}
+
+// SaveDryRun updates just c's dry run outcome. Like probe results, it leaves
+// mod_time alone.
+func (cr *certRepository) SaveDryRun(c *model.Certificate) error {
+	buf, err := json.Marshal(c.DryRun)
+	if err != nil {
+		return err
+	}
+	_, err = cr.Exec(`UPDATE certificates SET dry_run = $1 WHERE id = $2`, string(buf), c.ID)
+	return err
+}
+
+// SaveSecret replaces old with c.Secret, failing with
+// certificate.ErrSecretUsed if old has already been replaced.
+func (cr *certRepository) SaveSecret(c *model.Certificate, old string) error {
+	sealed, err := envelope.SealString(cr.cipher, c.Secret, certAAD(c.ID, "secret"))
+	if err != nil {
+		return err
+	}
+	tx, err := cr.Begin()
+	if err != nil {
+		return err
+	}
+	var current string
+	err = tx.QueryRow(`SELECT secret FROM certificates WHERE id = $1`+cr.forUpdate(), c.ID).Scan(&current)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil
+	}
+	if err == nil {
+		current, err = envelope.OpenString(cr.cipher, current, certAAD(c.ID, "secret"))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if current != old {
+		tx.Rollback()
+		return certificate.ErrSecretUsed
+	}
+	_, err = tx.Exec(`UPDATE certificates SET secret = $1 WHERE id = $2`, sealed, c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveInstall records i against the stored installs and copies the result to
+// c.
+func (cr *certRepository) SaveInstall(c *model.Certificate, i *model.Install) error {
+	tx, err := cr.Begin()
+	if err != nil {
+		return err
+	}
+	var installs string
+	err = tx.QueryRow(`SELECT installs FROM certificates WHERE id = $1`+cr.forUpdate(), c.ID).Scan(&installs)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil
+	}
+	stored := &model.Certificate{}
+	if err == nil {
+		err = json.Unmarshal([]byte(installs), &stored.Installs)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stored.RecordInstall(i)
+	buf, err := json.Marshal(stored.Installs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`UPDATE certificates SET installs = $1 WHERE id = $2`, string(buf), c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	c.Installs = stored.Installs
+	return tx.Commit()
+}
+
+// SaveInstallTokens updates just c's install tokens. Like install reports,
+// they leave mod_time alone.
+func (cr *certRepository) SaveInstallTokens(c *model.Certificate) error {
+	buf, err := json.Marshal(c.InstallTokens)
+	if err != nil {
+		return err
+	}
+	_, err = cr.Exec(`UPDATE certificates SET install_tokens = $1 WHERE id = $2`, string(buf), c.ID)
+	return err
+}
+
+// SaveSettings updates the columns users edit: the requested domains, ACME
+// email, RenewAt, labels and endpoints. The requested domains are resolved
+// against the stored ones, in case an issuance finished in the meantime.
+func (cr *certRepository) SaveSettings(c *model.Certificate) error {
+	tx, err := cr.Begin()
+	if err != nil {
+		return err
+	}
+	var stored, commonName string
+	err = tx.QueryRow(`SELECT domains, common_name FROM certificates WHERE id = $1`+cr.forUpdate(), c.ID).Scan(&stored, &commonName)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var issued []string
+	err = json.Unmarshal([]byte(stored), &issued)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	domains, commonName, pending := model.ResolveDomains(issued, commonName, c.RequestedDomains())
+
+	var jsonCols [4]string
+	for i, v := range []interface{}{domains, pending, c.Labels, c.Endpoints} {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		jsonCols[i] = string(buf)
+	}
+	c.ModTime = time.Now()
+	_, err = tx.Exec(`UPDATE certificates SET domains = $1, common_name = $2, pending_domains = $3, acme_email = $4,
+		renew_at = $5, labels = $6, endpoints = $7, mod_time = $8 WHERE id = $9`,
+		jsonCols[0], commonName, jsonCols[1], c.ACMEEmail, c.RenewAt, jsonCols[2], jsonCols[3], nanos(c.ModTime), c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// An email change updates the account. Certs without one get it from
+	// SaveIssuance.
+	if c.ACMERegistration != nil {
+		registration, err := json.Marshal(c.ACMERegistration)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(`UPDATE certificates SET acme_registration = $1 WHERE id = $2`, string(registration), c.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveIssuance updates the columns the ACME workers own: the certificate and
+// its key, its state and the ACME account. A new version also replaces the
+// domains with the ones it was issued for, clearing the pending domains only
+// if they're the same, so a change requested during issuance survives.
+// Nothing is written if a newer version has been saved since c was loaded.
+func (cr *certRepository) SaveIssuance(c *model.Certificate) error {
+	var sealed [2]string
+	for i, v := range []string{string(c.PrivateKey), encodeKey(c.ACMEKey)} {
+		var err error
+		sealed[i], err = envelope.SealString(cr.cipher, v, certAAD(c.ID, sealedColumns[i+1]))
+		if err != nil {
+			return err
+		}
+	}
+	registration, err := json.Marshal(c.ACMERegistration)
+	if err != nil {
+		return err
+	}
+	var lastError string
+	if c.LastError != nil {
+		lastError = c.LastError.Error()
+	}
+
+	tx, err := cr.Begin()
+	if err != nil {
+		return err
+	}
+	var version int
+	var domains, commonName, pending string
+	err = tx.QueryRow(`SELECT version, domains, common_name, pending_domains FROM certificates WHERE id = $1`+cr.forUpdate(), c.ID).
+		Scan(&version, &domains, &commonName, &pending)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if c.Version < version {
+		tx.Rollback()
+		return nil
+	}
+	if c.Version > version {
+		var stored []string
+		err = json.Unmarshal([]byte(pending), &stored)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if model.SameDomains(stored, c.Domains) {
+			pending = "null"
+		}
+		buf, err := json.Marshal(c.Domains)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		domains, commonName = string(buf), c.CommonName
+	}
+
+	c.ModTime = time.Now()
+	_, err = tx.Exec(`UPDATE certificates SET domains = $1, common_name = $2, pending_domains = $3,
+		cert_url = $4, cert_stable_url = $5, private_key = $6, certificate = $7, issuer_certificate = $8,
+		issued = $9, version = $10, revoked = $11, expiry = $12, last_error = $13,
+		acme_registration = $14, acme_key = $15, mod_time = $16 WHERE id = $17`,
+		domains, commonName, pending, c.CertURL, c.CertStableURL, sealed[0], string(c.Certificate), string(c.IssuerCertificate),
+		c.Issued, c.Version, c.Revoked, nanos(c.Expiry), lastError,
+		string(registration), sealed[1], nanos(c.ModTime), c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// forUpdate locks the rows a read in a transaction returns, where the
+// database needs telling. SQLite only has one writer anyway.
+func (cr *certRepository) forUpdate() string {
+	if cr.Driver == DriverPostgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// DeleteCert removes any saved Cert object matching the id
+func (cr *certRepository) DeleteCert(id string) error {
+	_, err := cr.Exec(`DELETE FROM certificates WHERE id = $1`, id)
+	return err
+}
+
+// DeleteAllCerts removes every cert.
+func (cr *certRepository) DeleteAllCerts() error {
+	_, err := cr.Exec(`DELETE FROM certificates`)
+	return err
+}
+
+// Reseal rewrites the secrets of every cert not sealed with the current key.
+// mod_time is left alone since nothing about the cert changed.
+func (cr *certRepository) Reseal() (int, error) {
+	rows, err := cr.Query(`SELECT id, secret, private_key, acme_key FROM certificates`)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read everything before writing, since SQLite only has one connection.
+	var stale [][4]string
+	for rows.Next() {
+		var r [4]string
+		err := rows.Scan(&r[0], &r[1], &r[2], &r[3])
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !cr.cipher.Current([]byte(r[1])) || !cr.cipher.Current([]byte(r[2])) || !cr.cipher.Current([]byte(r[3])) {
+			stale = append(stale, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := cr.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range stale {
+		for i := 1; i < len(r); i++ {
+			aad := certAAD(r[0], sealedColumns[i-1])
+			v, err := envelope.OpenString(cr.cipher, r[i], aad)
+			if err == nil {
+				r[i], err = envelope.SealString(cr.cipher, v, aad)
+			}
+			if err != nil {
+				tx.Rollback()
+				return 0, fmt.Errorf("cert %s: %s", r[0], err)
+			}
+		}
+		_, err := tx.Exec(`UPDATE certificates SET secret = $1, private_key = $2, acme_key = $3 WHERE id = $4`, r[1], r[2], r[3], r[0])
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
+
+func encodeKey(privateKey *ecdsa.PrivateKey) string {
+	// Imported certs have no ACME account key.
+	if privateKey == nil {
+		return ""
+	}
+	x509Encoded, _ := x509.MarshalECPrivateKey(privateKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded}))
+}
+
+func decodeKey(pemEncoded string) *ecdsa.PrivateKey {
+	block, _ := pem.Decode([]byte(pemEncoded))
+	if block == nil {
+		return nil
+	}
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return privateKey
+}
@@ -0,0 +1,31 @@
+package sqldb
+
+// Compact rebuilds the database to reclaim the space left by deleted rows,
+// returning its size before and after.
+func (db *DB) Compact() (int64, int64, error) {
+	before, err := db.size()
+	if err != nil {
+		return 0, 0, err
+	}
+	// PostgreSQL's VACUUM can't run in a transaction, which Exec doesn't
+	// start.
+	_, err = db.Exec(`VACUUM`)
+	if err != nil {
+		return before, 0, err
+	}
+	after, err := db.size()
+	return before, after, err
+}
+
+// size returns the space the database takes up, in bytes.
+func (db *DB) size() (int64, error) {
+	var n int64
+	var err error
+	switch db.Driver {
+	case DriverSQLite:
+		err = db.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&n)
+	case DriverPostgres:
+		err = db.QueryRow(`SELECT pg_database_size(current_database())`).Scan(&n)
+	}
+	return n, err
+}
@@ -0,0 +1,196 @@
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ImageWare/TLSential/auth"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/envelope"
+)
+
+const (
+	jwtSecretKey  = "jwtsecret"
+	superAdminKey = "superadmin"
+	sessionKeyKey = "sessionkey"
+
+	authEmailKey = "authemail"
+	authKeyKey   = "authkey"
+)
+
+// settings reads and writes name/value rows in one table.
+type settings struct {
+	*DB
+	table string
+}
+
+// get returns the value for name, or "" if it isn't set.
+func (s *settings) get(name string) (string, error) {
+	var v string
+	err := s.QueryRow(`SELECT value FROM `+s.table+` WHERE name = $1`, name).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return v, err
+}
+
+func (s *settings) set(name, value string) error {
+	_, err := s.Exec(`INSERT INTO `+s.table+` (name, value) VALUES ($1, $2)
+ON CONFLICT (name) DO UPDATE SET value = excluded.value`, name, value)
+	return err
+}
+
+// sealedConfigKeys are the config values sealed at rest, since either one
+// lets its holder sign in as anyone.
+var sealedConfigKeys = []string{jwtSecretKey, sessionKeyKey}
+
+// authKeyAAD binds the sealed auth key to its setting.
+var authKeyAAD = envelope.AAD("challenge_config", "", authKeyKey)
+
+type configRepository struct {
+	settings
+	cipher envelope.Cipher
+}
+
+// NewConfigRepository provides a new config.Repository on the given DB. The
+// JWT secret and session key are sealed by ci.
+func NewConfigRepository(db *DB, ci envelope.Cipher) config.Repository {
+	return &configRepository{settings{db, "config"}, ci}
+}
+
+// Binary values are stored base64 encoded so they're safe in TEXT columns,
+// and sealed after encoding.
+
+// getSealed opens the binary value stored under name.
+func (r *configRepository) getSealed(name string) ([]byte, error) {
+	v, err := r.get(name)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	v, err = envelope.OpenString(r.cipher, v, envelope.AAD("config", "", name))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// setSealed seals value and stores it under name.
+func (r *configRepository) setSealed(name string, value []byte) error {
+	sealed, err := envelope.SealString(r.cipher, base64.StdEncoding.EncodeToString(value), envelope.AAD("config", "", name))
+	if err != nil {
+		return err
+	}
+	return r.set(name, sealed)
+}
+
+// JWTSecret returns the currently stored JWT signing secret.
+func (r *configRepository) JWTSecret() (*auth.JWTSecret, error) {
+	var secret auth.JWTSecret
+	v, err := r.getSealed(jwtSecretKey)
+	secret.SetSecret(v)
+	return &secret, err
+}
+
+// SetJWTSecret stores the given secret.
+func (r *configRepository) SetJWTSecret(secret []byte) error {
+	return r.setSealed(jwtSecretKey, secret)
+}
+
+// SuperAdmin returns the currently stored super admin username.
+func (r *configRepository) SuperAdmin() (string, error) {
+	return r.get(superAdminKey)
+}
+
+// SetSuperAdmin stores the given username.
+func (r *configRepository) SetSuperAdmin(name string) error {
+	return r.set(superAdminKey, name)
+}
+
+// SessionKey returns the currently stored session cookie key.
+func (r *configRepository) SessionKey() ([]byte, error) {
+	return r.getSealed(sessionKeyKey)
+}
+
+// SetSessionKey stores the given key.
+func (r *configRepository) SetSessionKey(key []byte) error {
+	return r.setSealed(sessionKeyKey, key)
+}
+
+// Reseal rewrites the JWT secret and session key if they aren't sealed with
+// the current key.
+func (r *configRepository) Reseal() (int, error) {
+	n := 0
+	for _, name := range sealedConfigKeys {
+		v, err := r.get(name)
+		if err != nil {
+			return n, err
+		}
+		if r.cipher.Current([]byte(v)) {
+			continue
+		}
+		key, err := r.getSealed(name)
+		if err != nil {
+			return n, fmt.Errorf("%s: %s", name, err)
+		}
+		err = r.setSealed(name, key)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+type challengeConfigRepository struct {
+	settings
+	cipher envelope.Cipher
+}
+
+// NewChallengeConfigRepository provides a new challenge_config.Repository on
+// the given DB. The DNS provider key is sealed by ci.
+func NewChallengeConfigRepository(db *DB, ci envelope.Cipher) challenge_config.Repository {
+	return &challengeConfigRepository{settings{db, "challenge_config"}, ci}
+}
+
+// AuthEmail returns the currently stored AuthEmail.
+func (r *challengeConfigRepository) AuthEmail() (string, error) {
+	return r.get(authEmailKey)
+}
+
+// SetAuthEmail stores the given email.
+func (r *challengeConfigRepository) SetAuthEmail(email string) error {
+	return r.set(authEmailKey, email)
+}
+
+// AuthKey returns the currently stored auth key.
+func (r *challengeConfigRepository) AuthKey() (string, error) {
+	v, err := r.get(authKeyKey)
+	if err != nil {
+		return "", err
+	}
+	return envelope.OpenString(r.cipher, v, authKeyAAD)
+}
+
+// SetAuthKey stores the given key.
+func (r *challengeConfigRepository) SetAuthKey(authkey string) error {
+	sealed, err := envelope.SealString(r.cipher, authkey, authKeyAAD)
+	if err != nil {
+		return err
+	}
+	return r.set(authKeyKey, sealed)
+}
+
+// Reseal rewrites the auth key if it isn't sealed with the current key.
+func (r *challengeConfigRepository) Reseal() (int, error) {
+	v, err := r.get(authKeyKey)
+	if err != nil || r.cipher.Current([]byte(v)) {
+		return 0, err
+	}
+	authkey, err := envelope.OpenString(r.cipher, v, authKeyAAD)
+	if err != nil {
+		return 0, err
+	}
+	return 1, r.SetAuthKey(authkey)
+}
@@ -0,0 +1,73 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+)
+
+type deployRepository struct {
+	*DB
+}
+
+// NewDeployRepository returns a new repo object with the associated DB.
+func NewDeployRepository(db *DB) deploy.Repository {
+	return &deployRepository{db}
+}
+
+// Targets returns every deploy target for the cert.
+func (dr *deployRepository) Targets(certID string) ([]*model.DeployTarget, error) {
+	return dr.targets(`SELECT data FROM deploy_targets WHERE cert_id = $1 ORDER BY id`, certID)
+}
+
+// Target returns the deploy target with the given id, or nil.
+func (dr *deployRepository) Target(id string) (*model.DeployTarget, error) {
+	t := &model.DeployTarget{}
+	found, err := dr.getJSON(t, `SELECT data FROM deploy_targets WHERE id = $1`, id)
+	if !found {
+		return nil, err
+	}
+	return t, err
+}
+
+// SaveTarget inserts or replaces a deploy target.
+func (dr *deployRepository) SaveTarget(t *model.DeployTarget) error {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = dr.Exec(`INSERT INTO deploy_targets (id, cert_id, status, next_attempt, data) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE SET cert_id = excluded.cert_id, status = excluded.status,
+	next_attempt = excluded.next_attempt, data = excluded.data`,
+		t.ID, t.CertID, t.Status, nanos(t.NextAttempt), string(buf))
+	return err
+}
+
+// DeleteTarget removes a deploy target.
+func (dr *deployRepository) DeleteTarget(id string) error {
+	_, err := dr.Exec(`DELETE FROM deploy_targets WHERE id = $1`, id)
+	return err
+}
+
+// DueTargets returns pending targets due at or before now.
+func (dr *deployRepository) DueTargets(now time.Time) ([]*model.DeployTarget, error) {
+	return dr.targets(`SELECT data FROM deploy_targets WHERE status = $1 AND next_attempt <= $2 ORDER BY id`,
+		model.DeployPending, nanos(now))
+}
+
+// DeployedTargets returns targets whose last push succeeded.
+func (dr *deployRepository) DeployedTargets() ([]*model.DeployTarget, error) {
+	return dr.targets(`SELECT data FROM deploy_targets WHERE status = $1 ORDER BY id`, model.DeployDeployed)
+}
+
+func (dr *deployRepository) targets(query string, args ...interface{}) ([]*model.DeployTarget, error) {
+	var targets = make([]*model.DeployTarget, 0)
+	err := dr.listJSON(func(data []byte) error {
+		t := &model.DeployTarget{}
+		targets = append(targets, t)
+		return json.Unmarshal(data, t)
+	}, query, args...)
+	return targets, err
+}
@@ -0,0 +1,79 @@
+package sqldb
+
+import (
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// tables are reported in the stats, in the same spirit as bolt's buckets.
+var tables = []string{
+	"users", "config", "challenge_config", "certificates",
+	"notifiers", "webhooks", "webhook_deliveries", "audit",
+	"scripts", "deploy_targets",
+}
+
+type healthRepository struct {
+	*DB
+}
+
+// NewHealthRepository returns a new repo object with the associated DB.
+func NewHealthRepository(db *DB) health.Repository {
+	return &healthRepository{db}
+}
+
+// Ping checks the database is reachable.
+func (hr *healthRepository) Ping() error {
+	return hr.DB.Ping()
+}
+
+// Stats returns the database size and the row count of every table.
+func (hr *healthRepository) Stats() (*model.DBStats, error) {
+	s := &model.DBStats{Path: hr.Path, Buckets: make(map[string]int)}
+
+	var err error
+	s.Size, err = hr.size()
+	if err != nil {
+		return nil, err
+	}
+
+	err = hr.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&s.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		var n int
+		err := hr.QueryRow(`SELECT COUNT(*) FROM ` + t).Scan(&n)
+		if err != nil {
+			return nil, err
+		}
+		s.Buckets[t] = n
+	}
+	return s, nil
+}
+
+// Check runs SQLite's integrity check. PostgreSQL has no equivalent, so there
+// only reachability is checked.
+func (hr *healthRepository) Check() ([]string, error) {
+	if hr.Driver != DriverSQLite {
+		return nil, hr.DB.Ping()
+	}
+
+	rows, err := hr.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		if p != "ok" {
+			problems = append(problems, p)
+		}
+	}
+	return problems, rows.Err()
+}
@@ -0,0 +1,46 @@
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Records without fields worth querying are stored whole as JSON in a data
+// column, next to whatever columns the queries need.
+
+// getJSON loads the data column of the single row matched into v. It returns
+// false if there was no row.
+func (db *DB) getJSON(v interface{}, query string, args ...interface{}) (bool, error) {
+	var data string
+	err := db.QueryRow(query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(data), v)
+}
+
+// listJSON runs a query selecting one data column and calls add with each
+// row's data.
+func (db *DB) listJSON(add func(data []byte) error, query string, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err := rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+		err = add([]byte(data))
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
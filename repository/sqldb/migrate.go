@@ -0,0 +1,160 @@
+package sqldb
+
+import (
+	"fmt"
+	"time"
+)
+
+// migrations are applied in order, each in its own transaction. Never edit
+// one that has shipped; add a new one instead.
+var migrations = []string{
+	// 1: initial schema.
+	`
+CREATE TABLE users (
+	name TEXT PRIMARY KEY,
+	role TEXT NOT NULL,
+	hash TEXT NOT NULL
+);
+
+CREATE TABLE config (
+	name  TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE challenge_config (
+	name  TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE certificates (
+	id                 TEXT PRIMARY KEY,
+	secret             TEXT NOT NULL,
+	common_name        TEXT NOT NULL,
+	domains            TEXT NOT NULL,
+	labels             TEXT NOT NULL,
+	cert_url           TEXT NOT NULL,
+	cert_stable_url    TEXT NOT NULL,
+	private_key        TEXT NOT NULL,
+	certificate        TEXT NOT NULL,
+	issuer_certificate TEXT NOT NULL,
+	issued             BOOLEAN NOT NULL,
+	version            INTEGER NOT NULL,
+	imported           BOOLEAN NOT NULL,
+	revoked            BOOLEAN NOT NULL,
+	expiry             BIGINT NOT NULL,
+	renew_at           INTEGER NOT NULL,
+	endpoints          TEXT NOT NULL,
+	last_error         TEXT NOT NULL,
+	validate_first     BOOLEAN NOT NULL,
+	dry_run            TEXT NOT NULL,
+	mod_time           BIGINT NOT NULL,
+	acme_email         TEXT NOT NULL,
+	acme_registration  TEXT NOT NULL,
+	acme_key           TEXT NOT NULL
+);
+
+CREATE TABLE notifiers (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE webhooks (
+	id      TEXT PRIMARY KEY,
+	cert_id TEXT NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE INDEX webhooks_cert_id ON webhooks (cert_id);
+
+CREATE TABLE webhook_deliveries (
+	id           TEXT PRIMARY KEY,
+	webhook_id   TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	next_attempt BIGINT NOT NULL,
+	finished     BIGINT NOT NULL,
+	data         TEXT NOT NULL
+);
+CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt);
+
+CREATE TABLE audit (
+	id     TEXT PRIMARY KEY,
+	time   BIGINT NOT NULL,
+	actor  TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	data   TEXT NOT NULL
+);
+CREATE INDEX audit_time ON audit (time);
+`,
+	// 2: deployment agent reports.
+	`
+ALTER TABLE certificates ADD COLUMN installs TEXT NOT NULL DEFAULT 'null';
+`,
+	// 3: deploy script templates.
+	`
+CREATE TABLE scripts (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+`,
+	// 4: deploy targets.
+	`
+CREATE TABLE deploy_targets (
+	id           TEXT PRIMARY KEY,
+	cert_id      TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	next_attempt BIGINT NOT NULL,
+	data         TEXT NOT NULL
+);
+CREATE INDEX deploy_targets_cert_id ON deploy_targets (cert_id);
+CREATE INDEX deploy_targets_due ON deploy_targets (status, next_attempt);
+`,
+	// 5: domains waiting on a reissue.
+	`
+ALTER TABLE certificates ADD COLUMN pending_domains TEXT NOT NULL DEFAULT 'null';
+`,
+	// 6: install tokens for agents and deploy scripts.
+	`
+ALTER TABLE certificates ADD COLUMN install_tokens TEXT NOT NULL DEFAULT 'null';
+`,
+}
+
+// migrate brings the schema up to date.
+func (db *DB) migrate() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	applied BIGINT NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %s", err)
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, len(migrations))
+	}
+
+	for v := current + 1; v <= len(migrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[v-1])
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied) VALUES ($1, $2)`, v, time.Now().UnixNano())
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %s", v, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+)
+
+type notifierRepository struct {
+	*DB
+	cipher envelope.Cipher
+}
+
+// NewNotifierRepository returns a new repo object with the associated DB,
+// storing SMTP passwords sealed by ci.
+func NewNotifierRepository(db *DB, ci envelope.Cipher) notifier.Repository {
+	return &notifierRepository{db, ci}
+}
+
+// encode returns n as stored, with its password sealed.
+func (nr *notifierRepository) encode(n *model.Notifier) (string, error) {
+	sealed := *n
+	var err error
+	sealed.Password, err = envelope.SealString(nr.cipher, n.Password, envelope.AAD("notifier", n.ID, "password"))
+	if err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(&sealed)
+	return string(buf), err
+}
+
+// decode reverses encode.
+func (nr *notifierRepository) decode(data []byte) (*model.Notifier, error) {
+	n := &model.Notifier{}
+	err := json.Unmarshal(data, n)
+	if err != nil {
+		return nil, err
+	}
+	n.Password, err = envelope.OpenString(nr.cipher, n.Password, envelope.AAD("notifier", n.ID, "password"))
+	if err != nil {
+		return nil, fmt.Errorf("notifier %s: %s", n.ID, err)
+	}
+	return n, nil
+}
+
+// AllNotifiers returns every stored notifier.
+func (nr *notifierRepository) AllNotifiers() ([]*model.Notifier, error) {
+	var notifiers = make([]*model.Notifier, 0)
+	err := nr.listJSON(func(data []byte) error {
+		n, err := nr.decode(data)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+		return nil
+	}, `SELECT data FROM notifiers ORDER BY id`)
+	return notifiers, err
+}
+
+// Notifier returns the notifier with the given id, or nil.
+func (nr *notifierRepository) Notifier(id string) (*model.Notifier, error) {
+	var n *model.Notifier
+	err := nr.listJSON(func(data []byte) error {
+		var err error
+		n, err = nr.decode(data)
+		return err
+	}, `SELECT data FROM notifiers WHERE id = $1`, id)
+	return n, err
+}
+
+// SaveNotifier inserts or replaces a notifier.
+func (nr *notifierRepository) SaveNotifier(n *model.Notifier) error {
+	data, err := nr.encode(n)
+	if err != nil {
+		return err
+	}
+	_, err = nr.Exec(`INSERT INTO notifiers (id, data) VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET data = excluded.data`, n.ID, data)
+	return err
+}
+
+// DeleteNotifier removes the notifier with the given id.
+func (nr *notifierRepository) DeleteNotifier(id string) error {
+	_, err := nr.Exec(`DELETE FROM notifiers WHERE id = $1`, id)
+	return err
+}
+
+// Reseal rewrites every notifier whose password isn't sealed with the current
+// key.
+func (nr *notifierRepository) Reseal() (int, error) {
+	// Read everything before writing, since SQLite only has one connection.
+	var stale []*model.Notifier
+	err := nr.listJSON(func(data []byte) error {
+		n := &model.Notifier{}
+		err := json.Unmarshal(data, n)
+		if err != nil || nr.cipher.Current([]byte(n.Password)) {
+			return err
+		}
+		n, err = nr.decode(data)
+		if err != nil {
+			return err
+		}
+		stale = append(stale, n)
+		return nil
+	}, `SELECT data FROM notifiers`)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := nr.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range stale {
+		data, err := nr.encode(n)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE notifiers SET data = $1 WHERE id = $2`, data, n.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
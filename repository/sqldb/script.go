@@ -0,0 +1,55 @@
+package sqldb
+
+import (
+	"encoding/json"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/script"
+)
+
+type scriptRepository struct {
+	*DB
+}
+
+// NewScriptRepository returns a new repo object with the associated DB.
+func NewScriptRepository(db *DB) script.Repository {
+	return &scriptRepository{db}
+}
+
+// AllScripts returns every stored script template, ordered by ID.
+func (sr *scriptRepository) AllScripts() ([]*model.ScriptTemplate, error) {
+	var scripts = make([]*model.ScriptTemplate, 0)
+	err := sr.listJSON(func(data []byte) error {
+		s := &model.ScriptTemplate{}
+		scripts = append(scripts, s)
+		return json.Unmarshal(data, s)
+	}, `SELECT data FROM scripts ORDER BY id`)
+	return scripts, err
+}
+
+// Script returns the script template with the given id, or nil.
+func (sr *scriptRepository) Script(id string) (*model.ScriptTemplate, error) {
+	s := &model.ScriptTemplate{}
+	found, err := sr.getJSON(s, `SELECT data FROM scripts WHERE id = $1`, id)
+	if !found {
+		return nil, err
+	}
+	return s, err
+}
+
+// SaveScript inserts or replaces a script template.
+func (sr *scriptRepository) SaveScript(s *model.ScriptTemplate) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = sr.Exec(`INSERT INTO scripts (id, data) VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET data = excluded.data`, s.ID, string(buf))
+	return err
+}
+
+// DeleteScript removes the script template with the given id.
+func (sr *scriptRepository) DeleteScript(id string) error {
+	_, err := sr.Exec(`DELETE FROM scripts WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,95 @@
+// Package sqldb implements the repositories on top of database/sql, for
+// SQLite and PostgreSQL. Queries stick to syntax both understand: $n
+// placeholders, TEXT, BIGINT and BOOLEAN columns, and INSERT ... ON CONFLICT.
+// Times are stored as Unix nanoseconds so they compare correctly everywhere.
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	// Registers the "postgres" driver.
+	_ "github.com/lib/pq"
+	// Registers the "sqlite3" driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Supported drivers, as passed to -db-driver.
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+var ErrUnknownDriver = errors.New("db driver must be bolt, sqlite3 or postgres")
+
+// DB is a migrated database along with the driver it was opened with.
+type DB struct {
+	*sql.DB
+	Driver string
+	// Path is the database file for SQLite, and empty otherwise so
+	// credentials in a PostgreSQL DSN aren't reported anywhere.
+	Path string
+}
+
+// Open connects to the database and applies any pending migrations.
+func Open(driver, dsn string) (*DB, error) {
+	if driver != DriverSQLite && driver != DriverPostgres {
+		return nil, ErrUnknownDriver
+	}
+
+	path := ""
+	if driver == DriverSQLite {
+		path = strings.TrimPrefix(strings.SplitN(dsn, "?", 2)[0], "file:")
+		// Writers would otherwise fail with "database is locked" instead of
+		// waiting their turn.
+		if !strings.Contains(dsn, "_busy_timeout") {
+			if strings.Contains(dsn, "?") {
+				dsn += "&_busy_timeout=5000"
+			} else {
+				dsn += "?_busy_timeout=5000"
+			}
+		}
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverSQLite {
+		// SQLite only has one writer anyway, and a single connection keeps
+		// in-memory databases shared.
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d := &DB{db, driver, path}
+	err = d.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// nanos converts t for storage, keeping the zero time as 0.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromNanos is the inverse of nanos.
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,117 @@
+package sqldb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/repository/repotest"
+	"github.com/ImageWare/TLSential/webhook"
+)
+
+// Set this to a PostgreSQL DSN to run the suite against a real server too.
+const postgresEnv = "TLSENTIAL_TEST_POSTGRES"
+
+func conformance(t *testing.T, db *DB) {
+	t.Run("Users", func(t *testing.T) { repotest.Users(t, NewUserRepository(db)) })
+	t.Run("Config", func(t *testing.T) { repotest.Config(t, NewConfigRepository(db, envelope.None)) })
+	t.Run("ChallengeConfig", func(t *testing.T) { repotest.ChallengeConfig(t, NewChallengeConfigRepository(db, envelope.None)) })
+	t.Run("Certificates", func(t *testing.T) { repotest.Certificates(t, NewCertificateRepository(db, envelope.None)) })
+	t.Run("Notifiers", func(t *testing.T) { repotest.Notifiers(t, NewNotifierRepository(db, envelope.None)) })
+	t.Run("Webhooks", func(t *testing.T) { repotest.Webhooks(t, NewWebhookRepository(db, envelope.None)) })
+	t.Run("Audit", func(t *testing.T) { repotest.Audit(t, NewAuditRepository(db)) })
+	t.Run("Scripts", func(t *testing.T) { repotest.Scripts(t, NewScriptRepository(db)) })
+	t.Run("DeployTargets", func(t *testing.T) { repotest.DeployTargets(t, NewDeployRepository(db)) })
+	t.Run("Encryption", func(t *testing.T) {
+		repotest.Encryption(t, func(ci envelope.Cipher) (certificate.Repository, challenge_config.Repository) {
+			return NewCertificateRepository(db, ci), NewChallengeConfigRepository(db, ci)
+		})
+	})
+	t.Run("SecretEncryption", func(t *testing.T) {
+		repotest.SecretEncryption(t, func(ci envelope.Cipher) (notifier.Repository, webhook.Repository) {
+			return NewNotifierRepository(db, ci), NewWebhookRepository(db, ci)
+		})
+	})
+	t.Run("ConfigEncryption", func(t *testing.T) {
+		repotest.ConfigEncryption(t, func(ci envelope.Cipher) config.Repository {
+			return NewConfigRepository(db, ci)
+		})
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tlsential.db")
+	db, err := Open(DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance(t, db)
+
+	stats, err := NewHealthRepository(db).Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Size == 0 || len(stats.Buckets) == 0 || stats.SchemaVersion != len(migrations) {
+		t.Errorf("expected database stats, got %+v", stats)
+	}
+	problems, err := NewHealthRepository(db).Check()
+	if err != nil || len(problems) > 0 {
+		t.Errorf("expected a clean check, got %v, %v", problems, err)
+	}
+	if _, after, err := db.Compact(); err != nil || after == 0 {
+		t.Errorf("compacting: %d bytes, %v", after, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := NewBackupRepository(db).Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("SQLite format 3")) {
+		t.Error("expected the snapshot to be an SQLite database")
+	}
+	db.Close()
+
+	// Opening again must find the schema already current.
+	db, err = Open(DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("reopening: %s", err)
+	}
+	defer db.Close()
+	var v int
+	err = db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v)
+	if err != nil || v != len(migrations) {
+		t.Errorf("expected schema version %d, got %d, %v", len(migrations), v, err)
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(postgresEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresEnv)
+	}
+	db, err := Open(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conformance(t, db)
+}
+
+func TestUnknownDriver(t *testing.T) {
+	if _, err := Open("mysql", ""); err != ErrUnknownDriver {
+		t.Errorf("expected ErrUnknownDriver, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package sqldb
+
+import (
+	"database/sql"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/user"
+)
+
+type userRepository struct {
+	*DB
+}
+
+// NewUserRepository returns a new repo object with the associated DB.
+func NewUserRepository(db *DB) user.Repository {
+	return &userRepository{db}
+}
+
+// GetAllUsers returns every stored user, ordered by name.
+func (ur *userRepository) GetAllUsers() ([]*model.User, error) {
+	rows, err := ur.Query(`SELECT name, role, hash FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*model.User
+	for rows.Next() {
+		u := &model.User{}
+		err := rows.Scan(&u.Name, &u.Role, &u.Hash)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetUser takes an username and returns their whole user object, or nil if
+// there's no such user.
+func (ur *userRepository) GetUser(name string) (*model.User, error) {
+	u := &model.User{}
+	err := ur.QueryRow(`SELECT name, role, hash FROM users WHERE name = $1`, name).Scan(&u.Name, &u.Role, &u.Hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SaveUser inserts or replaces a user.
+func (ur *userRepository) SaveUser(u *model.User) error {
+	_, err := ur.Exec(`INSERT INTO users (name, role, hash) VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET role = excluded.role, hash = excluded.hash`,
+		u.Name, u.Role, u.Hash)
+	return err
+}
+
+// DeleteUser removes any saved User object matching the username
+func (ur *userRepository) DeleteUser(name string) error {
+	_, err := ur.Exec(`DELETE FROM users WHERE name = $1`, name)
+	return err
+}
+
+// DeleteAllUsers removes every user.
+func (ur *userRepository) DeleteAllUsers() error {
+	_, err := ur.Exec(`DELETE FROM users`)
+	return err
+}
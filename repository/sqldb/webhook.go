@@ -0,0 +1,183 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/webhook"
+)
+
+type webhookRepository struct {
+	*DB
+	cipher envelope.Cipher
+}
+
+// NewWebhookRepository returns a new repo object with the associated DB,
+// storing signing secrets sealed by ci.
+func NewWebhookRepository(db *DB, ci envelope.Cipher) webhook.Repository {
+	return &webhookRepository{db, ci}
+}
+
+// encode returns w as stored, with its secret sealed.
+func (wr *webhookRepository) encode(w *model.Webhook) (string, error) {
+	sealed := *w
+	var err error
+	sealed.Secret, err = envelope.SealString(wr.cipher, w.Secret, envelope.AAD("webhook", w.ID, "secret"))
+	if err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(&sealed)
+	return string(buf), err
+}
+
+// decode reverses encode.
+func (wr *webhookRepository) decode(data []byte) (*model.Webhook, error) {
+	w := &model.Webhook{}
+	err := json.Unmarshal(data, w)
+	if err != nil {
+		return nil, err
+	}
+	w.Secret, err = envelope.OpenString(wr.cipher, w.Secret, envelope.AAD("webhook", w.ID, "secret"))
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %s", w.ID, err)
+	}
+	return w, nil
+}
+
+// Webhooks returns every subscription for the cert.
+func (wr *webhookRepository) Webhooks(certID string) ([]*model.Webhook, error) {
+	var hooks = make([]*model.Webhook, 0)
+	err := wr.listJSON(func(data []byte) error {
+		w, err := wr.decode(data)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, w)
+		return nil
+	}, `SELECT data FROM webhooks WHERE cert_id = $1 ORDER BY id`, certID)
+	return hooks, err
+}
+
+// Webhook returns the subscription with the given id, or nil.
+func (wr *webhookRepository) Webhook(id string) (*model.Webhook, error) {
+	var w *model.Webhook
+	err := wr.listJSON(func(data []byte) error {
+		var err error
+		w, err = wr.decode(data)
+		return err
+	}, `SELECT data FROM webhooks WHERE id = $1`, id)
+	return w, err
+}
+
+// SaveWebhook inserts or replaces a subscription.
+func (wr *webhookRepository) SaveWebhook(w *model.Webhook) error {
+	data, err := wr.encode(w)
+	if err != nil {
+		return err
+	}
+	_, err = wr.Exec(`INSERT INTO webhooks (id, cert_id, data) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET cert_id = excluded.cert_id, data = excluded.data`, w.ID, w.CertID, data)
+	return err
+}
+
+// DeleteWebhook removes the subscription and all of its deliveries.
+func (wr *webhookRepository) DeleteWebhook(id string) error {
+	tx, err := wr.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveDelivery inserts or replaces a delivery in the outbox.
+func (wr *webhookRepository) SaveDelivery(d *model.Delivery) error {
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = wr.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, status, next_attempt, finished, data)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (id) DO UPDATE SET status = excluded.status, next_attempt = excluded.next_attempt,
+	finished = excluded.finished, data = excluded.data`,
+		d.ID, d.WebhookID, d.Status, nanos(d.NextAttempt), nanos(d.Finished), string(buf))
+	return err
+}
+
+// Deliveries returns the webhook's deliveries, newest first.
+func (wr *webhookRepository) Deliveries(webhookID string) ([]*model.Delivery, error) {
+	return wr.deliveries(`SELECT data FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC`, webhookID)
+}
+
+// DueDeliveries returns pending deliveries due at or before now, oldest first.
+func (wr *webhookRepository) DueDeliveries(now time.Time) ([]*model.Delivery, error) {
+	return wr.deliveries(`SELECT data FROM webhook_deliveries WHERE status = $1 AND next_attempt <= $2 ORDER BY id`,
+		model.DeliveryPending, now.UnixNano())
+}
+
+func (wr *webhookRepository) deliveries(query string, args ...interface{}) ([]*model.Delivery, error) {
+	var deliveries = make([]*model.Delivery, 0)
+	err := wr.listJSON(func(data []byte) error {
+		d := &model.Delivery{}
+		deliveries = append(deliveries, d)
+		return json.Unmarshal(data, d)
+	}, query, args...)
+	return deliveries, err
+}
+
+// PruneDeliveries removes delivered and failed deliveries that finished before
+// the cutoff. Pending ones are kept however old they are.
+func (wr *webhookRepository) PruneDeliveries(before time.Time) error {
+	_, err := wr.Exec(`DELETE FROM webhook_deliveries WHERE status <> $1 AND finished < $2`,
+		model.DeliveryPending, before.UnixNano())
+	return err
+}
+
+// Reseal rewrites every subscription whose secret isn't sealed with the
+// current key.
+func (wr *webhookRepository) Reseal() (int, error) {
+	// Read everything before writing, since SQLite only has one connection.
+	var stale []*model.Webhook
+	err := wr.listJSON(func(data []byte) error {
+		w := &model.Webhook{}
+		err := json.Unmarshal(data, w)
+		if err != nil || wr.cipher.Current([]byte(w.Secret)) {
+			return err
+		}
+		w, err = wr.decode(data)
+		if err != nil {
+			return err
+		}
+		stale = append(stale, w)
+		return nil
+	}, `SELECT data FROM webhooks`)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := wr.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, w := range stale {
+		data, err := wr.encode(w)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE webhooks SET data = $1 WHERE id = $2`, data, w.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
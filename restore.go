@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/boltdb/bolt"
+)
+
+// restore replaces the bolt database with a snapshot, such as one from
+// /api/admin/backup or -backup-dir. The snapshot is checked before anything
+// is touched and the current database is kept alongside it. The server has to
+// be stopped first, since it holds a lock on the file.
+//
+//	tlsential restore [-db tlsential.db] snapshot.db
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbFile := fs.String("db", "tlsential.db", "filename of the bolt database to replace")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tlsential restore [-db tlsential.db] snapshot.db")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("restore needs exactly one snapshot file")
+	}
+	snapshot := fs.Arg(0)
+
+	version, err := boltdb.ValidateSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("%s: %s", snapshot, err)
+	}
+	fmt.Printf("Snapshot %s is valid, schema version %d\n", snapshot, version)
+
+	if _, err := os.Stat(*dbFile); err == nil {
+		backup, err := setAside(*dbFile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Kept the current database as %s\n", backup)
+	}
+
+	// Copy next to the target then rename, so the swap is atomic.
+	tmp := *dbFile + ".restoring"
+	err = copyFile(snapshot, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, *dbFile)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fmt.Printf("Restored %s from %s\n", *dbFile, snapshot)
+	return nil
+}
+
+// setAside copies the current database next to itself. Opening it also
+// proves the server isn't running.
+func setAside(dbFile string) (string, error) {
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return "", fmt.Errorf("%s is in use, stop the server before restoring: %s", dbFile, err)
+	}
+	defer db.Close()
+
+	backup := fmt.Sprintf("%s.pre-restore-%s.bak", dbFile, time.Now().UTC().Format("20060102T150405Z"))
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backup, 0600)
+	})
+	return backup, err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
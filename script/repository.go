@@ -0,0 +1,12 @@
+package script
+
+import "github.com/ImageWare/TLSential/model"
+
+// Repository provides an interface for persisting deploy script templates.
+type Repository interface {
+	AllScripts() ([]*model.ScriptTemplate, error)
+	// Script returns the template with id, or nil if there isn't one.
+	Script(id string) (*model.ScriptTemplate, error)
+	SaveScript(s *model.ScriptTemplate) error
+	DeleteScript(id string) error
+}
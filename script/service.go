@@ -0,0 +1,46 @@
+package script
+
+import (
+	"errors"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+var (
+	// ErrScriptNotFound is returned when rendering a template that doesn't
+	// exist.
+	ErrScriptNotFound = errors.New("script not found")
+
+	// ErrNoBaseURL is returned when rendering without a base URL for scripts
+	// to call back to. The request's Host can't be trusted for that.
+	ErrNoBaseURL = errors.New("deploy scripts need -base-url to be set")
+)
+
+// BodyError is returned when saving a template whose body doesn't parse or
+// render.
+type BodyError struct {
+	Err error
+}
+
+func (e *BodyError) Error() string {
+	return "script body: " + e.Err.Error()
+}
+
+// Service provides an interface for managing deploy script templates and
+// rendering them for certificates.
+type Service interface {
+	AllScripts() ([]*model.ScriptTemplate, error)
+	Script(id string) (*model.ScriptTemplate, error)
+	// SaveScript validates s, including that its body renders, and stores
+	// it.
+	SaveScript(s *model.ScriptTemplate) error
+	DeleteScript(id string) error
+
+	// SeedBuiltins stores the built in templates if there are no templates
+	// at all, so edits and deletions stick unless every one is deleted.
+	SeedBuiltins() error
+
+	// Render returns the script with id for c, calling back to the
+	// configured base URL with the install token it embeds.
+	Render(id string, c *model.Certificate, token string) ([]byte, error)
+}
@@ -1,29 +1,52 @@
 package service
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"log"
+	"net/mail"
 	"time"
 
 	"github.com/ImageWare/TLSential/acme"
 	cert "github.com/ImageWare/TLSential/certificate"
 	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/events"
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/metrics"
 	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/webhook"
 	"github.com/go-acme/lego/v3/certcrypto"
 	lcert "github.com/go-acme/lego/v3/certificate"
 	"github.com/go-acme/lego/v3/lego"
+	"github.com/go-acme/lego/v3/registration"
 )
 
 var certAutoRenewChan chan string
 var certIssueChan chan string
+var certDryRunChan chan string
 
 type acmeService struct {
-	certService  cert.Service
-	challService challenge_config.Service
+	certService     cert.Service
+	challService    challenge_config.Service
+	notifierService notifier.Service
+	webhookService  webhook.Service
+	deployService   deploy.Service
+	bus             *events.Bus
+	// caDirURL is the production ACME directory.
+	caDirURL string
 }
 
 func CreateChannelsAndListeners(buffSize int, listeners int, cs cert.Service, as acme.Service) {
 	certAutoRenewChan = make(chan string, buffSize)
 	certIssueChan = make(chan string)
+	certDryRunChan = make(chan string, buffSize)
+
+	metrics.TrackRenewQueue(certAutoRenewChan)
+	metrics.Workers.Set(float64(listeners))
 
 	for i := 0; i < listeners; i++ {
 		go handleCertChannels(cs, as)
@@ -31,33 +54,79 @@ func CreateChannelsAndListeners(buffSize int, listeners int, cs cert.Service, as
 }
 
 func handleCertChannels(cs cert.Service, as acme.Service) {
+	health.WorkerStarted()
+	defer health.WorkerStopped()
+
 	for {
 		select {
 		case id := <-as.GetAutoRenewChannel():
+			health.Dequeued()
+			metrics.WorkersBusy.Inc()
 			c, err := cs.Cert(id)
 
 			if err != nil {
 				log.Printf("service: acme: handleCertChannels: error with triggered autorenew of cert '%s': %s", id, err.Error())
+				metrics.WorkersBusy.Dec()
 				break
 			}
 
 			if c == nil {
 				log.Printf("service: acme: handleCertChannels: told to renew cert '%s' which doesn't exist", id)
+				metrics.WorkersBusy.Dec()
 				break
 			}
 
 			as.Renew(c)
+			metrics.WorkersBusy.Dec()
 			break
 
 		case id := <-as.GetIssueChannel():
+			metrics.WorkersBusy.Inc()
 			as.Trigger(id)
+			metrics.WorkersBusy.Dec()
 			break
+
+		case id := <-as.GetDryRunChannel():
+			metrics.WorkersBusy.Inc()
+			as.DryRun(id)
+			metrics.WorkersBusy.Dec()
+			break
+		}
+	}
+}
+
+func NewAcmeService(cts cert.Service, chs challenge_config.Service, ns notifier.Service, ws webhook.Service, ds deploy.Service, bus *events.Bus) acme.Service {
+	return &acmeService{certService: cts, challService: chs, notifierService: ns, webhookService: ws, deployService: ds, bus: bus, caDirURL: model.CADirURL}
+}
+
+// notify sends an event for c to the notifiers and event subscribers, and
+// queues it for the cert's webhooks if it's a change to the certificate
+// itself.
+func (s *acmeService) notify(t string, c *model.Certificate, message string, err error) {
+	e := model.NewEvent(t, c, message, err)
+	s.publish(e)
+	if s.webhookService != nil && (t == model.EventIssued || t == model.EventRenewed || t == model.EventRevoked) {
+		werr := s.webhookService.Enqueue(t, c)
+		if werr != nil {
+			log.Printf("service: acme: failed to queue %s webhooks for cert '%s': %s", t, c.ID, werr.Error())
 		}
 	}
+	if s.deployService != nil && (t == model.EventIssued || t == model.EventRenewed) {
+		derr := s.deployService.Schedule(c)
+		if derr != nil {
+			log.Printf("service: acme: failed to schedule deployments for cert '%s': %s", c.ID, derr.Error())
+		}
+	}
+	if s.notifierService != nil {
+		s.notifierService.Notify(e)
+	}
 }
 
-func NewAcmeService(cts cert.Service, chs challenge_config.Service) acme.Service {
-	return &acmeService{certService: cts, challService: chs}
+// publish sends e to the subscribers of its cert, if there's a bus.
+func (s *acmeService) publish(e *model.Event) {
+	if s.bus != nil {
+		s.bus.Publish(e)
+	}
 }
 
 //RequestRenew will try to send to the CertAutoRenewChan channel, but won't block if the channel is full.
@@ -82,6 +151,17 @@ func (s *acmeService) RequestIssue(id string) bool {
 	}
 }
 
+//RequestDryRun will try to send to the CertDryRunChan channel, but won't block if the channel is full.
+//Instead of blocking the function will return false to indicate that the send failed and you should try again later.
+func (s *acmeService) RequestDryRun(id string) bool {
+	select {
+	case s.GetDryRunChannel() <- id:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *acmeService) GetAutoRenewChannel() chan string {
 	return certAutoRenewChan
 }
@@ -90,18 +170,235 @@ func (s *acmeService) GetIssueChannel() chan string {
 	return certIssueChan
 }
 
-func (s *acmeService) Trigger(id string) {
+func (s *acmeService) GetDryRunChannel() chan string {
+	return certDryRunChan
+}
+
+// DryRun runs a full order for the certificate's domains against the staging
+// directory using the configured DNS provider. The outcome is stored on the
+// certificate, and a certificate that has never been issued is queued for real
+// issuance only if the dry run succeeds.
+func (s *acmeService) DryRun(id string) {
 	c, err := s.certService.Cert(id)
 	if err != nil {
 		log.Printf("Error getting cert from ID - ID: %s, Err: %s\n", id, err.Error())
+		return
+	}
+	if c == nil {
+		log.Printf("service: acme: DryRun: told to dry run cert '%s' which doesn't exist", id)
+		return
+	}
+
+	if c.Imported {
+		log.Printf("service: acme: DryRun: cert '%s' was imported and can't be issued via ACME", id)
+		return
+	}
+
+	// Only the outcome is saved, so edits and issuances made while the dry
+	// run is going aren't undone.
+	c.DryRun = &model.DryRun{Started: time.Now()}
+	err = s.certService.SaveDryRun(c)
+	if err != nil {
+		s.dryRunNotSaved(c, err)
+		return
+	}
+
+	err = s.obtainStaging(c)
+
+	c.DryRun.Finished = time.Now()
+	if err != nil {
+		log.Printf("Dry run failed - ID: %s, Err: %s\n", c.ID, err.Error())
+		c.DryRun.Error = err.Error()
+		err = s.certService.SaveDryRun(c)
+		if err != nil {
+			s.dryRunNotSaved(c, err)
+		}
+		return
+	}
+	c.DryRun.Success = true
+
+	log.Printf("/- Dry run succeeded for %s - %s\n", c.ID, c.CommonName)
+	err = s.certService.SaveDryRun(c)
+	if err != nil {
+		s.dryRunNotSaved(c, err)
+		return
+	}
+
+	if !c.Issued {
+		//We're not using RequestIssue because we always want this request to go through even if the
+		//channel buffers are full.
+		go func(id string) { s.GetIssueChannel() <- id }(c.ID)
+	}
+}
+
+// dryRunNotSaved reports that the outcome of c's dry run couldn't be saved.
+func (s *acmeService) dryRunNotSaved(c *model.Certificate, err error) {
+	log.Printf("service: acme: DryRun: failed to save the dry run of cert '%s': %s", c.ID, err.Error())
+	s.publish(model.NewEvent(model.EventFailed, c, "Saving the dry run failed.", err))
+}
+
+// stagingAccount is an ACME account that isn't a certificate yet: a
+// throwaway one for dry runs, since the certificate's own registration only
+// exists on the production directory, or one being registered.
+type stagingAccount struct {
+	email        string
+	registration *registration.Resource
+	key          crypto.PrivateKey
+}
+
+func (a *stagingAccount) GetEmail() string {
+	return a.email
+}
+
+func (a *stagingAccount) GetRegistration() *registration.Resource {
+	return a.registration
+}
+
+func (a *stagingAccount) GetPrivateKey() crypto.PrivateKey {
+	return a.key
+}
+
+// obtainStaging runs an order for c's requested domains against the staging directory and
+// discards the resulting certificate.
+func (s *acmeService) obtainStaging(c *model.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	account := &stagingAccount{email: c.ACMEEmail, key: key}
+
+	config := lego.NewConfig(account)
+	config.CADirURL = model.StagingCADirURL
+	config.Certificate.KeyType = certcrypto.RSA2048
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	account.registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return err
+	}
+
+	provider, err := s.challService.NewDNSProvider()
+	if err != nil {
+		return err
+	}
+	err = client.Challenge.SetDNS01Provider(provider)
+	if err != nil {
+		return err
+	}
+
+	request := lcert.ObtainRequest{
+		Domains: c.RequestedDomains(),
+		Bundle:  true,
+	}
+
+	_, err = client.Certificate.Obtain(request)
+	return err
+}
+
+// UpdateEmail changes the contact address on c's ACME account. The change is
+// only applied to c if the CA accepts it; c is not saved.
+func (s *acmeService) UpdateEmail(c *model.Certificate, email string) error {
+	e, err := mail.ParseAddress(email)
+	if err != nil {
+		return model.ErrInvalidEmail
 	}
 
-	// Create a user. New accounts need an email and private key to start.
+	old := c.ACMEEmail
+	c.ACMEEmail = e.Address
+
+	// Imported certs don't have an account to update.
+	if !c.HasAccount() {
+		return nil
+	}
 
 	config := lego.NewConfig(c)
+	config.CADirURL = s.caDirURL
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		c.ACMEEmail = old
+		return err
+	}
+
+	reg, err := client.Registration.UpdateRegistration(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		c.ACMEEmail = old
+		return err
+	}
+	c.ACMERegistration = reg
+	return nil
+}
+
+// register creates a new ACME account for c, for certs imported without
+// theirs. The key is only kept if the CA accepts the registration; c isn't
+// saved.
+func (s *acmeService) register(c *model.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	account := &stagingAccount{email: c.ACMEEmail, key: key}
+
+	config := lego.NewConfig(account)
+	config.CADirURL = s.caDirURL
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return err
+	}
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return err
+	}
+	c.ACMEKey, c.ACMERegistration = key, reg
+	return nil
+}
+
+func (s *acmeService) Trigger(id string) {
+	c, err := s.certService.Cert(id)
+	if err != nil {
+		log.Printf("Error getting cert from ID - ID: %s, Err: %s\n", id, err.Error())
+	}
+
+	if c == nil {
+		log.Printf("service: acme: Trigger: told to issue cert '%s' which doesn't exist", id)
+		return
+	}
+
+	if c.Imported {
+		log.Printf("service: acme: Trigger: cert '%s' was imported and can't be issued via ACME", id)
+		return
+	}
+
+	if c.AwaitingDryRun() {
+		log.Printf("service: acme: Trigger: cert '%s' hasn't passed its dry run yet", id)
+		return
+	}
+
+	s.publish(model.NewEvent(model.EventIssuing, c, "Issuing the certificate.", nil))
+	start := time.Now()
+	defer func() { metrics.ObserveIssuance(start, c.LastError) }()
+
+	if !c.HasAccount() {
+		err = s.register(c)
+		if err != nil {
+			log.Printf("Error registering an ACME account - ID: %s, Err: %s\n", id, err.Error())
+			c.LastError = err
+			s.notify(model.EventFailed, c, "Issuing the certificate failed.", err)
+			err = s.certService.SaveIssuance(c)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			return
+		}
+	}
 
-	// This CA URL is configured for a local dev instance of Boulder running in Docker in a VM.
-	config.CADirURL = "https://acme-v02.api.letsencrypt.org/directory"
+	config := lego.NewConfig(c)
+	config.CADirURL = s.caDirURL
 	config.Certificate.KeyType = certcrypto.RSA2048
 
 	// A client facilitates communication with the CA server.
@@ -114,7 +411,8 @@ func (s *acmeService) Trigger(id string) {
 	if err != nil {
 		log.Printf("Error creating New DNS Provider - ID: %s, Err: %s\n", id, err.Error())
 		c.LastError = err
-		err = s.certService.SaveCert(c)
+		s.notify(model.EventFailed, c, "Issuing the certificate failed.", err)
+		err = s.certService.SaveIssuance(c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -125,8 +423,10 @@ func (s *acmeService) Trigger(id string) {
 		log.Fatal(err)
 	}
 
+	// Domains only change once they're issued for.
+	domains := c.RequestedDomains()
 	request := lcert.ObtainRequest{
-		Domains: c.Domains,
+		Domains: domains,
 		Bundle:  true,
 	}
 
@@ -134,38 +434,53 @@ func (s *acmeService) Trigger(id string) {
 	if err != nil {
 		log.Printf("Error getting cert from ID - ID: %s, Err: %s\n", id, err.Error())
 		c.LastError = err
-		err = s.certService.SaveCert(c)
+		s.notify(model.EventFailed, c, "Issuing the certificate failed.", err)
+		err = s.certService.SaveIssuance(c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 		return
 	}
 
+	c.Domains, c.CommonName = domains, domains[0]
 	c.CertURL = signedCert.CertURL
 	c.CertStableURL = signedCert.CertStableURL
 	c.PrivateKey = signedCert.PrivateKey
 	c.Certificate = signedCert.Certificate
 	c.IssuerCertificate = signedCert.IssuerCertificate
 	c.Issued = true
+	c.Version++
 	c.Expiry = getExpiry(c)
+	c.LastError = nil
+	c.Revoked = false
 
 	log.Printf("/- Successfully minted certificate for %s - %s\n", c.ID, c.CommonName)
-	err = s.certService.SaveCert(c)
+	err = s.certService.SaveIssuance(c)
 	if err != nil {
 		log.Fatal(err)
 	}
+	s.notify(model.EventIssued, c, "A new certificate was issued.", nil)
 
 }
 
 func (s *acmeService) Renew(c *model.Certificate) {
-	if !c.Issued {
+	if c.Imported {
+		log.Printf("service: acme: Renew: cert '%s' was imported and can't be renewed via ACME", c.ID)
+		return
+	}
+	// Without the old key or an account to renew with, it's a new
+	// issuance, which registers an account first. Domain changes get a
+	// new key too.
+	if !c.Issued || len(c.PrivateKey) == 0 || !c.HasAccount() || len(c.PendingDomains) != 0 {
 		s.Trigger(c.ID)
 		return
 	}
-	config := lego.NewConfig(c)
+	s.publish(model.NewEvent(model.EventIssuing, c, "Renewing the certificate.", nil))
+	start := time.Now()
+	defer func() { metrics.ObserveIssuance(start, c.LastError) }()
 
-	// This CA URL is configured for a local dev instance of Boulder running in Docker in a VM.
-	config.CADirURL = "https://acme-v02.api.letsencrypt.org/directory"
+	config := lego.NewConfig(c)
+	config.CADirURL = s.caDirURL
 	config.Certificate.KeyType = certcrypto.RSA2048
 
 	// A client facilitates communication with the CA server.
@@ -178,7 +493,8 @@ func (s *acmeService) Renew(c *model.Certificate) {
 	if err != nil {
 		log.Printf("Error creating New DNS Provider - ID: %s, Err: %s\n", c.ID, err.Error())
 		c.LastError = err
-		err = s.certService.SaveCert(c)
+		s.notify(model.EventFailed, c, "Renewing the certificate failed.", err)
+		err = s.certService.SaveIssuance(c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -193,7 +509,8 @@ func (s *acmeService) Renew(c *model.Certificate) {
 	if err != nil {
 		log.Printf("Error getting privatekey from cert - ID: %s, Err: %s\n", c.ID, err.Error())
 		c.LastError = err
-		err = s.certService.SaveCert(c)
+		s.notify(model.EventFailed, c, "Renewing the certificate failed.", err)
+		err = s.certService.SaveIssuance(c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -210,7 +527,8 @@ func (s *acmeService) Renew(c *model.Certificate) {
 	if err != nil {
 		log.Printf("Error getting cert from ID - ID: %s, Err: %s\n", c.ID, err.Error())
 		c.LastError = err
-		err = s.certService.SaveCert(c)
+		s.notify(model.EventFailed, c, "Renewing the certificate failed.", err)
+		err = s.certService.SaveIssuance(c)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -223,14 +541,55 @@ func (s *acmeService) Renew(c *model.Certificate) {
 	c.Certificate = signedCert.Certificate
 	c.IssuerCertificate = signedCert.IssuerCertificate
 	c.Issued = true
+	c.Version++
 	c.Expiry = getExpiry(c)
+	c.LastError = nil
+	c.Revoked = false
 
 	log.Printf("/- Successfully minted certificate for %s - %s\n", c.ID, c.CommonName)
-	err = s.certService.SaveCert(c)
+	err = s.certService.SaveIssuance(c)
 	if err != nil {
 		log.Fatal(err)
 	}
+	s.notify(model.EventRenewed, c, "The certificate was renewed.", nil)
+
+}
+
+// Revoke asks the CA to revoke c's current certificate, marks it revoked and
+// saves it.
+func (s *acmeService) Revoke(c *model.Certificate) error {
+	if c.Imported {
+		return acme.ErrImported
+	}
+	if !c.Issued {
+		return acme.ErrNotIssued
+	}
+	if !c.HasAccount() {
+		return acme.ErrNoAccount
+	}
+
+	config := lego.NewConfig(c)
+	config.CADirURL = s.caDirURL
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	err = client.Certificate.Revoke(c.Certificate)
+	if err != nil {
+		return err
+	}
+
+	c.Revoked = true
+	err = s.certService.SaveIssuance(c)
+	if err != nil {
+		return err
+	}
 
+	log.Printf("/- Revoked certificate for %s - %s\n", c.ID, c.CommonName)
+	s.notify(model.EventRevoked, c, "The certificate was revoked.", nil)
+	return nil
 }
 
 func getExpiry(c *model.Certificate) time.Time {
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/archive"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/events"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/boltdb/bolt"
+	"github.com/go-acme/lego/v3/challenge"
+)
+
+// testCA is an ACME directory that only takes account registrations.
+type testCA struct {
+	mu       sync.Mutex
+	url      string
+	accounts int
+}
+
+func newTestCA() (*testCA, *httptest.Server) {
+	ca := &testCA{}
+	srv := httptest.NewServer(ca)
+	ca.url = srv.URL
+	return ca, srv
+}
+
+func (ca *testCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	w.Header().Set("Replay-Nonce", "nonce")
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.url + "/nonce",
+			"newAccount": ca.url + "/account",
+			"newOrder":   ca.url + "/order",
+		})
+	case "/nonce":
+	case "/account":
+		ca.accounts++
+		w.Header().Set("Location", ca.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status": "valid"}`))
+	default:
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}
+}
+
+var errNoDNS = errors.New("no DNS provider")
+
+type fakeChallenges struct {
+	challenge_config.Service
+}
+
+func (f *fakeChallenges) NewDNSProvider() (challenge.Provider, error) {
+	return nil, errNoDNS
+}
+
+var errSave = errors.New("disk full")
+
+// failingDryRuns is a cert service that can't save dry runs.
+type failingDryRuns struct {
+	certificate.Service
+}
+
+func (f *failingDryRuns) SaveDryRun(c *model.Certificate) error {
+	return errSave
+}
+
+func TestIssueWithoutAccount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "tlsential.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo, err := boltdb.NewCertificateRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certs imported without their keys have no ACME account.
+	pending := &model.Certificate{ID: "pending", Secret: "s1", CommonName: "a.com", Domains: []string{"a.com"}, ACMEEmail: "ops@a.com"}
+	issued := &model.Certificate{ID: "issued", Secret: "s2", CommonName: "b.com", Domains: []string{"b.com"}, ACMEEmail: "ops@b.com",
+		Issued: true, Version: 1}
+	a := &model.Archive{}
+	for _, c := range []*model.Certificate{pending, issued} {
+		a.Certificates = append(a.Certificates, model.NewArchivedCertificate(c, true))
+	}
+	if _, err := archive.Import(&archive.Store{Certs: repo}, a, &archive.ImportOptions{NoPrivateKeys: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, srv := newTestCA()
+	defer srv.Close()
+	s := NewAcmeService(NewCertificateService(repo), &fakeChallenges{}, nil, nil, nil, nil).(*acmeService)
+	s.caDirURL = srv.URL + "/directory"
+
+	c, _ := repo.Cert("issued")
+	if err := s.Revoke(c); err != acme.ErrNoAccount {
+		t.Errorf("expected ErrNoAccount, got %v", err)
+	}
+
+	// Issuing registers an account before getting as far as the DNS
+	// provider, rather than signing with a nil key.
+	s.Trigger("pending")
+	c, _ = repo.Cert("pending")
+	if !c.HasAccount() || ca.accounts != 1 {
+		t.Errorf("expected a new account to be registered and saved, got %d registrations", ca.accounts)
+	}
+
+	// Renewing without the old key is a new issuance.
+	c, _ = repo.Cert("issued")
+	s.Renew(c)
+	c, _ = repo.Cert("issued")
+	if !c.HasAccount() || ca.accounts != 2 {
+		t.Errorf("expected the renewal to register an account, got %d registrations", ca.accounts)
+	}
+
+	// Once registered, the account is reused.
+	s.Trigger("pending")
+	if ca.accounts != 2 {
+		t.Errorf("expected the saved account to be reused, got %d registrations", ca.accounts)
+	}
+}
+
+func TestDryRunImported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "tlsential.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo, err := boltdb.NewCertificateRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveCert(&model.Certificate{ID: "imported", Secret: "s1", CommonName: "a.com", Domains: []string{"a.com"}, Imported: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewAcmeService(NewCertificateService(repo), &fakeChallenges{}, nil, nil, nil, nil)
+	s.DryRun("imported")
+	if c, _ := repo.Cert("imported"); c.DryRun != nil {
+		t.Errorf("expected imported certs not to be dry run, got %+v", c.DryRun)
+	}
+}
+
+func TestDryRunNotSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "tlsential.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo, err := boltdb.NewCertificateRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveCert(&model.Certificate{ID: "cert", Secret: "s1", CommonName: "a.com", Domains: []string{"a.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus()
+	ch, unsubscribe := bus.Subscribe("cert")
+	defer unsubscribe()
+
+	// The failure is reported instead of exiting the process.
+	s := NewAcmeService(&failingDryRuns{NewCertificateService(repo)}, &fakeChallenges{}, nil, nil, nil, bus)
+	s.DryRun("cert")
+	select {
+	case e := <-ch:
+		if e.Type != model.EventFailed || e.Error != errSave.Error() {
+			t.Errorf("expected a failed event for the save error, got %+v", e)
+		}
+	default:
+		t.Error("expected a failed event")
+	}
+}
+
+func TestTriggerAwaitingDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsential-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "tlsential.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo, err := boltdb.NewCertificateRepository(db, envelope.None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = repo.SaveCert(&model.Certificate{ID: "failed", Secret: "s1", CommonName: "a.com", Domains: []string{"a.com"}, ACMEEmail: "ops@a.com",
+		ValidateFirst: true, DryRun: &model.DryRun{Started: now, Finished: now, Error: "staging said no"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, srv := newTestCA()
+	defer srv.Close()
+	s := NewAcmeService(NewCertificateService(repo), &fakeChallenges{}, nil, nil, nil, nil).(*acmeService)
+	s.caDirURL = srv.URL + "/directory"
+
+	// Neither issuing nor renewing goes to production after a failed dry
+	// run.
+	s.Trigger("failed")
+	c, _ := repo.Cert("failed")
+	s.Renew(c)
+	if ca.accounts != 0 {
+		t.Errorf("expected nothing to be ordered, got %d registrations", ca.accounts)
+	}
+}
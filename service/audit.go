@@ -0,0 +1,49 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/model"
+)
+
+type auditService struct {
+	repo  audit.Repository
+	sinks []audit.Sink
+}
+
+// NewAuditService returns a new service object with the associated Repo,
+// copying entries to each of the sinks.
+func NewAuditService(r audit.Repository, sinks ...audit.Sink) audit.Service {
+	return &auditService{r, sinks}
+}
+
+// Record stores e and writes it to the sinks.
+func (s *auditService) Record(e *model.AuditEntry) {
+	err := s.repo.Append(e)
+	if err != nil {
+		log.Printf("service: audit: Append: %s", err.Error())
+	}
+
+	for _, sink := range s.sinks {
+		err = sink.Write(e)
+		if err != nil {
+			log.Printf("service: audit: sink: %s", err.Error())
+		}
+	}
+}
+
+// Entries returns matching entries, newest first.
+func (s *auditService) Entries(q *model.AuditQuery) ([]*model.AuditEntry, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Entries(q)
+}
+
+// Prune removes entries recorded before the cutoff.
+func (s *auditService) Prune(before time.Time) error {
+	return s.repo.Prune(before)
+}
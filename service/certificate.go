@@ -19,6 +19,20 @@ func (cs *certService) AllCerts() ([]*model.Certificate, error) {
 	return cs.cr.AllCerts()
 }
 
+// QueryCerts returns one page of the certs matching q.
+func (cs *certService) QueryCerts(q *model.CertificateQuery) (*model.CertificatePage, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cs.cr.QueryCerts(q)
+}
+
+// CertSummaries returns the listing summary of every cert.
+func (cs *certService) CertSummaries() ([]*model.CertificateSummary, error) {
+	return cs.cr.CertSummaries()
+}
+
 // Cert takes an id and returns their whole cert object.
 func (cs *certService) Cert(id string) (*model.Certificate, error) {
 	return cs.cr.Cert(id)
@@ -29,6 +43,41 @@ func (cs *certService) SaveCert(c *model.Certificate) error {
 	return cs.cr.SaveCert(c)
 }
 
+// SaveEndpoints persists c's endpoint probe results.
+func (cs *certService) SaveEndpoints(c *model.Certificate) error {
+	return cs.cr.SaveEndpoints(c)
+}
+
+// SaveDryRun persists the outcome of c's latest dry run.
+func (cs *certService) SaveDryRun(c *model.Certificate) error {
+	return cs.cr.SaveDryRun(c)
+}
+
+// SaveSecret rotates c's one time secret from old.
+func (cs *certService) SaveSecret(c *model.Certificate, old string) error {
+	return cs.cr.SaveSecret(c, old)
+}
+
+// SaveInstall records an install report for c.
+func (cs *certService) SaveInstall(c *model.Certificate, i *model.Install) error {
+	return cs.cr.SaveInstall(c, i)
+}
+
+// SaveInstallTokens persists c's install tokens.
+func (cs *certService) SaveInstallTokens(c *model.Certificate) error {
+	return cs.cr.SaveInstallTokens(c)
+}
+
+// SaveSettings persists the fields of c that users edit.
+func (cs *certService) SaveSettings(c *model.Certificate) error {
+	return cs.cr.SaveSettings(c)
+}
+
+// SaveIssuance persists the outcome of an ACME operation on c.
+func (cs *certService) SaveIssuance(c *model.Certificate) error {
+	return cs.cr.SaveIssuance(c)
+}
+
 // DeleteCert removes any saved Cert object matching the id
 func (cs *certService) DeleteCert(id string) error {
 	return cs.cr.DeleteCert(id)
@@ -4,8 +4,10 @@ import (
 	"time"
 
 	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/metrics"
 	"github.com/ImageWare/TLSential/model"
 	"github.com/go-acme/lego/v3/challenge"
+	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/providers/dns/cloudflare"
 )
 
@@ -18,6 +20,22 @@ func NewChallengeConfigService(r challenge_config.Repository) challenge_config.S
 }
 
 func (s *challengeConfigService) NewDNSProvider() (challenge.Provider, error) {
+	p, err := s.newDNSProvider()
+	if err != nil {
+		metrics.DNSProviderErrors.WithLabelValues("setup").Inc()
+		return nil, err
+	}
+	return &countingProvider{p}, nil
+}
+
+// CheckDNSProvider builds a provider without counting failures in the
+// metrics, since nothing is being issued.
+func (s *challengeConfigService) CheckDNSProvider() error {
+	_, err := s.newDNSProvider()
+	return err
+}
+
+func (s *challengeConfigService) newDNSProvider() (challenge.Provider, error) {
 	cfConfig := cloudflare.NewDefaultConfig()
 
 	cfConfig.PropagationTimeout = time.Minute * 10
@@ -43,6 +61,36 @@ func (s *challengeConfigService) NewDNSProvider() (challenge.Provider, error) {
 	return dnsChallenge, nil
 }
 
+// countingProvider counts DNS provider errors in the metrics.
+type countingProvider struct {
+	challenge.Provider
+}
+
+func (p *countingProvider) Present(domain, token, keyAuth string) error {
+	err := p.Provider.Present(domain, token, keyAuth)
+	if err != nil {
+		metrics.DNSProviderErrors.WithLabelValues("present").Inc()
+	}
+	return err
+}
+
+func (p *countingProvider) CleanUp(domain, token, keyAuth string) error {
+	err := p.Provider.CleanUp(domain, token, keyAuth)
+	if err != nil {
+		metrics.DNSProviderErrors.WithLabelValues("cleanup").Inc()
+	}
+	return err
+}
+
+// Timeout passes through the wrapped provider's propagation timeout, since
+// lego only looks for it on the outer type.
+func (p *countingProvider) Timeout() (timeout, interval time.Duration) {
+	if t, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return t.Timeout()
+	}
+	return dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+}
+
 func (s *challengeConfigService) Auth() (*model.ChallengeConfig, error) {
 	email, err := s.repo.AuthEmail()
 	if err != nil {
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	cert "github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// DeployMaxAttempts is how many times a push is tried before the target is
+// marked failed.
+const DeployMaxAttempts = 8
+
+// The first retry waits deployBaseBackoff, doubling after every failure up
+// to deployMaxBackoff.
+const deployBaseBackoff = time.Minute
+const deployMaxBackoff = time.Hour
+
+// deploying holds the targets being pushed to. It's shared because the API,
+// UI and background loop each have their own service.
+var deploying = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+type deployService struct {
+	repo    deploy.Repository
+	certs   cert.Service
+	pushers map[string]deploy.Pusher
+}
+
+// NewDeployService returns a new service object with the associated Repo.
+// pushers maps each target type to what pushes to it.
+func NewDeployService(r deploy.Repository, cs cert.Service, pushers map[string]deploy.Pusher) deploy.Service {
+	return &deployService{repo: r, certs: cs, pushers: pushers}
+}
+
+// Targets returns the deploy targets for a cert.
+func (s *deployService) Targets(certID string) ([]*model.DeployTarget, error) {
+	return s.repo.Targets(certID)
+}
+
+// Target takes an id and returns the matching deploy target.
+func (s *deployService) Target(id string) (*model.DeployTarget, error) {
+	return s.repo.Target(id)
+}
+
+// SaveTarget validates and persists a deploy target.
+func (s *deployService) SaveTarget(t *model.DeployTarget) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	return s.repo.SaveTarget(t)
+}
+
+// DeleteTarget removes a deploy target.
+func (s *deployService) DeleteTarget(id string) error {
+	return s.repo.DeleteTarget(id)
+}
+
+// Schedule marks every target of c pending for its current version. Nothing
+// is pushed until DeployDue runs.
+func (s *deployService) Schedule(c *model.Certificate) error {
+	targets, err := s.repo.Targets(c.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		t.Schedule(c.Version)
+		err = s.repo.SaveTarget(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deploy queues the cert's current version for the target, starting its
+// attempts over, and wakes the deploy worker rather than waiting for its next
+// run. The push happens in the background; the returned target is pending.
+func (s *deployService) Deploy(id string) (*model.DeployTarget, error) {
+	if !claimTarget(id) {
+		return nil, deploy.ErrBusy
+	}
+	t, err := s.schedule(id)
+	releaseTarget(id)
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	go s.DeployDue()
+	return t, nil
+}
+
+func (s *deployService) schedule(id string) (*model.DeployTarget, error) {
+	t, err := s.repo.Target(id)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	c, err := s.certs.Cert(t.CertID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, cert.ErrCertNotFound
+	}
+	if c.Version == 0 {
+		return nil, errors.New("certificate hasn't been issued yet")
+	}
+
+	t.Schedule(c.Version)
+	err = s.repo.SaveTarget(t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DeployDue pushes to every due target concurrently and records the outcome.
+// Targets already being pushed to are left for the next run.
+func (s *deployService) DeployDue() {
+	due, err := s.repo.DueTargets(time.Now())
+	if err != nil {
+		log.Printf("service: deploy: DeployDue: %s", err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range due {
+		if !claimTarget(t.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func(t *model.DeployTarget) {
+			defer wg.Done()
+			defer releaseTarget(t.ID)
+			s.attempt(t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (s *deployService) attempt(t *model.DeployTarget) {
+	c, err := s.certs.Cert(t.CertID)
+	if err != nil {
+		log.Printf("service: deploy: attempt: %s", err.Error())
+		return
+	}
+	if c == nil {
+		// The cert was deleted, so its targets have nothing left to push.
+		err = s.repo.DeleteTarget(t.ID)
+		if err != nil {
+			log.Printf("service: deploy: DeleteTarget: %s", err.Error())
+		}
+		return
+	}
+	// A renewal while retrying means the newer version is what's wanted.
+	if c.Version > t.Version {
+		t.Version = c.Version
+	}
+
+	err = s.push(t, c)
+	if err != nil {
+		log.Printf("service: deploy: SaveTarget: %s", err.Error())
+	}
+}
+
+// push tries t once with c, then saves how it went.
+func (s *deployService) push(t *model.DeployTarget, c *model.Certificate) error {
+	t.Attempts++
+	t.LastAttempt = time.Now()
+
+	var err error
+	p := s.pushers[t.Type]
+	if p == nil {
+		err = fmt.Errorf("no way to push to %s targets", t.Type)
+		t.Output = ""
+	} else {
+		t.Output, err = p.Push(t, c)
+	}
+
+	if err == nil {
+		t.Status = model.DeployDeployed
+		t.DeployedVersion = t.Version
+		t.LastError = ""
+		t.Drift = ""
+	} else {
+		t.LastError = err.Error()
+		if t.Attempts >= DeployMaxAttempts {
+			t.Status = model.DeployFailed
+			log.Printf("service: deploy: giving up on target '%s' of cert '%s' after %d attempts: %s", t.ID, t.CertID, t.Attempts, err.Error())
+		} else {
+			t.NextAttempt = time.Now().Add(deployBackoff(t.Attempts))
+		}
+	}
+	return s.repo.SaveTarget(t)
+}
+
+// CheckDrift compares every deployed target that can be checked with the
+// version pushed to it. Drift is only reported, as pushing again could undo a
+// deliberate change.
+func (s *deployService) CheckDrift() {
+	targets, err := s.repo.DeployedTargets()
+	if err != nil {
+		log.Printf("service: deploy: CheckDrift: %s", err.Error())
+		return
+	}
+	for _, t := range targets {
+		ch, ok := s.pushers[t.Type].(deploy.Checker)
+		if !ok || !claimTarget(t.ID) {
+			continue
+		}
+		s.check(ch, t.ID)
+		releaseTarget(t.ID)
+	}
+}
+
+func (s *deployService) check(ch deploy.Checker, id string) {
+	// Reread the target now it's claimed, in case a push just finished.
+	t, err := s.repo.Target(id)
+	if err != nil || t == nil || t.Status != model.DeployDeployed {
+		return
+	}
+	c, err := s.certs.Cert(t.CertID)
+	if err != nil || c == nil {
+		return
+	}
+	// The target is about to be sent a newer version anyway.
+	if c.Version != t.DeployedVersion {
+		return
+	}
+
+	drift, err := ch.Check(t, c)
+	if err != nil {
+		log.Printf("service: deploy: checking target '%s' of cert '%s': %s", t.ID, t.CertID, err.Error())
+		return
+	}
+	if drift != "" && t.Drift == "" {
+		log.Printf("service: deploy: target '%s' of cert '%s' has drifted: %s", t.ID, t.CertID, drift)
+	}
+	t.Drift = drift
+	t.DriftChecked = time.Now()
+	err = s.repo.SaveTarget(t)
+	if err != nil {
+		log.Printf("service: deploy: SaveTarget: %s", err.Error())
+	}
+}
+
+// claimTarget marks a target busy, returning false if it already was.
+func claimTarget(id string) bool {
+	deploying.Lock()
+	defer deploying.Unlock()
+	if deploying.ids[id] {
+		return false
+	}
+	deploying.ids[id] = true
+	return true
+}
+
+func releaseTarget(id string) {
+	deploying.Lock()
+	defer deploying.Unlock()
+	delete(deploying.ids, id)
+}
+
+// deployBackoff returns how long to wait after the given number of failed
+// attempts.
+func deployBackoff(attempts int) time.Duration {
+	wait := deployBaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= deployMaxBackoff {
+			return deployMaxBackoff
+		}
+	}
+	return wait
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/notify"
+)
+
+// ErrNotifierNotFound is returned when testing a notifier that doesn't exist.
+var ErrNotifierNotFound = errors.New("notifier not found")
+
+type notifierService struct {
+	repo notifier.Repository
+	// sending tracks deliveries still in flight.
+	sending sync.WaitGroup
+}
+
+// NewNotifierService returns a new service object with the associated Repo.
+func NewNotifierService(r notifier.Repository) notifier.Service {
+	return &notifierService{repo: r}
+}
+
+// AllNotifiers returns every configured notifier.
+func (s *notifierService) AllNotifiers() ([]*model.Notifier, error) {
+	return s.repo.AllNotifiers()
+}
+
+// Notifier takes an id and returns the matching notifier.
+func (s *notifierService) Notifier(id string) (*model.Notifier, error) {
+	return s.repo.Notifier(id)
+}
+
+// SaveNotifier validates and persists a notifier.
+func (s *notifierService) SaveNotifier(n *model.Notifier) error {
+	err := n.Validate()
+	if err != nil {
+		return err
+	}
+	return s.repo.SaveNotifier(n)
+}
+
+// DeleteNotifier removes the notifier matching the id.
+func (s *notifierService) DeleteNotifier(id string) error {
+	return s.repo.DeleteNotifier(id)
+}
+
+// Notify sends e to every notifier that wants it. Deliveries happen in the
+// background so a slow mail server can't hold up issuance; failures are only
+// logged.
+func (s *notifierService) Notify(e *model.Event) {
+	notifiers, err := s.repo.AllNotifiers()
+	if err != nil {
+		log.Printf("service: notifier: Notify: %s", err.Error())
+		return
+	}
+
+	for _, n := range notifiers {
+		if !n.Wants(e) {
+			continue
+		}
+		s.sending.Add(1)
+		go func(n *model.Notifier) {
+			defer s.sending.Done()
+			err := send(n, e)
+			if err != nil {
+				log.Printf("service: notifier: failed to send %s event for cert '%s' to notifier '%s': %s", e.Type, e.CertID, n.Name, err.Error())
+			}
+		}(n)
+	}
+}
+
+// Wait blocks until the deliveries started by Notify have finished.
+func (s *notifierService) Wait() {
+	s.sending.Wait()
+}
+
+// Test sends a test event to the notifier with the given id.
+func (s *notifierService) Test(id string) error {
+	n, err := s.repo.Notifier(id)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return ErrNotifierNotFound
+	}
+
+	e := &model.Event{
+		Type:    model.EventTest,
+		Time:    time.Now(),
+		Message: "This is a test notification from TLSential for notifier " + n.Name + ".",
+	}
+	return send(n, e)
+}
+
+func send(n *model.Notifier, e *model.Event) error {
+	sender, err := notify.New(n)
+	if err != nil {
+		return err
+	}
+	return sender.Send(e)
+}
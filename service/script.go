@@ -0,0 +1,80 @@
+package service
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/deployscript"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/script"
+)
+
+type scriptService struct {
+	repo script.Repository
+	// baseURL is what rendered scripts call back to. Nothing is rendered
+	// without it.
+	baseURL string
+}
+
+// NewScriptService returns a new service object with the associated Repo.
+func NewScriptService(r script.Repository, baseURL string) script.Service {
+	return &scriptService{r, baseURL}
+}
+
+// AllScripts returns every script template.
+func (s *scriptService) AllScripts() ([]*model.ScriptTemplate, error) {
+	return s.repo.AllScripts()
+}
+
+// Script takes an id and returns the matching script template.
+func (s *scriptService) Script(id string) (*model.ScriptTemplate, error) {
+	return s.repo.Script(id)
+}
+
+// SaveScript validates and persists a script template.
+func (s *scriptService) SaveScript(st *model.ScriptTemplate) error {
+	err := st.Validate()
+	if err != nil {
+		return err
+	}
+	err = deployscript.Check(st.Body)
+	if err != nil {
+		return &script.BodyError{Err: err}
+	}
+	st.ModTime = time.Now()
+	return s.repo.SaveScript(st)
+}
+
+// DeleteScript removes the script template matching the id.
+func (s *scriptService) DeleteScript(id string) error {
+	return s.repo.DeleteScript(id)
+}
+
+// SeedBuiltins stores the built in templates on first start.
+func (s *scriptService) SeedBuiltins() error {
+	all, err := s.repo.AllScripts()
+	if err != nil || len(all) > 0 {
+		return err
+	}
+	for _, st := range deployscript.Builtins() {
+		err = s.SaveScript(st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render returns the script with id for c, authenticating with token.
+func (s *scriptService) Render(id string, c *model.Certificate, token string) ([]byte, error) {
+	if s.baseURL == "" {
+		return nil, script.ErrNoBaseURL
+	}
+	st, err := s.repo.Script(id)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, script.ErrScriptNotFound
+	}
+	return deployscript.Render(st, deployscript.NewData(st, c, s.baseURL, token))
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/webhook"
+)
+
+// WebhookMaxAttempts is how many times a delivery is tried before it's marked
+// failed.
+const WebhookMaxAttempts = 10
+
+// The first retry waits webhookBaseBackoff, doubling after every failure up
+// to webhookMaxBackoff.
+const webhookBaseBackoff = 30 * time.Second
+const webhookMaxBackoff = time.Hour
+
+// Finished deliveries are kept this long for the history API.
+const webhookHistory = 30 * 24 * time.Hour
+
+// webhookConcurrency bounds how many deliveries are sent at once, so a
+// backlog built up while a receiver was down doesn't all go out together.
+const webhookConcurrency = 8
+
+type webhookService struct {
+	repo    webhook.Repository
+	baseURL string
+	client  *http.Client
+}
+
+// NewWebhookService returns a new service object with the associated Repo.
+// baseURL is the externally reachable address of this server, used to build
+// download links in payloads; they're left out if it's empty, since a
+// receiver can't resolve a relative link.
+func NewWebhookService(r webhook.Repository, baseURL string) webhook.Service {
+	return &webhookService{r, baseURL, &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Webhooks returns the subscriptions for a cert.
+func (s *webhookService) Webhooks(certID string) ([]*model.Webhook, error) {
+	return s.repo.Webhooks(certID)
+}
+
+// Webhook takes an id and returns the matching subscription.
+func (s *webhookService) Webhook(id string) (*model.Webhook, error) {
+	return s.repo.Webhook(id)
+}
+
+// SaveWebhook persists a subscription.
+func (s *webhookService) SaveWebhook(w *model.Webhook) error {
+	return s.repo.SaveWebhook(w)
+}
+
+// DeleteWebhook removes a subscription and its history.
+func (s *webhookService) DeleteWebhook(id string) error {
+	return s.repo.DeleteWebhook(id)
+}
+
+// Deliveries returns a subscription's delivery history, newest first.
+func (s *webhookService) Deliveries(webhookID string) ([]*model.Delivery, error) {
+	return s.repo.Deliveries(webhookID)
+}
+
+// Enqueue writes a delivery to the outbox for every subscription of c that
+// wants the event. Nothing is sent until DeliverDue runs.
+func (s *webhookService) Enqueue(event string, c *model.Certificate) error {
+	hooks, err := s.repo.Webhooks(c.ID)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(s.payload(event, c))
+	if err != nil {
+		return err
+	}
+
+	for _, w := range hooks {
+		if !w.Wants(event) {
+			continue
+		}
+		err = s.repo.SaveDelivery(model.NewDelivery(w, event, payload))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *webhookService) payload(event string, c *model.Certificate) *model.WebhookPayload {
+	p := &model.WebhookPayload{
+		Event:      event,
+		ID:         c.ID,
+		Version:    c.Version,
+		CommonName: c.CommonName,
+		Domains:    c.Domains,
+		Expiry:     c.Expiry,
+	}
+	if s.baseURL != "" {
+		base := fmt.Sprintf("%s/api/certificate/%s", s.baseURL, c.ID)
+		p.CertURL = base + "/cert"
+		p.IssuerURL = base + "/issuer"
+		p.PrivkeyURL = base + "/privkey"
+		p.BundleURL = base + "/bundle"
+	}
+	if d, err := c.Details(); err == nil && d != nil {
+		p.Serial = d.SerialNumber
+	}
+	return p
+}
+
+// DeliverDue sends every due delivery, webhookConcurrency at a time, and
+// records the outcome. Old finished deliveries are pruned afterwards.
+func (s *webhookService) DeliverDue() {
+	due, err := s.repo.DueDeliveries(time.Now())
+	if err != nil {
+		log.Printf("service: webhook: DeliverDue: %s", err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, d := range due {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(d *model.Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.attempt(d)
+		}(d)
+	}
+	wg.Wait()
+
+	err = s.repo.PruneDeliveries(time.Now().Add(-webhookHistory))
+	if err != nil {
+		log.Printf("service: webhook: PruneDeliveries: %s", err.Error())
+	}
+}
+
+func (s *webhookService) attempt(d *model.Delivery) {
+	w, err := s.repo.Webhook(d.WebhookID)
+	if err != nil {
+		log.Printf("service: webhook: attempt: %s", err.Error())
+		return
+	}
+	if w == nil {
+		// The subscription was deleted between listing and sending.
+		return
+	}
+
+	d.Attempts++
+	d.LastStatusCode, err = s.post(w, d)
+	now := time.Now()
+	if err == nil {
+		d.Status = model.DeliveryDelivered
+		d.LastError = ""
+		d.Finished = now
+	} else {
+		d.LastError = err.Error()
+		if d.Attempts >= WebhookMaxAttempts {
+			d.Status = model.DeliveryFailed
+			d.Finished = now
+			log.Printf("service: webhook: giving up on delivery '%s' to %s after %d attempts: %s", d.ID, w.URL, d.Attempts, err.Error())
+		} else {
+			d.NextAttempt = now.Add(webhookBackoff(d.Attempts))
+		}
+	}
+
+	err = s.repo.SaveDelivery(d)
+	if err != nil {
+		log.Printf("service: webhook: SaveDelivery: %s", err.Error())
+	}
+}
+
+// post sends a signed delivery and returns the response status code.
+func (s *webhookService) post(w *model.Webhook, d *model.Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(model.WebhookEventHeader, d.Event)
+	req.Header.Set(model.WebhookDeliveryHeader, d.ID)
+	req.Header.Set(model.WebhookTimestampHeader, fmt.Sprintf("%d", now.Unix()))
+	req.Header.Set(model.WebhookSignatureHeader, model.SignWebhook(w.Secret, now, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff returns how long to wait after the given number of failed
+// attempts.
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return wait
+}
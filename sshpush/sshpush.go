@@ -0,0 +1,226 @@
+// Package sshpush pushes certificates to hosts without an agent, writing
+// files over SFTP and then running a command such as a reload.
+package sshpush
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHosts is the file in the key directory checked for host keys when a
+// target doesn't pin one.
+const KnownHosts = "known_hosts"
+
+const dialTimeout = 15 * time.Second
+
+// commandTimeout bounds the post-install command.
+const commandTimeout = 2 * time.Minute
+
+// maxOutput is how much of the end of the command's output is kept.
+const maxOutput = 4 << 10
+
+type pusher struct {
+	keyDir string
+}
+
+// New returns a Pusher for ssh targets, reading private keys and known_hosts
+// from keyDir.
+func New(keyDir string) deploy.Pusher {
+	return &pusher{keyDir}
+}
+
+// Push writes every file of t atomically, then runs its command.
+func (p *pusher) Push(t *model.DeployTarget, c *model.Certificate) (string, error) {
+	if t.Type != model.DeployTargetSSH || t.SSH == nil {
+		return "", model.ErrInvalidDeployTarget
+	}
+	st := t.SSH
+
+	config, err := p.clientConfig(st)
+	if err != nil {
+		return "", err
+	}
+	addr := st.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return "", fmt.Errorf("starting sftp: %s", err)
+	}
+	b := model.NewInstallBundle(c)
+	for _, f := range st.Files {
+		mode, err := f.FileMode()
+		if err != nil {
+			client.Close()
+			return "", err
+		}
+		err = writeFile(client, f.Path, b.Contents(f.Contents), os.FileMode(mode))
+		if err != nil {
+			client.Close()
+			return "", err
+		}
+	}
+	client.Close()
+
+	if st.Command == "" {
+		return "", nil
+	}
+	return run(conn, st.Command)
+}
+
+func (p *pusher) clientConfig(st *model.SSHTarget) (*ssh.ClientConfig, error) {
+	key, err := ioutil.ReadFile(filepath.Join(p.keyDir, filepath.Base(st.Key)))
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", st.Key, err)
+	}
+
+	var hostKey ssh.HostKeyCallback
+	if st.HostKey != "" {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(st.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("host key: %s", err)
+		}
+		hostKey = ssh.FixedHostKey(pub)
+	} else {
+		hostKey, err = knownhosts.New(filepath.Join(p.keyDir, KnownHosts))
+		if os.IsNotExist(err) {
+			return nil, errors.New("no host key set and no known_hosts in the deploy key directory")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            st.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// writeFile replaces path with data. It writes to a temporary file next to
+// it first, so the old file stays whole until the rename. The temporary file
+// gets its mode before anything is written, so a private key is never
+// readable by anyone it shouldn't be, and it's removed on any error.
+func writeFile(client *sftp.Client, path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tlsential-new"
+	// A file left by an earlier failure may have a looser mode or another
+	// owner, so start afresh.
+	err := client.Remove(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %s", tmp, err)
+	}
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("%s: %s", tmp, err)
+	}
+	// The server creates it with its default mode and umask.
+	err = f.Chmod(mode)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = rename(client, tmp, path)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// rename moves oldpath over newpath. Plain SFTP renames fail if newpath
+// exists, so without OpenSSH's extension it's removed first.
+func rename(client *sftp.Client, oldpath, newpath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldpath, newpath)
+	}
+	err := client.Remove(newpath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return client.Rename(oldpath, newpath)
+}
+
+// run runs cmd in its own session, returning the end of its combined output.
+func run(conn *ssh.Client, cmd string) (string, error) {
+	s, err := conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	var out tail
+	s.Stdout = &out
+	s.Stderr = &out
+	err = s.Start(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(commandTimeout):
+		s.Close()
+		err = fmt.Errorf("command timed out after %s", commandTimeout)
+	}
+	return out.String(), err
+}
+
+// tail keeps the last maxOutput bytes written to it. Stdout and stderr are
+// copied concurrently, so it locks.
+type tail struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	t.buf.Write(p)
+	if t.buf.Len() > maxOutput {
+		b := t.buf.Bytes()
+		keep := append([]byte(nil), b[len(b)-maxOutput:]...)
+		t.buf.Reset()
+		t.buf.Write(keep)
+	}
+	return n, nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.String()
+}
@@ -0,0 +1,342 @@
+package sshpush
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ImageWare/TLSential/deploy/deploytest"
+	"github.com/ImageWare/TLSential/model"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an SSH server with just enough SFTP and exec to be pushed
+// to. Absolute paths are taken relative to root, and commands run there.
+type testServer struct {
+	root    string
+	addr    string
+	hostKey ssh.PublicKey
+
+	mu sync.Mutex
+	// writeModes is the mode each file had when it was first written to.
+	writeModes map[string]os.FileMode
+}
+
+func newTestServer(t *testing.T, root string, client ssh.PublicKey) *testServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(client.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{root: root, addr: l.Addr().String(), hostKey: signer.PublicKey(), writeModes: make(map[string]os.FileMode)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(c net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(ch, reqs)
+	}
+}
+
+func (s *testServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		var arg struct{ Value string }
+		ssh.Unmarshal(req.Payload, &arg)
+		switch {
+		case req.Type == "subsystem" && arg.Value == "sftp":
+			req.Reply(true, nil)
+			s.sftp(ch)
+			return
+		case req.Type == "exec":
+			req.Reply(true, nil)
+			cmd := exec.Command("sh", "-c", arg.Value)
+			cmd.Dir = s.root
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			status := 0
+			if err := cmd.Run(); err != nil {
+				status = 1
+			}
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testServer) path(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(p))
+}
+
+func (s *testServer) sftp(ch ssh.Channel) {
+	h := &testHandlers{s}
+	srv := sftp.NewRequestServer(ch, sftp.Handlers{FilePut: h, FileCmd: h})
+	srv.Serve()
+	srv.Close()
+}
+
+// testHandlers handles only the SFTP requests a push makes.
+type testHandlers struct {
+	s *testServer
+}
+
+func (h *testHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if r.Pflags().Excl {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(h.s.path(r.Filepath), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &modeWriter{f, h.s, r.Filepath, false}, nil
+}
+
+// modeWriter records the file's mode when it's first written to.
+type modeWriter struct {
+	*os.File
+	s       *testServer
+	path    string
+	written bool
+}
+
+func (w *modeWriter) WriteAt(b []byte, off int64) (int, error) {
+	if !w.written {
+		w.written = true
+		if fi, err := w.Stat(); err == nil {
+			w.s.mu.Lock()
+			w.s.writeModes[w.path] = fi.Mode().Perm()
+			w.s.mu.Unlock()
+		}
+	}
+	return w.File.WriteAt(b, off)
+}
+
+func (h *testHandlers) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		if !r.AttrFlags().Permissions {
+			return nil
+		}
+		return os.Chmod(h.s.path(r.Filepath), r.Attributes().FileMode().Perm())
+	case "Remove", "Rmdir":
+		return os.Remove(h.s.path(r.Filepath))
+	case "Rename":
+		if _, err := os.Stat(h.s.path(r.Target)); err == nil {
+			return os.ErrExist
+		}
+		return os.Rename(h.s.path(r.Filepath), h.s.path(r.Target))
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *testHandlers) PosixRename(r *sftp.Request) error {
+	return os.Rename(h.s.path(r.Filepath), h.s.path(r.Target))
+}
+
+// setup returns a key directory holding a client key, and the key's public
+// half.
+func setup(t *testing.T) (string, ssh.PublicKey) {
+	dir, err := ioutil.TempDir("", "tlsential-sshpush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, "id_test"), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "root/etc/ssl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, sshPub
+}
+
+func target(s *testServer) *model.DeployTarget {
+	t := model.NewDeployTarget("cert1")
+	t.Type = model.DeployTargetSSH
+	t.SSH = &model.SSHTarget{
+		Host:    s.addr,
+		User:    "deploy",
+		Key:     "id_test",
+		HostKey: string(ssh.MarshalAuthorizedKey(s.hostKey)),
+		Files: []*model.DeployFile{
+			{Path: "/etc/ssl/fullchain.pem", Contents: model.ContentsFullChain},
+			{Path: "/etc/ssl/privkey.pem", Contents: model.ContentsKey},
+			{Path: "/etc/ssl/chain.pem", Contents: model.ContentsChain, Mode: "0640"},
+		},
+		Command: "cat etc/ssl/chain.pem; echo reloaded >&2",
+	}
+	return t
+}
+
+func TestPush(t *testing.T) {
+	// The server's extensions are package state in pkg/sftp.
+	defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+	for _, posix := range []bool{true, false} {
+		if !posix {
+			sftp.SetSFTPExtensions("statvfs@openssh.com")
+		}
+		dir, client := setup(t)
+		defer os.RemoveAll(dir)
+		root := filepath.Join(dir, "root")
+		s := newTestServer(t, root, client)
+		p := New(dir)
+		c := deploytest.Cert()
+		tg := target(s)
+
+		// A temporary file left behind by a failed push, readable by
+		// anyone, is replaced rather than reused.
+		stale := s.path("/etc/ssl/privkey.pem.tlsential-new")
+		if err := ioutil.WriteFile(stale, []byte("old"), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		// Push twice so the second replaces existing files.
+		for i := 0; i < 2; i++ {
+			out, err := p.Push(tg, c)
+			if err != nil {
+				t.Fatalf("posix %v: %s", posix, err)
+			}
+			if !strings.Contains(out, "issuer") || !strings.Contains(out, "reloaded") {
+				t.Errorf("posix %v: expected the command's output, got %q", posix, out)
+			}
+		}
+
+		b := model.NewInstallBundle(c)
+		for _, f := range tg.SSH.Files {
+			path := s.path(f.Path)
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(b.Contents(f.Contents)) {
+				t.Errorf("%s: unexpected contents %q", f.Path, got)
+			}
+			want, _ := f.FileMode()
+			if fi, _ := os.Stat(path); uint32(fi.Mode().Perm()) != want {
+				t.Errorf("%s: expected mode %o, got %o", f.Path, want, fi.Mode().Perm())
+			}
+			s.mu.Lock()
+			written, ok := s.writeModes[f.Path+".tlsential-new"]
+			s.mu.Unlock()
+			if !ok || uint32(written) != want {
+				t.Errorf("%s: expected mode %o before anything was written, got %o", f.Path, want, written)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(root, "etc/ssl/*.tlsential-new")); len(matches) > 0 {
+			t.Errorf("expected no temporary files, found %v", matches)
+		}
+	}
+}
+
+func TestPushFailures(t *testing.T) {
+	dir, client := setup(t)
+	defer os.RemoveAll(dir)
+	s := newTestServer(t, filepath.Join(dir, "root"), client)
+	p := New(dir)
+
+	tg := target(s)
+	tg.SSH.Command = "echo broken; exit 1"
+	out, err := p.Push(tg, deploytest.Cert())
+	if err == nil || !strings.Contains(out, "broken") {
+		t.Errorf("expected the command to fail with its output, got %q, %v", out, err)
+	}
+
+	// A different pinned host key is refused before anything is written.
+	other := newTestServer(t, filepath.Join(dir, "root"), client)
+	tg = target(s)
+	tg.SSH.HostKey = string(ssh.MarshalAuthorizedKey(other.hostKey))
+	tg.SSH.Files[0].Path = "/etc/ssl/never.pem"
+	if _, err := p.Push(tg, deploytest.Cert()); err == nil {
+		t.Error("expected a host key mismatch to fail")
+	}
+	if _, err := os.Stat(s.path("/etc/ssl/never.pem")); err == nil {
+		t.Error("expected nothing to be written")
+	}
+
+	// Without a pinned key the host must be in known_hosts.
+	tg = target(s)
+	tg.SSH.HostKey = ""
+	if _, err := p.Push(tg, deploytest.Cert()); err == nil {
+		t.Error("expected a push without known_hosts to fail")
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, KnownHosts), []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tg.SSH.Command = ""
+	if _, err := p.Push(tg, deploytest.Cert()); err != nil {
+		t.Errorf("expected known_hosts to be used, got %s", err)
+	}
+
+	// A directory that doesn't exist fails the write.
+	tg.SSH.Files[0].Path = "/missing/cert.pem"
+	if _, err := p.Push(tg, deploytest.Cert()); err == nil {
+		t.Error("expected writing to a missing directory to fail")
+	}
+}
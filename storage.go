@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ImageWare/TLSential/archive"
+	"github.com/ImageWare/TLSential/audit"
+	"github.com/ImageWare/TLSential/backup"
+	"github.com/ImageWare/TLSential/certificate"
+	"github.com/ImageWare/TLSential/challenge_config"
+	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/envelope"
+	"github.com/ImageWare/TLSential/health"
+	"github.com/ImageWare/TLSential/notifier"
+	"github.com/ImageWare/TLSential/repository/boltdb"
+	"github.com/ImageWare/TLSential/repository/sqldb"
+	"github.com/ImageWare/TLSential/script"
+	"github.com/ImageWare/TLSential/user"
+	"github.com/ImageWare/TLSential/webhook"
+	"github.com/boltdb/bolt"
+)
+
+// DriverBolt stores everything in a single bolt file, and is the default.
+const DriverBolt = "bolt"
+
+// masterKeyEnv holds the master keys when no key file is given.
+const masterKeyEnv = "TLSENTIAL_MASTER_KEY"
+
+// loadCipher returns the cipher for secrets at rest, using the master keys in
+// keyFile or else the environment. Without any keys secrets are stored in
+// plaintext.
+func loadCipher(keyFile string) (envelope.Cipher, error) {
+	var kr *envelope.Keyring
+	var err error
+	switch {
+	case keyFile != "":
+		kr, err = envelope.LoadKeyring(keyFile)
+	case os.Getenv(masterKeyEnv) != "":
+		kr, err = envelope.ParseKeyring(os.Getenv(masterKeyEnv))
+	default:
+		return envelope.None, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("master key: %s", err)
+	}
+	return envelope.New(kr), nil
+}
+
+// repositories is every repository the app uses, all backed by the same
+// database.
+type repositories struct {
+	users      user.Repository
+	config     config.Repository
+	challenges challenge_config.Repository
+	certs      certificate.Repository
+	notifiers  notifier.Repository
+	webhooks   webhook.Repository
+	audit      audit.Repository
+	health     health.Repository
+	backup     backup.Repository
+	scripts    script.Repository
+	deploys    deploy.Repository
+
+	close func() error
+}
+
+// openRepositories opens the database for driver, sealing secrets with ci.
+// For bolt dsn is the file name, otherwise it's passed to the SQL driver.
+func openRepositories(driver, dsn string, ci envelope.Cipher) (*repositories, error) {
+	switch driver {
+	case DriverBolt:
+		return openBolt(dsn, ci)
+	case sqldb.DriverSQLite, sqldb.DriverPostgres:
+		db, err := sqldb.Open(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &repositories{
+			users:      sqldb.NewUserRepository(db),
+			config:     sqldb.NewConfigRepository(db, ci),
+			challenges: sqldb.NewChallengeConfigRepository(db, ci),
+			certs:      sqldb.NewCertificateRepository(db, ci),
+			notifiers:  sqldb.NewNotifierRepository(db, ci),
+			webhooks:   sqldb.NewWebhookRepository(db, ci),
+			audit:      sqldb.NewAuditRepository(db),
+			health:     sqldb.NewHealthRepository(db),
+			backup:     sqldb.NewBackupRepository(db),
+			scripts:    sqldb.NewScriptRepository(db),
+			deploys:    sqldb.NewDeployRepository(db),
+			close:      db.Close,
+		}, nil
+	}
+	return nil, sqldb.ErrUnknownDriver
+}
+
+func openBolt(file string, ci envelope.Cipher) (*repositories, error) {
+	db, err := bolt.Open(file, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt DB file lock timeout: %s", err)
+	}
+
+	applied, backup, err := boltdb.Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if backup != "" {
+		log.Printf("Backed up %s to %s before migrating", file, backup)
+	}
+	if applied > 0 {
+		log.Printf("Applied %d bolt schema migrations, now at version %d", applied, boltdb.SchemaVersion)
+	}
+
+	r := &repositories{
+		health: boltdb.NewHealthRepository(db),
+		backup: boltdb.NewBackupRepository(db),
+		close:  db.Close,
+	}
+	if r.users, err = boltdb.NewUserRepository(db); err != nil {
+		return nil, err
+	}
+	if r.config, err = boltdb.NewConfigRepository(db, ci); err != nil {
+		return nil, err
+	}
+	if r.challenges, err = boltdb.NewChallengeConfigRepository(db, ci); err != nil {
+		return nil, err
+	}
+	if r.certs, err = boltdb.NewCertificateRepository(db, ci); err != nil {
+		return nil, err
+	}
+	if r.notifiers, err = boltdb.NewNotifierRepository(db, ci); err != nil {
+		return nil, err
+	}
+	if r.webhooks, err = boltdb.NewWebhookRepository(db, ci); err != nil {
+		return nil, err
+	}
+	if r.audit, err = boltdb.NewAuditRepository(db); err != nil {
+		return nil, err
+	}
+	if r.scripts, err = boltdb.NewScriptRepository(db); err != nil {
+		return nil, err
+	}
+	if r.deploys, err = boltdb.NewDeployRepository(db); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reseal brings every sealed record onto the current master key, encrypting
+// any still in plaintext. It returns the number of records rewritten.
+func (r *repositories) reseal() (int, error) {
+	total := 0
+	for _, repo := range []interface{}{r.config, r.certs, r.challenges, r.notifiers, r.webhooks} {
+		n, err := repo.(envelope.Resealer).Reseal()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// archiveStore returns the repositories that export and import work on.
+func (r *repositories) archiveStore() *archive.Store {
+	return &archive.Store{Certs: r.certs, Users: r.users, Challenges: r.challenges, Config: r.config}
+}
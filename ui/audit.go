@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/ImageWare/TLSential/model"
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Audit is a middleware recording every form submission in the audit log.
+// Pages re-render with a 200 on validation errors, so the outcome of a form
+// only reflects whether it was allowed, not whether it was accepted.
+func (h *uiHandler) Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route, _ = cr.GetPathTemplate()
+		}
+
+		// Read before the handler runs, since logging out clears it.
+		actor, authenticated := h.sessionUser(r)
+
+		rec := &statusRecorder{w, http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// A login is only attributed to the user once it has succeeded.
+		var claimed string
+		if route == "/ui/login" {
+			actor, authenticated = h.sessionUser(r)
+			if !authenticated {
+				actor, claimed = "", r.FormValue("username")
+			}
+		}
+		actorType := model.ActorUser
+		switch {
+		case claimed != "":
+			actorType = model.ActorUnauthenticated
+		case actor == "":
+			actorType = model.ActorAnonymous
+		}
+
+		e := model.NewAuditEntry(actorType, actor, remoteIP(r), r.Method+" "+route, mux.Vars(r)["id"], rec.code)
+		e.ClaimedActor = claimed
+		if !authenticated {
+			e.Outcome = model.OutcomeDenied
+		}
+		h.auditService.Record(e)
+	})
+}
+
+// sessionUser returns the logged in username, if any, and whether the
+// session is authenticated.
+func (h *uiHandler) sessionUser(r *http.Request) (string, bool) {
+	session, err := h.store.Get(r, cookieName)
+	if err != nil {
+		return "", false
+	}
+	auth, _ := session.Values["authenticated"].(bool)
+	name, _ := session.Values["username"].(string)
+	return name, auth
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -3,32 +3,39 @@ package ui
 import (
 	"fmt"
 	"html/template"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/ImageWare/TLSential/model"
+	"github.com/ImageWare/TLSential/probe"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 )
 
 // createCertTemplate holds variables for html template that renders the cert create page.
 type createCertTemplate struct {
-	Domains    string
-	RenewAt    string
-	Email      string
-	CSRFField  template.HTML
-	Validation certValidation
+	Domains       string
+	RenewAt       string
+	Email         string
+	Labels        string
+	Endpoints     string
+	ValidateFirst bool
+	CSRFField     template.HTML
+	Validation    certValidation
 }
 
 // certValidation holds any UI error strings that will need to be rendered if Creation fails.
 type certValidation struct {
-	Domains string
-	RenewAt string
-	Email   string
-	Success string
-	Error   string
+	Domains   string
+	RenewAt   string
+	Email     string
+	Labels    string
+	Endpoints string
+	Success   string
+	Error     string
 }
 
 // Serve /ui/certificate/create page.
@@ -37,8 +44,33 @@ func (h *uiHandler) CreateCertificate() http.HandlerFunc {
 		if r.Method == "POST" {
 			cv := certValidation{}
 
+			renewAt, err := strconv.Atoi(r.FormValue("renewAt"))
+			if err != nil || !model.ValidRenewAt(renewAt) {
+				cv.RenewAt = "Invalid RenewAt value"
+				cv.Error = "Fix invalid fields and try again."
+				h.renderCreateCertificate(w, r, cv)
+				return
+			}
+			labels, err := model.ParseLabels(r.FormValue("labels"))
+			if err == nil && !model.ValidLabels(labels) {
+				err = model.ErrInvalidLabels
+			}
+			if err != nil {
+				cv.Labels = err.Error()
+				cv.Error = "Fix invalid fields and try again."
+				h.renderCreateCertificate(w, r, cv)
+				return
+			}
+			endpoints, err := model.ParseEndpoints(r.FormValue("endpoints"))
+			if err != nil {
+				cv.Endpoints = err.Error()
+				cv.Error = "Fix invalid fields and try again."
+				h.renderCreateCertificate(w, r, cv)
+				return
+			}
 			domains := strings.Split(r.FormValue("domains"), ",")
 			email := r.FormValue("email")
+			// NewCertificate registers an ACME account, so it goes last.
 			cert, err := model.NewCertificate(domains, email)
 			if err != nil {
 				if err == model.ErrInvalidDomains {
@@ -53,16 +85,15 @@ func (h *uiHandler) CreateCertificate() http.HandlerFunc {
 					h.renderCreateCertificate(w, r, cv)
 					return
 				}
-			}
-
-			renewAt, err := strconv.Atoi(r.FormValue("renewAt"))
-			if err != nil {
-				cv.RenewAt = "Invalid RenewAt value"
-				cv.Error = "Fix invalid fields and try again."
-				h.renderCreateCertificate(w, r, cv)
+				log.Print(err.Error())
+				http.Error(w, "oh dang", http.StatusInternalServerError)
 				return
 			}
+
 			cert.RenewAt = renewAt
+			cert.Labels = labels
+			cert.Endpoints = endpoints
+			cert.ValidateFirst = r.FormValue("validateFirst") == "on"
 			err = h.certificateService.SaveCert(cert)
 			if err != nil {
 				log.Print(err.Error())
@@ -71,8 +102,13 @@ func (h *uiHandler) CreateCertificate() http.HandlerFunc {
 			}
 
 			//We're not using RequestIssue because we always want this request to go through even if the
-			//channel buffers are full.
-			go func(id string) { h.acmeService.GetIssueChannel() <- id }(cert.ID)
+			//channel buffers are full. A dry run queues the issuance itself once
+			//staging succeeds.
+			if cert.ValidateFirst {
+				go func(id string) { h.acmeService.GetDryRunChannel() <- id }(cert.ID)
+			} else {
+				go func(id string) { h.acmeService.GetIssueChannel() <- id }(cert.ID)
+			}
 			http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
 			return
 		}
@@ -90,11 +126,14 @@ func (h *uiHandler) renderCreateCertificate(w http.ResponseWriter, r *http.Reque
 	}
 
 	p := createCertTemplate{
-		Domains:    r.FormValue("domains"),
-		RenewAt:    r.FormValue("renewAt"),
-		Email:      r.FormValue("email"),
-		CSRFField:  csrf.TemplateField(r),
-		Validation: cv,
+		Domains:       r.FormValue("domains"),
+		RenewAt:       r.FormValue("renewAt"),
+		Email:         r.FormValue("email"),
+		Labels:        r.FormValue("labels"),
+		Endpoints:     r.FormValue("endpoints"),
+		ValidateFirst: r.FormValue("validateFirst") == "on",
+		CSRFField:     csrf.TemplateField(r),
+		Validation:    cv,
 	}
 
 	err = renderLayout(t, "Create New Certificate", p, w, r)
@@ -103,22 +142,139 @@ func (h *uiHandler) renderCreateCertificate(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-// certTemplate holds the cert variable being rendered for the html template.
-type certTemplate struct {
-	Cert *model.Certificate
+// importCertTemplate holds variables for html template that renders the cert import page.
+type importCertTemplate struct {
+	RenewAt    string
+	CSRFField  template.HTML
+	Validation importValidation
 }
 
-// Serve /ui/certificate/id/{id} page.
-func (h *uiHandler) ViewCertificate() http.HandlerFunc {
+// importValidation holds any UI error strings that will need to be rendered if an import fails.
+type importValidation struct {
+	Certificate string
+	PrivateKey  string
+	RenewAt     string
+	Error       string
+}
+
+// Serve /ui/certificate/import page.
+func (h *uiHandler) ImportCertificate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			iv := importValidation{}
 
-		t, err := template.ParseFiles("ui/templates/view_certificate.html")
-		if err != nil {
-			log.Print(err.Error())
-			http.Error(w, "drats", http.StatusInternalServerError)
+			certPEM, err := formFile(r, "certificate")
+			if err != nil || len(certPEM) == 0 {
+				iv.Certificate = "A PEM certificate file is required"
+				iv.Error = "Fix invalid fields and try again."
+				h.renderImportCertificate(w, r, iv)
+				return
+			}
+			chainPEM, _ := formFile(r, "chain")
+			keyPEM, _ := formFile(r, "privateKey")
+
+			renewAt, err := strconv.Atoi(r.FormValue("renewAt"))
+			if err != nil || !model.ValidRenewAt(renewAt) {
+				iv.RenewAt = "Invalid RenewAt value"
+				iv.Error = "Fix invalid fields and try again."
+				h.renderImportCertificate(w, r, iv)
+				return
+			}
+
+			cert, err := model.NewImportedCertificate(certPEM, chainPEM, keyPEM)
+			if err != nil {
+				switch err {
+				case model.ErrInvalidPrivateKey, model.ErrKeyMismatch:
+					iv.PrivateKey = err.Error()
+				default:
+					iv.Certificate = err.Error()
+				}
+				iv.Error = "Fix invalid fields and try again."
+				h.renderImportCertificate(w, r, iv)
+				return
+			}
+			cert.RenewAt = renewAt
+
+			err = h.certificateService.SaveCert(cert)
+			if err != nil {
+				log.Print(err.Error())
+				http.Error(w, "oh dang", http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
 			return
 		}
+		h.renderImportCertificate(w, r, importValidation{})
+	}
+}
+
+// formFile returns the contents of an uploaded multipart file, or nil if no
+// file was provided for the field.
+func formFile(r *http.Request, field string) ([]byte, error) {
+	f, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (h *uiHandler) renderImportCertificate(w http.ResponseWriter, r *http.Request, iv importValidation) {
+
+	t, err := template.ParseFiles("ui/templates/import_certificate.html")
+	if err != nil {
+		log.Print(err.Error())
+		http.Error(w, "oh boyyyy :(", http.StatusInternalServerError)
+		return
+	}
+
+	renewAt := r.FormValue("renewAt")
+	if renewAt == "" {
+		renewAt = strconv.Itoa(model.DefaultRenewAt)
+	}
+
+	p := importCertTemplate{
+		RenewAt:    renewAt,
+		CSRFField:  csrf.TemplateField(r),
+		Validation: iv,
+	}
+
+	err = renderLayout(t, "Import Certificate", p, w, r)
+	if err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// certTemplate holds the cert variable being rendered for the html template.
+type certTemplate struct {
+	Cert      *model.Certificate
+	Details   *model.CertificateDetails
+	Scripts   []*model.ScriptTemplate
+	Targets   []*model.DeployTarget
+	CSRFField template.HTML
+
+	// Vault fills in the add Vault target form again after TargetError.
+	Vault       *model.VaultTarget
+	TargetError string
+
+	// NewToken is an install token just created, shown only this once.
+	NewToken   *newInstallToken
+	TokenError string
+}
+
+// newInstallToken is an install token and the value to give its host.
+type newInstallToken struct {
+	Name  string
+	Token string
+}
 
+// Serve /ui/certificate/id/{id} page.
+func (h *uiHandler) ViewCertificate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 
 		cert, err := h.certificateService.Cert(id)
@@ -128,14 +284,50 @@ func (h *uiHandler) ViewCertificate() http.HandlerFunc {
 			return
 		}
 
-		p := certTemplate{
-			cert,
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
 		}
 
-		err = renderLayout(t, fmt.Sprintf("Certificate - %s", cert.CommonName), p, w, r)
-		if err != nil {
-			log.Print(err.Error())
-		}
+		h.renderViewCertificate(w, r, certTemplate{Cert: cert})
+	}
+}
+
+// renderViewCertificate fills in the rest of p from p.Cert and renders the
+// certificate page.
+func (h *uiHandler) renderViewCertificate(w http.ResponseWriter, r *http.Request, p certTemplate) {
+	t, err := template.ParseFiles("ui/templates/view_certificate.html")
+	if err != nil {
+		log.Print(err.Error())
+		http.Error(w, "drats", http.StatusInternalServerError)
+		return
+	}
+
+	// A broken stored cert shouldn't keep the rest of the page from
+	// rendering.
+	p.Details, err = p.Cert.Details()
+	if err != nil {
+		log.Print(err.Error())
+	}
+
+	p.Scripts, err = h.scriptService.AllScripts()
+	if err != nil {
+		log.Print(err.Error())
+	}
+
+	p.Targets, err = h.deployService.Targets(p.Cert.ID)
+	if err != nil {
+		log.Print(err.Error())
+	}
+
+	p.CSRFField = csrf.TemplateField(r)
+	if p.Vault == nil {
+		p.Vault = &model.VaultTarget{}
+	}
+
+	err = renderLayout(t, fmt.Sprintf("Certificate - %s", p.Cert.CommonName), p, w, r)
+	if err != nil {
+		log.Print(err.Error())
 	}
 }
 
@@ -144,6 +336,9 @@ func (h *uiHandler) SaveCertificate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		domains := r.FormValue("domains")
 		renewAt := r.FormValue("renewAt")
+		email := r.FormValue("email")
+		labels := r.FormValue("labels")
+		endpoints := r.FormValue("endpoints")
 
 		id := mux.Vars(r)["id"]
 
@@ -154,37 +349,307 @@ func (h *uiHandler) SaveCertificate() http.HandlerFunc {
 			return
 		}
 
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
 		cv := certValidation{}
 
 		cert.RenewAt, err = strconv.Atoi(renewAt)
+		if err != nil || !model.ValidRenewAt(cert.RenewAt) {
+			cv.RenewAt = "RenewAt must be between 0 and 89 days"
+			cv.Error = "Fix invalid fields and try again."
+			h.renderCertificate(w, r, cv)
+			return
+		}
+
+		newLabels, err := model.ParseLabels(labels)
+		if err == nil && !model.ValidLabels(newLabels) {
+			err = model.ErrInvalidLabels
+		}
 		if err != nil {
-			cv.RenewAt = "Invalid RenewAt value"
+			cv.Labels = err.Error()
 			cv.Error = "Fix invalid fields and try again."
 			h.renderCertificate(w, r, cv)
 			return
 		}
+		cert.SetLabels(newLabels)
 
-		cert.Domains = strings.Split(domains, ",")
-		if !model.ValidDomains(cert.Domains) {
-			cv.Domains = "One or more domains are not valid"
+		eps, err := model.ParseEndpoints(endpoints)
+		if err == nil {
+			err = cert.SetEndpoints(eps)
+		}
+		if err != nil {
+			cv.Endpoints = err.Error()
 			cv.Error = "Fix invalid fields and try again."
 			h.renderCertificate(w, r, cv)
 			return
 		}
 
-		h.certificateService.SaveCert(cert)
+		// Imported certs are only tracked here, so domains and email don't
+		// apply.
+		reissue := false
+		if !cert.Imported {
+			reissue, err = cert.SetDomains(strings.Split(domains, ","))
+			if err != nil {
+				cv.Domains = "One or more domains are not valid"
+				cv.Error = "Fix invalid fields and try again."
+				h.renderCertificate(w, r, cv)
+				return
+			}
+
+			if email != "" && email != cert.ACMEEmail {
+				err = h.acmeService.UpdateEmail(cert, email)
+				if err != nil {
+					log.Print(err.Error())
+					cv.Email = "Email could not be updated: " + err.Error()
+					cv.Error = "Fix invalid fields and try again."
+					h.renderCertificate(w, r, cv)
+					return
+				}
+			}
+		}
+
+		err = h.certificateService.SaveSettings(cert)
 		if err != nil {
 			log.Print(err.Error())
 			http.Error(w, "noooooo", http.StatusInternalServerError)
 			return
 		}
 
-		if ok := h.acmeService.RequestRenew(cert.ID); !ok {
-			log.Print("***WARNING*** Renew pipeline full...")
+		// A new domain set needs a fresh issuance; the current cert stays in
+		// place until it succeeds.
+		if reissue {
+			go func(id string) { h.acmeService.GetIssueChannel() <- id }(cert.ID)
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/probe requests.
+func (h *uiHandler) ProbeCertificate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		cert, err := h.certificateService.Cert(id)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		if cert.Issued {
+			cert.UpdateEndpoints(probe.Check(cert, probe.DefaultTimeout))
+			err = h.certificateService.SaveEndpoints(cert)
+			if err != nil {
+				log.Print(err.Error())
+				http.Error(w, "whoops", http.StatusInternalServerError)
+				return
+			}
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/target/{target}/deploy requests.
+func (h *uiHandler) RedeployTarget() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		t, err := h.deployService.Target(vars["target"])
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if t == nil || t.CertID != vars["id"] {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		// The push runs in the background and its outcome is shown on the
+		// certificate page once it's done.
+		_, err = h.deployService.Deploy(t.ID)
+		if err != nil {
+			log.Print(err.Error())
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+t.CertID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/target/vault requests.
+func (h *uiHandler) AddVaultTarget() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cert, err := h.certificateService.Cert(mux.Vars(r)["id"])
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		t := model.NewDeployTarget(cert.ID)
+		t.Type = model.DeployTargetVault
+		t.Vault = &model.VaultTarget{
+			Address:      strings.TrimSpace(r.FormValue("address")),
+			Namespace:    strings.TrimSpace(r.FormValue("namespace")),
+			Mount:        strings.TrimSpace(r.FormValue("mount")),
+			Path:         strings.TrimSpace(r.FormValue("path")),
+			TokenFile:    strings.TrimSpace(r.FormValue("tokenFile")),
+			RoleID:       strings.TrimSpace(r.FormValue("roleID")),
+			SecretIDFile: strings.TrimSpace(r.FormValue("secretIDFile")),
+			AppRoleMount: strings.TrimSpace(r.FormValue("approleMount")),
+			CACert:       strings.TrimSpace(r.FormValue("caCert")),
+		}
+		err = t.Validate()
+		if err != nil {
+			h.renderViewCertificate(w, r, certTemplate{Cert: cert, Vault: t.Vault, TargetError: err.Error()})
+			return
+		}
+		if cert.Version > 0 {
+			t.Schedule(cert.Version)
+		}
+
+		err = h.deployService.SaveTarget(t)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/target/{target}/delete requests.
+func (h *uiHandler) DeleteTarget() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		t, err := h.deployService.Target(vars["target"])
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if t == nil || t.CertID != vars["id"] {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		err = h.deployService.DeleteTarget(t.ID)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+t.CertID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/installtoken requests.
+func (h *uiHandler) CreateInstallToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cert, err := h.certificateService.Cert(mux.Vars(r)["id"])
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		t, value, err := model.NewInstallToken(r.FormValue("name"))
+		if err == nil {
+			err = cert.AddInstallToken(t)
+		}
+		if err != nil {
+			h.renderViewCertificate(w, r, certTemplate{Cert: cert, TokenError: err.Error()})
+			return
+		}
+
+		err = h.certificateService.SaveInstallTokens(cert)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		// Rendered rather than redirected to, since this is the only time
+		// the token can be shown.
+		w.Header().Set("Cache-Control", "no-store")
+		h.renderViewCertificate(w, r, certTemplate{Cert: cert, NewToken: &newInstallToken{t.Name, value}})
+	}
+}
+
+// Serve /ui/certificate/id/{id}/installtoken/{token}/delete requests.
+func (h *uiHandler) DeleteInstallToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		cert, err := h.certificateService.Cert(vars["id"])
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if cert == nil || !cert.RemoveInstallToken(vars["token"]) {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		err = h.certificateService.SaveInstallTokens(cert)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
+	}
+}
+
+// Serve /ui/certificate/id/{id}/dryrun requests.
+func (h *uiHandler) DryRunCertificate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		cert, err := h.certificateService.Cert(id)
+		if err != nil {
+			log.Print(err.Error())
+			http.Error(w, "whoops", http.StatusInternalServerError)
+			return
+		}
+
+		if cert == nil {
+			http.Error(w, "Not found.", http.StatusNotFound)
+			return
+		}
+
+		if cert.Imported {
+			http.Error(w, "Imported certificates can't be issued via ACME.", http.StatusBadRequest)
+			return
+		}
+
+		if ok := h.acmeService.RequestDryRun(cert.ID); !ok {
+			log.Print("***WARNING*** Dry run pipeline full...")
 			http.Error(w, "whoops", http.StatusTooManyRequests)
 			return
 		}
-		cv.Success = "Successfully saved certificate."
 		http.Redirect(w, r, "/ui/certificate/id/"+cert.ID, http.StatusSeeOther)
 	}
 }
@@ -195,6 +660,10 @@ type editCertTemplate struct {
 	CommonName string
 	Domains    string
 	RenewAt    int
+	Email      string
+	Labels     string
+	Endpoints  string
+	Imported   bool
 	CSRFField  template.HTML
 	Validation certValidation
 }
@@ -230,12 +699,24 @@ func (h *uiHandler) renderCertificate(w http.ResponseWriter, r *http.Request, cv
 		return
 	}
 
-	domains := strings.Join(cert.Domains, ",")
+	// Reserved labels can't be edited, and are kept when the form is saved.
+	labels := make(map[string]string)
+	for k, v := range cert.Labels {
+		if !model.ReservedLabel(k) {
+			labels[k] = v
+		}
+	}
+
+	domains := strings.Join(cert.RequestedDomains(), ",")
 	p := editCertTemplate{
 		ID:         cert.ID,
 		CommonName: cert.CommonName,
 		Domains:    domains,
 		RenewAt:    cert.RenewAt,
+		Email:      cert.ACMEEmail,
+		Labels:     model.FormatLabels(labels),
+		Endpoints:  model.FormatEndpoints(cert.Endpoints),
+		Imported:   cert.Imported,
 		CSRFField:  csrf.TemplateField(r),
 		Validation: cv,
 	}
@@ -247,9 +728,24 @@ func (h *uiHandler) renderCertificate(w http.ResponseWriter, r *http.Request, cv
 	}
 }
 
-// certListTemplate holds all certificates for parsing into html template.
+// certListTemplate holds one page of certificates and the active filters for
+// parsing into html template.
 type certListTemplate struct {
-	Certs []*model.Certificate
+	Certs      []*model.Certificate
+	Total      int
+	Filter     certListFilter
+	NextURL    string
+	Validation certValidation
+}
+
+// certListFilter mirrors the filter form on the certificates page.
+type certListFilter struct {
+	Labels   string
+	Domain   string
+	State    string
+	Expiring string
+	Sort     string
+	Order    string
 }
 
 // Serve /ui/certificates page.
@@ -263,15 +759,55 @@ func (h *uiHandler) ListCertificates() http.HandlerFunc {
 			return
 		}
 
-		certs, err := h.certificateService.AllCerts()
+		v := r.URL.Query()
+		f := certListFilter{
+			Labels:   v.Get("labels"),
+			Domain:   v.Get("domain"),
+			State:    v.Get("state"),
+			Expiring: v.Get("expiring"),
+			Sort:     v.Get("sort"),
+			Order:    v.Get("order"),
+		}
+		p := certListTemplate{Filter: f}
+
+		q := &model.CertificateQuery{
+			Domain: f.Domain,
+			State:  f.State,
+			Sort:   f.Sort,
+			Desc:   f.Order == "desc",
+			Cursor: v.Get("cursor"),
+		}
+		q.Labels, err = model.ParseLabels(f.Labels)
+		if err == nil && f.Expiring != "" {
+			q.ExpiringWithin, err = strconv.Atoi(f.Expiring)
+			if err != nil || q.ExpiringWithin <= 0 {
+				err = model.ErrInvalidExpiring
+			}
+		}
+		if err == nil {
+			err = q.Validate()
+		}
+		if err != nil {
+			p.Validation.Error = err.Error()
+			err = renderLayout(t, "Certificates", p, w, r)
+			if err != nil {
+				log.Print(err.Error())
+			}
+			return
+		}
+
+		page, err := h.certificateService.QueryCerts(q)
 		if err != nil {
 			log.Print(err.Error())
 			http.Error(w, "yikes", http.StatusInternalServerError)
 			return
 		}
 
-		p := certListTemplate{
-			certs,
+		p.Certs = page.Certs
+		p.Total = page.Total
+		if page.NextCursor != "" {
+			v.Set("cursor", page.NextCursor)
+			p.NextURL = "/ui/certificates?" + v.Encode()
 		}
 
 		err = renderLayout(t, "Certificates", p, w, r)
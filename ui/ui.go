@@ -9,9 +9,12 @@ import (
 	"strings"
 
 	"github.com/ImageWare/TLSential/acme"
+	"github.com/ImageWare/TLSential/audit"
 	"github.com/ImageWare/TLSential/certificate"
 	"github.com/ImageWare/TLSential/challenge_config"
 	"github.com/ImageWare/TLSential/config"
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/script"
 	"github.com/ImageWare/TLSential/user"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -33,17 +36,20 @@ type uiHandler struct {
 	challengeService   challenge_config.Service
 	certificateService certificate.Service
 	acmeService        acme.Service
+	auditService       audit.Service
+	scriptService      script.Service
+	deployService      deploy.Service
 	store              *sessions.CookieStore
 }
 
 // NewHandler returns a new UI Handler for use in main.
-func NewHandler(version string, us user.Service, cs config.Service, chs challenge_config.Service, crs certificate.Service, as acme.Service) Handler {
+func NewHandler(version string, us user.Service, cs config.Service, chs challenge_config.Service, crs certificate.Service, as acme.Service, aus audit.Service, ss script.Service, ds deploy.Service) Handler {
 	key, err := cs.SessionKey()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	store := sessions.NewCookieStore(key)
-	return &uiHandler{version, us, cs, chs, crs, as, store}
+	return &uiHandler{version, us, cs, chs, crs, as, aus, ss, ds, store}
 }
 
 // Route returns a handler for all /ui/ routes.
@@ -61,13 +67,22 @@ func (h *uiHandler) Route(unsafe bool) http.Handler {
 	)
 
 	r := mux.NewRouter()
+	r.Use(h.Audit)
 	r.HandleFunc("/ui/dashboard", h.Authenticated(h.Dashboard())).Methods("GET")
 	r.HandleFunc("/ui/certificates", h.Authenticated(h.ListCertificates())).Methods("GET")
 	r.HandleFunc("/ui/certificate/id/{id}", h.Authenticated(h.ViewCertificate())).Methods("GET")
 	r.HandleFunc("/ui/certificate/id/{id}/edit", h.Authenticated(h.EditCertificate())).Methods("GET")
 	r.HandleFunc("/ui/certificate/id/{id}/edit", h.Authenticated(h.SaveCertificate())).Methods("POST")
 	r.HandleFunc("/ui/certificate/id/{id}/delete", h.Authenticated(h.DeleteCertificate())).Methods("GET", "POST")
+	r.HandleFunc("/ui/certificate/id/{id}/dryrun", h.Authenticated(h.DryRunCertificate())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/probe", h.Authenticated(h.ProbeCertificate())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/installtoken", h.Authenticated(h.CreateInstallToken())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/installtoken/{token}/delete", h.Authenticated(h.DeleteInstallToken())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/target/vault", h.Authenticated(h.AddVaultTarget())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/target/{target}/deploy", h.Authenticated(h.RedeployTarget())).Methods("POST")
+	r.HandleFunc("/ui/certificate/id/{id}/target/{target}/delete", h.Authenticated(h.DeleteTarget())).Methods("POST")
 	r.HandleFunc("/ui/certificate/create", h.Authenticated(h.CreateCertificate())).Methods("GET", "POST")
+	r.HandleFunc("/ui/certificate/import", h.Authenticated(h.ImportCertificate())).Methods("GET", "POST")
 
 	r.HandleFunc("/ui/users", h.Authenticated(h.ListUsers())).Methods("GET")
 	r.HandleFunc("/ui/user/id/{id}", h.Authenticated(h.ViewUser())).Methods("GET")
@@ -193,9 +208,10 @@ func (h *uiHandler) PostLogin() http.HandlerFunc {
 			log.Fatal(err.Error())
 		}
 
-		// TODO: Add role and maybe username here.
+		// TODO: Add role here.
 		// Set user as authenticated
 		session.Values["authenticated"] = true
+		session.Values["username"] = u.Name
 		session.Save(r, w)
 
 		http.Redirect(w, r, "/ui/dashboard", http.StatusSeeOther)
@@ -213,6 +229,7 @@ func (h *uiHandler) Logout() http.HandlerFunc {
 
 		// Set user as NOT authenticated
 		session.Values["authenticated"] = false
+		delete(session.Values, "username")
 		session.Save(r, w)
 
 		http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
@@ -0,0 +1,249 @@
+// Package vaultsink writes certificates to Vault KV version 2 secrets, for
+// applications that read their secrets from Vault rather than files.
+package vaultsink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ImageWare/TLSential/deploy"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Keys of the secret's data.
+const (
+	CertificateKey = "certificate"
+	ChainKey       = "chain"
+	PrivateKeyKey  = "private_key"
+)
+
+// Keys of the secret's custom metadata.
+const (
+	CertIDMetadata     = "tlsential_cert_id"
+	VersionMetadata    = "tlsential_version"
+	CommonNameMetadata = "common_name"
+	ExpiryMetadata     = "expiry"
+)
+
+const requestTimeout = 30 * time.Second
+
+// maxResponse bounds what's read from Vault.
+const maxResponse = 1 << 20
+
+type pusher struct {
+	keyDir string
+	// allowed is the scheme and host of every Vault credentials may be
+	// sent to.
+	allowed map[string]bool
+}
+
+// New returns a Pusher for vault targets, reading tokens, AppRole secret IDs
+// and CA certificates from keyDir. Since anyone who can add a target could
+// otherwise point it at their own server and collect those credentials,
+// only the Vault addresses given are pushed to.
+func New(keyDir string, addresses []string) deploy.Pusher {
+	p := &pusher{keyDir: keyDir, allowed: make(map[string]bool)}
+	for _, a := range addresses {
+		if o := origin(a); o != "" {
+			p.allowed[o] = true
+		}
+	}
+	return p
+}
+
+// origin returns the scheme and host of address, or "" if it isn't a URL.
+func origin(address string) string {
+	u, err := url.Parse(strings.TrimSpace(address))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// Push writes c as a new version of the target's secret, then records c's
+// version in the secret's metadata.
+func (p *pusher) Push(t *model.DeployTarget, c *model.Certificate) (string, error) {
+	if t.Type != model.DeployTargetVault || t.Vault == nil {
+		return "", model.ErrInvalidDeployTarget
+	}
+	v := t.Vault
+
+	cl, err := p.client(v)
+	if err != nil {
+		return "", err
+	}
+	if v.TokenFile != "" {
+		cl.token, err = p.readFile(v.TokenFile)
+	} else {
+		err = p.login(cl, v)
+		if err == nil {
+			// The token from logging in isn't needed after this push.
+			defer cl.do(http.MethodPost, "auth/token/revoke-self", nil, nil)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	b := model.NewInstallBundle(c)
+	data := map[string]interface{}{
+		"data": map[string]string{
+			CertificateKey: string(b.Contents(model.ContentsCert)),
+			ChainKey:       string(b.Contents(model.ContentsChain)),
+			PrivateKeyKey:  string(b.Contents(model.ContentsKey)),
+		},
+	}
+	written := &struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}{}
+	err = cl.do(http.MethodPost, v.MountPath()+"/data/"+v.Path, data, written)
+	if err != nil {
+		return "", err
+	}
+
+	meta := map[string]interface{}{
+		"custom_metadata": map[string]string{
+			CertIDMetadata:     c.ID,
+			VersionMetadata:    strconv.Itoa(c.Version),
+			CommonNameMetadata: c.CommonName,
+			ExpiryMetadata:     c.Expiry.UTC().Format(time.RFC3339),
+		},
+	}
+	err = cl.do(http.MethodPost, v.MountPath()+"/metadata/"+v.Path, meta, nil)
+	if err != nil {
+		return "", fmt.Errorf("wrote the secret but not its metadata: %s", err)
+	}
+	return fmt.Sprintf("wrote version %d of %s/%s", written.Data.Version, v.MountPath(), v.Path), nil
+}
+
+// readFile returns the trimmed contents of a file in the key directory.
+func (p *pusher) readFile(name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(p.keyDir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// login gets a token for cl with AppRole.
+func (p *pusher) login(cl *client, v *model.VaultTarget) error {
+	secretID, err := p.readFile(v.SecretIDFile)
+	if err != nil {
+		return err
+	}
+	resp := &struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+	err = cl.do(http.MethodPost, "auth/"+v.AppRolePath()+"/login", map[string]string{
+		"role_id":   v.RoleID,
+		"secret_id": secretID,
+	}, resp)
+	if err != nil {
+		return fmt.Errorf("approle login: %s", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return errors.New("approle login: no token in the response")
+	}
+	cl.token = resp.Auth.ClientToken
+	return nil
+}
+
+// client is a connection to one Vault server.
+type client struct {
+	address   string
+	namespace string
+	token     string
+	http      *http.Client
+}
+
+func (p *pusher) client(v *model.VaultTarget) (*client, error) {
+	if o := origin(v.Address); o == "" || !p.allowed[o] {
+		return nil, fmt.Errorf("%s isn't one of the Vault addresses this server may push to", v.Address)
+	}
+	tc := &tls.Config{}
+	if v.CACert != "" {
+		pem, err := ioutil.ReadFile(filepath.Join(p.keyDir, v.CACert))
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", v.CACert)
+		}
+	}
+	return &client{
+		address:   strings.TrimSuffix(v.Address, "/"),
+		namespace: v.Namespace,
+		http: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tc, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// do sends in as JSON, if it's set, to the API path and decodes the response
+// into out, if it's set.
+func (c *client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.address+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		verr := &struct {
+			Errors []string `json:"errors"`
+		}{}
+		msg := resp.Status
+		if json.Unmarshal(b, verr) == nil && len(verr.Errors) > 0 {
+			msg = fmt.Sprintf("%d %s", resp.StatusCode, strings.Join(verr.Errors, "; "))
+		}
+		return fmt.Errorf("vault: %s %s: %s", method, path, msg)
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,237 @@
+package vaultsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ImageWare/TLSential/deploy/deploytest"
+	"github.com/ImageWare/TLSential/model"
+)
+
+// testVault stands in for Vault's HTTP API: AppRole login, token
+// revocation, and KV version 2 data and metadata writes.
+type testVault struct {
+	mu        sync.Mutex
+	tokens    map[string]bool
+	namespace string
+	versions  map[string][]map[string]string
+	metadata  map[string]map[string]string
+	revoked   []string
+	// readOnly refuses writes with a permission error.
+	readOnly bool
+}
+
+func newTestVault() (*testVault, *httptest.Server) {
+	v := &testVault{
+		tokens:   map[string]bool{"root-token": true},
+		versions: make(map[string][]map[string]string),
+		metadata: make(map[string]map[string]string),
+	}
+	return v, httptest.NewServer(v)
+}
+
+func (v *testVault) fail(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+}
+
+func (v *testVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if r.Method != http.MethodPost {
+		v.fail(w, http.StatusMethodNotAllowed, "unsupported")
+		return
+	}
+	if r.Header.Get("X-Vault-Namespace") != v.namespace {
+		v.fail(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if p == "auth/approle/login" {
+		if body["role_id"] != "web-role" || body["secret_id"] != "web-secret" {
+			v.fail(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		v.tokens["approle-token"] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": "approle-token"}})
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if !v.tokens[token] {
+		v.fail(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	switch {
+	case p == "auth/token/revoke-self":
+		delete(v.tokens, token)
+		v.revoked = append(v.revoked, token)
+		w.WriteHeader(http.StatusNoContent)
+	case v.readOnly:
+		v.fail(w, http.StatusForbidden, "permission denied")
+	case strings.HasPrefix(p, "secret/data/"):
+		data := map[string]string{}
+		for k, val := range body["data"].(map[string]interface{}) {
+			data[k] = val.(string)
+		}
+		key := strings.TrimPrefix(p, "secret/data/")
+		v.versions[key] = append(v.versions[key], data)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": len(v.versions[key])}})
+	case strings.HasPrefix(p, "secret/metadata/"):
+		meta := map[string]string{}
+		for k, val := range body["custom_metadata"].(map[string]interface{}) {
+			meta[k] = val.(string)
+		}
+		v.metadata[strings.TrimPrefix(p, "secret/metadata/")] = meta
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		v.fail(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+// setup returns a key directory holding a token and an AppRole secret ID.
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tlsential-vaultsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"vault-token": "root-token\n", "web-secret-id": "web-secret\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func target(addr string, mod func(v *model.VaultTarget)) *model.DeployTarget {
+	t := model.NewDeployTarget("cert1")
+	t.Type = model.DeployTargetVault
+	t.Vault = &model.VaultTarget{Address: addr, Path: "apps/web/tls", TokenFile: "vault-token"}
+	if mod != nil {
+		mod(t.Vault)
+	}
+	return t
+}
+
+func TestPushToken(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	v, srv := newTestVault()
+	defer srv.Close()
+	p, c := New(dir, []string{srv.URL}), deploytest.Cert()
+
+	for want := 1; want <= 2; want++ {
+		out, err := p.Push(target(srv.URL, nil), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != fmt.Sprintf("wrote version %d of secret/apps/web/tls", want) {
+			t.Errorf("unexpected output %q", out)
+		}
+	}
+
+	versions := v.versions["apps/web/tls"]
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+	data := versions[1]
+	if data[CertificateKey] != string(c.Certificate) || data[ChainKey] != string(c.IssuerCertificate) || data[PrivateKeyKey] != string(c.PrivateKey) {
+		t.Errorf("unexpected data %v", data)
+	}
+	meta := v.metadata["apps/web/tls"]
+	if meta[CertIDMetadata] != "cert1" || meta[VersionMetadata] != "3" || meta[CommonNameMetadata] != "www.example.com" || meta[ExpiryMetadata] != "2026-01-02T03:04:05Z" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+	if len(v.revoked) != 0 {
+		t.Errorf("expected a configured token not to be revoked, got %v", v.revoked)
+	}
+}
+
+func TestPushAppRole(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	v, srv := newTestVault()
+	defer srv.Close()
+	v.namespace = "team-a"
+
+	tg := target(srv.URL, func(vt *model.VaultTarget) {
+		vt.TokenFile = ""
+		vt.RoleID = "web-role"
+		vt.SecretIDFile = "web-secret-id"
+		vt.Namespace = "team-a"
+	})
+	if err := tg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir, []string{srv.URL}).Push(tg, deploytest.Cert()); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.versions["apps/web/tls"]) != 1 {
+		t.Error("expected the secret to be written")
+	}
+	if len(v.revoked) != 1 || v.revoked[0] != "approle-token" {
+		t.Errorf("expected the login token to be revoked, got %v", v.revoked)
+	}
+
+	tg.Vault.RoleID = "other-role"
+	_, err := New(dir, []string{srv.URL}).Push(tg, deploytest.Cert())
+	if err == nil || !strings.Contains(err.Error(), "invalid role or secret ID") {
+		t.Errorf("expected the login to fail, got %v", err)
+	}
+}
+
+func TestPushFailures(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+	v, srv := newTestVault()
+	defer srv.Close()
+
+	v.readOnly = true
+	_, err := New(dir, []string{srv.URL}).Push(target(srv.URL, nil), deploytest.Cert())
+	if err == nil || !strings.Contains(err.Error(), "403 permission denied") {
+		t.Errorf("expected Vault's error, got %v", err)
+	}
+
+	_, err = New(dir, []string{srv.URL}).Push(target(srv.URL, func(vt *model.VaultTarget) { vt.TokenFile = "missing" }), deploytest.Cert())
+	if err == nil {
+		t.Error("expected an error for a missing token file")
+	}
+
+	_, err = New(dir, []string{srv.URL}).Push(target(srv.URL, func(vt *model.VaultTarget) { vt.CACert = "vault-token" }), deploytest.Cert())
+	if err == nil || !strings.Contains(err.Error(), "no certificates") {
+		t.Errorf("expected an error for a bad CA file, got %v", err)
+	}
+
+	// Credentials are never sent to a Vault the server wasn't given.
+	contacted := false
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { contacted = true }))
+	defer other.Close()
+	_, err = New(dir, []string{srv.URL}).Push(target(other.URL, nil), deploytest.Cert())
+	if err == nil || contacted {
+		t.Errorf("expected an unlisted address to be refused, got %v", err)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	for address, want := range map[string]string{
+		"https://Vault.example.com:8200/": "https://vault.example.com:8200",
+		" http://127.0.0.1:8200":          "http://127.0.0.1:8200",
+		"vault.example.com":               "",
+		"":                                "",
+	} {
+		if got := origin(address); got != want {
+			t.Errorf("origin(%q) = %q, expected %q", address, got, want)
+		}
+	}
+}
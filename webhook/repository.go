@@ -0,0 +1,25 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Repository provides an interface for persisting webhook subscriptions and
+// their delivery outbox.
+type Repository interface {
+	Webhooks(certID string) ([]*model.Webhook, error)
+	Webhook(id string) (*model.Webhook, error)
+	SaveWebhook(w *model.Webhook) error
+	// DeleteWebhook removes the subscription and its delivery history.
+	DeleteWebhook(id string) error
+
+	SaveDelivery(d *model.Delivery) error
+	// Deliveries returns a webhook's deliveries, newest first.
+	Deliveries(webhookID string) ([]*model.Delivery, error)
+	// DueDeliveries returns pending deliveries whose next attempt is due.
+	DueDeliveries(now time.Time) ([]*model.Delivery, error)
+	// PruneDeliveries removes deliveries that finished before the cutoff.
+	PruneDeliveries(before time.Time) error
+}
@@ -0,0 +1,23 @@
+package webhook
+
+import (
+	"github.com/ImageWare/TLSential/model"
+)
+
+// Service provides an interface for managing per-certificate webhooks and
+// delivering events to them.
+type Service interface {
+	Webhooks(certID string) ([]*model.Webhook, error)
+	Webhook(id string) (*model.Webhook, error)
+	SaveWebhook(w *model.Webhook) error
+	DeleteWebhook(id string) error
+	Deliveries(webhookID string) ([]*model.Delivery, error)
+
+	// Enqueue adds an event for c to the outbox of every subscription that
+	// wants it.
+	Enqueue(event string, c *model.Certificate) error
+
+	// DeliverDue attempts every delivery that's due, rescheduling failures
+	// with backoff.
+	DeliverDue()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ImageWare/TLSential/webhook"
+)
+
+// How often to check the webhook outbox for due deliveries.
+var deliveryPeriod = 15 * time.Second
+
+func webhookDeliveries(ws webhook.Service) {
+	for {
+		select {
+		case <-time.After(deliveryPeriod):
+			ws.DeliverDue()
+			break
+		}
+	}
+}